@@ -0,0 +1,370 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	chatCompletionObject = "chat.completion"
+	completionObject     = "text_completion"
+)
+
+// Accumulator 将 stream 模式下的 chunk 合并成一个完整的响应
+type Accumulator[T any] interface {
+	Add(chunk T) error
+	Result() T
+}
+
+// ChatAccumulator 将 stream 模式下返回的 chunk 合并成一个完整的 ChatCreateResponse，
+// 合并后每个 choice 的 Message 包含完整的内容、function call 和 tool calls，Delta 为 nil，
+// 这样 stream 模式和非 stream 模式的结果可以交给同一套代码处理
+// 非 stream 模式下的响应也可以直接 Add，结果与原响应一致
+type ChatAccumulator struct {
+	resp    ChatCreateResponse
+	choices map[int64]*chatChoiceBuilder
+}
+
+type chatChoiceBuilder struct {
+	role         string
+	content      strings.Builder
+	refusal      strings.Builder
+	name         string
+	functionCall *FunctionCall
+	arguments    strings.Builder
+	toolCalls    map[int64]*toolCallBuilder
+	logprobs     *ChatLogprobs
+	finishReason string
+}
+
+type toolCallBuilder struct {
+	id        string
+	typ       string
+	name      string
+	arguments strings.Builder
+}
+
+func NewChatAccumulator() *ChatAccumulator {
+	return &ChatAccumulator{
+		choices: make(map[int64]*chatChoiceBuilder),
+	}
+}
+
+// Add 合并一个 chunk，如果 chunk 携带了流异常中断的错误，直接返回该错误
+func (a *ChatAccumulator) Add(chunk *ChatCreateResponse) error {
+	if err := chunk.Err(); err != nil {
+		return err
+	}
+
+	if chunk.Id != "" {
+		a.resp.Id = chunk.Id
+	}
+
+	if chunk.Created != 0 {
+		a.resp.Created = chunk.Created
+	}
+
+	if chunk.Model != "" {
+		a.resp.Model = chunk.Model
+	}
+
+	if chunk.SystemFingerprint != "" {
+		a.resp.SystemFingerprint = chunk.SystemFingerprint
+	}
+
+	if chunk.ServiceTier != "" {
+		a.resp.ServiceTier = chunk.ServiceTier
+	}
+
+	// stream 模式下只有最后一个 chunk 可能携带 usage
+	if chunk.Usage != (Usage{}) {
+		a.resp.Usage = chunk.Usage
+	}
+
+	for _, choice := range chunk.Choices {
+		b, ok := a.choices[choice.Index]
+		if !ok {
+			b = &chatChoiceBuilder{}
+			a.choices[choice.Index] = b
+		}
+
+		if choice.FinishReason != "" {
+			b.finishReason = choice.FinishReason
+		}
+
+		// stream 模式下每个 chunk 只包含对应 token 的 logprobs，需要按顺序拼接
+		if choice.Logprobs != nil {
+			if b.logprobs == nil {
+				b.logprobs = &ChatLogprobs{}
+			}
+			b.logprobs.Content = append(b.logprobs.Content, choice.Logprobs.Content...)
+			b.logprobs.Refusal = append(b.logprobs.Refusal, choice.Logprobs.Refusal...)
+		}
+
+		if choice.Message != nil {
+			var functionCall *FunctionCall
+			if choice.Message.HasFunctionCall() {
+				functionCall = &choice.Message.FunctionCall
+			}
+			b.merge(choice.Message.Role, choice.Message.Content, functionCall, choice.Message.ToolCalls)
+			b.refusal.WriteString(choice.Message.Refusal)
+			b.name = choice.Message.Name
+		}
+
+		if choice.Delta != nil {
+			b.merge(choice.Delta.Role, choice.Delta.Content, choice.Delta.FunctionCall, choice.Delta.ToolCalls)
+			b.refusal.WriteString(choice.Delta.Refusal)
+		}
+	}
+
+	return nil
+}
+
+func (b *chatChoiceBuilder) merge(role, content string, functionCall *FunctionCall, toolCalls []*ToolCall) {
+	if role != "" {
+		b.role = role
+	}
+
+	b.content.WriteString(content)
+
+	for i, tc := range toolCalls {
+		// 非 stream 模式下没有 index，按照顺序处理
+		index := int64(i)
+		if tc.Index != nil {
+			index = *tc.Index
+		}
+
+		if b.toolCalls == nil {
+			b.toolCalls = make(map[int64]*toolCallBuilder)
+		}
+
+		t, ok := b.toolCalls[index]
+		if !ok {
+			t = &toolCallBuilder{}
+			b.toolCalls[index] = t
+		}
+
+		// id、type 和 name 只会在第一个分片中返回，arguments 需要拼接
+		if tc.Id != "" {
+			t.id = tc.Id
+		}
+		if tc.Type != "" {
+			t.typ = tc.Type
+		}
+		if tc.Function.Name != "" {
+			t.name = tc.Function.Name
+		}
+		t.arguments.WriteString(tc.Function.Arguments)
+	}
+
+	if functionCall == nil || (functionCall.Name == "" && functionCall.Arguments == "") {
+		return
+	}
+
+	if b.functionCall == nil {
+		b.functionCall = &FunctionCall{}
+	}
+
+	// name 只会在第一个分片中返回，arguments 需要拼接
+	if functionCall.Name != "" {
+		b.functionCall.Name = functionCall.Name
+	}
+
+	b.arguments.WriteString(functionCall.Arguments)
+}
+
+// Result 返回目前为止合并的结果，choices 按照 index 排序
+func (a *ChatAccumulator) Result() *ChatCreateResponse {
+	resp := a.resp
+	resp.Object = chatCompletionObject
+
+	indexes := make([]int64, 0, len(a.choices))
+	for index := range a.choices {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i] < indexes[j]
+	})
+
+	resp.Choices = make([]*ChatCompletion, 0, len(indexes))
+	for _, index := range indexes {
+		b := a.choices[index]
+
+		msg := &Message{
+			Role:    b.role,
+			Content: b.content.String(),
+			Refusal: b.refusal.String(),
+			Name:    b.name,
+		}
+
+		if b.functionCall != nil {
+			msg.FunctionCall = FunctionCall{
+				Name:      b.functionCall.Name,
+				Arguments: b.arguments.String(),
+			}
+		}
+
+		if len(b.toolCalls) > 0 {
+			toolIndexes := make([]int64, 0, len(b.toolCalls))
+			for i := range b.toolCalls {
+				toolIndexes = append(toolIndexes, i)
+			}
+			sort.Slice(toolIndexes, func(i, j int) bool {
+				return toolIndexes[i] < toolIndexes[j]
+			})
+
+			msg.ToolCalls = make([]*ToolCall, 0, len(toolIndexes))
+			for _, i := range toolIndexes {
+				t := b.toolCalls[i]
+				msg.ToolCalls = append(msg.ToolCalls, &ToolCall{
+					Id:   t.id,
+					Type: t.typ,
+					Function: FunctionCall{
+						Name:      t.name,
+						Arguments: t.arguments.String(),
+					},
+				})
+			}
+		}
+
+		resp.Choices = append(resp.Choices, &ChatCompletion{
+			Index:        index,
+			Message:      msg,
+			Logprobs:     b.logprobs,
+			FinishReason: b.finishReason,
+		})
+	}
+
+	return &resp
+}
+
+// AccumulateChat 消费 ChatService.Create 返回的 channel，无论是否 stream 模式都返回一个完整的 ChatCreateResponse
+// 注意 ctx 被取消时 channel 会直接关闭，此时返回的是部分结果，调用方需要自行检查 ctx
+func AccumulateChat(res <-chan *ChatCreateResponse) (*ChatCreateResponse, error) {
+	acc := NewChatAccumulator()
+
+	for chunk := range res {
+		if err := acc.Add(chunk); err != nil {
+			return nil, err
+		}
+	}
+
+	return acc.Result(), nil
+}
+
+// AccumulateChatStream 消费 ChatService.CreateStream 返回的 Stream，返回一个完整的 ChatCreateResponse，
+// 流被截断或者出错时返回错误
+func AccumulateChatStream(s *Stream[*ChatCreateResponse]) (*ChatCreateResponse, error) {
+	defer s.Close()
+
+	acc := NewChatAccumulator()
+
+	for s.Next() {
+		if err := acc.Add(s.Current()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return acc.Result(), nil
+}
+
+// CompletionAccumulator 将 stream 模式下返回的 chunk 合并成一个完整的 CompletionCreateResponse，
+// 合并后每个 choice 的 Text 为完整的文本，Delta 为 nil
+type CompletionAccumulator struct {
+	resp    CompletionCreateResponse
+	choices map[int64]*completionChoiceBuilder
+}
+
+type completionChoiceBuilder struct {
+	text         strings.Builder
+	finishReason string
+}
+
+func NewCompletionAccumulator() *CompletionAccumulator {
+	return &CompletionAccumulator{
+		choices: make(map[int64]*completionChoiceBuilder),
+	}
+}
+
+// Add 合并一个 chunk，如果 chunk 携带了流异常中断的错误，直接返回该错误
+func (a *CompletionAccumulator) Add(chunk *CompletionCreateResponse) error {
+	if err := chunk.Err(); err != nil {
+		return err
+	}
+
+	if chunk.Id != "" {
+		a.resp.Id = chunk.Id
+	}
+
+	if chunk.Created != 0 {
+		a.resp.Created = chunk.Created
+	}
+
+	if chunk.Model != "" {
+		a.resp.Model = chunk.Model
+	}
+
+	if chunk.Usage != (Usage{}) {
+		a.resp.Usage = chunk.Usage
+	}
+
+	for _, choice := range chunk.Choices {
+		b, ok := a.choices[choice.Index]
+		if !ok {
+			b = &completionChoiceBuilder{}
+			a.choices[choice.Index] = b
+		}
+
+		b.text.WriteString(choice.Text)
+
+		if choice.FinishReason != "" {
+			b.finishReason = choice.FinishReason
+		}
+	}
+
+	return nil
+}
+
+// Result 返回目前为止合并的结果，choices 按照 index 排序
+func (a *CompletionAccumulator) Result() *CompletionCreateResponse {
+	resp := a.resp
+	resp.Object = completionObject
+
+	indexes := make([]int64, 0, len(a.choices))
+	for index := range a.choices {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i] < indexes[j]
+	})
+
+	resp.Choices = make([]*Completion, 0, len(indexes))
+	for _, index := range indexes {
+		b := a.choices[index]
+		resp.Choices = append(resp.Choices, &Completion{
+			Text:         b.text.String(),
+			Index:        index,
+			FinishReason: b.finishReason,
+		})
+	}
+
+	return &resp
+}
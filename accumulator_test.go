@@ -0,0 +1,231 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+func TestChatAccumulator(t *testing.T) {
+	testCase := []struct {
+		name    string
+		chunks  []string
+		wantRes *ChatCreateResponse
+	}{
+		{
+			name: "test accumulate content",
+			chunks: []string{
+				`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":null}]}`,
+				mockChatChunk(0, "Hello", ""),
+				mockChatChunk(0, " world", ""),
+				mockChatChunk(0, "", FinishReasonStop),
+			},
+			wantRes: &ChatCreateResponse{
+				Id:      "chatcmpl-123",
+				Object:  "chat.completion",
+				Created: 1694268190,
+				Choices: []*ChatCompletion{
+					{
+						Index:        0,
+						Message:      &Message{Role: "assistant", Content: "Hello world"},
+						FinishReason: FinishReasonStop,
+					},
+				},
+			},
+		},
+		{
+			name: "test accumulate logprobs and usage",
+			chunks: []string{
+				`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"model":"gpt-4o-2024-08-06","system_fingerprint":"fp_44709d6fcb","choices":[{"index":0,"delta":{"role":"assistant","content":"Hi"},"logprobs":{"content":[{"token":"Hi","logprob":-0.1,"bytes":[72,105],"top_logprobs":[]}]},"finish_reason":null}]}`,
+				`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"model":"gpt-4o-2024-08-06","system_fingerprint":"fp_44709d6fcb","choices":[{"index":0,"delta":{"content":"!"},"logprobs":{"content":[{"token":"!","logprob":-0.2,"bytes":[33],"top_logprobs":[]}]},"finish_reason":"length"}]}`,
+				`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"model":"gpt-4o-2024-08-06","system_fingerprint":"fp_44709d6fcb","service_tier":"default","choices":[],"usage":{"prompt_tokens":9,"completion_tokens":2,"total_tokens":11,"prompt_tokens_details":{"cached_tokens":0},"completion_tokens_details":{"reasoning_tokens":0}}}`,
+			},
+			wantRes: &ChatCreateResponse{
+				Id:                "chatcmpl-123",
+				Object:            "chat.completion",
+				Created:           1694268190,
+				Model:             "gpt-4o-2024-08-06",
+				SystemFingerprint: "fp_44709d6fcb",
+				ServiceTier:       ServiceTierDefault,
+				Choices: []*ChatCompletion{
+					{
+						Index:   0,
+						Message: &Message{Role: "assistant", Content: "Hi!"},
+						Logprobs: &ChatLogprobs{
+							Content: []*TokenLogprob{
+								{Token: "Hi", Logprob: -0.1, Bytes: []int{72, 105}, TopLogprobs: []*TopLogprob{}},
+								{Token: "!", Logprob: -0.2, Bytes: []int{33}, TopLogprobs: []*TopLogprob{}},
+							},
+						},
+						FinishReason: FinishReasonLength,
+					},
+				},
+				Usage: Usage{
+					PromptTokens:            9,
+					CompletionTokens:        2,
+					TotalTokens:             11,
+					PromptTokensDetails:     &PromptTokensDetails{},
+					CompletionTokensDetails: &CompletionTokensDetails{},
+				},
+			},
+		},
+		{
+			name: "test accumulate function call",
+			chunks: []string{
+				`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"choices":[{"index":0,"delta":{"role":"assistant","content":null,"function_call":{"name":"get_weather","arguments":""}},"finish_reason":null}]}`,
+				`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"choices":[{"index":0,"delta":{"function_call":{"arguments":"{\"loc"}},"finish_reason":null}]}`,
+				`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"choices":[{"index":0,"delta":{"function_call":{"arguments":"ation\":\"Boston\"}"}},"finish_reason":null}]}`,
+				`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"choices":[{"index":0,"delta":{},"finish_reason":"function_call"}]}`,
+			},
+			wantRes: &ChatCreateResponse{
+				Id:      "chatcmpl-123",
+				Object:  "chat.completion",
+				Created: 1694268190,
+				Choices: []*ChatCompletion{
+					{
+						Index: 0,
+						Message: &Message{
+							Role: "assistant",
+							FunctionCall: FunctionCall{
+								Name:      "get_weather",
+								Arguments: `{"location":"Boston"}`,
+							},
+						},
+						FinishReason: FinishReasonFunctionCall,
+					},
+				},
+			},
+		},
+		{
+			name: "test accumulate parallel tool calls",
+			chunks: []string{
+				`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"choices":[{"index":0,"delta":{"role":"assistant","content":null,"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]},"finish_reason":null}]}`,
+				`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"location\":"}}]},"finish_reason":null}]}`,
+				`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"get_time","arguments":"{}"}}]},"finish_reason":null}]}`,
+				`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Boston\"}"}}]},"finish_reason":null}]}`,
+				`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+			},
+			wantRes: &ChatCreateResponse{
+				Id:      "chatcmpl-123",
+				Object:  "chat.completion",
+				Created: 1694268190,
+				Choices: []*ChatCompletion{
+					{
+						Index: 0,
+						Message: &Message{
+							Role: "assistant",
+							ToolCalls: []*ToolCall{
+								{Id: "call_1", Type: ToolTypeFunction, Function: FunctionCall{Name: "get_weather", Arguments: `{"location":"Boston"}`}},
+								{Id: "call_2", Type: ToolTypeFunction, Function: FunctionCall{Name: "get_time", Arguments: `{}`}},
+							},
+						},
+						FinishReason: FinishReasonToolCalls,
+					},
+				},
+			},
+		},
+		{
+			name: "test accumulate multiple choices",
+			chunks: []string{
+				mockChatChunk(1, "B", ""),
+				mockChatChunk(0, "A", ""),
+				mockChatChunk(1, "b", FinishReasonStop),
+				mockChatChunk(0, "a", FinishReasonStop),
+			},
+			wantRes: &ChatCreateResponse{
+				Id:      "chatcmpl-123",
+				Object:  "chat.completion",
+				Created: 1694268190,
+				Choices: []*ChatCompletion{
+					{Index: 0, Message: &Message{Content: "Aa"}, FinishReason: FinishReasonStop},
+					{Index: 1, Message: &Message{Content: "Bb"}, FinishReason: FinishReasonStop},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newMockServer(newMockStreamServer(tc.chunks, 0, true, nil))
+			defer server.Close()
+
+			client := newMockClient(server.URL)
+
+			s, err := client.Chat.CreateStream(context.TODO(), &ChatCreateRequest{Model: GPT35Turbo})
+			require.NoError(t, err)
+
+			res, err := AccumulateChatStream(s)
+			require.NoError(t, err)
+			require.Equal(t, tc.wantRes, res)
+		})
+	}
+}
+
+func TestAccumulateChat(t *testing.T) {
+	for _, filename := range []string{"chat_completion_for_function_call.json", "chat_completion_for_tool_calls.json"} {
+		t.Run("test accumulate not stream response "+filename, func(t *testing.T) {
+			var want ChatCreateResponse
+			loadMockData(filename, &want)
+
+			res := make(chan *ChatCreateResponse, 1)
+			res <- &want
+			close(res)
+
+			got, err := AccumulateChat(res)
+			require.NoError(t, err)
+			require.Equal(t, &want, got)
+		})
+	}
+
+	t.Run("test accumulate interrupted stream", func(t *testing.T) {
+		server := newMockServer(newMockStreamServer([]string{mockChatChunk(0, "Hello", "")}, 0, false, nil))
+		defer server.Close()
+
+		client := newMockClient(server.URL)
+
+		res, err := client.Chat.Create(context.TODO(), &ChatCreateRequest{Model: GPT35Turbo, Stream: true})
+		require.NoError(t, err)
+
+		_, err = AccumulateChat(res)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestCompletionAccumulator(t *testing.T) {
+	acc := NewCompletionAccumulator()
+
+	chunks := []*CompletionCreateResponse{
+		{Id: "cmpl-123", Model: TextDaVinci003, Choices: []*Completion{{Text: "This is", Index: 0}, {Text: "It", Index: 1}}},
+		{Id: "cmpl-123", Model: TextDaVinci003, Choices: []*Completion{{Text: " a test", Index: 0, FinishReason: "length"}}},
+		{Id: "cmpl-123", Model: TextDaVinci003, Choices: []*Completion{{Text: " works", Index: 1, FinishReason: FinishReasonStop}}},
+	}
+
+	for _, chunk := range chunks {
+		require.NoError(t, acc.Add(chunk))
+	}
+
+	require.Equal(t, &CompletionCreateResponse{
+		Id:     "cmpl-123",
+		Object: "text_completion",
+		Model:  TextDaVinci003,
+		Choices: []*Completion{
+			{Text: "This is a test", Index: 0, FinishReason: "length"},
+			{Text: "It works", Index: 1, FinishReason: FinishReasonStop},
+		},
+	}, acc.Result())
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	BatchCreatePath   = "/batches"
+	BatchListPath     = "/batches"
+	BatchRetrievePath = "/batches/%s"
+	BatchCancelPath   = "/batches/%s/cancel"
+
+	// BatchEndpointChatCompletions 等为批量任务支持的接口，对应 BatchCreateRequest.Endpoint 和输入文件中每一行的 url
+	BatchEndpointChatCompletions = "/v1/chat/completions"
+	BatchEndpointEmbeddings      = "/v1/embeddings"
+	BatchEndpointCompletions     = "/v1/completions"
+
+	// BatchCompletionWindow24h 目前唯一支持的完成时限
+	BatchCompletionWindow24h = "24h"
+
+	// FilePurposeBatch 批量任务输入文件的 purpose
+	FilePurposeBatch = "batch"
+
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+type BatchService interface {
+	Create(ctx context.Context, req *BatchCreateRequest) (*Batch, error)
+	Retrieve(ctx context.Context, id string) (*Batch, error)
+	List(ctx context.Context, req *BatchListRequest) (*BatchListResponse, error)
+	Cancel(ctx context.Context, id string) (*Batch, error)
+}
+
+type BatchCreateRequest struct {
+	InputFileId      string            `json:"input_file_id"`
+	Endpoint         string            `json:"endpoint"`
+	CompletionWindow string            `json:"completion_window"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// BatchListRequest 分页参数，After 为上一页最后一个 Batch 的 id
+type BatchListRequest struct {
+	After string `url:"after,omitempty"`
+	Limit int64  `url:"limit,omitempty"`
+}
+
+type BatchListResponse struct {
+	Object  string   `json:"object"`
+	Data    []*Batch `json:"data"`
+	FirstId string   `json:"first_id"`
+	LastId  string   `json:"last_id"`
+	HasMore bool     `json:"has_more"`
+}
+
+type Batch struct {
+	Id               string             `json:"id"`
+	Object           string             `json:"object"`
+	Endpoint         string             `json:"endpoint"`
+	Errors           *BatchErrors       `json:"errors"` // 输入文件校验失败的原因
+	InputFileId      string             `json:"input_file_id"`
+	CompletionWindow string             `json:"completion_window"`
+	Status           string             `json:"status"`
+	OutputFileId     string             `json:"output_file_id"` // 成功请求的结果
+	ErrorFileId      string             `json:"error_file_id"`  // 失败请求的结果
+	CreatedAt        int64              `json:"created_at"`
+	InProgressAt     int64              `json:"in_progress_at"`
+	ExpiresAt        int64              `json:"expires_at"`
+	FinalizingAt     int64              `json:"finalizing_at"`
+	CompletedAt      int64              `json:"completed_at"`
+	FailedAt         int64              `json:"failed_at"`
+	ExpiredAt        int64              `json:"expired_at"`
+	CancellingAt     int64              `json:"cancelling_at"`
+	CancelledAt      int64              `json:"cancelled_at"`
+	RequestCounts    BatchRequestCounts `json:"request_counts"`
+	Metadata         map[string]string  `json:"metadata"`
+}
+
+// Done 判断 Batch 是否已经处于终止状态，终止状态下才会有完整的结果文件
+func (b *Batch) Done() bool {
+	switch b.Status {
+	case BatchStatusCompleted, BatchStatusFailed, BatchStatusExpired, BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+type BatchErrors struct {
+	Object string        `json:"object"`
+	Data   []*BatchError `json:"data"`
+}
+
+type BatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param"`
+	Line    int64  `json:"line"`
+}
+
+type BatchRequestCounts struct {
+	Total     int64 `json:"total"`
+	Completed int64 `json:"completed"`
+	Failed    int64 `json:"failed"`
+}
+
+type BatchServiceOp struct {
+	client *Client
+}
+
+func (b BatchServiceOp) Create(ctx context.Context, req *BatchCreateRequest) (*Batch, error) {
+	r := *req
+	if r.CompletionWindow == "" {
+		r.CompletionWindow = BatchCompletionWindow24h
+	}
+
+	var resp Batch
+	err := b.client.Post(ctx, BatchCreatePath, &r, &resp)
+	return &resp, err
+}
+
+func (b BatchServiceOp) Retrieve(ctx context.Context, id string) (*Batch, error) {
+	var resp Batch
+	err := b.client.Get(ctx, fmt.Sprintf(BatchRetrievePath, id), nil, &resp)
+	return &resp, err
+}
+
+func (b BatchServiceOp) List(ctx context.Context, req *BatchListRequest) (*BatchListResponse, error) {
+	var resp BatchListResponse
+	err := b.client.Get(ctx, BatchListPath, req, &resp)
+	return &resp, err
+}
+
+// Cancel 取消 Batch，Batch 会先进入 cancelling 状态，最多 10 分钟后变为 cancelled，已经完成的请求的结果仍然可以获取
+func (b BatchServiceOp) Cancel(ctx context.Context, id string) (*Batch, error) {
+	var resp Batch
+	err := b.client.Post(ctx, fmt.Sprintf(BatchCancelPath, id), nil, &resp)
+	return &resp, err
+}
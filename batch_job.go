@@ -0,0 +1,395 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	defaultBatchPollInterval = 30 * time.Second
+	maxBatchLineSize         = 64 * 1024 * 1024
+)
+
+var (
+	// ErrDuplicateCustomId 同一个批量任务中 custom_id 重复
+	ErrDuplicateCustomId = errors.New("openai: duplicate batch custom_id")
+	// ErrBatchNotCompleted 批量任务以 failed、expired 或者 cancelled 状态结束
+	ErrBatchNotCompleted = errors.New("openai: batch not completed")
+)
+
+// BatchRequestLine 批量任务输入文件中的一行
+type BatchRequestLine[T any] struct {
+	CustomId string `json:"custom_id"`
+	Method   string `json:"method"`
+	Url      string `json:"url"`
+	Body     T      `json:"body"`
+}
+
+// BatchInput 构建批量任务的 JSONL 输入文件，同一个输入文件中的请求必须使用同一个接口，用法如下：
+//
+//	input := openai.NewChatBatchInput()
+//	_ = input.Add("request-1", &openai.ChatCreateRequest{...})
+//	batch, err := openai.SubmitBatch(ctx, client, input)
+type BatchInput[T any] struct {
+	endpoint string
+	buf      bytes.Buffer
+	ids      map[string]struct{}
+	validate func(body T) error
+}
+
+// NewBatchInput 创建指定接口的 BatchInput，endpoint 为 BatchEndpointChatCompletions 等
+func NewBatchInput[T any](endpoint string) *BatchInput[T] {
+	return &BatchInput[T]{
+		endpoint: endpoint,
+		ids:      make(map[string]struct{}),
+	}
+}
+
+// NewChatBatchInput 创建聊天接口的 BatchInput，批量任务不支持 stream 模式
+func NewChatBatchInput() *BatchInput[*ChatCreateRequest] {
+	input := NewBatchInput[*ChatCreateRequest](BatchEndpointChatCompletions)
+	input.validate = func(req *ChatCreateRequest) error {
+		if req.Stream {
+			return errors.New("openai: batch does not support stream requests")
+		}
+		return nil
+	}
+	return input
+}
+
+// NewEmbeddingBatchInput 创建 embedding 接口的 BatchInput
+func NewEmbeddingBatchInput() *BatchInput[*EmbeddingCreateRequest] {
+	return NewBatchInput[*EmbeddingCreateRequest](BatchEndpointEmbeddings)
+}
+
+// Add 添加一个请求，customId 用于在结果中找到对应的响应，不能为空也不能重复
+func (b *BatchInput[T]) Add(customId string, body T) error {
+	if customId == "" {
+		return errors.New("openai: batch custom_id is empty")
+	}
+
+	if _, ok := b.ids[customId]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateCustomId, customId)
+	}
+
+	if b.validate != nil {
+		if err := b.validate(body); err != nil {
+			return err
+		}
+	}
+
+	line, err := json.Marshal(&BatchRequestLine[T]{
+		CustomId: customId,
+		Method:   http.MethodPost,
+		Url:      b.endpoint,
+		Body:     body,
+	})
+	if err != nil {
+		return err
+	}
+
+	b.buf.Write(line)
+	b.buf.WriteByte('\n')
+	b.ids[customId] = struct{}{}
+
+	return nil
+}
+
+// Endpoint 返回输入文件中请求的接口
+func (b *BatchInput[T]) Endpoint() string {
+	return b.endpoint
+}
+
+// Len 返回请求的数量
+func (b *BatchInput[T]) Len() int {
+	return len(b.ids)
+}
+
+// Bytes 返回 JSONL 格式的输入文件内容
+func (b *BatchInput[T]) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// WriteTo 将 JSONL 格式的输入文件内容写入 w
+func (b *BatchInput[T]) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(b.buf.Bytes())
+	return int64(n), err
+}
+
+// UploadBatchInput 通过 FileService 上传输入文件，FileService 只支持上传本地文件，这里会先写入临时文件
+func UploadBatchInput[T any](ctx context.Context, files FileService, input *BatchInput[T]) (*File, error) {
+	if input.Len() == 0 {
+		return nil, errors.New("openai: batch input is empty")
+	}
+
+	f, err := os.CreateTemp("", "batch-*.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = input.WriteTo(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return files.Upload(ctx, &FileUploadRequest{
+		File:    f.Name(),
+		Purpose: FilePurposeBatch,
+	})
+}
+
+// SubmitBatch 上传输入文件并创建批量任务，metadata 可以为 nil
+func SubmitBatch[T any](ctx context.Context, client *Client, input *BatchInput[T], metadata map[string]string) (*Batch, error) {
+	file, err := UploadBatchInput(ctx, client.Files, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Batches.Create(ctx, &BatchCreateRequest{
+		InputFileId:      file.Id,
+		Endpoint:         input.Endpoint(),
+		CompletionWindow: BatchCompletionWindow24h,
+		Metadata:         metadata,
+	})
+}
+
+type batchWaitConfig struct {
+	interval time.Duration
+	onUpdate func(batch *Batch)
+}
+
+type BatchWaitOption func(*batchWaitConfig)
+
+// WithBatchPollInterval 设置轮询间隔，默认为 30s
+func WithBatchPollInterval(interval time.Duration) BatchWaitOption {
+	return func(c *batchWaitConfig) {
+		if interval > 0 {
+			c.interval = interval
+		}
+	}
+}
+
+// WithBatchOnUpdate 每次轮询到状态或者进度变化时回调
+func WithBatchOnUpdate(fn func(batch *Batch)) BatchWaitOption {
+	return func(c *batchWaitConfig) {
+		c.onUpdate = fn
+	}
+}
+
+// WaitBatch 轮询直到 Batch 处于终止状态，返回最后一次查询的结果，ctx 被取消时返回 ctx 的错误
+func WaitBatch(ctx context.Context, batches BatchService, id string, opts ...BatchWaitOption) (*Batch, error) {
+	cfg := &batchWaitConfig{
+		interval: defaultBatchPollInterval,
+	}
+
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	var last *Batch
+	for {
+		batch, err := batches.Retrieve(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.onUpdate != nil && (last == nil || last.Status != batch.Status || last.RequestCounts != batch.RequestCounts) {
+			cfg.onUpdate(batch)
+		}
+		last = batch
+
+		if batch.Done() {
+			return batch, nil
+		}
+
+		timer := time.NewTimer(cfg.interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// BatchResultError 单个请求没有得到响应的原因，例如请求过期
+type BatchResultError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *BatchResultError) Error() string {
+	return fmt.Sprintf("openai: batch request failed: %s (code: %s)", e.Message, e.Code)
+}
+
+// BatchResult 单个请求的结果，请求成功时 Response 不为 nil，
+// 服务端返回错误时 StatusCode 不为 200，APIError 为服务端返回的错误，请求没有执行时 Error 不为 nil
+type BatchResult[T any] struct {
+	Id         string
+	CustomId   string
+	StatusCode int
+	RequestId  string
+	Response   *T
+	APIError   *APIError
+	Error      *BatchResultError
+}
+
+// Err 返回请求失败的原因，成功时返回 nil
+func (r *BatchResult[T]) Err() error {
+	switch {
+	case r.Error != nil:
+		return r.Error
+	case r.APIError != nil:
+		return r.APIError
+	case r.StatusCode != http.StatusOK:
+		return fmt.Errorf("openai: batch request failed with status %d", r.StatusCode)
+	}
+	return nil
+}
+
+// batchOutputLine 结果文件中的一行，Processor 输出的结果文件使用同样的格式
+type batchOutputLine struct {
+	Id       string               `json:"id"`
+	CustomId string               `json:"custom_id"`
+	Response *batchOutputResponse `json:"response"`
+	Error    *BatchResultError    `json:"error"`
+}
+
+type batchOutputResponse struct {
+	StatusCode int             `json:"status_code"`
+	RequestId  string          `json:"request_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// DecodeBatchResults 解析结果文件，返回以 custom_id 为 key 的结果，T 为对应接口的响应类型，例如 ChatCreateResponse
+func DecodeBatchResults[T any](data []byte) (map[string]*BatchResult[T], error) {
+	results := make(map[string]*BatchResult[T])
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBatchLineSize)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var out batchOutputLine
+		if err := json.Unmarshal(line, &out); err != nil {
+			return nil, fmt.Errorf("openai: decode batch result line %d: %w", n, err)
+		}
+
+		result := &BatchResult[T]{
+			Id:       out.Id,
+			CustomId: out.CustomId,
+			Error:    out.Error,
+		}
+
+		if resp := out.Response; resp != nil {
+			result.StatusCode = resp.StatusCode
+			result.RequestId = resp.RequestId
+
+			if resp.StatusCode == http.StatusOK {
+				var body T
+				if err := json.Unmarshal(resp.Body, &body); err != nil {
+					return nil, fmt.Errorf("openai: decode batch result line %d: %w", n, err)
+				}
+				result.Response = &body
+			} else {
+				var body struct {
+					Error *APIError `json:"error"`
+				}
+				// 错误响应的格式不固定，解析失败时通过 StatusCode 判断
+				_ = json.Unmarshal(resp.Body, &body)
+				result.APIError = body.Error
+			}
+		}
+
+		results[out.CustomId] = result
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// BatchResults 下载 Batch 的结果文件和错误文件，返回以 custom_id 为 key 的结果，
+// Batch 没有完成时只返回已经完成的请求的结果
+func BatchResults[T any](ctx context.Context, files FileService, batch *Batch) (map[string]*BatchResult[T], error) {
+	results := make(map[string]*BatchResult[T])
+
+	for _, id := range []string{batch.OutputFileId, batch.ErrorFileId} {
+		if id == "" {
+			continue
+		}
+
+		data, err := files.RetrieveContent(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		decoded, err := DecodeBatchResults[T](data)
+		if err != nil {
+			return nil, err
+		}
+
+		for customId, result := range decoded {
+			results[customId] = result
+		}
+	}
+
+	return results, nil
+}
+
+// RunBatch 提交批量任务，等待完成并返回结果，Batch 以 completed 以外的状态结束时，
+// 仍然返回已经完成的请求的结果，同时返回 ErrBatchNotCompleted
+func RunBatch[T any, R any](ctx context.Context, client *Client, input *BatchInput[T], opts ...BatchWaitOption) (*Batch, map[string]*BatchResult[R], error) {
+	batch, err := SubmitBatch(ctx, client, input, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	batch, err = WaitBatch(ctx, client.Batches, batch.Id, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results, err := BatchResults[R](ctx, client.Files, batch)
+	if err != nil {
+		return batch, nil, err
+	}
+
+	if batch.Status != BatchStatusCompleted {
+		return batch, results, fmt.Errorf("%w: %s", ErrBatchNotCompleted, batch.Status)
+	}
+
+	return batch, results, nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchInput(t *testing.T) {
+	input := NewChatBatchInput()
+	require.NoError(t, input.Add("request-1", &ChatCreateRequest{
+		Model:    "gpt-4o-mini",
+		Messages: []*Message{{Role: RoleUser, Content: "Hello"}},
+	}))
+
+	require.ErrorIs(t, input.Add("request-1", &ChatCreateRequest{Model: "gpt-4o-mini"}), ErrDuplicateCustomId)
+	require.Error(t, input.Add("", &ChatCreateRequest{Model: "gpt-4o-mini"}))
+	require.Error(t, input.Add("request-2", &ChatCreateRequest{Model: "gpt-4o-mini", Stream: true}))
+	require.Equal(t, 1, input.Len())
+	require.Equal(t, BatchEndpointChatCompletions, input.Endpoint())
+	require.JSONEq(t, `{
+		"custom_id": "request-1",
+		"method": "POST",
+		"url": "/v1/chat/completions",
+		"body": {"model": "gpt-4o-mini", "messages": [{"role": "user", "content": "Hello"}]}
+	}`, string(input.Bytes()))
+
+	embeddings := NewEmbeddingBatchInput()
+	require.NoError(t, embeddings.Add("a", &EmbeddingCreateRequest{Model: "text-embedding-3-small", Input: []string{"a"}}))
+	require.NoError(t, embeddings.Add("b", &EmbeddingCreateRequest{Model: "text-embedding-3-small", Input: []string{"b"}}))
+
+	var sb strings.Builder
+	_, err := embeddings.WriteTo(&sb)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(sb.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var line BatchRequestLine[*EmbeddingCreateRequest]
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &line))
+	require.Equal(t, "b", line.CustomId)
+	require.Equal(t, BatchEndpointEmbeddings, line.Url)
+	require.Equal(t, []string{"b"}, line.Body.Input)
+}
+
+func TestDecodeBatchResults(t *testing.T) {
+	output, err := DecodeBatchResults[ChatCreateResponse](loadTestdata("batch_output.jsonl"))
+	require.NoError(t, err)
+	require.Len(t, output, 2)
+
+	ok := output["request-1"]
+	require.NoError(t, ok.Err())
+	require.Equal(t, "req_1", ok.RequestId)
+	require.Equal(t, "Hello!", ok.Response.Choices[0].Message.Content)
+	require.Equal(t, int64(24), ok.Response.Usage.TotalTokens)
+
+	failed := output["request-2"]
+	require.Nil(t, failed.Response)
+	require.Equal(t, http.StatusBadRequest, failed.StatusCode)
+	require.Equal(t, &APIError{Message: "Invalid model", Type: "invalid_request_error", Code: "model_not_found"}, failed.Err())
+
+	errs, err := DecodeBatchResults[ChatCreateResponse](loadTestdata("batch_error.jsonl"))
+	require.NoError(t, err)
+	var resultErr *BatchResultError
+	require.True(t, errors.As(errs["request-3"].Err(), &resultErr))
+	require.Equal(t, "batch_expired", resultErr.Code)
+
+	_, err = DecodeBatchResults[ChatCreateResponse]([]byte("{\n"))
+	require.Error(t, err)
+}
+
+// newMockBatchServer 模拟上传文件、创建批量任务、轮询和下载结果的完整流程，前两次查询时任务还在进行中
+func newMockBatchServer(t *testing.T, status string) http.HandlerFunc {
+	var batch Batch
+	loadMockData("batch_response.json", &batch)
+
+	retrieves := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		require.Equal(t, FilePurposeBatch, r.FormValue("purpose"))
+
+		f, _, err := r.FormFile("file")
+		require.NoError(t, err)
+		defer f.Close()
+
+		lines := 0
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines++
+		}
+		require.Equal(t, 2, lines)
+
+		_ = json.NewEncoder(w).Encode(&File{Id: "file-abc123", Purpose: FilePurposeBatch})
+	})
+	mux.HandleFunc("/v1/batches", func(w http.ResponseWriter, r *http.Request) {
+		var req BatchCreateRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, "file-abc123", req.InputFileId)
+
+		_ = json.NewEncoder(w).Encode(&Batch{Id: batch.Id, Status: BatchStatusValidating})
+	})
+	mux.HandleFunc("/v1/batches/batch_abc123", func(w http.ResponseWriter, r *http.Request) {
+		retrieves++
+		res := batch
+		res.Status = status
+		if retrieves < 3 {
+			res.Status = BatchStatusInProgress
+			res.RequestCounts.Completed = int64(retrieves)
+		}
+		_ = json.NewEncoder(w).Encode(&res)
+	})
+	mux.HandleFunc("/v1/files/file-cvaTdG/content", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(loadTestdata("batch_output.jsonl"))
+	})
+	mux.HandleFunc("/v1/files/file-HOWS94/content", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(loadTestdata("batch_error.jsonl"))
+	})
+
+	return mux.ServeHTTP
+}
+
+func TestRunBatch(t *testing.T) {
+	testCase := []struct {
+		name    string
+		status  string
+		wantErr error
+	}{
+		{
+			name:   "test run batch completed",
+			status: BatchStatusCompleted,
+		},
+		{
+			name:    "test run batch expired",
+			status:  BatchStatusExpired,
+			wantErr: ErrBatchNotCompleted,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newMockServer(newMockBatchServer(t, tc.status))
+			defer server.Close()
+			client := newMockClient(server.URL)
+
+			input := NewChatBatchInput()
+			require.NoError(t, input.Add("request-1", &ChatCreateRequest{Model: "gpt-4o-mini"}))
+			require.NoError(t, input.Add("request-2", &ChatCreateRequest{Model: "gpt-5"}))
+
+			var updates []string
+			batch, results, err := RunBatch[*ChatCreateRequest, ChatCreateResponse](context.TODO(), client, input,
+				WithBatchPollInterval(time.Millisecond),
+				WithBatchOnUpdate(func(batch *Batch) {
+					updates = append(updates, batch.Status)
+				}),
+			)
+			require.ErrorIs(t, err, tc.wantErr)
+			require.Equal(t, tc.status, batch.Status)
+			require.Equal(t, []string{BatchStatusInProgress, BatchStatusInProgress, tc.status}, updates)
+			require.Len(t, results, 3)
+			require.Equal(t, "Hello!", results["request-1"].Response.Choices[0].Message.Content)
+			require.Error(t, results["request-3"].Err())
+		})
+	}
+}
+
+func TestWaitBatch_Cancel(t *testing.T) {
+	server := newMockServer(newMockBatchServer(t, BatchStatusCompleted))
+	defer server.Close()
+	client := newMockClient(server.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := WaitBatch(ctx, client.Batches, "batch_abc123", WithBatchPollInterval(time.Hour))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
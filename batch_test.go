@@ -0,0 +1,131 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestBatchServiceOp(t *testing.T) {
+	var wantBatch Batch
+	loadMockData("batch_response.json", &wantBatch)
+
+	testCase := []struct {
+		name       string
+		wantMethod string
+		wantPath   string
+		wantQuery  string
+		wantBody   string
+		call       func(ctx context.Context, client *Client) (any, error)
+		wantRes    any
+	}{
+		{
+			name:       "test batch create",
+			wantMethod: http.MethodPost,
+			wantPath:   "/v1/batches",
+			wantBody:   `{"input_file_id":"file-abc123","endpoint":"/v1/chat/completions","completion_window":"24h"}`,
+			call: func(ctx context.Context, client *Client) (any, error) {
+				return client.Batches.Create(ctx, &BatchCreateRequest{
+					InputFileId: "file-abc123",
+					Endpoint:    BatchEndpointChatCompletions,
+				})
+			},
+			wantRes: &wantBatch,
+		},
+		{
+			name:       "test batch retrieve",
+			wantMethod: http.MethodGet,
+			wantPath:   "/v1/batches/batch_abc123",
+			call: func(ctx context.Context, client *Client) (any, error) {
+				return client.Batches.Retrieve(ctx, "batch_abc123")
+			},
+			wantRes: &wantBatch,
+		},
+		{
+			name:       "test batch list",
+			wantMethod: http.MethodGet,
+			wantPath:   "/v1/batches",
+			wantQuery:  "after=batch_abc122&limit=2",
+			call: func(ctx context.Context, client *Client) (any, error) {
+				return client.Batches.List(ctx, &BatchListRequest{After: "batch_abc122", Limit: 2})
+			},
+			wantRes: &BatchListResponse{
+				Object:  "list",
+				Data:    []*Batch{&wantBatch},
+				FirstId: "batch_abc123",
+				LastId:  "batch_abc123",
+			},
+		},
+		{
+			name:       "test batch cancel",
+			wantMethod: http.MethodPost,
+			wantPath:   "/v1/batches/batch_abc123/cancel",
+			call: func(ctx context.Context, client *Client) (any, error) {
+				return client.Batches.Cancel(ctx, "batch_abc123")
+			},
+			wantRes: &wantBatch,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newMockServer(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, tc.wantMethod, r.Method)
+				require.Equal(t, tc.wantPath, r.URL.Path)
+				require.Equal(t, tc.wantQuery, r.URL.RawQuery)
+
+				if tc.wantBody != "" {
+					body, err := io.ReadAll(r.Body)
+					require.NoError(t, err)
+					require.JSONEq(t, tc.wantBody, string(body))
+				}
+
+				_ = json.NewEncoder(w).Encode(tc.wantRes)
+			})
+			defer server.Close()
+
+			res, err := tc.call(context.TODO(), newMockClient(server.URL))
+			require.NoError(t, err)
+			require.Equal(t, tc.wantRes, res)
+		})
+	}
+}
+
+func TestBatch_Done(t *testing.T) {
+	testCase := []struct {
+		status string
+		want   bool
+	}{
+		{status: BatchStatusValidating},
+		{status: BatchStatusInProgress},
+		{status: BatchStatusFinalizing},
+		{status: BatchStatusCancelling},
+		{status: BatchStatusCompleted, want: true},
+		{status: BatchStatusFailed, want: true},
+		{status: BatchStatusExpired, want: true},
+		{status: BatchStatusCancelled, want: true},
+	}
+
+	for _, tc := range testCase {
+		t.Run("test batch done "+tc.status, func(t *testing.T) {
+			require.Equal(t, tc.want, (&Batch{Status: tc.status}).Done())
+		})
+	}
+}
@@ -16,68 +16,414 @@ package openai
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"github.com/go-logr/logr"
+	"strings"
 )
 
 const (
 	// ChatCreatePath 聊天创建路径
 	ChatCreatePath = "/chat/completions"
 
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleFunction  = "function"
+	RoleTool      = "tool"
+
 	FunctionCallNone = FunctionCallString("none")
 	FunctionCallAuto = FunctionCallString("auto")
 
-	FinishReasonFunctionCall = "function_call"
-	FinishReasonStop         = "stop"
+	ToolTypeFunction = "function"
+
+	ResponseFormatText       = "text"
+	ResponseFormatJSONObject = "json_object"
+	ResponseFormatJSONSchema = "json_schema"
+
+	ToolChoiceNone     = ToolChoiceString("none")
+	ToolChoiceAuto     = ToolChoiceString("auto")
+	ToolChoiceRequired = ToolChoiceString("required")
+
+	FinishReasonFunctionCall  = "function_call"
+	FinishReasonToolCalls     = "tool_calls"
+	FinishReasonStop          = "stop"
+	FinishReasonLength        = "length"
+	FinishReasonContentFilter = "content_filter"
+
+	ReasoningEffortLow    = "low"
+	ReasoningEffortMedium = "medium"
+	ReasoningEffortHigh   = "high"
+
+	ServiceTierAuto    = "auto"
+	ServiceTierDefault = "default"
+	ServiceTierFlex    = "flex"
+
+	ModalityText  = "text"
+	ModalityAudio = "audio"
+
+	PredictionTypeContent = "content"
 )
 
 type ChatService interface {
 	Create(ctx context.Context, req *ChatCreateRequest) (chan *ChatCreateResponse, error)
+	CreateStream(ctx context.Context, req *ChatCreateRequest) (*Stream[*ChatCreateResponse], error)
 }
 
 type ChatCreateRequest struct {
-	Model            string           `json:"model"`
-	Messages         []*Message       `json:"messages,omitempty"`
-	Functions        []*Function      `json:"functions,omitempty"`
-	FunctionCall     IFunctionCall    `json:"function_call,omitempty"`
-	Temperature      float64          `json:"temperature,omitempty"`
-	TopP             float64          `json:"top_p,omitempty"`
-	N                int64            `json:"n,omitempty"`
-	Stream           bool             `json:"stream,omitempty"`
-	Stop             []string         `json:"stop,omitempty"`
-	MaxTokens        int64            `json:"max_tokens,omitempty"`
-	PresencePenalty  float64          `json:"presence_penalty,omitempty"`
-	FrequencyPenalty float64          `json:"frequency_penalty,omitempty"`
-	LogitBias        map[string]int64 `json:"logit_bias,omitempty"`
-	User             string           `json:"user,omitempty"`
+	Model               string            `json:"model"`
+	Messages            []*Message        `json:"messages,omitempty"`
+	Functions           []*Function       `json:"functions,omitempty"`     // Deprecated: 使用 Tools
+	FunctionCall        IFunctionCall     `json:"function_call,omitempty"` // Deprecated: 使用 ToolChoice
+	Tools               []*Tool           `json:"tools,omitempty"`
+	ToolChoice          IToolChoice       `json:"tool_choice,omitempty"`
+	ParallelToolCalls   *bool             `json:"parallel_tool_calls,omitempty"`
+	ResponseFormat      *ResponseFormat   `json:"response_format,omitempty"`
+	Temperature         float64           `json:"temperature,omitempty"`
+	TopP                float64           `json:"top_p,omitempty"`
+	N                   int64             `json:"n,omitempty"`
+	Stream              bool              `json:"stream,omitempty"`
+	StreamOptions       *StreamOptions    `json:"stream_options,omitempty"` // 只能在 Stream 为 true 时设置
+	Stop                []string          `json:"stop,omitempty"`
+	MaxTokens           int64             `json:"max_tokens,omitempty"`            // Deprecated: 使用 MaxCompletionTokens，o 系列的推理模型不支持该参数
+	MaxCompletionTokens int64             `json:"max_completion_tokens,omitempty"` // 生成的 token 数量上限，包括推理模型不可见的推理 token
+	PresencePenalty     float64           `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    float64           `json:"frequency_penalty,omitempty"`
+	LogitBias           map[string]int64  `json:"logit_bias,omitempty"`
+	Logprobs            bool              `json:"logprobs,omitempty"`
+	TopLogprobs         *int64            `json:"top_logprobs,omitempty"`     // 0 到 20，需要同时设置 Logprobs
+	Seed                *int64            `json:"seed,omitempty"`             // 尽量保证相同的 seed 和参数返回相同的结果，配合 SystemFingerprint 判断后端是否变化
+	ReasoningEffort     string            `json:"reasoning_effort,omitempty"` // 只用于推理模型，low, medium, high
+	Store               *bool             `json:"store,omitempty"`
+	Metadata            map[string]string `json:"metadata,omitempty"`
+	ServiceTier         string            `json:"service_tier,omitempty"` // auto, default, flex
+	Modalities          []string          `json:"modalities,omitempty"`   // text, audio
+	Prediction          *Prediction       `json:"prediction,omitempty"`
+	User                string            `json:"user,omitempty"`
 }
 
+// UnmarshalJSON function_call 和 tool_choice 既可能是字符串也可能是对象，需要根据内容选择具体的类型
+func (c *ChatCreateRequest) UnmarshalJSON(data []byte) error {
+	type alias ChatCreateRequest
+	aux := struct {
+		*alias
+		FunctionCall json.RawMessage `json:"function_call,omitempty"`
+		ToolChoice   json.RawMessage `json:"tool_choice,omitempty"`
+	}{
+		alias: (*alias)(c),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	c.FunctionCall = nil
+	if len(aux.FunctionCall) > 0 && string(aux.FunctionCall) != "null" {
+		var s FunctionCallString
+		if err := json.Unmarshal(aux.FunctionCall, &s); err == nil {
+			c.FunctionCall = s
+		} else {
+			var f FunctionCall
+			if err := json.Unmarshal(aux.FunctionCall, &f); err != nil {
+				return err
+			}
+			c.FunctionCall = f
+		}
+	}
+
+	c.ToolChoice = nil
+	if len(aux.ToolChoice) > 0 && string(aux.ToolChoice) != "null" {
+		var s ToolChoiceString
+		if err := json.Unmarshal(aux.ToolChoice, &s); err == nil {
+			c.ToolChoice = s
+		} else {
+			var f ToolChoiceFunction
+			if err := json.Unmarshal(aux.ToolChoice, &f); err != nil {
+				return err
+			}
+			c.ToolChoice = f
+		}
+	}
+
+	return nil
+}
+
+type StreamOptions struct {
+	// IncludeUsage 为 true 时会在 [DONE] 之前额外返回一个 chunk，其中 Choices 为空，Usage 为整个请求的用量
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// Prediction 预测的输出内容，比如重新生成一个只有少量修改的文件时，可以显著降低延迟
+type Prediction struct {
+	Type    string `json:"type"` // content only
+	Content string `json:"content"`
+}
+
+// NewPrediction 使用预测的输出内容创建 Prediction
+func NewPrediction(content string) *Prediction {
+	return &Prediction{
+		Type:    PredictionTypeContent,
+		Content: content,
+	}
+}
+
+// ResponseFormat 指定模型输出的格式，Type 为 json_schema 时需要设置 JSONSchema
+type ResponseFormat struct {
+	Type       string              `json:"type"` // text, json_object, json_schema
+	JSONSchema *ResponseJSONSchema `json:"json_schema,omitempty"`
+}
+
+type ResponseJSONSchema struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Schema      *Property `json:"schema"`
+	// Strict 为 true 时模型的输出严格遵守 schema，此时所有字段都必须是 required，并且 additionalProperties 为 false
+	Strict bool `json:"strict,omitempty"`
+}
+
+// IFunctionCall function_call 的取值，可以是 FunctionCallNone、FunctionCallAuto 或者 FunctionCall{Name: "xxx"} 强制调用指定的函数
 type IFunctionCall interface {
 	Call()
 }
 
+// IToolChoice tool_choice 的取值，可以是 ToolChoiceNone、ToolChoiceAuto、ToolChoiceRequired 或者 NewToolChoiceFunction("xxx") 强制调用指定的函数
+type IToolChoice interface {
+	toolChoice()
+}
+
+type Tool struct {
+	Type     string    `json:"type"` // function only
+	Function *Function `json:"function"`
+}
+
+// NewFunctionTool 将 Function 包装为 Tool
+func NewFunctionTool(f *Function) *Tool {
+	return &Tool{
+		Type:     ToolTypeFunction,
+		Function: f,
+	}
+}
+
+type ToolChoiceString string
+
+func (t ToolChoiceString) toolChoice() {}
+
+// ToolChoiceFunction 强制模型调用指定的函数，序列化为 {"type": "function", "function": {"name": "xxx"}}
+type ToolChoiceFunction struct {
+	Type     string       `json:"type"` // function only
+	Function FunctionName `json:"function"`
+}
+
+func (t ToolChoiceFunction) toolChoice() {}
+
+type FunctionName struct {
+	Name string `json:"name"`
+}
+
+func NewToolChoiceFunction(name string) ToolChoiceFunction {
+	return ToolChoiceFunction{
+		Type:     ToolTypeFunction,
+		Function: FunctionName{Name: name},
+	}
+}
+
 type Function struct {
 	Name        string     `json:"name"`
 	Description string     `json:"description,omitempty"`
 	Parameters  *Parameter `json:"parameters,omitempty"`
 }
 
-type Parameter struct {
-	Type       string               `json:"type"` // object only
-	Properties map[string]*Property `json:"properties,omitempty"`
-	Required   []string             `json:"required,omitempty"`
-}
+// Parameter 函数参数的 JSON Schema，顶层的 Type 必须为 object，可以通过 GenerateSchema 从 Go 的结构体生成
+type Parameter = Property
 
+// Property JSON Schema，字段与 JSON Schema draft 2020-12 对应
 type Property struct {
-	Type        string               `json:"type"`
-	Description string               `json:"description,omitempty"`
-	Properties  map[string]*Property `json:"properties,omitempty"` // if type is object, this field will be set
+	Type        string `json:"type,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// object
+	Properties           map[string]*Property `json:"properties,omitempty"` // if type is object, this field will be set
+	Required             []string             `json:"required,omitempty"`
+	AdditionalProperties any                  `json:"additionalProperties,omitempty"` // bool 或者 *Property
+	MinProperties        *int64               `json:"minProperties,omitempty"`
+	MaxProperties        *int64               `json:"maxProperties,omitempty"`
+
+	// array
+	Items       *Property `json:"items,omitempty"`
+	MinItems    *int64    `json:"minItems,omitempty"`
+	MaxItems    *int64    `json:"maxItems,omitempty"`
+	UniqueItems bool      `json:"uniqueItems,omitempty"`
+
+	// string
+	Format    string `json:"format,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+	MinLength *int64 `json:"minLength,omitempty"`
+	MaxLength *int64 `json:"maxLength,omitempty"`
+
+	// number and integer
+	Minimum          *float64 `json:"minimum,omitempty"`
+	Maximum          *float64 `json:"maximum,omitempty"`
+	ExclusiveMinimum *float64 `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum *float64 `json:"exclusiveMaximum,omitempty"`
+	MultipleOf       *float64 `json:"multipleOf,omitempty"`
+
+	// any type
+	Enum    []any `json:"enum,omitempty"`
+	Const   any   `json:"const,omitempty"`
+	Default any   `json:"default,omitempty"`
+
+	// composition
+	AnyOf []*Property `json:"anyOf,omitempty"`
+	OneOf []*Property `json:"oneOf,omitempty"`
+	AllOf []*Property `json:"allOf,omitempty"`
+	Not   *Property   `json:"not,omitempty"`
+
+	// reference
+	Ref  string               `json:"$ref,omitempty"`
+	Defs map[string]*Property `json:"$defs,omitempty"`
 }
 
 type Message struct {
-	Role         string       `json:"role"` // user,assistant,system,function
-	Content      string       `json:"content,omitempty"`
-	Name         string       `json:"name,omitempty"`
-	FunctionCall FunctionCall `json:"function_call,omitempty"`
+	Role         string         `json:"role"` // user,assistant,system,function,tool
+	Content      string         `json:"content,omitempty"`
+	MultiContent []*ContentPart `json:"-"`                 // 由文本、图片等多个部分组成的内容，不为空时代替 Content 序列化为数组
+	Refusal      string         `json:"refusal,omitempty"` // 模型拒绝回答时的说明，此时 Content 为空
+	Name         string         `json:"name,omitempty"`
+	FunctionCall FunctionCall   `json:"function_call,omitempty"` // Deprecated: 使用 ToolCalls，没有调用函数时 Name 为空，序列化时会被忽略
+	ToolCalls    []*ToolCall    `json:"tool_calls,omitempty"`    // assistant 消息中模型要求调用的工具
+	ToolCallId   string         `json:"tool_call_id,omitempty"`  // tool 消息对应的 ToolCall.Id
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	type alias Message
+	aux := struct {
+		alias
+		Content      any           `json:"content,omitempty"`
+		FunctionCall *FunctionCall `json:"function_call,omitempty"`
+	}{
+		alias: alias(m),
+	}
+
+	switch {
+	case len(m.MultiContent) > 0:
+		aux.Content = m.MultiContent
+	case m.Content != "" || m.Role == RoleTool || m.Role == RoleFunction:
+		// 工具和函数的结果必须带有 content，即使为空
+		aux.Content = m.Content
+	}
+
+	if m.HasFunctionCall() {
+		aux.FunctionCall = &m.FunctionCall
+	}
+
+	return json.Marshal(aux)
+}
+
+// HasFunctionCall 判断模型是否通过旧的 function_call 方式调用了函数
+func (m *Message) HasFunctionCall() bool {
+	return m.FunctionCall.Name != "" || m.FunctionCall.Arguments != ""
+}
+
+// UnmarshalJSON content 可以是字符串，也可以是 ContentPart 的数组
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type alias Message
+	aux := struct {
+		*alias
+		Content json.RawMessage `json:"content,omitempty"`
+	}{
+		alias: (*alias)(m),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	m.Content, m.MultiContent = "", nil
+	if len(aux.Content) == 0 || string(aux.Content) == "null" {
+		return nil
+	}
+
+	if aux.Content[0] == '[' {
+		return json.Unmarshal(aux.Content, &m.MultiContent)
+	}
+
+	return json.Unmarshal(aux.Content, &m.Content)
+}
+
+// Text 返回消息中的文本，包括 MultiContent 中所有的文本部分
+func (m *Message) Text() string {
+	if len(m.MultiContent) == 0 {
+		return m.Content
+	}
+
+	var texts []string
+	if m.Content != "" {
+		texts = append(texts, m.Content)
+	}
+	for _, part := range m.MultiContent {
+		if part.Type == ContentPartTypeText {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+const (
+	ContentPartTypeText       = "text"
+	ContentPartTypeImageURL   = "image_url"
+	ContentPartTypeInputAudio = "input_audio"
+
+	ImageDetailAuto = "auto"
+	ImageDetailLow  = "low"
+	ImageDetailHigh = "high"
+
+	AudioFormatWav = "wav"
+	AudioFormatMp3 = "mp3"
+)
+
+// ContentPart 消息内容的一部分，根据 Type 设置 Text、ImageURL 或者 InputAudio
+type ContentPart struct {
+	Type       string      `json:"type"` // text, image_url, input_audio
+	Text       string      `json:"text,omitempty"`
+	ImageURL   *ImageURL   `json:"image_url,omitempty"`
+	InputAudio *InputAudio `json:"input_audio,omitempty"`
+}
+
+type ImageURL struct {
+	URL    string `json:"url"`              // 图片的地址或者 base64 编码的 data URL
+	Detail string `json:"detail,omitempty"` // auto, low, high
+}
+
+type InputAudio struct {
+	Data   string `json:"data"`   // base64 编码的音频
+	Format string `json:"format"` // wav, mp3
+}
+
+func NewTextPart(text string) *ContentPart {
+	return &ContentPart{
+		Type: ContentPartTypeText,
+		Text: text,
+	}
+}
+
+// NewImageURLPart url 可以是图片的地址，也可以是 ImageToDataURL 等函数生成的 data URL
+func NewImageURLPart(url string, detail string) *ContentPart {
+	return &ContentPart{
+		Type:     ContentPartTypeImageURL,
+		ImageURL: &ImageURL{URL: url, Detail: detail},
+	}
+}
+
+// NewInputAudioPart data 为音频文件的原始内容
+func NewInputAudioPart(data []byte, format string) *ContentPart {
+	return &ContentPart{
+		Type: ContentPartTypeInputAudio,
+		InputAudio: &InputAudio{
+			Data:   base64.StdEncoding.EncodeToString(data),
+			Format: format,
+		},
+	}
 }
 
 type FunctionCall struct {
@@ -85,36 +431,74 @@ type FunctionCall struct {
 	Arguments string `json:"arguments,omitempty"`
 }
 
-func (f FunctionCall) Call() {
-	//TODO implement me
-	panic("implement me")
-}
+// Call 仅用于实现 IFunctionCall，没有实际作用
+func (f FunctionCall) Call() {}
 
 type FunctionCallString string
 
-func (f FunctionCallString) Call() {
-	//TODO implement me
-	panic("implement me")
+// Call 仅用于实现 IFunctionCall，没有实际作用
+func (f FunctionCallString) Call() {}
+
+type ToolCall struct {
+	Index    *int64       `json:"index,omitempty"` // 只在 stream 模式下的 Delta 中返回，用于区分并行的多个调用
+	Id       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"` // function only
+	Function FunctionCall `json:"function"`
 }
 
 type ChatCreateResponse struct {
-	Id      string            `json:"id"`
-	Object  string            `json:"object"`
-	Created int64             `json:"created"`
-	Choices []*ChatCompletion `json:"choices"`
-	Usage   Usage             `json:"usage"`
+	Id                string            `json:"id"`
+	Object            string            `json:"object"`
+	Created           int64             `json:"created"`
+	Model             string            `json:"model,omitempty"`
+	SystemFingerprint string            `json:"system_fingerprint,omitempty"` // 后端配置的标识，与 Seed 一起用于判断结果是否可以复现
+	ServiceTier       string            `json:"service_tier,omitempty"`
+	Choices           []*ChatCompletion `json:"choices"`
+	Usage             Usage             `json:"usage"`
+
+	// err 流式模式下流异常中断时的错误，只会出现在 channel 的最后一个元素上
+	err error
+}
+
+// Err 返回流异常中断的原因，为 nil 表示这是一个正常的响应
+func (c *ChatCreateResponse) Err() error {
+	return c.err
 }
 
 type ChatCompletion struct {
-	Index        int64    `json:"index"`
-	Delta        *Delta   `json:"delta"`
-	Message      *Message `json:"message"`
-	FinishReason string   `json:"finish_reason"`
+	Index        int64         `json:"index"`
+	Delta        *Delta        `json:"delta"`
+	Message      *Message      `json:"message"`
+	Logprobs     *ChatLogprobs `json:"logprobs,omitempty"` // 只在请求设置了 Logprobs 时返回
+	FinishReason string        `json:"finish_reason"`
+}
+
+// ChatLogprobs 每个输出 token 的对数概率，stream 模式下每个 chunk 只包含对应的 token
+type ChatLogprobs struct {
+	Content []*TokenLogprob `json:"content"`
+	Refusal []*TokenLogprob `json:"refusal,omitempty"`
+}
+
+type TokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	// Bytes token 的 UTF-8 字节，一个字符可能被拆分为多个 token，此时需要合并字节才能得到完整的字符
+	Bytes       []int         `json:"bytes"`
+	TopLogprobs []*TopLogprob `json:"top_logprobs"` // 概率最高的 TopLogprobs 个候选 token
+}
+
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
 }
 
 type Delta struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role         string        `json:"role"`
+	Content      string        `json:"content"`
+	Refusal      string        `json:"refusal,omitempty"`
+	FunctionCall *FunctionCall `json:"function_call,omitempty"` // stream 模式下 function call 的 name 和 arguments 也是分片返回的
+	ToolCalls    []*ToolCall   `json:"tool_calls,omitempty"`    // stream 模式下按照 ToolCall.Index 分片返回
 }
 
 type ChatServiceOp struct {
@@ -123,6 +507,7 @@ type ChatServiceOp struct {
 
 // Create 创建一个新的聊天，为了兼容 stream 模式，返回一个 channel，如果不是 stream 模式，返回的 channel 会在第一次返回后关闭
 // 如果是 stream 模式，返回的 channel 会在 ctx.Done() 或者 stream 关闭后关闭
+// 如果 stream 异常中断（连接断开、服务端返回错误、数据无法解析等），channel 的最后一个元素会携带错误，可以通过 Err 获取
 // 这里其实也可以考虑拆分为两个方法，一个是 Create，一个是 CreateStream，但是这样会导致 API 不一致，所以这里就不拆分了
 func (c ChatServiceOp) Create(ctx context.Context, req *ChatCreateRequest) (chan *ChatCreateResponse, error) {
 
@@ -140,40 +525,61 @@ func (c ChatServiceOp) Create(ctx context.Context, req *ChatCreateRequest) (chan
 	}
 
 	// 如果是 stream 模式，返回一个 channel，这个 channel 会在 ctx.Done() 或者 stream 关闭后关闭
-	es, err := c.client.PostByStream(ctx, ChatCreatePath, req)
+	stream, err := c.CreateStream(ctx, req)
 
 	if err != nil {
 		return nil, err
 	}
 
+	return chatStreamToChannel(ctx, stream, c.client.logger), nil
+}
+
+// chatStreamToChannel 将 Stream 转换为 channel，channel 会在 ctx.Done() 或者 stream 结束后关闭，
+// stream 异常中断时 channel 的最后一个元素会携带错误
+func chatStreamToChannel(ctx context.Context, stream *Stream[*ChatCreateResponse], logger logr.Logger) chan *ChatCreateResponse {
 	res := make(chan *ChatCreateResponse)
 
 	go func() {
-		defer close(res)
+		// 读取结束后需要主动断开连接，避免消费协程提前退出时底层连接一直占用到 ctx 被取消
+		defer func() {
+			_ = stream.Close()
+			close(res)
+		}()
 
-		for {
+		for stream.Next() {
 			select {
 			case <-ctx.Done():
 				return
-			case e, ok := <-es:
-				if !ok {
-					return
-				}
-				var resp ChatCreateResponse
-				err := json.Unmarshal([]byte(e.Data), &resp)
-				if err != nil {
-					c.client.logger.Error(err, "failed to unmarshal chat response")
-					continue
-				}
-				select {
-				case <-ctx.Done():
-					return
-				case res <- &resp:
-				}
+			case res <- stream.Current():
 			}
 		}
 
+		err := stream.Err()
+
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		// 流异常中断，通过最后一个元素将错误返回给消费者
+		logger.Error(err, "chat stream interrupted")
+
+		select {
+		case <-ctx.Done():
+		case res <- &ChatCreateResponse{err: err}:
+		}
+
 	}()
 
-	return res, nil
+	return res
+}
+
+// CreateStream 以 stream 模式创建一个新的聊天，返回一个迭代器风格的 Stream，无论 req.Stream 是否设置都会以 stream 模式请求
+// 与 Create 不同，消费者可以随时调用 Stream.Close 提前结束，底层连接会被立即断开
+func (c ChatServiceOp) CreateStream(ctx context.Context, req *ChatCreateRequest) (*Stream[*ChatCreateResponse], error) {
+	r := *req
+	r.Stream = true
+
+	return newStream[*ChatCreateResponse](ctx, func(ctx context.Context) (EventSource, error) {
+		return c.client.PostByStream(ctx, ChatCreatePath, &r)
+	})
 }
@@ -42,6 +42,8 @@ func TestChatServiceOp_Create(t *testing.T) {
 
 		if req.Functions != nil {
 			mockData = loadTestdata("chat_completion_for_function_call.json")
+		} else if req.Tools != nil {
+			mockData = loadTestdata("chat_completion_for_tool_calls.json")
 		} else {
 			mockData = loadTestdata("chat_completion_create_response.json")
 		}
@@ -184,6 +186,34 @@ func TestChatServiceOp_Create(t *testing.T) {
 			}(),
 			wantResCount: 1,
 		},
+		{
+			name: "test chat for parallel tool calls",
+			ctx:  context.TODO(),
+			req: func() *ChatCreateRequest {
+				r := mockReq
+				r.Stream = false
+				r.Tools = []*Tool{
+					NewFunctionTool(&Function{
+						Name: "get_weather",
+						Parameters: &Parameter{
+							Type: "object",
+							Properties: map[string]*Property{
+								"location": {Type: "string"},
+							},
+							Required: []string{"location"},
+						},
+					}),
+				}
+				r.ToolChoice = ToolChoiceRequired
+				return &r
+			}(),
+			wantRes: func() *ChatCreateResponse {
+				var wantRes ChatCreateResponse
+				loadMockData("chat_completion_for_tool_calls.json", &wantRes)
+				return &wantRes
+			}(),
+			wantResCount: 1,
+		},
 	}
 
 	for _, tc := range testCase {
@@ -216,6 +246,163 @@ func TestChatServiceOp_Create(t *testing.T) {
 	}
 }
 
+func TestChatCreateRequest_JSON(t *testing.T) {
+	parallel := false
+	seed, topLogprobs := int64(42), int64(0)
+
+	testCase := []struct {
+		name     string
+		req      *ChatCreateRequest
+		wantJSON string
+	}{
+		{
+			name: "test message without function call",
+			req: &ChatCreateRequest{
+				Model:    GPT35Turbo,
+				Messages: []*Message{{Role: RoleUser, Content: "Hello"}},
+			},
+			wantJSON: `{"model":"gpt-3.5-turbo","messages":[{"role":"user","content":"Hello"}]}`,
+		},
+		{
+			name: "test force legacy function call",
+			req: &ChatCreateRequest{
+				Model:        GPT35Turbo,
+				Functions:    []*Function{{Name: "echo"}},
+				FunctionCall: FunctionCall{Name: "echo"},
+			},
+			wantJSON: `{"model":"gpt-3.5-turbo","functions":[{"name":"echo"}],"function_call":{"name":"echo"}}`,
+		},
+		{
+			name: "test legacy function call auto",
+			req: &ChatCreateRequest{
+				Model:        GPT35Turbo,
+				Functions:    []*Function{{Name: "echo"}},
+				FunctionCall: FunctionCallAuto,
+			},
+			wantJSON: `{"model":"gpt-3.5-turbo","functions":[{"name":"echo"}],"function_call":"auto"}`,
+		},
+		{
+			name: "test tool choice required",
+			req: &ChatCreateRequest{
+				Model:             GPT35Turbo,
+				Tools:             []*Tool{NewFunctionTool(&Function{Name: "echo"})},
+				ToolChoice:        ToolChoiceRequired,
+				ParallelToolCalls: &parallel,
+			},
+			wantJSON: `{"model":"gpt-3.5-turbo","tools":[{"type":"function","function":{"name":"echo"}}],"tool_choice":"required","parallel_tool_calls":false}`,
+		},
+		{
+			name: "test force tool function",
+			req: &ChatCreateRequest{
+				Model:      GPT35Turbo,
+				Tools:      []*Tool{NewFunctionTool(&Function{Name: "echo"})},
+				ToolChoice: NewToolChoiceFunction("echo"),
+			},
+			wantJSON: `{"model":"gpt-3.5-turbo","tools":[{"type":"function","function":{"name":"echo"}}],"tool_choice":{"type":"function","function":{"name":"echo"}}}`,
+		},
+		{
+			name: "test tool messages",
+			req: &ChatCreateRequest{
+				Model: GPT35Turbo,
+				Messages: []*Message{
+					{
+						Role: RoleAssistant,
+						ToolCalls: []*ToolCall{
+							{Id: "call_1", Type: ToolTypeFunction, Function: FunctionCall{Name: "echo", Arguments: "{}"}},
+						},
+					},
+					{Role: RoleTool, Content: "ok", ToolCallId: "call_1"},
+				},
+			},
+			wantJSON: `{"model":"gpt-3.5-turbo","messages":[{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"echo","arguments":"{}"}}]},{"role":"tool","content":"ok","tool_call_id":"call_1"}]}`,
+		},
+		{
+			name: "test legacy function call messages",
+			req: &ChatCreateRequest{
+				Model: GPT35Turbo,
+				Messages: []*Message{
+					{Role: RoleUser, Content: "hi"},
+					{Role: RoleAssistant, FunctionCall: FunctionCall{Name: "echo", Arguments: "{}"}},
+					{Role: RoleFunction, Name: "echo", Content: "ok"},
+				},
+			},
+			wantJSON: `{"model":"gpt-3.5-turbo","messages":[{"role":"user","content":"hi"},{"role":"assistant","function_call":{"name":"echo","arguments":"{}"}},{"role":"function","content":"ok","name":"echo"}]}`,
+		},
+		{
+			name: "test empty tool message keeps content",
+			req: &ChatCreateRequest{
+				Model:    GPT35Turbo,
+				Messages: []*Message{{Role: RoleTool, ToolCallId: "call_1"}},
+			},
+			wantJSON: `{"model":"gpt-3.5-turbo","messages":[{"role":"tool","content":"","tool_call_id":"call_1"}]}`,
+		},
+		{
+			name: "test modern fields",
+			req: &ChatCreateRequest{
+				Model:               "o3-mini",
+				Messages:            []*Message{{Role: RoleUser, Content: "Hello"}},
+				Stream:              true,
+				StreamOptions:       &StreamOptions{IncludeUsage: true},
+				MaxCompletionTokens: 1024,
+				Logprobs:            true,
+				TopLogprobs:         &topLogprobs,
+				Seed:                &seed,
+				ReasoningEffort:     ReasoningEffortLow,
+				Store:               &parallel,
+				Metadata:            map[string]string{"user": "u1"},
+				ServiceTier:         ServiceTierFlex,
+				Modalities:          []string{ModalityText},
+				Prediction:          NewPrediction("Hello"),
+			},
+			wantJSON: `{"model":"o3-mini","messages":[{"role":"user","content":"Hello"}],"stream":true,"stream_options":{"include_usage":true},
+				"max_completion_tokens":1024,"logprobs":true,"top_logprobs":0,"seed":42,"reasoning_effort":"low","store":false,
+				"metadata":{"user":"u1"},"service_tier":"flex","modalities":["text"],"prediction":{"type":"content","content":"Hello"}}`,
+		},
+		{
+			name: "test multimodal message",
+			req: &ChatCreateRequest{
+				Model: "gpt-4o",
+				Messages: []*Message{
+					{
+						Role: RoleUser,
+						MultiContent: []*ContentPart{
+							NewTextPart("What's in this image?"),
+							NewImageURLPart("https://example.com/a.png", ImageDetailHigh),
+							NewInputAudioPart([]byte("RIFF"), AudioFormatWav),
+						},
+					},
+				},
+			},
+			wantJSON: `{"model":"gpt-4o","messages":[{"role":"user","content":[{"type":"text","text":"What's in this image?"},{"type":"image_url","image_url":{"url":"https://example.com/a.png","detail":"high"}},{"type":"input_audio","input_audio":{"data":"UklGRg==","format":"wav"}}]}]}`,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := json.Marshal(tc.req)
+			require.NoError(t, err)
+			require.JSONEq(t, tc.wantJSON, string(b))
+
+			var got ChatCreateRequest
+			require.NoError(t, json.Unmarshal(b, &got))
+			require.Equal(t, tc.req, &got)
+		})
+	}
+}
+
+func TestMessage_Text(t *testing.T) {
+	require.Equal(t, "hello", (&Message{Content: "hello"}).Text())
+	require.Equal(t, "a\nb", (&Message{MultiContent: []*ContentPart{
+		NewTextPart("a"),
+		NewImageURLPart("https://example.com/a.png", ""),
+		NewTextPart("b"),
+	}}).Text())
+
+	var m Message
+	require.NoError(t, json.Unmarshal([]byte(`{"role":"user","content":null}`), &m))
+	require.Equal(t, Message{Role: RoleUser}, m)
+}
+
 func mockOutputWithStream(ctx context.Context, w http.ResponseWriter, data []byte, count int) {
 
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -243,3 +430,110 @@ func mockOutputWithStream(ctx context.Context, w http.ResponseWriter, data []byt
 	_, _ = w.Write([]byte("data:"))
 	_, _ = w.Write([]byte("[DONE]"))
 }
+
+func TestChatServiceOp_Create_StreamInterrupted(t *testing.T) {
+	chunk := `{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"choices":[{"index":0,"delta":{"content":"Hello"},"finish_reason":null}]}`
+
+	testCase := []struct {
+		name          string
+		body          string
+		wantResCount  int
+		wantErr       bool
+		wantErrIs     error
+		wantAPIErrMsg string
+	}{
+		{
+			name:         "test chat stream finished",
+			body:         "data: " + chunk + "\n\ndata: " + chunk + "\n\ndata: [DONE]\n\n",
+			wantResCount: 2,
+		},
+		{
+			name:         "test chat stream truncated",
+			body:         "data: " + chunk + "\n\n",
+			wantResCount: 1,
+			wantErr:      true,
+			wantErrIs:    io.ErrUnexpectedEOF,
+		},
+		{
+			name:          "test chat stream with error payload",
+			body:          "data: " + chunk + "\n\ndata: {\"error\":{\"message\":\"The server had an error\",\"type\":\"server_error\"}}\n\n",
+			wantResCount:  1,
+			wantErr:       true,
+			wantAPIErrMsg: "The server had an error",
+		},
+		{
+			name:         "test chat stream with invalid data",
+			body:         "data: " + chunk + "\n\ndata: {invalid\n\ndata: " + chunk + "\n\ndata: [DONE]\n\n",
+			wantResCount: 1,
+			wantErr:      true,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newMockServer(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				_, _ = w.Write([]byte(tc.body))
+			})
+			defer server.Close()
+
+			client := newMockClient(server.URL)
+
+			res, err := client.Chat.Create(context.TODO(), &ChatCreateRequest{
+				Model:    GPT35Turbo,
+				Messages: []*Message{{Role: "user", Content: "Hello"}},
+				Stream:   true,
+			})
+			require.NoError(t, err)
+
+			count := 0
+			var streamErr error
+			for r := range res {
+				if r.Err() != nil {
+					streamErr = r.Err()
+					continue
+				}
+				require.Equal(t, "Hello", r.Choices[0].Delta.Content)
+				count++
+			}
+
+			require.Equal(t, tc.wantResCount, count)
+
+			if !tc.wantErr {
+				require.NoError(t, streamErr)
+				return
+			}
+
+			require.Error(t, streamErr)
+
+			if tc.wantErrIs != nil {
+				require.ErrorIs(t, streamErr, tc.wantErrIs)
+			}
+
+			if tc.wantAPIErrMsg != "" {
+				var apiErr *APIError
+				require.ErrorAs(t, streamErr, &apiErr)
+				require.Equal(t, tc.wantAPIErrMsg, apiErr.Message)
+			}
+		})
+	}
+}
+
+func TestMessage_FunctionCall(t *testing.T) {
+	var withCall, withoutCall ChatCreateResponse
+	loadMockData("chat_completion_for_function_call.json", &withCall)
+	loadMockData("chat_completion_create_response.json", &withoutCall)
+
+	msg := withCall.Choices[0].Message
+	require.True(t, msg.HasFunctionCall())
+	require.Equal(t, "generate_image", msg.FunctionCall.Name)
+
+	// 没有调用函数时可以直接访问，不会 panic
+	msg = withoutCall.Choices[0].Message
+	require.False(t, msg.HasFunctionCall())
+	require.Empty(t, msg.FunctionCall.Name)
+
+	b, err := json.Marshal(msg)
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "function_call")
+}
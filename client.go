@@ -103,10 +103,18 @@ func New(app App, opts ...Option) (*Client, error) {
 		client: c,
 	}
 
+	c.FineTuningJobs = &FineTuningJobServiceOp{
+		client: c,
+	}
+
 	c.Moderations = &ModerationServiceOp{
 		client: c,
 	}
 
+	c.Batches = &BatchServiceOp{
+		client: c,
+	}
+
 	for _, opt := range opts {
 		opt(c)
 	}
@@ -178,6 +186,14 @@ func WithLogger(logger logr.Logger) Option {
 	}
 }
 
+// WithStreamReconnect 设置流式连接断开后的自动重连策略，只对 GetByStream 生效，
+// POST 请求（例如 chat）重新发送会重新生成内容，因此不会自动重连
+func WithStreamReconnect(policy ReconnectPolicy) Option {
+	return func(c *Client) {
+		c.reconnect = &policy
+	}
+}
+
 // WithVersion 设置默认版本，如果不设置，默认为v1
 func WithVersion(version string) Option {
 	return func(c *Client) {
@@ -200,6 +216,8 @@ type Client struct {
 	apiKey  string
 	retries int
 
+	reconnect *ReconnectPolicy
+
 	formBuilder func(w io.Writer) FormBuilder
 
 	logger logr.Logger
@@ -214,6 +232,10 @@ type Client struct {
 	Files       FileService
 	FineTunes   FineTuneService
 	Moderations ModerationService
+	Batches     BatchService
+
+	// FineTuningJobs 新的训练接口，FineTunes 对应的 /fine-tunes 接口已经废弃，只用于兼容旧的账号
+	FineTuningJobs FineTuningJobService
 }
 
 // V 设置版本,返回一个新的Client实例，不会修改原有实例
@@ -229,6 +251,9 @@ func (c *Client) Close() error {
 }
 
 func (c *Client) GetByStream(ctx context.Context, relPath string, params any) (EventSource, error) {
+	if c.reconnect != nil {
+		return c.streamWithReconnect(ctx, http.MethodGet, relPath, params, nil, *c.reconnect)
+	}
 	return c.Stream(ctx, http.MethodGet, relPath, nil, params, nil)
 }
 
@@ -265,6 +290,8 @@ func (c *Client) Stream(ctx context.Context, method, relPath string, headers map
 }
 
 // NewEventSource 处理SSE
+// 如果流在收到 doneStr 之前就结束了（读取出错或者提前 EOF），会额外发送一个携带 Err 的 Event，
+// 以便消费者区分正常结束和被截断的流；如果 doneStr 为空，则 EOF 视为正常结束
 func NewEventSource(ctx context.Context, r io.ReadCloser, doneStr string) EventSource {
 	es := make(EventSource)
 
@@ -278,6 +305,10 @@ func NewEventSource(ctx context.Context, r io.ReadCloser, doneStr string) EventS
 		for scanner.Scan() {
 			line := scanner.Text()
 			if line == "" {
+				// 只有注释（例如心跳）的空事件直接忽略
+				if event == (Event{}) {
+					continue
+				}
 				select {
 				case <-ctx.Done():
 					return
@@ -288,23 +319,47 @@ func NewEventSource(ctx context.Context, r io.ReadCloser, doneStr string) EventS
 				event.Event = strings.TrimSpace(line[len("event:"):])
 			} else if strings.HasPrefix(line, "data:") {
 				event.Data = strings.TrimSpace(line[len("data:"):])
-				if event.Data == doneStr {
+				if doneStr != "" && event.Data == doneStr {
 					return
 				}
 			} else if strings.HasPrefix(line, "id:") {
 				event.Id = strings.TrimSpace(line[len("id:"):])
 			} else if strings.HasPrefix(line, "retry:") {
-				duration, err := time.ParseDuration(strings.TrimSpace(line[len("retry:"):]))
-				if err != nil {
-					event.Err = err
-				} else {
-					event.Retry = duration
+				// 按照 SSE 规范，retry 的值为毫秒数，非法的值直接忽略
+				ms, err := strconv.ParseInt(strings.TrimSpace(line[len("retry:"):]), 10, 64)
+				if err == nil {
+					event.Retry = time.Duration(ms) * time.Millisecond
 				}
 			}
 			if ctx.Err() != nil {
 				return
 			}
 		}
+
+		// 由消费者主动取消的情况，消费者可以通过 ctx 感知，这里不再额外发送错误
+		if ctx.Err() != nil {
+			return
+		}
+
+		err := scanner.Err()
+
+		if err == nil {
+			if doneStr == "" {
+				if event.Data != "" {
+					select {
+					case <-ctx.Done():
+					case es <- event:
+					}
+				}
+				return
+			}
+			err = io.ErrUnexpectedEOF
+		}
+
+		select {
+		case <-ctx.Done():
+		case es <- Event{Err: err}:
+		}
 	}()
 
 	return es
@@ -320,6 +375,28 @@ type Event struct {
 	Err   error
 }
 
+// decodeEvent 将事件中的数据解码到 v 中
+// 事件本身携带的错误、服务端在流中返回的 {"error": {...}} 以及解码失败都会以 error 的形式返回
+func decodeEvent(e Event, v any) error {
+	if e.Err != nil {
+		return e.Err
+	}
+
+	var payload struct {
+		Error *APIError `json:"error"`
+	}
+
+	if err := json.Unmarshal([]byte(e.Data), &payload); err != nil {
+		return err
+	}
+
+	if payload.Error != nil {
+		return payload.Error
+	}
+
+	return json.Unmarshal([]byte(e.Data), v)
+}
+
 func (c *Client) Post(ctx context.Context, relPath string, body, resp any) error {
 	return c.Do(ctx, http.MethodPost, relPath, nil, nil, body, resp)
 }
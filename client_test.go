@@ -15,13 +15,16 @@
 package openai
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/require"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -83,3 +86,71 @@ func loadMockData(filename string, out any) {
 		panic(fmt.Sprintf("decode mock data error: %s", err))
 	}
 }
+
+func TestNewEventSource(t *testing.T) {
+	testCase := []struct {
+		name       string
+		body       string
+		doneStr    string
+		wantEvents []Event
+		wantErr    error
+	}{
+		{
+			name:    "test event source finished with done",
+			body:    "id: 1\nretry: 3000\ndata: foo\n\ndata: bar\n\ndata: [DONE]\n\n",
+			doneStr: "[DONE]",
+			wantEvents: []Event{
+				{Id: "1", Data: "foo", Retry: 3 * time.Second},
+				{Data: "bar"},
+			},
+		},
+		{
+			name:    "test event source truncated without done",
+			body:    "data: foo\n\ndata: bar\n\n",
+			doneStr: "[DONE]",
+			wantEvents: []Event{
+				{Data: "foo"},
+				{Data: "bar"},
+			},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "test event source without done str",
+			body:    "data: foo\n\ndata: bar",
+			doneStr: "",
+			wantEvents: []Event{
+				{Data: "foo"},
+				{Data: "bar"},
+			},
+		},
+		{
+			name:    "test event source ignore invalid retry",
+			body:    "retry: 3s\ndata: foo\n\ndata: [DONE]\n\n",
+			doneStr: "[DONE]",
+			wantEvents: []Event{
+				{Data: "foo"},
+			},
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			es := NewEventSource(context.TODO(), io.NopCloser(strings.NewReader(tc.body)), tc.doneStr)
+
+			var (
+				events []Event
+				err    error
+			)
+			for e := range es {
+				if e.Err != nil {
+					err = e.Err
+					continue
+				}
+				events = append(events, e)
+			}
+
+			require.Equal(t, tc.wantEvents, events)
+			require.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+}
@@ -16,7 +16,6 @@ package openai
 
 import (
 	"context"
-	"encoding/json"
 )
 
 const (
@@ -25,6 +24,7 @@ const (
 
 type CompletionService interface {
 	Create(ctx context.Context, req *CompletionCreateRequest) (chan *CompletionCreateResponse, error)
+	CreateStream(ctx context.Context, req *CompletionCreateRequest) (*Stream[*CompletionCreateResponse], error)
 }
 
 type CompletionCreateRequest struct {
@@ -53,6 +53,14 @@ type CompletionCreateResponse struct {
 	Model   string        `json:"model"`
 	Choices []*Completion `json:"choices"`
 	Usage   Usage         `json:"usage"`
+
+	// err 流式模式下流异常中断时的错误，只会出现在 channel 的最后一个元素上
+	err error
+}
+
+// Err 返回流异常中断的原因，为 nil 表示这是一个正常的响应
+func (c *CompletionCreateResponse) Err() error {
+	return c.err
 }
 
 type Completion struct {
@@ -64,9 +72,56 @@ type Completion struct {
 }
 
 type Usage struct {
-	PromptTokens     int64 `json:"prompt_tokens"`
-	CompletionTokens int64 `json:"completion_tokens"`
-	TotalTokens      int64 `json:"total_tokens"`
+	PromptTokens            int64                    `json:"prompt_tokens"`
+	CompletionTokens        int64                    `json:"completion_tokens"`
+	TotalTokens             int64                    `json:"total_tokens"`
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+type PromptTokensDetails struct {
+	CachedTokens int64 `json:"cached_tokens"` // 命中 prompt 缓存的 token 数量
+	AudioTokens  int64 `json:"audio_tokens,omitempty"`
+}
+
+type CompletionTokensDetails struct {
+	ReasoningTokens          int64 `json:"reasoning_tokens"` // 推理模型用于推理的 token 数量，不会出现在输出中，但是会计费
+	AudioTokens              int64 `json:"audio_tokens,omitempty"`
+	AcceptedPredictionTokens int64 `json:"accepted_prediction_tokens,omitempty"`
+	RejectedPredictionTokens int64 `json:"rejected_prediction_tokens,omitempty"`
+}
+
+// Add 返回 u 与 o 相加的结果，详情只在至少一方存在时才会设置
+func (u Usage) Add(o Usage) Usage {
+	res := Usage{
+		PromptTokens:     u.PromptTokens + o.PromptTokens,
+		CompletionTokens: u.CompletionTokens + o.CompletionTokens,
+		TotalTokens:      u.TotalTokens + o.TotalTokens,
+	}
+
+	if u.PromptTokensDetails != nil || o.PromptTokensDetails != nil {
+		res.PromptTokensDetails = &PromptTokensDetails{}
+		for _, d := range []*PromptTokensDetails{u.PromptTokensDetails, o.PromptTokensDetails} {
+			if d != nil {
+				res.PromptTokensDetails.CachedTokens += d.CachedTokens
+				res.PromptTokensDetails.AudioTokens += d.AudioTokens
+			}
+		}
+	}
+
+	if u.CompletionTokensDetails != nil || o.CompletionTokensDetails != nil {
+		res.CompletionTokensDetails = &CompletionTokensDetails{}
+		for _, d := range []*CompletionTokensDetails{u.CompletionTokensDetails, o.CompletionTokensDetails} {
+			if d != nil {
+				res.CompletionTokensDetails.ReasoningTokens += d.ReasoningTokens
+				res.CompletionTokensDetails.AudioTokens += d.AudioTokens
+				res.CompletionTokensDetails.AcceptedPredictionTokens += d.AcceptedPredictionTokens
+				res.CompletionTokensDetails.RejectedPredictionTokens += d.RejectedPredictionTokens
+			}
+		}
+	}
+
+	return res
 }
 
 type CompletionServiceOp struct {
@@ -75,6 +130,7 @@ type CompletionServiceOp struct {
 
 // Create 创建一个新的聊天，为了兼容 stream 模式，返回一个 channel，如果不是 stream 模式，返回的 channel 会在第一次返回后关闭
 // 如果是 stream 模式，返回的 channel 会在 ctx.Done() 或者 stream 关闭后关闭
+// 如果 stream 异常中断（连接断开、服务端返回错误、数据无法解析等），channel 的最后一个元素会携带错误，可以通过 Err 获取
 // 这里其实也可以考虑拆分为两个方法，一个是 Create，一个是 CreateStream，但是这样会导致 API 不一致，所以这里就不拆分了
 func (c CompletionServiceOp) Create(ctx context.Context, req *CompletionCreateRequest) (chan *CompletionCreateResponse, error) {
 
@@ -92,7 +148,7 @@ func (c CompletionServiceOp) Create(ctx context.Context, req *CompletionCreateRe
 	}
 
 	// 如果是 stream 模式，返回一个 channel，这个 channel 会在 ctx.Done() 或者 stream 关闭后关闭
-	es, err := c.client.PostByStream(ctx, CompletionsCreatePath, req)
+	stream, err := c.CreateStream(ctx, req)
 
 	if err != nil {
 		return nil, err
@@ -101,32 +157,46 @@ func (c CompletionServiceOp) Create(ctx context.Context, req *CompletionCreateRe
 	res := make(chan *CompletionCreateResponse)
 
 	go func() {
-		defer close(res)
+		// 读取结束后需要主动断开连接，避免消费协程提前退出时底层连接一直占用到 ctx 被取消
+		defer func() {
+			_ = stream.Close()
+			close(res)
+		}()
 
-		for {
+		for stream.Next() {
 			select {
 			case <-ctx.Done():
 				return
-			case e, ok := <-es:
-				if !ok {
-					return
-				}
-				var resp CompletionCreateResponse
-				err := json.Unmarshal([]byte(e.Data), &resp)
-				if err != nil {
-					c.client.logger.Error(err, "failed to unmarshal chat response")
-					continue
-				}
-				select {
-				case <-ctx.Done():
-					return
-				case res <- &resp:
-				}
+			case res <- stream.Current():
 			}
+		}
+
+		err := stream.Err()
 
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		// 流异常中断，通过最后一个元素将错误返回给消费者
+		c.client.logger.Error(err, "completion stream interrupted")
+
+		select {
+		case <-ctx.Done():
+		case res <- &CompletionCreateResponse{err: err}:
 		}
 
 	}()
 
 	return res, nil
 }
+
+// CreateStream 以 stream 模式创建一个新的补全，返回一个迭代器风格的 Stream，无论 req.Stream 是否设置都会以 stream 模式请求
+// 与 Create 不同，消费者可以随时调用 Stream.Close 提前结束，底层连接会被立即断开
+func (c CompletionServiceOp) CreateStream(ctx context.Context, req *CompletionCreateRequest) (*Stream[*CompletionCreateResponse], error) {
+	r := *req
+	r.Stream = true
+
+	return newStream[*CompletionCreateResponse](ctx, func(ctx context.Context) (EventSource, error) {
+		return c.client.PostByStream(ctx, CompletionsCreatePath, &r)
+	})
+}
@@ -176,3 +176,18 @@ func TestCompletionServiceOp_Create(t *testing.T) {
 		})
 	}
 }
+
+func TestUsage_Add(t *testing.T) {
+	a := Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15, PromptTokensDetails: &PromptTokensDetails{CachedTokens: 4}}
+	b := Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3, CompletionTokensDetails: &CompletionTokensDetails{ReasoningTokens: 2}}
+
+	require.Equal(t, Usage{
+		PromptTokens:            11,
+		CompletionTokens:        7,
+		TotalTokens:             18,
+		PromptTokensDetails:     &PromptTokensDetails{CachedTokens: 4},
+		CompletionTokensDetails: &CompletionTokensDetails{ReasoningTokens: 2},
+	}, a.Add(b))
+
+	require.Equal(t, Usage{PromptTokens: 2, TotalTokens: 2}, Usage{PromptTokens: 1, TotalTokens: 1}.Add(Usage{PromptTokens: 1, TotalTokens: 1}))
+}
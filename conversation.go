@@ -0,0 +1,586 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/uzziahlin/openai/tokenizer"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultSummaryKeep   = 4
+	defaultSummaryPrompt = "Summarize the conversation below in a few sentences. " +
+		"Keep the facts, decisions, names and open questions that are needed to continue the conversation."
+
+	// summaryMessageName 摘要消息的 name，用于区分摘要和用户设置的 system 消息
+	summaryMessageName = "conversation_summary"
+)
+
+// ErrContextWindowExceeded 裁剪之后消息仍然超过上下文长度的限制，比如最后一条消息本身就过长
+var ErrContextWindowExceeded = errors.New("openai: messages exceed the context window")
+
+// TokenCounter 计算 messages 和 functions 作为 prompt 的 token 数量
+type TokenCounter func(model string, messages []*Message, functions []*Function) (int, error)
+
+// FitFunc 判断 messages 是否满足上下文长度的限制
+type FitFunc func(messages []*Message) (bool, error)
+
+// TrimStrategy 消息超过上下文长度时的裁剪策略，返回的消息需要满足 fits，
+// 实现不能修改传入的 messages，最后一条消息必须保留
+type TrimStrategy interface {
+	Trim(ctx context.Context, model string, messages []*Message, fits FitFunc) ([]*Message, error)
+}
+
+// TrimStrategyFunc 将函数适配为 TrimStrategy
+type TrimStrategyFunc func(ctx context.Context, model string, messages []*Message, fits FitFunc) ([]*Message, error)
+
+func (f TrimStrategyFunc) Trim(ctx context.Context, model string, messages []*Message, fits FitFunc) ([]*Message, error) {
+	return f(ctx, model, messages, fits)
+}
+
+// DropOldest 保留所有的 system 消息，从最早的消息开始丢弃，直到满足限制
+func DropOldest() TrimStrategy {
+	return TrimStrategyFunc(func(ctx context.Context, model string, messages []*Message, fits FitFunc) ([]*Message, error) {
+		return dropUntilFits(messages, fits)
+	})
+}
+
+// KeepLastN 保留所有的 system 消息和最后 n 条其他消息，如果仍然超过限制，继续丢弃最早的消息
+func KeepLastN(n int) TrimStrategy {
+	return TrimStrategyFunc(func(ctx context.Context, model string, messages []*Message, fits FitFunc) ([]*Message, error) {
+		ok, err := fits(messages)
+		if err != nil || ok {
+			return messages, err
+		}
+
+		pinned, rest := splitPinned(messages, false)
+		return dropUntilFits(append(pinned, keepLast(rest, n)...), fits)
+	})
+}
+
+type summarizeConfig struct {
+	model  string
+	keep   int
+	prompt string
+}
+
+type SummarizeOption func(*summarizeConfig)
+
+// WithSummaryModel 生成摘要使用的模型，默认与对话使用的模型相同
+func WithSummaryModel(model string) SummarizeOption {
+	return func(c *summarizeConfig) {
+		c.model = model
+	}
+}
+
+// WithSummaryKeep 保留最后 n 条消息不参与摘要，默认为 4
+func WithSummaryKeep(n int) SummarizeOption {
+	return func(c *summarizeConfig) {
+		if n > 0 {
+			c.keep = n
+		}
+	}
+}
+
+// WithSummaryPrompt 生成摘要使用的 system 提示词
+func WithSummaryPrompt(prompt string) SummarizeOption {
+	return func(c *summarizeConfig) {
+		c.prompt = prompt
+	}
+}
+
+// SummarizeOlder 超过限制时，使用模型将除了 system 消息和最后几条消息以外的历史消息总结为一条 system 消息，
+// 之前生成的摘要会与新的消息一起重新总结。如果总结之后仍然超过限制，继续丢弃最早的消息
+func SummarizeOlder(chat ChatService, opts ...SummarizeOption) TrimStrategy {
+	cfg := &summarizeConfig{
+		keep:   defaultSummaryKeep,
+		prompt: defaultSummaryPrompt,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return TrimStrategyFunc(func(ctx context.Context, model string, messages []*Message, fits FitFunc) ([]*Message, error) {
+		ok, err := fits(messages)
+		if err != nil || ok {
+			return messages, err
+		}
+
+		pinned, rest := splitPinned(messages, true)
+		kept := keepLast(rest, cfg.keep)
+		older := rest[:len(rest)-len(kept)]
+		if len(older) == 0 {
+			return dropUntilFits(messages, fits)
+		}
+
+		summaryModel := cfg.model
+		if summaryModel == "" {
+			summaryModel = model
+		}
+
+		res, err := chat.Create(ctx, &ChatCreateRequest{
+			Model: summaryModel,
+			Messages: []*Message{
+				{Role: RoleSystem, Content: cfg.prompt},
+				{Role: RoleUser, Content: formatTranscript(older)},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := AccumulateChat(res)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(resp.Choices) == 0 || resp.Choices[0].Message == nil {
+			return nil, ErrNoChoices
+		}
+
+		summary := &Message{
+			Role:    RoleSystem,
+			Name:    summaryMessageName,
+			Content: "Summary of the earlier conversation:\n" + resp.Choices[0].Message.Content,
+		}
+
+		trimmed := append(append(pinned, summary), kept...)
+		return dropUntilFits(trimmed, fits)
+	})
+}
+
+// splitPinned 将 system 消息与其他消息分开，两者都保持原有的顺序，summaries 为 true 时之前生成的摘要不作为 system 消息
+func splitPinned(messages []*Message, summaries bool) (pinned, rest []*Message) {
+	for _, m := range messages {
+		if m.Role == RoleSystem && !(summaries && m.Name == summaryMessageName) {
+			pinned = append(pinned, m)
+			continue
+		}
+		rest = append(rest, m)
+	}
+	return pinned, rest
+}
+
+// keepLast 返回最后 n 条消息，开头的工具调用结果会被一起丢弃，因为对应的调用已经不在了
+func keepLast(messages []*Message, n int) []*Message {
+	if n < 1 {
+		n = 1
+	}
+
+	start := len(messages) - n
+	if start < 0 {
+		start = 0
+	}
+
+	for start < len(messages)-1 && isToolResult(messages[start]) {
+		start++
+	}
+
+	return messages[start:]
+}
+
+// dropUntilFits 保留 system 消息，从最早的消息开始丢弃，直到满足限制
+func dropUntilFits(messages []*Message, fits FitFunc) ([]*Message, error) {
+	messages = append(make([]*Message, 0, len(messages)), messages...)
+
+	for {
+		ok, err := fits(messages)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return messages, nil
+		}
+
+		var dropped bool
+		if messages, dropped = dropFirst(messages); !dropped {
+			return nil, ErrContextWindowExceeded
+		}
+	}
+}
+
+// dropFirst 丢弃第一条非 system 消息以及随后的工具调用结果，最后一条消息不会被丢弃
+func dropFirst(messages []*Message) ([]*Message, bool) {
+	i := 0
+	for i < len(messages) && messages[i].Role == RoleSystem {
+		i++
+	}
+
+	if i >= len(messages)-1 {
+		return messages, false
+	}
+
+	j := i + 1
+	for j < len(messages)-1 && isToolResult(messages[j]) {
+		j++
+	}
+
+	return append(messages[:i], messages[j:]...), true
+}
+
+func isToolResult(m *Message) bool {
+	return m.Role == RoleTool || m.Role == RoleFunction
+}
+
+// formatTranscript 将消息格式化为纯文本，用于生成摘要
+func formatTranscript(messages []*Message) string {
+	var b strings.Builder
+	for _, m := range messages {
+		role := m.Role
+		if m.Name != "" && m.Name != summaryMessageName {
+			role += "(" + m.Name + ")"
+		}
+
+		if text := m.Text(); text != "" {
+			fmt.Fprintf(&b, "%s: %s\n", role, text)
+		}
+		if m.HasFunctionCall() {
+			fmt.Fprintf(&b, "%s called %s(%s)\n", role, m.FunctionCall.Name, m.FunctionCall.Arguments)
+		}
+		for _, call := range m.ToolCalls {
+			fmt.Fprintf(&b, "%s called %s(%s)\n", role, call.Function.Name, call.Function.Arguments)
+		}
+	}
+	return b.String()
+}
+
+// Turn 一轮对话的记录
+type Turn struct {
+	// Messages 本轮新增的消息，最后一条为模型的回复
+	Messages []*Message
+	// PromptTokens 发送之前本地估算的 prompt token 数量
+	PromptTokens int
+	// Trimmed 本轮发送之前被裁剪掉的消息数量
+	Trimmed int
+	// Usage 接口返回的实际用量
+	Usage Usage
+	// Response 接口返回的完整响应，流式请求时为合并之后的结果
+	Response *ChatCreateResponse
+}
+
+type ConversationOption func(*Conversation)
+
+// WithTrimStrategy 设置裁剪策略，默认为 DropOldest
+func WithTrimStrategy(strategy TrimStrategy) ConversationOption {
+	return func(c *Conversation) {
+		c.strategy = strategy
+	}
+}
+
+// WithTokenCounter 设置 token 的计算方式，默认使用 CountMessageTokens，
+// 没有模型对应的 encoding 时按照字符数粗略估算
+func WithTokenCounter(counter TokenCounter) ConversationOption {
+	return func(c *Conversation) {
+		c.counter = counter
+	}
+}
+
+// WithContextWindow 设置上下文长度，默认根据模型确定，为 0 时不进行裁剪
+func WithContextWindow(n int) ConversationOption {
+	return func(c *Conversation) {
+		c.window = n
+	}
+}
+
+// Conversation 管理一个会话的历史消息，发送之前按照上下文长度减去 MaxTokens 的预算裁剪历史消息，
+// 裁剪会直接修改保存的历史。可以并发使用，但是同一时间只会有一个请求在进行
+type Conversation struct {
+	chat     ChatService
+	template ChatCreateRequest
+	strategy TrimStrategy
+	counter  TokenCounter
+	window   int
+
+	sendMu sync.Mutex
+
+	mu       sync.Mutex
+	messages []*Message
+	pending  []*Message
+	turns    []*Turn
+}
+
+// NewConversation 创建会话，req 作为每次请求的模板，其中的 Messages 作为初始的历史消息
+func NewConversation(chat ChatService, req *ChatCreateRequest, opts ...ConversationOption) *Conversation {
+	c := &Conversation{
+		chat:     chat,
+		template: *req,
+		strategy: DropOldest(),
+		counter:  defaultTokenCounter,
+		window:   ContextWindow(req.Model),
+		messages: append([]*Message(nil), req.Messages...),
+	}
+	c.template.Messages = nil
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Model 会话使用的模型
+func (c *Conversation) Model() string {
+	return c.template.Model
+}
+
+// Budget prompt 可以使用的 token 数量，为上下文长度减去 MaxCompletionTokens 或者 MaxTokens，为 0 时表示不限制
+func (c *Conversation) Budget() int {
+	if c.window <= 0 {
+		return 0
+	}
+
+	reserved := c.template.MaxCompletionTokens
+	if reserved == 0 {
+		reserved = c.template.MaxTokens
+	}
+
+	budget := c.window - int(reserved)
+	if budget < 1 {
+		budget = 1
+	}
+	return budget
+}
+
+// SetSystem 设置 system 消息，已经存在时替换第一条 system 消息，有请求在进行时会等待请求结束。
+// 消息可能与调用方的请求以及之前的 Turn 共享，因此替换为新的消息而不是修改原来的消息
+func (c *Conversation) SetSystem(content string) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, m := range c.messages {
+		if m.Role == RoleSystem && m.Name != summaryMessageName {
+			c.messages[i] = &Message{Role: RoleSystem, Content: content}
+			return
+		}
+	}
+
+	c.messages = append([]*Message{{Role: RoleSystem, Content: content}}, c.messages...)
+}
+
+// Add 追加消息，会在下一次 Complete 时发送
+func (c *Conversation) Add(messages ...*Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.messages = append(c.messages, messages...)
+	c.pending = append(c.pending, messages...)
+}
+
+func (c *Conversation) AddUser(content string) {
+	c.Add(&Message{Role: RoleUser, Content: content})
+}
+
+func (c *Conversation) AddAssistant(content string) {
+	c.Add(&Message{Role: RoleAssistant, Content: content})
+}
+
+// AddFunction 追加旧版函数调用的结果
+func (c *Conversation) AddFunction(name string, content string) {
+	c.Add(&Message{Role: RoleFunction, Name: name, Content: content})
+}
+
+// AddTool 追加工具调用的结果
+func (c *Conversation) AddTool(toolCallId string, content string) {
+	c.Add(&Message{Role: RoleTool, ToolCallId: toolCallId, Content: content})
+}
+
+// Messages 返回当前的历史消息
+func (c *Conversation) Messages() []*Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]*Message(nil), c.messages...)
+}
+
+// Turns 返回每一轮对话的记录
+func (c *Conversation) Turns() []*Turn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]*Turn(nil), c.turns...)
+}
+
+// Usage 返回所有轮次接口返回的用量之和，不包括生成摘要的用量
+func (c *Conversation) Usage() Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var total Usage
+	for _, t := range c.turns {
+		total = total.Add(t.Usage)
+	}
+	return total
+}
+
+// Tokens 估算当前历史消息作为 prompt 的 token 数量
+func (c *Conversation) Tokens() (int, error) {
+	return c.counter(c.template.Model, c.Messages(), c.functions())
+}
+
+// Send 追加一条 user 消息并请求模型，返回模型的回复
+func (c *Conversation) Send(ctx context.Context, content string) (*Message, error) {
+	c.AddUser(content)
+
+	resp, err := c.Complete(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Choices[0].Message, nil
+}
+
+// Complete 裁剪历史消息之后请求模型，并将模型的回复追加到历史消息中。
+// 模型要求调用工具时，调用方需要通过 AddTool 追加结果之后再次调用 Complete
+func (c *Conversation) Complete(ctx context.Context) (*ChatCreateResponse, error) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	messages := c.Messages()
+	functions := c.functions()
+	budget := c.Budget()
+
+	fits := func(messages []*Message) (bool, error) {
+		if budget <= 0 {
+			return true, nil
+		}
+		n, err := c.counter(c.template.Model, messages, functions)
+		if err != nil {
+			return false, err
+		}
+		return n <= budget, nil
+	}
+
+	trimmed, err := c.strategy.Trim(ctx, c.template.Model, messages, fits)
+	if err != nil {
+		return nil, err
+	}
+
+	promptTokens, err := c.counter(c.template.Model, trimmed, functions)
+	if err != nil {
+		return nil, err
+	}
+
+	req := c.template
+	req.Messages = trimmed
+
+	res, err := c.chat.Create(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := AccumulateChat(res)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Choices) == 0 || resp.Choices[0].Message == nil {
+		return nil, ErrNoChoices
+	}
+
+	reply := resp.Choices[0].Message
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 请求期间追加的消息保留在裁剪之后的历史消息后面
+	c.messages = append(append(trimmed, c.messages[len(messages):]...), reply)
+	c.turns = append(c.turns, &Turn{
+		Messages:     append(c.pending, reply),
+		PromptTokens: promptTokens,
+		Trimmed:      countRemoved(messages, trimmed),
+		Usage:        resp.Usage,
+		Response:     resp,
+	})
+	c.pending = nil
+
+	return resp, nil
+}
+
+func (c *Conversation) functions() []*Function {
+	functions := append([]*Function(nil), c.template.Functions...)
+	for _, tool := range c.template.Tools {
+		if tool.Function != nil {
+			functions = append(functions, tool.Function)
+		}
+	}
+	return functions
+}
+
+// countRemoved 统计 before 中不在 after 里的消息数量
+func countRemoved(before, after []*Message) int {
+	kept := make(map[*Message]bool, len(after))
+	for _, m := range after {
+		kept[m] = true
+	}
+
+	n := 0
+	for _, m := range before {
+		if !kept[m] {
+			n++
+		}
+	}
+	return n
+}
+
+// defaultTokenCounter 优先使用 tokenizer 精确计算，没有模型对应的 encoding 时粗略估算
+func defaultTokenCounter(model string, messages []*Message, functions []*Function) (int, error) {
+	n, err := CountMessageTokens(model, messages, functions)
+	if err == nil {
+		return n, nil
+	}
+
+	if errors.Is(err, tokenizer.ErrEncodingNotFound) || errors.Is(err, tokenizer.ErrUnknownModel) {
+		return approximateMessageTokens(messages, functions), nil
+	}
+
+	return 0, err
+}
+
+// approximateMessageTokens 按照平均 4 个字节一个 token 估算
+func approximateMessageTokens(messages []*Message, functions []*Function) int {
+	approx := func(s string) int {
+		return (len(s) + 3) / 4
+	}
+
+	n := 3
+	for _, m := range messages {
+		n += 3 + approx(m.Role) + approx(m.Text()) + countImageParts(m)*lowDetailImageTokens + approx(m.Name)
+		if m.HasFunctionCall() {
+			n += approx(m.FunctionCall.Name) + approx(m.FunctionCall.Arguments) + 3
+		}
+		for _, call := range m.ToolCalls {
+			n += approx(call.Function.Name) + approx(call.Function.Arguments) + 3
+		}
+	}
+
+	if len(functions) > 0 {
+		b, _ := json.Marshal(functions)
+		n += approx(string(b)) + 9
+	}
+
+	return n
+}
@@ -0,0 +1,175 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const (
+	defaultConversationTable = "conversations"
+)
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type SQLConversationStoreOption func(*SQLConversationStore)
+
+// WithConversationTable 设置保存会话的表名，默认为 conversations
+func WithConversationTable(table string) SQLConversationStoreOption {
+	return func(s *SQLConversationStore) {
+		s.table = table
+	}
+}
+
+// SQLConversationStore 通过 database/sql 保存会话，SQL 语句使用 SQLite 的语法，
+// 驱动由调用方注册，比如 modernc.org/sqlite 或者 github.com/mattn/go-sqlite3。
+// 快照以 JSON 的形式保存在 data 列中，其余的列用于列出会话
+type SQLConversationStore struct {
+	db    *sql.DB
+	table string
+}
+
+// NewSQLConversationStore 创建 SQLConversationStore，并在表不存在时创建
+func NewSQLConversationStore(ctx context.Context, db *sql.DB, opts ...SQLConversationStoreOption) (*SQLConversationStore, error) {
+	s := &SQLConversationStore{
+		db:    db,
+		table: defaultConversationTable,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if !sqlIdentifier.MatchString(s.table) {
+		return nil, fmt.Errorf("openai: invalid conversation table name %q", s.table)
+	}
+
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id TEXT PRIMARY KEY,
+	version INTEGER NOT NULL,
+	parent_id TEXT NOT NULL DEFAULT '',
+	model TEXT NOT NULL DEFAULT '',
+	message_count INTEGER NOT NULL DEFAULT 0,
+	data TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+)`, s.table))
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *SQLConversationStore) Save(ctx context.Context, snapshot *ConversationSnapshot) error {
+	if snapshot.Id == "" {
+		return ErrInvalidConversationId
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existing *ConversationSnapshot
+	var createdAt int64
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT created_at FROM %s WHERE id = ?`, s.table), snapshot.Id).Scan(&createdAt)
+	switch {
+	case err == nil:
+		existing = &ConversationSnapshot{CreatedAt: time.Unix(0, createdAt).UTC()}
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+	touchSnapshot(snapshot, existing)
+
+	data, err := EncodeSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (id, version, parent_id, model, message_count, data, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET version = excluded.version, parent_id = excluded.parent_id, model = excluded.model,
+	message_count = excluded.message_count, data = excluded.data, created_at = excluded.created_at, updated_at = excluded.updated_at`, s.table),
+		snapshot.Id, snapshot.Version, snapshot.ParentId, snapshot.Model, len(snapshot.Messages), string(data),
+		snapshot.CreatedAt.UnixNano(), snapshot.UpdatedAt.UnixNano())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLConversationStore) Load(ctx context.Context, id string) (*ConversationSnapshot, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT data FROM %s WHERE id = ?`, s.table), id).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrConversationNotFound
+		}
+		return nil, err
+	}
+
+	return DecodeSnapshot([]byte(data))
+}
+
+func (s *SQLConversationStore) List(ctx context.Context) ([]*ConversationInfo, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT id, parent_id, model, message_count, created_at, updated_at FROM %s
+ORDER BY updated_at DESC, id ASC`, s.table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var infos []*ConversationInfo
+	for rows.Next() {
+		var (
+			info                 ConversationInfo
+			createdAt, updatedAt int64
+		)
+		if err := rows.Scan(&info.Id, &info.ParentId, &info.Model, &info.MessageCount, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		info.CreatedAt = time.Unix(0, createdAt).UTC()
+		info.UpdatedAt = time.Unix(0, updatedAt).UTC()
+		infos = append(infos, &info)
+	}
+
+	return infos, rows.Err()
+}
+
+func (s *SQLConversationStore) Delete(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, s.table), id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrConversationNotFound
+	}
+
+	return nil
+}
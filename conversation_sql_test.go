@@ -0,0 +1,174 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockSQLDriver 只支持 SQLConversationStore 用到的语句的内存数据库，用于在没有 SQLite 驱动的情况下测试
+type mockSQLDriver struct {
+	mu  sync.Mutex
+	dbs map[string]*mockSQLTable
+}
+
+type mockSQLTable struct {
+	mu   sync.Mutex
+	rows map[string][]driver.Value // id, version, parent_id, model, message_count, data, created_at, updated_at
+}
+
+var (
+	mockSQLOnce sync.Once
+	mockSQL     = &mockSQLDriver{dbs: make(map[string]*mockSQLTable)}
+)
+
+func (d *mockSQLDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, ok := d.dbs[name]
+	if !ok {
+		t = &mockSQLTable{rows: make(map[string][]driver.Value)}
+		d.dbs[name] = t
+	}
+	return &mockSQLConn{table: t}, nil
+}
+
+type mockSQLConn struct {
+	table *mockSQLTable
+}
+
+func (c *mockSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &mockSQLStmt{table: c.table, query: strings.TrimSpace(query)}, nil
+}
+
+func (c *mockSQLConn) Close() error { return nil }
+
+func (c *mockSQLConn) Begin() (driver.Tx, error) { return c, nil }
+
+func (c *mockSQLConn) Commit() error { return nil }
+
+func (c *mockSQLConn) Rollback() error { return nil }
+
+type mockSQLStmt struct {
+	table *mockSQLTable
+	query string
+}
+
+func (s *mockSQLStmt) Close() error { return nil }
+
+func (s *mockSQLStmt) NumInput() int { return -1 }
+
+func (s *mockSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	t := s.table
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE IF NOT EXISTS"):
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(s.query, "INSERT INTO"):
+		t.rows[args[0].(string)] = args
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "DELETE FROM"):
+		id := args[0].(string)
+		if _, ok := t.rows[id]; !ok {
+			return driver.RowsAffected(0), nil
+		}
+		delete(t.rows, id)
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unsupported statement: %s", s.query)
+}
+
+func (s *mockSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	t := s.table
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(s.query, "SELECT created_at FROM"), strings.HasPrefix(s.query, "SELECT data FROM"):
+		row, ok := t.rows[args[0].(string)]
+		if !ok {
+			return &mockSQLRows{}, nil
+		}
+		if strings.HasPrefix(s.query, "SELECT data") {
+			return &mockSQLRows{rows: [][]driver.Value{{row[5]}}}, nil
+		}
+		return &mockSQLRows{rows: [][]driver.Value{{row[6]}}}, nil
+	case strings.HasPrefix(s.query, "SELECT id, parent_id"):
+		var rows [][]driver.Value
+		for _, row := range t.rows {
+			rows = append(rows, []driver.Value{row[0], row[2], row[3], row[4], row[6], row[7]})
+		}
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i][5].(int64) != rows[j][5].(int64) {
+				return rows[i][5].(int64) > rows[j][5].(int64)
+			}
+			return rows[i][0].(string) < rows[j][0].(string)
+		})
+		return &mockSQLRows{rows: rows}, nil
+	}
+	return nil, fmt.Errorf("unsupported query: %s", s.query)
+}
+
+type mockSQLRows struct {
+	rows [][]driver.Value
+}
+
+func (r *mockSQLRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return []string{"c"}
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *mockSQLRows) Close() error { return nil }
+
+func (r *mockSQLRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSQLConversationStore(t *testing.T) {
+	mockSQLOnce.Do(func() {
+		sql.Register("openai_mock_sql", mockSQL)
+	})
+
+	db, err := sql.Open("openai_mock_sql", t.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = NewSQLConversationStore(context.TODO(), db, WithConversationTable("drop table;"))
+	require.Error(t, err)
+
+	store, err := NewSQLConversationStore(context.TODO(), db, WithConversationTable("chat_history"))
+	require.NoError(t, err)
+
+	testConversationStore(t, store)
+}
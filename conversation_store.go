@@ -0,0 +1,479 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConversationSnapshotVersion 当前快照的格式版本，格式变化时递增，并通过 RegisterSnapshotMigration 注册旧版本的迁移。
+// 版本从 1 开始，没有版本（为 0）的快照视为第一个版本
+const ConversationSnapshotVersion = 1
+
+var (
+	// ErrConversationNotFound 会话不存在
+	ErrConversationNotFound = errors.New("openai: conversation not found")
+	// ErrInvalidConversationId 会话 id 为空或者包含不允许的字符
+	ErrInvalidConversationId = errors.New("openai: invalid conversation id")
+	// ErrUnsupportedSnapshotVersion 快照的版本比当前版本新，或者没有对应的迁移
+	ErrUnsupportedSnapshotVersion = errors.New("openai: unsupported conversation snapshot version")
+)
+
+// ConversationSnapshot 会话的快照，用于持久化
+type ConversationSnapshot struct {
+	Version   int               `json:"version"`
+	Id        string            `json:"id"`
+	ParentId  string            `json:"parent_id,omitempty"`  // 从其他会话 fork 而来时为原会话的 id
+	ForkIndex int               `json:"fork_index,omitempty"` // fork 时保留的原会话消息数量
+	Model     string            `json:"model,omitempty"`
+	Messages  []*Message        `json:"messages"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// ConversationInfo 列出会话时返回的摘要信息
+type ConversationInfo struct {
+	Id           string
+	ParentId     string
+	Model        string
+	MessageCount int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// ConversationStore 会话的持久化存储，实现需要可以并发使用
+type ConversationStore interface {
+	// Save 保存快照，已经存在时覆盖，会更新 UpdatedAt，CreatedAt 为空时会被设置
+	Save(ctx context.Context, snapshot *ConversationSnapshot) error
+	// Load 加载快照，不存在时返回 ErrConversationNotFound
+	Load(ctx context.Context, id string) (*ConversationSnapshot, error)
+	// List 列出所有的会话，按照更新时间倒序
+	List(ctx context.Context) ([]*ConversationInfo, error)
+	// Delete 删除会话，不存在时返回 ErrConversationNotFound
+	Delete(ctx context.Context, id string) error
+}
+
+// SnapshotMigration 将 from 版本的快照转换为 from+1 版本，data 为原始的 JSON
+type SnapshotMigration func(data []byte) ([]byte, error)
+
+var (
+	migrationsMu sync.RWMutex
+	migrations   = make(map[int]SnapshotMigration)
+)
+
+// RegisterSnapshotMigration 注册从 from 版本迁移到 from+1 版本的函数，from 从 1 开始
+func RegisterSnapshotMigration(from int, migration SnapshotMigration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+
+	migrations[from] = migration
+}
+
+// EncodeSnapshot 将快照编码为 JSON，总是使用当前的版本
+func EncodeSnapshot(snapshot *ConversationSnapshot) ([]byte, error) {
+	s := *snapshot
+	s.Version = ConversationSnapshotVersion
+	return json.Marshal(&s)
+}
+
+// DecodeSnapshot 解码快照，旧版本的快照会依次经过注册的迁移转换为当前版本，没有版本的快照视为版本 1
+func DecodeSnapshot(data []byte) (*ConversationSnapshot, error) {
+	return decodeSnapshot(data, ConversationSnapshotVersion)
+}
+
+func decodeSnapshot(data []byte, current int) (*ConversationSnapshot, error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+
+	// 引入版本号之前的快照即为第一个版本的格式
+	if header.Version == 0 {
+		header.Version = 1
+	}
+
+	for v := header.Version; v < current; v++ {
+		migrationsMu.RLock()
+		migrate, ok := migrations[v]
+		migrationsMu.RUnlock()
+
+		if !ok {
+			return nil, fmt.Errorf("%w: no migration from version %d", ErrUnsupportedSnapshotVersion, v)
+		}
+
+		var err error
+		if data, err = migrate(data); err != nil {
+			return nil, fmt.Errorf("openai: migrate conversation snapshot from version %d: %w", v, err)
+		}
+	}
+
+	if header.Version > current {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedSnapshotVersion, header.Version)
+	}
+
+	var snapshot ConversationSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	snapshot.Version = current
+
+	return &snapshot, nil
+}
+
+// ForkConversation 复制会话 id 的前 at 条消息保存为新的会话 newId，用于从之前的某个位置重新开始
+func ForkConversation(ctx context.Context, store ConversationStore, id string, at int, newId string) (*ConversationSnapshot, error) {
+	src, err := store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if at < 0 || at > len(src.Messages) {
+		return nil, fmt.Errorf("openai: fork index %d out of range [0, %d]", at, len(src.Messages))
+	}
+
+	fork := &ConversationSnapshot{
+		Id:        newId,
+		ParentId:  src.Id,
+		ForkIndex: at,
+		Model:     src.Model,
+		Messages:  copyMessages(src.Messages[:at]),
+		Metadata:  copyMetadata(src.Metadata),
+	}
+
+	if err := store.Save(ctx, fork); err != nil {
+		return nil, err
+	}
+
+	return fork, nil
+}
+
+// Snapshot 返回当前会话的快照
+func (c *Conversation) Snapshot(id string) *ConversationSnapshot {
+	return &ConversationSnapshot{
+		Version:  ConversationSnapshotVersion,
+		Id:       id,
+		Model:    c.template.Model,
+		Messages: copyMessages(c.Messages()),
+	}
+}
+
+// Fork 返回一个新的会话，包含当前会话的前 at 条消息以及相同的请求模板和配置，两者之后互不影响
+func (c *Conversation) Fork(at int) (*Conversation, error) {
+	messages := c.Messages()
+	if at < 0 || at > len(messages) {
+		return nil, fmt.Errorf("openai: fork index %d out of range [0, %d]", at, len(messages))
+	}
+
+	return &Conversation{
+		chat:     c.chat,
+		template: c.template,
+		strategy: c.strategy,
+		counter:  c.counter,
+		window:   c.window,
+		messages: copyMessages(messages[:at]),
+	}, nil
+}
+
+// RestoreConversation 从快照恢复会话，req 为请求的模板，为 nil 时只使用快照中的模型
+func RestoreConversation(chat ChatService, snapshot *ConversationSnapshot, req *ChatCreateRequest, opts ...ConversationOption) *Conversation {
+	template := ChatCreateRequest{Model: snapshot.Model}
+	if req != nil {
+		template = *req
+		if template.Model == "" {
+			template.Model = snapshot.Model
+		}
+	}
+	template.Messages = copyMessages(snapshot.Messages)
+
+	return NewConversation(chat, &template, opts...)
+}
+
+// MemoryConversationStore 保存在内存中的 ConversationStore，保存和加载时都会复制快照
+type MemoryConversationStore struct {
+	mu        sync.RWMutex
+	snapshots map[string][]byte
+}
+
+func NewMemoryConversationStore() *MemoryConversationStore {
+	return &MemoryConversationStore{
+		snapshots: make(map[string][]byte),
+	}
+}
+
+func (s *MemoryConversationStore) Save(ctx context.Context, snapshot *ConversationSnapshot) error {
+	if snapshot.Id == "" {
+		return ErrInvalidConversationId
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var existing *ConversationSnapshot
+	if data, ok := s.snapshots[snapshot.Id]; ok {
+		existing, _ = DecodeSnapshot(data)
+	}
+	touchSnapshot(snapshot, existing)
+
+	data, err := EncodeSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	s.snapshots[snapshot.Id] = data
+	return nil
+}
+
+func (s *MemoryConversationStore) Load(ctx context.Context, id string) (*ConversationSnapshot, error) {
+	s.mu.RLock()
+	data, ok := s.snapshots[id]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrConversationNotFound
+	}
+
+	return DecodeSnapshot(data)
+}
+
+func (s *MemoryConversationStore) List(ctx context.Context) ([]*ConversationInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make([]*ConversationInfo, 0, len(s.snapshots))
+	for _, data := range s.snapshots {
+		snapshot, err := DecodeSnapshot(data)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, snapshotInfo(snapshot))
+	}
+
+	sortConversationInfos(infos)
+	return infos, nil
+}
+
+func (s *MemoryConversationStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.snapshots[id]; !ok {
+		return ErrConversationNotFound
+	}
+
+	delete(s.snapshots, id)
+	return nil
+}
+
+// FileConversationStore 每个会话保存为目录下的一个 JSON 文件，文件名为 <id>.json，
+// 写入时先写临时文件再重命名，避免进程崩溃时留下不完整的文件
+type FileConversationStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileConversationStore 创建 FileConversationStore，目录不存在时会被创建
+func NewFileConversationStore(dir string) (*FileConversationStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileConversationStore{dir: dir}, nil
+}
+
+func (s *FileConversationStore) path(id string) (string, error) {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) || strings.ContainsRune(id, 0) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidConversationId, id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s *FileConversationStore) Save(ctx context.Context, snapshot *ConversationSnapshot) error {
+	path, err := s.path(snapshot.Id)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.load(path)
+	if err != nil && !errors.Is(err, ErrConversationNotFound) {
+		return err
+	}
+	touchSnapshot(snapshot, existing)
+
+	data, err := EncodeSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, "."+snapshot.Id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileConversationStore) Load(ctx context.Context, id string) (*ConversationSnapshot, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.load(path)
+}
+
+func (s *FileConversationStore) load(path string) (*ConversationSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrConversationNotFound
+		}
+		return nil, err
+	}
+
+	return DecodeSnapshot(data)
+}
+
+func (s *FileConversationStore) List(ctx context.Context) ([]*ConversationInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []*ConversationInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		snapshot, err := s.load(filepath.Join(s.dir, name))
+		if err != nil {
+			// 其他进程可能刚刚删除了文件
+			if errors.Is(err, ErrConversationNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("openai: load conversation %s: %w", name, err)
+		}
+
+		infos = append(infos, snapshotInfo(snapshot))
+	}
+
+	sortConversationInfos(infos)
+	return infos, nil
+}
+
+func (s *FileConversationStore) Delete(ctx context.Context, id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrConversationNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// touchSnapshot 设置快照的版本和时间，CreatedAt 为空时使用已经存在的快照的创建时间
+func touchSnapshot(snapshot *ConversationSnapshot, existing *ConversationSnapshot) {
+	now := time.Now().UTC()
+
+	snapshot.Version = ConversationSnapshotVersion
+	if snapshot.CreatedAt.IsZero() {
+		if existing != nil && !existing.CreatedAt.IsZero() {
+			snapshot.CreatedAt = existing.CreatedAt
+		} else {
+			snapshot.CreatedAt = now
+		}
+	}
+	snapshot.UpdatedAt = now
+}
+
+func snapshotInfo(s *ConversationSnapshot) *ConversationInfo {
+	return &ConversationInfo{
+		Id:           s.Id,
+		ParentId:     s.ParentId,
+		Model:        s.Model,
+		MessageCount: len(s.Messages),
+		CreatedAt:    s.CreatedAt,
+		UpdatedAt:    s.UpdatedAt,
+	}
+}
+
+func sortConversationInfos(infos []*ConversationInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].UpdatedAt.Equal(infos[j].UpdatedAt) {
+			return infos[i].UpdatedAt.After(infos[j].UpdatedAt)
+		}
+		return infos[i].Id < infos[j].Id
+	})
+}
+
+// copyMessages 复制消息本身，避免修改一个会话的消息影响另一个会话
+func copyMessages(messages []*Message) []*Message {
+	res := make([]*Message, 0, len(messages))
+	for _, m := range messages {
+		cp := *m
+		res = append(res, &cp)
+	}
+	return res
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+
+	res := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		res[k] = v
+	}
+	return res
+}
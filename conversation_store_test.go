@@ -0,0 +1,225 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testConversationStore 所有 ConversationStore 的实现都需要满足的行为
+func testConversationStore(t *testing.T, store ConversationStore) {
+	ctx := context.TODO()
+
+	_, err := store.Load(ctx, "missing")
+	require.ErrorIs(t, err, ErrConversationNotFound)
+	require.ErrorIs(t, store.Delete(ctx, "missing"), ErrConversationNotFound)
+	require.ErrorIs(t, store.Save(ctx, &ConversationSnapshot{}), ErrInvalidConversationId)
+
+	snapshot := &ConversationSnapshot{
+		Id:    "conv-1",
+		Model: GPT35Turbo,
+		Messages: []*Message{
+			{Role: RoleSystem, Content: "sys"},
+			{Role: RoleUser, Content: "hello"},
+			{Role: RoleAssistant, Content: "hi"},
+			{Role: RoleUser, Content: "how are you"},
+		},
+		Metadata: map[string]string{"user": "u1"},
+	}
+	require.NoError(t, store.Save(ctx, snapshot))
+	require.Equal(t, ConversationSnapshotVersion, snapshot.Version)
+	require.False(t, snapshot.CreatedAt.IsZero())
+
+	got, err := store.Load(ctx, "conv-1")
+	require.NoError(t, err)
+	require.Equal(t, snapshot.Messages, got.Messages)
+	require.Equal(t, snapshot.Metadata, got.Metadata)
+	require.True(t, snapshot.CreatedAt.Equal(got.CreatedAt))
+
+	// 再次保存时保留创建时间
+	createdAt := got.CreatedAt
+	require.NoError(t, store.Save(ctx, &ConversationSnapshot{
+		Id:       got.Id,
+		Model:    got.Model,
+		Messages: append(got.Messages, &Message{Role: RoleAssistant, Content: "fine"}),
+		Metadata: got.Metadata,
+	}))
+
+	got, err = store.Load(ctx, "conv-1")
+	require.NoError(t, err)
+	require.Len(t, got.Messages, 5)
+	require.True(t, createdAt.Equal(got.CreatedAt))
+
+	fork, err := ForkConversation(ctx, store, "conv-1", 2, "conv-2")
+	require.NoError(t, err)
+	require.Equal(t, "conv-1", fork.ParentId)
+	require.Equal(t, 2, fork.ForkIndex)
+
+	got, err = store.Load(ctx, "conv-2")
+	require.NoError(t, err)
+	require.Equal(t, []string{"sys", "hello"}, contents(got.Messages))
+	require.Equal(t, map[string]string{"user": "u1"}, got.Metadata)
+
+	_, err = ForkConversation(ctx, store, "conv-1", 10, "conv-3")
+	require.Error(t, err)
+
+	infos, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	require.Equal(t, "conv-2", infos[0].Id)
+	require.Equal(t, "conv-1", infos[0].ParentId)
+	require.Equal(t, 2, infos[0].MessageCount)
+	require.Equal(t, "conv-1", infos[1].Id)
+	require.Equal(t, 5, infos[1].MessageCount)
+	require.Equal(t, GPT35Turbo, infos[1].Model)
+
+	require.NoError(t, store.Delete(ctx, "conv-1"))
+	_, err = store.Load(ctx, "conv-1")
+	require.ErrorIs(t, err, ErrConversationNotFound)
+
+	infos, err = store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+}
+
+func TestMemoryConversationStore(t *testing.T) {
+	store := NewMemoryConversationStore()
+	testConversationStore(t, store)
+
+	// 修改保存之后的快照不会影响存储的内容
+	snapshot := &ConversationSnapshot{Id: "a", Messages: []*Message{{Role: RoleUser, Content: "hello"}}}
+	require.NoError(t, store.Save(context.TODO(), snapshot))
+	snapshot.Messages[0].Content = "changed"
+
+	got, err := store.Load(context.TODO(), "a")
+	require.NoError(t, err)
+	require.Equal(t, "hello", got.Messages[0].Content)
+}
+
+func TestFileConversationStore(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewFileConversationStore(filepath.Join(dir, "conversations"))
+	require.NoError(t, err)
+	testConversationStore(t, store)
+
+	for _, id := range []string{"../escape", "a/b", "..", ""} {
+		require.ErrorIs(t, store.Save(context.TODO(), &ConversationSnapshot{Id: id}), ErrInvalidConversationId)
+	}
+
+	// 临时文件和其他文件不会被列出
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "conversations", ".x.tmp"), []byte("{"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "conversations", "notes.txt"), []byte("hi"), 0o644))
+
+	infos, err := store.List(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+}
+
+func TestDecodeSnapshot(t *testing.T) {
+	t.Run("test decode future version", func(t *testing.T) {
+		_, err := DecodeSnapshot([]byte(`{"version": 99, "id": "a"}`))
+		require.ErrorIs(t, err, ErrUnsupportedSnapshotVersion)
+	})
+
+	t.Run("test decode without version", func(t *testing.T) {
+		snapshot, err := DecodeSnapshot([]byte(`{"id": "a", "messages": [{"role": "user", "content": "hello"}]}`))
+		require.NoError(t, err)
+		require.Equal(t, ConversationSnapshotVersion, snapshot.Version)
+		require.Equal(t, []*Message{{Role: RoleUser, Content: "hello"}}, snapshot.Messages)
+	})
+
+	// 模拟当前版本为 3 的情况
+	t.Run("test decode without migration", func(t *testing.T) {
+		_, err := decodeSnapshot([]byte(`{"version": 2, "id": "a"}`), 3)
+		require.ErrorIs(t, err, ErrUnsupportedSnapshotVersion)
+	})
+
+	t.Run("test decode with migration", func(t *testing.T) {
+		// 版本 1 的快照中消息保存在 history 字段，版本 2 只修改了版本号
+		RegisterSnapshotMigration(1, func(data []byte) ([]byte, error) {
+			var v map[string]any
+			if err := json.Unmarshal(data, &v); err != nil {
+				return nil, err
+			}
+			v["messages"] = v["history"]
+			delete(v, "history")
+			v["version"] = 2
+			return json.Marshal(v)
+		})
+		RegisterSnapshotMigration(2, func(data []byte) ([]byte, error) {
+			var v map[string]any
+			if err := json.Unmarshal(data, &v); err != nil {
+				return nil, err
+			}
+			v["version"] = 3
+			return json.Marshal(v)
+		})
+		defer func() {
+			migrationsMu.Lock()
+			delete(migrations, 1)
+			delete(migrations, 2)
+			migrationsMu.Unlock()
+		}()
+
+		snapshot, err := decodeSnapshot([]byte(`{"version": 1, "id": "a", "history": [{"role": "user", "content": "hello"}]}`), 3)
+		require.NoError(t, err)
+		require.Equal(t, 3, snapshot.Version)
+		require.Equal(t, []*Message{{Role: RoleUser, Content: "hello"}}, snapshot.Messages)
+
+		// 没有版本的快照从版本 1 开始迁移
+		snapshot, err = decodeSnapshot([]byte(`{"id": "a", "history": [{"role": "user", "content": "hello"}]}`), 3)
+		require.NoError(t, err)
+		require.Equal(t, 3, snapshot.Version)
+		require.Equal(t, []*Message{{Role: RoleUser, Content: "hello"}}, snapshot.Messages)
+	})
+}
+
+func TestConversation_Fork(t *testing.T) {
+	c := NewConversation(nil, &ChatCreateRequest{
+		Model: GPT4,
+		Messages: []*Message{
+			{Role: RoleSystem, Content: "sys"},
+			{Role: RoleUser, Content: "hello"},
+			{Role: RoleAssistant, Content: "hi"},
+		},
+	})
+
+	fork, err := c.Fork(2)
+	require.NoError(t, err)
+	require.Equal(t, GPT4, fork.Model())
+	require.Equal(t, []string{"sys", "hello"}, contents(fork.Messages()))
+
+	// 两个会话互不影响
+	fork.SetSystem("changed")
+	require.Equal(t, "sys", c.Messages()[0].Content)
+
+	_, err = c.Fork(4)
+	require.Error(t, err)
+
+	snapshot := c.Snapshot("conv-1")
+	require.Equal(t, "conv-1", snapshot.Id)
+	require.Equal(t, GPT4, snapshot.Model)
+
+	restored := RestoreConversation(nil, snapshot, &ChatCreateRequest{MaxTokens: 10})
+	require.Equal(t, GPT4, restored.Model())
+	require.Equal(t, contents(c.Messages()), contents(restored.Messages()))
+	require.Equal(t, ContextWindow(GPT4)-10, restored.Budget())
+}
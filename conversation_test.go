@@ -0,0 +1,256 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// contentCounter 以内容的字节数作为 token 数量，方便计算
+func contentCounter(model string, messages []*Message, functions []*Function) (int, error) {
+	n := 0
+	for _, m := range messages {
+		n += len(m.Content)
+	}
+	return n, nil
+}
+
+func contents(messages []*Message) []string {
+	res := make([]string, 0, len(messages))
+	for _, m := range messages {
+		res = append(res, m.Content)
+	}
+	return res
+}
+
+func TestTrimStrategy(t *testing.T) {
+	call := &Message{Role: RoleAssistant, Content: "c", ToolCalls: []*ToolCall{{Id: "call_1", Type: ToolTypeFunction}}}
+	messages := []*Message{
+		{Role: RoleSystem, Content: "sys"},
+		{Role: RoleUser, Content: "u1"},
+		call,
+		{Role: RoleTool, ToolCallId: "call_1", Content: "t1"},
+		{Role: RoleAssistant, Content: "a1"},
+		{Role: RoleUser, Content: "u2"},
+	}
+
+	budget := func(n int) FitFunc {
+		return func(messages []*Message) (bool, error) {
+			got, _ := contentCounter("", messages, nil)
+			return got <= n, nil
+		}
+	}
+
+	testCase := []struct {
+		name     string
+		strategy TrimStrategy
+		budget   int
+		want     []string
+		wantErr  error
+	}{
+		{
+			name:     "test drop oldest fits",
+			strategy: DropOldest(),
+			budget:   100,
+			want:     []string{"sys", "u1", "c", "t1", "a1", "u2"},
+		},
+		{
+			name:     "test drop oldest",
+			strategy: DropOldest(),
+			budget:   10,
+			want:     []string{"sys", "c", "t1", "a1", "u2"},
+		},
+		{
+			name:     "test drop oldest with tool results",
+			strategy: DropOldest(),
+			budget:   8,
+			want:     []string{"sys", "a1", "u2"},
+		},
+		{
+			name:     "test drop oldest exceeded",
+			strategy: DropOldest(),
+			budget:   4,
+			wantErr:  ErrContextWindowExceeded,
+		},
+		{
+			name:     "test keep last n",
+			strategy: KeepLastN(3),
+			budget:   10,
+			want:     []string{"sys", "a1", "u2"},
+		},
+		{
+			name:     "test keep last n fallback to drop oldest",
+			strategy: KeepLastN(3),
+			budget:   5,
+			want:     []string{"sys", "u2"},
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.strategy.Trim(context.TODO(), GPT35Turbo, messages, budget(tc.budget))
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, contents(got))
+		})
+	}
+
+	// 原有的消息不能被修改
+	require.Len(t, messages, 6)
+	require.Equal(t, "u1", messages[1].Content)
+}
+
+func TestConversation_Send(t *testing.T) {
+	reply := mockStopResponse("ok")
+	reply.Usage = Usage{PromptTokens: 15, CompletionTokens: 2, TotalTokens: 17}
+
+	server := newMockServer(newMockChatSequence(t, []*ChatCreateResponse{reply}, func(t *testing.T, i int, req *ChatCreateRequest) {
+		require.Equal(t, int64(5), req.MaxTokens)
+		require.Equal(t, []string{"sys", "bbbbbbbbbb", "cc"}, contents(req.Messages))
+	}))
+	defer server.Close()
+
+	client := newMockClient(server.URL)
+
+	c := NewConversation(client.Chat, &ChatCreateRequest{
+		Model:     GPT35Turbo,
+		MaxTokens: 5,
+		Messages: []*Message{
+			{Role: RoleUser, Content: "aaaaaaaaaa"},
+			{Role: RoleAssistant, Content: "bbbbbbbbbb"},
+		},
+	}, WithContextWindow(20), WithTokenCounter(contentCounter))
+	c.SetSystem("sys")
+
+	require.Equal(t, 15, c.Budget())
+
+	n, err := c.Tokens()
+	require.NoError(t, err)
+	require.Equal(t, 23, n)
+
+	msg, err := c.Send(context.TODO(), "cc")
+	require.NoError(t, err)
+	require.Equal(t, "ok", msg.Content)
+
+	require.Equal(t, []string{"sys", "bbbbbbbbbb", "cc", "ok"}, contents(c.Messages()))
+
+	turns := c.Turns()
+	require.Len(t, turns, 1)
+	require.Equal(t, []string{"cc", "ok"}, contents(turns[0].Messages))
+	require.Equal(t, 15, turns[0].PromptTokens)
+	require.Equal(t, 1, turns[0].Trimmed)
+	require.Equal(t, reply.Usage, c.Usage())
+}
+
+func TestConversation_Summarize(t *testing.T) {
+	server := newMockServer(newMockChatSequence(t, []*ChatCreateResponse{
+		mockStopResponse("they greeted"),
+		mockStopResponse("ok"),
+	}, func(t *testing.T, i int, req *ChatCreateRequest) {
+		if i == 0 {
+			require.Equal(t, GPT35Turbo, req.Model)
+			require.Equal(t, RoleSystem, req.Messages[0].Role)
+			require.Equal(t, "user: hello\nassistant: hi there\n", req.Messages[1].Content)
+			return
+		}
+
+		require.Len(t, req.Messages, 4)
+		require.Equal(t, "sys", req.Messages[0].Content)
+		require.Equal(t, summaryMessageName, req.Messages[1].Name)
+		require.Equal(t, "Summary of the earlier conversation:\nthey greeted", req.Messages[1].Content)
+		require.Equal(t, []string{"how are you", "next"}, contents(req.Messages[2:]))
+	}))
+	defer server.Close()
+
+	client := newMockClient(server.URL)
+
+	// 每条消息 10 个 token
+	counter := func(model string, messages []*Message, functions []*Function) (int, error) {
+		return 10 * len(messages), nil
+	}
+
+	c := NewConversation(client.Chat, &ChatCreateRequest{
+		Model: GPT4,
+		Messages: []*Message{
+			{Role: RoleSystem, Content: "sys"},
+			{Role: RoleUser, Content: "hello"},
+			{Role: RoleAssistant, Content: "hi there"},
+			{Role: RoleUser, Content: "how are you"},
+		},
+	},
+		WithContextWindow(45),
+		WithTokenCounter(counter),
+		WithTrimStrategy(SummarizeOlder(client.Chat, WithSummaryKeep(2), WithSummaryModel(GPT35Turbo))),
+	)
+
+	_, err := c.Send(context.TODO(), "next")
+	require.NoError(t, err)
+
+	require.Len(t, c.Messages(), 5)
+	require.Equal(t, 2, c.Turns()[0].Trimmed)
+	require.Equal(t, 40, c.Turns()[0].PromptTokens)
+}
+
+func TestConversation_SetSystem(t *testing.T) {
+	req := &ChatCreateRequest{
+		Model: GPT35Turbo,
+		Messages: []*Message{
+			{Role: RoleSystem, Content: "old"},
+			{Role: RoleUser, Content: "hi"},
+		},
+	}
+
+	c := NewConversation(nil, req)
+	before := c.Messages()
+
+	c.SetSystem("new")
+	require.Equal(t, []string{"new", "hi"}, contents(c.Messages()))
+
+	// 调用方持有的消息不会被修改
+	require.Equal(t, "old", req.Messages[0].Content)
+	require.Equal(t, "old", before[0].Content)
+
+	c = NewConversation(nil, &ChatCreateRequest{Model: GPT35Turbo, Messages: []*Message{{Role: RoleUser, Content: "hi"}}})
+	c.SetSystem("sys")
+	require.Equal(t, []string{"sys", "hi"}, contents(c.Messages()))
+	require.Equal(t, RoleSystem, c.Messages()[0].Role)
+}
+
+func TestContextWindow(t *testing.T) {
+	testCase := []struct {
+		model string
+		want  int
+	}{
+		{model: GPT4, want: 8192},
+		{model: GPT40613, want: 8192},
+		{model: "gpt-4-32k-0613", want: 32768},
+		{model: "gpt-4o-mini-2024-07-18", want: 128000},
+		{model: GPT35Turbo16k0613, want: 16385},
+		{model: "ft:gpt-3.5-turbo-0613:org::abc", want: 4096},
+		{model: "curie:ft-personal-2023-06-01", want: 2049},
+		{model: "unknown", want: 0},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.model, func(t *testing.T) {
+			require.Equal(t, tc.want, ContextWindow(tc.model))
+		})
+	}
+}
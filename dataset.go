@@ -0,0 +1,714 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/uzziahlin/openai/tokenizer"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	DatasetFormatChat             DatasetFormat = "chat"
+	DatasetFormatPromptCompletion DatasetFormat = "prompt_completion"
+
+	// DatasetMaxTokensChat 聊天格式每个训练样本的 token 上限，超出的部分会被截断
+	DatasetMaxTokensChat = 16385
+	// DatasetMaxTokensPromptCompletion prompt/completion 格式每个训练样本的 token 上限
+	DatasetMaxTokensPromptCompletion = 2048
+
+	// 训练文件至少需要的样本数量，以及建议的最少样本数量
+	datasetMinExamples         = 10
+	datasetRecommendedExamples = 100
+
+	// 没有指定 epoch 时 /fine_tuning/jobs 接口选择 epoch 的规则
+	datasetTargetEpochs      = 3
+	datasetMinTargetExamples = 100
+	datasetMaxTargetExamples = 25000
+	datasetMinDefaultEpochs  = 1
+	datasetMaxDefaultEpochs  = 25
+
+	// 没有指定 epoch 时 /fine-tunes 接口使用的 epoch
+	datasetLegacyEpochs = 4
+)
+
+const (
+	// DatasetAPIFineTuningJobs 使用 /fine_tuning/jobs 接口（FineTuningJobService）训练
+	DatasetAPIFineTuningJobs DatasetAPI = "fine_tuning_jobs"
+	// DatasetAPIFineTunes 使用已经废弃的 /fine-tunes 接口（FineTuneService）训练
+	DatasetAPIFineTunes DatasetAPI = "fine_tunes"
+)
+
+// 校验问题的代码
+const (
+	DatasetIssueInvalidJSON           = "invalid_json"
+	DatasetIssueUnknownFormat         = "unknown_format"
+	DatasetIssueMissingMessages       = "missing_messages_list"
+	DatasetIssueMissingKey            = "message_missing_key"
+	DatasetIssueUnrecognizedKey       = "unrecognized_key"
+	DatasetIssueUnrecognizedRole      = "unrecognized_role"
+	DatasetIssueMissingContent        = "missing_content"
+	DatasetIssueMissingAssistant      = "missing_assistant_message"
+	DatasetIssueMissingPrompt         = "missing_prompt"
+	DatasetIssueMissingCompletion     = "missing_completion"
+	DatasetIssueTooManyTokens         = "too_many_tokens"
+	DatasetIssueDuplicate             = "duplicate_example"
+	DatasetIssueTooFewExamples        = "too_few_examples"
+	DatasetIssueFewExamples           = "few_examples"
+	DatasetIssueMissingSeparator      = "missing_separator"
+	DatasetIssueMissingStopSequence   = "missing_stop_sequence"
+	DatasetIssueCompletionWhitespace  = "completion_missing_whitespace"
+	DatasetIssueApproximateTokenCount = "approximate_token_count"
+)
+
+// ErrInvalidDataset 训练文件没有通过校验
+var ErrInvalidDataset = errors.New("openai: invalid fine-tuning dataset")
+
+// fineTuningTrainingPrices 每 1K 训练 token 的价格（美元），按照模型前缀匹配，越具体的前缀越靠前
+var fineTuningTrainingPrices = []struct {
+	prefix string
+	price  float64
+}{
+	{"gpt-4o-mini", 0.003},
+	{"gpt-4o", 0.025},
+	{"gpt-3.5-turbo", 0.008},
+	{"davinci-002", 0.006},
+	{"babbage-002", 0.0004},
+}
+
+// DatasetFormat 训练文件的格式
+type DatasetFormat string
+
+// DatasetAPI 训练使用的接口，两个接口没有指定 epoch 时的默认值不同
+type DatasetAPI string
+
+// DatasetIssue 校验发现的一个问题，Line 从 1 开始，为 0 表示针对整个文件的问题
+type DatasetIssue struct {
+	Line    int
+	Code    string
+	Message string
+}
+
+func (i *DatasetIssue) String() string {
+	if i.Line == 0 {
+		return i.Code + ": " + i.Message
+	}
+	return fmt.Sprintf("line %d: %s: %s", i.Line, i.Code, i.Message)
+}
+
+// DatasetReport 训练文件的校验结果和费用估算
+type DatasetReport struct {
+	Format   DatasetFormat
+	Model    string
+	Examples int // 非空行的数量
+
+	// Errors 会导致训练任务创建失败的问题，Warnings 不影响创建但可能影响训练效果
+	Errors   []*DatasetIssue
+	Warnings []*DatasetIssue
+
+	// 以下统计只包括没有错误的样本
+	MinTokens         int
+	MaxTokens         int
+	MeanTokens        float64
+	TruncatedExamples int // 超过 token 上限会被截断的样本数量
+	Duplicates        int
+	MissingSystem     int // 只针对聊天格式
+	MissingUser       int // 只针对聊天格式
+
+	// BilledTokens 每个 epoch 计费的 token 数量，超过上限的样本按照上限计算
+	BilledTokens int
+	// NEpochs 指定的 epoch，没有指定时使用训练接口的默认值，见 WithDatasetAPI
+	NEpochs int
+	// TrainingTokens 训练总共计费的 token 数量，即 BilledTokens * NEpochs
+	TrainingTokens int
+	// PricePer1K 每 1K 训练 token 的价格（美元），为 0 表示未知
+	PricePer1K float64
+	// EstimatedCost 估算的训练费用（美元），价格未知时为 0
+	EstimatedCost float64
+}
+
+// Valid 训练文件是否可以用于创建训练任务
+func (r *DatasetReport) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// Err 校验失败时返回包含所有错误的 ErrInvalidDataset
+func (r *DatasetReport) Err() error {
+	if r.Valid() {
+		return nil
+	}
+
+	msgs := make([]string, 0, len(r.Errors))
+	for _, issue := range r.Errors {
+		msgs = append(msgs, issue.String())
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidDataset, strings.Join(msgs, "; "))
+}
+
+// Summary 返回可读的校验报告
+func (r *DatasetReport) Summary() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "format: %s\n", r.Format)
+	fmt.Fprintf(&b, "examples: %d (%d errors, %d warnings)\n", r.Examples, len(r.Errors), len(r.Warnings))
+	fmt.Fprintf(&b, "tokens per example: min %d, max %d, mean %.1f\n", r.MinTokens, r.MaxTokens, r.MeanTokens)
+
+	if r.TruncatedExamples > 0 {
+		fmt.Fprintf(&b, "examples over the token limit: %d (will be truncated)\n", r.TruncatedExamples)
+	}
+	if r.Duplicates > 0 {
+		fmt.Fprintf(&b, "duplicate examples: %d\n", r.Duplicates)
+	}
+	if r.Format == DatasetFormatChat {
+		fmt.Fprintf(&b, "examples missing system message: %d\n", r.MissingSystem)
+		fmt.Fprintf(&b, "examples missing user message: %d\n", r.MissingUser)
+	}
+
+	fmt.Fprintf(&b, "billed tokens per epoch: %d\n", r.BilledTokens)
+	fmt.Fprintf(&b, "epochs: %d\n", r.NEpochs)
+	fmt.Fprintf(&b, "training tokens: %d\n", r.TrainingTokens)
+
+	if r.PricePer1K > 0 {
+		fmt.Fprintf(&b, "estimated cost: $%.2f (%s, $%.4f/1K tokens)\n", r.EstimatedCost, r.Model, r.PricePer1K)
+	} else {
+		fmt.Fprintf(&b, "estimated cost: unknown (no price for %s)\n", r.Model)
+	}
+
+	writeIssues := func(title string, issues []*DatasetIssue) {
+		if len(issues) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "%s:\n", title)
+		for _, issue := range issues {
+			fmt.Fprintf(&b, "  %s\n", issue)
+		}
+	}
+	writeIssues("errors", r.Errors)
+	writeIssues("warnings", r.Warnings)
+
+	return b.String()
+}
+
+type datasetConfig struct {
+	format    DatasetFormat
+	model     string
+	api       DatasetAPI
+	nEpochs   int
+	maxTokens int
+	price     float64
+}
+
+type DatasetOption func(*datasetConfig)
+
+// WithDatasetFormat 指定训练文件的格式，默认根据第一个样本自动识别
+func WithDatasetFormat(format DatasetFormat) DatasetOption {
+	return func(c *datasetConfig) {
+		c.format = format
+	}
+}
+
+// WithDatasetModel 指定要训练的模型，用于计算 token 数量和价格，默认为 gpt-3.5-turbo
+func WithDatasetModel(model string) DatasetOption {
+	return func(c *datasetConfig) {
+		c.model = model
+	}
+}
+
+// WithDatasetAPI 指定训练使用的接口，用于确定没有指定 epoch 时的默认值，默认为 DatasetAPIFineTuningJobs：
+// DatasetAPIFineTuningJobs 根据有效样本的数量选择 epoch，DatasetAPIFineTunes 固定为 4
+func WithDatasetAPI(api DatasetAPI) DatasetOption {
+	return func(c *datasetConfig) {
+		c.api = api
+	}
+}
+
+// WithDatasetEpochs 指定训练的 epoch，对应 FineTuningHyperparameters.NEpochs 或者 FineTuneCreateRequest.NEpochs，
+// 默认使用训练接口的默认值，见 WithDatasetAPI
+func WithDatasetEpochs(n int) DatasetOption {
+	return func(c *datasetConfig) {
+		if n > 0 {
+			c.nEpochs = n
+		}
+	}
+}
+
+// WithDatasetMaxTokens 指定每个样本的 token 上限，默认聊天格式为 16385，prompt/completion 格式为 2048
+func WithDatasetMaxTokens(n int) DatasetOption {
+	return func(c *datasetConfig) {
+		if n > 0 {
+			c.maxTokens = n
+		}
+	}
+}
+
+// WithDatasetPrice 指定每 1K 训练 token 的价格（美元），默认使用内置的价格表
+func WithDatasetPrice(per1K float64) DatasetOption {
+	return func(c *datasetConfig) {
+		if per1K > 0 {
+			c.price = per1K
+		}
+	}
+}
+
+// ValidateDatasetFile 校验本地的 JSONL 训练文件，见 ValidateDataset
+func ValidateDatasetFile(path string, opts ...DatasetOption) (*DatasetReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ValidateDataset(f, opts...)
+}
+
+// ValidateDataset 校验 JSONL 格式的训练数据并估算训练费用，支持聊天格式和 prompt/completion 格式，
+// 只有读取失败时才返回错误，校验发现的问题记录在 DatasetReport 中。
+// 模型没有对应的 encoding 时按照平均 4 个字节一个 token 估算
+func ValidateDataset(r io.Reader, opts ...DatasetOption) (*DatasetReport, error) {
+	cfg := datasetConfig{model: GPT35Turbo, api: DatasetAPIFineTuningJobs}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	enc, err := tokenizer.EncodingForModel(cfg.model)
+	if err != nil && !errors.Is(err, tokenizer.ErrEncodingNotFound) && !errors.Is(err, tokenizer.ErrUnknownModel) {
+		return nil, err
+	}
+
+	v := &datasetValidator{
+		cfg:    cfg,
+		enc:    enc,
+		report: &DatasetReport{Format: cfg.format, Model: cfg.model},
+		seen:   make(map[string]int),
+	}
+
+	if enc == nil {
+		v.warn(0, DatasetIssueApproximateTokenCount, fmt.Sprintf("no tokenizer for %s, token counts are approximate", cfg.model))
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBatchLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		v.validateLine(line, data)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	v.finish()
+
+	return v.report, nil
+}
+
+// UploadFineTuneFile 校验训练文件，通过之后以 fine-tune 的 purpose 上传，
+// 校验失败时不会上传，返回的错误为 ErrInvalidDataset，两种情况都会返回校验结果
+func UploadFineTuneFile(ctx context.Context, files FileService, path string, opts ...DatasetOption) (*File, *DatasetReport, error) {
+	report, err := ValidateDatasetFile(path, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := report.Err(); err != nil {
+		return nil, report, err
+	}
+
+	file, err := files.Upload(ctx, &FileUploadRequest{
+		File:    path,
+		Purpose: FilePurposeFineTune,
+	})
+	return file, report, err
+}
+
+// datasetChatMessageKeys 聊天格式中消息允许的字段
+var datasetChatMessageKeys = map[string]bool{
+	"role":          true,
+	"content":       true,
+	"name":          true,
+	"function_call": true,
+	"tool_calls":    true,
+	"tool_call_id":  true,
+	"weight":        true,
+}
+
+// datasetChatExampleKeys 聊天格式中样本允许的字段
+var datasetChatExampleKeys = map[string]bool{
+	"messages":            true,
+	"functions":           true,
+	"tools":               true,
+	"parallel_tool_calls": true,
+}
+
+var datasetChatRoles = map[string]bool{
+	RoleSystem:    true,
+	RoleUser:      true,
+	RoleAssistant: true,
+	RoleFunction:  true,
+	RoleTool:      true,
+}
+
+type datasetValidator struct {
+	cfg    datasetConfig
+	enc    *tokenizer.Encoding
+	report *DatasetReport
+
+	seen        map[string]int // 样本内容到第一次出现的行号
+	tokens      []int
+	prompts     []string
+	completions []string
+}
+
+func (v *datasetValidator) fail(line int, code, msg string) {
+	v.report.Errors = append(v.report.Errors, &DatasetIssue{Line: line, Code: code, Message: msg})
+}
+
+func (v *datasetValidator) warn(line int, code, msg string) {
+	v.report.Warnings = append(v.report.Warnings, &DatasetIssue{Line: line, Code: code, Message: msg})
+}
+
+func (v *datasetValidator) count(text string) int {
+	if v.enc == nil {
+		return (len(text) + 3) / 4
+	}
+	return v.enc.Count(text)
+}
+
+func (v *datasetValidator) validateLine(line int, data []byte) {
+	v.report.Examples++
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		v.fail(line, DatasetIssueInvalidJSON, err.Error())
+		return
+	}
+
+	if v.report.Format == "" {
+		switch {
+		case obj["messages"] != nil:
+			v.report.Format = DatasetFormatChat
+		case obj["prompt"] != nil || obj["completion"] != nil:
+			v.report.Format = DatasetFormatPromptCompletion
+		default:
+			v.fail(line, DatasetIssueUnknownFormat, `expected "messages" or "prompt" and "completion"`)
+			return
+		}
+	}
+
+	var (
+		n  int
+		ok bool
+	)
+	switch v.report.Format {
+	case DatasetFormatChat:
+		n, ok = v.validateChat(line, data, obj)
+	case DatasetFormatPromptCompletion:
+		n, ok = v.validatePromptCompletion(line, obj)
+	default:
+		v.fail(line, DatasetIssueUnknownFormat, fmt.Sprintf("unsupported format %q", v.report.Format))
+		return
+	}
+
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	_ = json.Compact(&buf, data)
+	if first, dup := v.seen[buf.String()]; dup {
+		v.report.Duplicates++
+		v.warn(line, DatasetIssueDuplicate, fmt.Sprintf("same as line %d", first))
+	} else {
+		v.seen[buf.String()] = line
+	}
+
+	if n > v.maxTokens() {
+		v.report.TruncatedExamples++
+		v.warn(line, DatasetIssueTooManyTokens, fmt.Sprintf("%d tokens exceeds the limit of %d and will be truncated", n, v.maxTokens()))
+	}
+
+	v.tokens = append(v.tokens, n)
+}
+
+func (v *datasetValidator) validateChat(line int, data []byte, obj map[string]json.RawMessage) (int, bool) {
+	for key := range obj {
+		if !datasetChatExampleKeys[key] {
+			v.warn(line, DatasetIssueUnrecognizedKey, fmt.Sprintf("unrecognized key %q", key))
+		}
+	}
+
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal(obj["messages"], &raw); err != nil || len(raw) == 0 {
+		v.fail(line, DatasetIssueMissingMessages, `"messages" must be a non-empty list of messages`)
+		return 0, false
+	}
+
+	ok := true
+	for i, m := range raw {
+		for key := range m {
+			if !datasetChatMessageKeys[key] {
+				v.warn(line, DatasetIssueUnrecognizedKey, fmt.Sprintf("message %d: unrecognized key %q", i, key))
+			}
+		}
+
+		var role string
+		if m["role"] == nil {
+			v.fail(line, DatasetIssueMissingKey, fmt.Sprintf("message %d: missing role", i))
+			ok = false
+			continue
+		}
+		if err := json.Unmarshal(m["role"], &role); err != nil || !datasetChatRoles[role] {
+			v.fail(line, DatasetIssueUnrecognizedRole, fmt.Sprintf("message %d: unrecognized role %s", i, m["role"]))
+			ok = false
+			continue
+		}
+
+		content := m["content"]
+		empty := content == nil || string(content) == "null" || string(content) == `""`
+		calls := m["function_call"] != nil || m["tool_calls"] != nil
+		if empty && !(role == RoleAssistant && calls) {
+			v.fail(line, DatasetIssueMissingContent, fmt.Sprintf("message %d: missing content", i))
+			ok = false
+		}
+	}
+
+	if !ok {
+		return 0, false
+	}
+
+	var example struct {
+		Messages  []*Message  `json:"messages"`
+		Functions []*Function `json:"functions"`
+		Tools     []*Tool     `json:"tools"`
+	}
+	if err := json.Unmarshal(data, &example); err != nil {
+		v.fail(line, DatasetIssueInvalidJSON, err.Error())
+		return 0, false
+	}
+
+	roles := make(map[string]bool)
+	for _, m := range example.Messages {
+		roles[m.Role] = true
+	}
+
+	if !roles[RoleAssistant] {
+		v.fail(line, DatasetIssueMissingAssistant, "example has no assistant message")
+		return 0, false
+	}
+	if !roles[RoleSystem] {
+		v.report.MissingSystem++
+	}
+	if !roles[RoleUser] {
+		v.report.MissingUser++
+	}
+
+	functions := example.Functions
+	for _, tool := range example.Tools {
+		if tool.Function != nil {
+			functions = append(functions, tool.Function)
+		}
+	}
+
+	if v.enc == nil {
+		return approximateMessageTokens(example.Messages, functions), true
+	}
+	return countPromptTokens(v.enc, v.cfg.model, example.Messages, functions), true
+}
+
+func (v *datasetValidator) validatePromptCompletion(line int, obj map[string]json.RawMessage) (int, bool) {
+	for key := range obj {
+		if key != "prompt" && key != "completion" {
+			v.warn(line, DatasetIssueUnrecognizedKey, fmt.Sprintf("unrecognized key %q", key))
+		}
+	}
+
+	var prompt, completion string
+	ok := true
+
+	if err := json.Unmarshal(obj["prompt"], &prompt); err != nil {
+		v.fail(line, DatasetIssueMissingPrompt, `"prompt" must be a string`)
+		ok = false
+	}
+	if err := json.Unmarshal(obj["completion"], &completion); err != nil || completion == "" {
+		v.fail(line, DatasetIssueMissingCompletion, `"completion" must be a non-empty string`)
+		ok = false
+	}
+
+	if !ok {
+		return 0, false
+	}
+
+	v.prompts = append(v.prompts, prompt)
+	v.completions = append(v.completions, completion)
+
+	return v.count(prompt) + v.count(completion), true
+}
+
+func (v *datasetValidator) maxTokens() int {
+	if v.cfg.maxTokens > 0 {
+		return v.cfg.maxTokens
+	}
+	if v.report.Format == DatasetFormatPromptCompletion {
+		return DatasetMaxTokensPromptCompletion
+	}
+	return DatasetMaxTokensChat
+}
+
+// finish 检查整个文件范围的问题，计算统计数据和费用
+func (v *datasetValidator) finish() {
+	r := v.report
+
+	switch {
+	case r.Examples < datasetMinExamples:
+		v.fail(0, DatasetIssueTooFewExamples, fmt.Sprintf("%d examples, at least %d are required", r.Examples, datasetMinExamples))
+	case r.Examples < datasetRecommendedExamples:
+		v.warn(0, DatasetIssueFewExamples, fmt.Sprintf("%d examples, at least %d are recommended", r.Examples, datasetRecommendedExamples))
+	}
+
+	if r.Format == DatasetFormatPromptCompletion && len(v.prompts) > 0 {
+		v.checkPromptCompletion()
+	}
+
+	if len(v.tokens) > 0 {
+		r.MinTokens, r.MaxTokens = math.MaxInt, 0
+
+		total := 0
+		for _, n := range v.tokens {
+			if n < r.MinTokens {
+				r.MinTokens = n
+			}
+			if n > r.MaxTokens {
+				r.MaxTokens = n
+			}
+			total += n
+
+			if n > v.maxTokens() {
+				n = v.maxTokens()
+			}
+			r.BilledTokens += n
+		}
+
+		r.MeanTokens = float64(total) / float64(len(v.tokens))
+	}
+
+	r.NEpochs = v.cfg.nEpochs
+	if r.NEpochs == 0 {
+		if v.cfg.api == DatasetAPIFineTunes {
+			r.NEpochs = datasetLegacyEpochs
+		} else {
+			// 只统计有效的样本，无效的样本不会参与训练
+			r.NEpochs = defaultDatasetEpochs(len(v.tokens))
+		}
+	}
+	r.TrainingTokens = r.BilledTokens * r.NEpochs
+
+	r.PricePer1K = v.cfg.price
+	if r.PricePer1K == 0 {
+		r.PricePer1K = fineTuningTrainingPrice(v.cfg.model)
+	}
+	r.EstimatedCost = float64(r.TrainingTokens) / 1000 * r.PricePer1K
+
+	sort.SliceStable(r.Errors, func(i, j int) bool { return r.Errors[i].Line < r.Errors[j].Line })
+	sort.SliceStable(r.Warnings, func(i, j int) bool { return r.Warnings[i].Line < r.Warnings[j].Line })
+}
+
+// checkPromptCompletion prompt/completion 格式的建议，与官方数据准备工具的提示一致
+func (v *datasetValidator) checkPromptCompletion() {
+	if len(v.prompts) > 1 && commonSuffix(v.prompts) == "" {
+		v.warn(0, DatasetIssueMissingSeparator, `prompts do not end with a common separator such as "\n\n###\n\n"`)
+	}
+
+	if len(v.completions) > 1 && commonSuffix(v.completions) == "" {
+		v.warn(0, DatasetIssueMissingStopSequence, `completions do not end with a common stop sequence such as "\n" or " END"`)
+	}
+
+	missing := 0
+	for _, c := range v.completions {
+		if r := []rune(c); len(r) > 0 && !unicode.IsSpace(r[0]) {
+			missing++
+		}
+	}
+	if missing > 0 {
+		v.warn(0, DatasetIssueCompletionWhitespace, fmt.Sprintf("%d completions do not start with a whitespace character", missing))
+	}
+}
+
+// defaultDatasetEpochs 没有指定 epoch 时 /fine_tuning/jobs 接口根据样本数量选择的 epoch
+func defaultDatasetEpochs(examples int) int {
+	if examples == 0 {
+		return datasetTargetEpochs
+	}
+
+	switch {
+	case examples*datasetTargetEpochs < datasetMinTargetExamples:
+		// 与官方数据准备工具一致，向下取整
+		n := datasetMinTargetExamples / examples
+		if n > datasetMaxDefaultEpochs {
+			n = datasetMaxDefaultEpochs
+		}
+		return n
+	case examples*datasetTargetEpochs > datasetMaxTargetExamples:
+		n := datasetMaxTargetExamples / examples
+		if n < datasetMinDefaultEpochs {
+			n = datasetMinDefaultEpochs
+		}
+		return n
+	}
+
+	return datasetTargetEpochs
+}
+
+// fineTuningTrainingPrice 返回模型每 1K 训练 token 的价格，未知时返回 0
+func fineTuningTrainingPrice(model string) float64 {
+	model = strings.TrimPrefix(model, "ft:")
+	for _, p := range fineTuningTrainingPrices {
+		if strings.HasPrefix(model, p.prefix) {
+			return p.price
+		}
+	}
+	return 0
+}
+
+// commonSuffix 返回所有字符串共同的后缀
+func commonSuffix(values []string) string {
+	suffix := values[0]
+	for _, s := range values[1:] {
+		i := 0
+		for i < len(suffix) && i < len(s) && suffix[len(suffix)-1-i] == s[len(s)-1-i] {
+			i++
+		}
+		suffix = suffix[len(suffix)-i:]
+		if suffix == "" {
+			break
+		}
+	}
+	return suffix
+}
@@ -0,0 +1,369 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func chatDatasetLine(t *testing.T, messages ...*Message) string {
+	b, err := json.Marshal(map[string]any{"messages": messages})
+	require.NoError(t, err)
+	return string(b)
+}
+
+func chatDataset(t *testing.T, n int) []string {
+	lines := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		lines = append(lines, chatDatasetLine(t,
+			&Message{Role: RoleSystem, Content: "You are a helpful assistant."},
+			&Message{Role: RoleUser, Content: fmt.Sprintf("question %d", i)},
+			&Message{Role: RoleAssistant, Content: fmt.Sprintf("answer %d", i)},
+		))
+	}
+	return lines
+}
+
+func repeatLines(line string, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = line
+	}
+	return lines
+}
+
+func issueCodes(issues []*DatasetIssue) []string {
+	codes := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		codes = append(codes, fmt.Sprintf("%d:%s", issue.Line, issue.Code))
+	}
+	return codes
+}
+
+func TestValidateDataset(t *testing.T) {
+	registerMockEncoding(t)
+
+	example := []*Message{
+		{Role: RoleSystem, Content: "You are a helpful assistant."},
+		{Role: RoleUser, Content: "question 0"},
+		{Role: RoleAssistant, Content: "answer 0"},
+	}
+	exampleTokens, err := CountMessageTokens(GPT35Turbo, example, nil)
+	require.NoError(t, err)
+
+	testCase := []struct {
+		name         string
+		lines        []string
+		opts         []DatasetOption
+		wantFormat   DatasetFormat
+		wantExamples int
+		wantErrors   []string
+		wantWarnings []string
+		check        func(t *testing.T, r *DatasetReport)
+	}{
+		{
+			name:         "test valid chat dataset",
+			lines:        chatDataset(t, 100),
+			opts:         []DatasetOption{WithDatasetEpochs(2)},
+			wantFormat:   DatasetFormatChat,
+			wantExamples: 100,
+			wantErrors:   []string{},
+			wantWarnings: []string{},
+			check: func(t *testing.T, r *DatasetReport) {
+				require.True(t, r.Valid())
+				require.NoError(t, r.Err())
+				require.Equal(t, exampleTokens, r.MinTokens)
+				require.Equal(t, exampleTokens+2, r.MaxTokens)
+				require.Equal(t, 2, r.NEpochs)
+				require.Equal(t, r.BilledTokens*2, r.TrainingTokens)
+				require.Equal(t, 0.008, r.PricePer1K)
+				require.InDelta(t, float64(r.TrainingTokens)/1000*0.008, r.EstimatedCost, 1e-9)
+			},
+		},
+		{
+			name:         "test default epochs for small dataset",
+			lines:        chatDataset(t, 20),
+			wantFormat:   DatasetFormatChat,
+			wantExamples: 20,
+			wantErrors:   []string{},
+			wantWarnings: []string{"0:" + DatasetIssueFewExamples},
+			check: func(t *testing.T, r *DatasetReport) {
+				require.Equal(t, 5, r.NEpochs)
+			},
+		},
+		{
+			name:         "test default epochs rounds down",
+			lines:        chatDataset(t, 30),
+			wantFormat:   DatasetFormatChat,
+			wantExamples: 30,
+			wantErrors:   []string{},
+			wantWarnings: []string{"0:" + DatasetIssueFewExamples},
+			check: func(t *testing.T, r *DatasetReport) {
+				require.Equal(t, 3, r.NEpochs)
+				require.Equal(t, r.BilledTokens*3, r.TrainingTokens)
+			},
+		},
+		{
+			name:         "test default epochs count valid examples only",
+			lines:        append(chatDataset(t, 20), repeatLines(`{"messages": []}`, 20)...),
+			wantFormat:   DatasetFormatChat,
+			wantExamples: 40,
+			wantErrors: func() []string {
+				codes := make([]string, 0, 20)
+				for line := 21; line <= 40; line++ {
+					codes = append(codes, fmt.Sprintf("%d:%s", line, DatasetIssueMissingMessages))
+				}
+				return codes
+			}(),
+			wantWarnings: []string{"0:" + DatasetIssueFewExamples},
+			check: func(t *testing.T, r *DatasetReport) {
+				require.Equal(t, 5, r.NEpochs)
+			},
+		},
+		{
+			name:         "test default epochs for legacy api",
+			lines:        chatDataset(t, 20),
+			opts:         []DatasetOption{WithDatasetAPI(DatasetAPIFineTunes)},
+			wantFormat:   DatasetFormatChat,
+			wantExamples: 20,
+			wantErrors:   []string{},
+			wantWarnings: []string{"0:" + DatasetIssueFewExamples},
+			check: func(t *testing.T, r *DatasetReport) {
+				require.Equal(t, 4, r.NEpochs)
+				require.Equal(t, r.BilledTokens*4, r.TrainingTokens)
+			},
+		},
+		{
+			name: "test chat errors",
+			lines: append(chatDataset(t, 10),
+				`{"messages": [{"role": "user", "content": "hi"}`,
+				`{"messages": []}`,
+				`{"messages": [{"role": "bot", "content": "hi"}, {"role": "assistant", "content": "hello"}]}`,
+				`{"messages": [{"role": "user"}, {"role": "assistant", "content": "hello"}]}`,
+				`{"messages": [{"role": "user", "content": "hi"}, {"content": "hello"}]}`,
+				`{"messages": [{"role": "system", "content": "be brief"}, {"role": "user", "content": "hi"}]}`,
+			),
+			wantFormat:   DatasetFormatChat,
+			wantExamples: 16,
+			wantErrors: []string{
+				"11:" + DatasetIssueInvalidJSON,
+				"12:" + DatasetIssueMissingMessages,
+				"13:" + DatasetIssueUnrecognizedRole,
+				"14:" + DatasetIssueMissingContent,
+				"15:" + DatasetIssueMissingKey,
+				"16:" + DatasetIssueMissingAssistant,
+			},
+			wantWarnings: []string{"0:" + DatasetIssueFewExamples},
+			check: func(t *testing.T, r *DatasetReport) {
+				require.False(t, r.Valid())
+				require.ErrorIs(t, r.Err(), ErrInvalidDataset)
+			},
+		},
+		{
+			name: "test chat warnings",
+			lines: append(chatDataset(t, 10),
+				chatDataset(t, 1)[0],
+				`{"messages": [{"role": "assistant", "content": "hello", "score": 1}], "id": 1}`,
+				`{"messages": [{"role": "user", "content": "weather?"}, {"role": "assistant", "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "weather", "arguments": "{}"}}]}]}`,
+			),
+			wantFormat:   DatasetFormatChat,
+			wantExamples: 13,
+			wantErrors:   []string{},
+			wantWarnings: []string{
+				"0:" + DatasetIssueFewExamples,
+				"11:" + DatasetIssueDuplicate,
+				"12:" + DatasetIssueUnrecognizedKey,
+				"12:" + DatasetIssueUnrecognizedKey,
+			},
+			check: func(t *testing.T, r *DatasetReport) {
+				require.Equal(t, 1, r.Duplicates)
+				require.Equal(t, 2, r.MissingSystem)
+				require.Equal(t, 1, r.MissingUser)
+			},
+		},
+		{
+			name:         "test truncated examples",
+			lines:        chatDataset(t, 10)[:2],
+			opts:         []DatasetOption{WithDatasetMaxTokens(exampleTokens - 1), WithDatasetEpochs(1)},
+			wantFormat:   DatasetFormatChat,
+			wantExamples: 2,
+			wantErrors:   []string{"0:" + DatasetIssueTooFewExamples},
+			wantWarnings: []string{"1:" + DatasetIssueTooManyTokens, "2:" + DatasetIssueTooManyTokens},
+			check: func(t *testing.T, r *DatasetReport) {
+				require.Equal(t, 2, r.TruncatedExamples)
+				require.Equal(t, exampleTokens, r.MaxTokens)
+				// 超过上限的样本按照上限计费
+				require.Equal(t, (exampleTokens-1)*2, r.BilledTokens)
+			},
+		},
+		{
+			name: "test prompt completion dataset",
+			lines: func() []string {
+				lines := make([]string, 0, 12)
+				for i := 0; i < 10; i++ {
+					lines = append(lines, fmt.Sprintf(`{"prompt": "question %d\n\n###\n\n", "completion": " answer %d END"}`, i, i))
+				}
+				return append(lines,
+					`{"prompt": "question", "completion": ""}`,
+					`{"prompt": "question 10\n\n###\n\n", "completion": " answer 10 END", "id": 10}`,
+				)
+			}(),
+			opts:         []DatasetOption{WithDatasetModel("babbage-002"), WithDatasetMaxTokens(30)},
+			wantFormat:   DatasetFormatPromptCompletion,
+			wantExamples: 12,
+			wantErrors:   []string{"11:" + DatasetIssueMissingCompletion},
+			wantWarnings: []string{
+				"0:" + DatasetIssueFewExamples,
+				"12:" + DatasetIssueUnrecognizedKey,
+				"12:" + DatasetIssueTooManyTokens,
+			},
+			check: func(t *testing.T, r *DatasetReport) {
+				// "question 0\n\n###\n\n" 17 个 token，" answer 0 END" 13 个 token
+				require.Equal(t, 30, r.MinTokens)
+				require.Equal(t, 32, r.MaxTokens)
+				require.Equal(t, 1, r.TruncatedExamples)
+				require.Equal(t, 0.0004, r.PricePer1K)
+			},
+		},
+		{
+			name: "test prompt completion warnings",
+			lines: func() []string {
+				lines := make([]string, 0, 10)
+				for i := 0; i < 10; i++ {
+					lines = append(lines, fmt.Sprintf(`{"prompt": "question %d", "completion": "answer %d"}`, i, i))
+				}
+				return lines
+			}(),
+			opts:         []DatasetOption{WithDatasetFormat(DatasetFormatPromptCompletion), WithDatasetModel("unknown-model")},
+			wantFormat:   DatasetFormatPromptCompletion,
+			wantExamples: 10,
+			wantErrors:   []string{},
+			wantWarnings: []string{
+				"0:" + DatasetIssueApproximateTokenCount,
+				"0:" + DatasetIssueFewExamples,
+				"0:" + DatasetIssueMissingSeparator,
+				"0:" + DatasetIssueMissingStopSequence,
+				"0:" + DatasetIssueCompletionWhitespace,
+			},
+			check: func(t *testing.T, r *DatasetReport) {
+				require.Equal(t, 0.0, r.PricePer1K)
+				require.Equal(t, 0.0, r.EstimatedCost)
+				require.Contains(t, r.Summary(), "estimated cost: unknown")
+			},
+		},
+		{
+			name:         "test unknown format",
+			lines:        []string{`{"input": "hi"}`},
+			wantExamples: 1,
+			wantErrors:   []string{"0:" + DatasetIssueTooFewExamples, "1:" + DatasetIssueUnknownFormat},
+			wantWarnings: []string{},
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := ValidateDataset(strings.NewReader(strings.Join(tc.lines, "\n")+"\n\n"), tc.opts...)
+			require.NoError(t, err)
+			require.Equal(t, tc.wantFormat, r.Format)
+			require.Equal(t, tc.wantExamples, r.Examples)
+			require.Equal(t, tc.wantErrors, issueCodes(r.Errors))
+			require.Equal(t, tc.wantWarnings, issueCodes(r.Warnings))
+			if tc.check != nil {
+				tc.check(t, r)
+			}
+		})
+	}
+}
+
+func TestDefaultDatasetEpochs(t *testing.T) {
+	testCase := []struct {
+		name     string
+		examples int
+		want     int
+	}{
+		{name: "test tiny dataset", examples: 2, want: 25},
+		{name: "test small dataset", examples: 10, want: 10},
+		{name: "test small dataset not divisible", examples: 30, want: 3},
+		{name: "test small dataset rounds down", examples: 14, want: 7},
+		{name: "test medium dataset", examples: 1000, want: 3},
+		{name: "test large dataset", examples: 10000, want: 2},
+		{name: "test huge dataset", examples: 100000, want: 1},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, defaultDatasetEpochs(tc.examples))
+		})
+	}
+}
+
+func TestDatasetReport_Summary(t *testing.T) {
+	registerMockEncoding(t)
+
+	r, err := ValidateDataset(strings.NewReader(strings.Join(append(chatDataset(t, 10), `{"messages": []}`), "\n")))
+	require.NoError(t, err)
+
+	summary := r.Summary()
+	require.Contains(t, summary, "format: chat\n")
+	require.Contains(t, summary, "examples: 11 (1 errors, 1 warnings)\n")
+	require.Contains(t, summary, "epochs: 10\n")
+	require.Contains(t, summary, fmt.Sprintf("estimated cost: $%.2f (gpt-3.5-turbo, $0.0080/1K tokens)\n", r.EstimatedCost))
+	require.Contains(t, summary, "errors:\n  line 11: "+DatasetIssueMissingMessages)
+	require.Contains(t, summary, "warnings:\n  "+DatasetIssueFewExamples)
+}
+
+type fakeFileService struct {
+	FileService
+	uploads []*FileUploadRequest
+}
+
+func (f *fakeFileService) Upload(ctx context.Context, req *FileUploadRequest) (*File, error) {
+	f.uploads = append(f.uploads, req)
+	return &File{Id: "file-abc123", Purpose: req.Purpose}, nil
+}
+
+func TestUploadFineTuneFile(t *testing.T) {
+	registerMockEncoding(t)
+
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.jsonl")
+	invalid := filepath.Join(dir, "invalid.jsonl")
+	require.NoError(t, os.WriteFile(valid, []byte(strings.Join(chatDataset(t, 10), "\n")), 0o644))
+	require.NoError(t, os.WriteFile(invalid, []byte(strings.Join(chatDataset(t, 5), "\n")), 0o644))
+
+	files := &fakeFileService{}
+
+	file, report, err := UploadFineTuneFile(context.TODO(), files, invalid)
+	require.ErrorIs(t, err, ErrInvalidDataset)
+	require.Nil(t, file)
+	require.False(t, report.Valid())
+	require.Empty(t, files.uploads)
+
+	file, report, err = UploadFineTuneFile(context.TODO(), files, valid)
+	require.NoError(t, err)
+	require.True(t, report.Valid())
+	require.Equal(t, "file-abc123", file.Id)
+	require.Equal(t, []*FileUploadRequest{{File: valid, Purpose: FilePurposeFineTune}}, files.uploads)
+
+	_, _, err = UploadFineTuneFile(context.TODO(), files, filepath.Join(dir, "missing.jsonl"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,203 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"strings"
+	"sync"
+)
+
+// ChoiceStream n > 1 时某一个 choice 的子流，用法与 Stream 类似：
+//
+//	for cs.Next() {
+//		fmt.Print(cs.Current())
+//	}
+//
+// 子流内部使用无界缓冲，某个子流消费得慢不会阻塞其他子流
+type ChoiceStream struct {
+	Index int64
+
+	mu           sync.Mutex
+	cond         *sync.Cond
+	pending      []string
+	cur          string
+	text         strings.Builder
+	finishReason string
+	done         bool
+	err          error
+}
+
+func newChoiceStream(index int64) *ChoiceStream {
+	cs := &ChoiceStream{Index: index}
+	cs.cond = sync.NewCond(&cs.mu)
+	return cs
+}
+
+func (c *ChoiceStream) push(text, finishReason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 已经结束的 choice 忽略后续的 chunk
+	if c.done {
+		return
+	}
+
+	if text != "" {
+		c.pending = append(c.pending, text)
+		c.text.WriteString(text)
+	}
+
+	// 收到 finish_reason 后该 choice 立即结束，不需要等待其他 choice
+	if finishReason != "" {
+		c.finishReason = finishReason
+		c.done = true
+	}
+
+	c.cond.Broadcast()
+}
+
+// finish 上游流结束，已经结束的 choice 不受影响
+func (c *ChoiceStream) finish(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done {
+		return
+	}
+
+	c.done = true
+	c.err = err
+
+	c.cond.Broadcast()
+}
+
+// Next 阻塞直到该 choice 有新的文本片段，返回 false 表示该 choice 已经结束：
+// 收到该 choice 的 finish_reason，或者上游流结束
+func (c *ChoiceStream) Next() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.pending) == 0 && !c.done {
+		c.cond.Wait()
+	}
+
+	if len(c.pending) == 0 {
+		return false
+	}
+
+	c.cur = c.pending[0]
+	c.pending = c.pending[1:]
+
+	return true
+}
+
+// Current 返回当前的文本片段
+func (c *ChoiceStream) Current() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cur
+}
+
+// Text 返回该 choice 目前为止收到的全部文本
+func (c *ChoiceStream) Text() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.text.String()
+}
+
+// FinishReason 返回该 choice 的结束原因，还没有结束时为空
+func (c *ChoiceStream) FinishReason() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.finishReason
+}
+
+// Err 返回上游流的错误，所有没有收到 finish_reason 的子流共享同一个错误
+func (c *ChoiceStream) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+type choiceChunk struct {
+	index        int64
+	text         string
+	finishReason string
+}
+
+// DemuxChatStream 将 N > 1 的 chat stream 按照 choice 的 index 拆分成 n 个子流，返回值的下标即为 index
+// index 超出 n 的 chunk 会被忽略；上游流结束后会被自动 Close，如果需要提前结束，直接 Close 上游流即可
+func DemuxChatStream(s *Stream[*ChatCreateResponse], n int) []*ChoiceStream {
+	return demux(s, n, func(resp *ChatCreateResponse) []choiceChunk {
+		chunks := make([]choiceChunk, 0, len(resp.Choices))
+		for _, choice := range resp.Choices {
+			c := choiceChunk{
+				index:        choice.Index,
+				finishReason: choice.FinishReason,
+			}
+			if choice.Delta != nil {
+				c.text = choice.Delta.Content
+			}
+			chunks = append(chunks, c)
+		}
+		return chunks
+	})
+}
+
+// DemuxCompletionStream 将 N > 1 的 completion stream 按照 choice 的 index 拆分成 n 个子流，规则同 DemuxChatStream
+func DemuxCompletionStream(s *Stream[*CompletionCreateResponse], n int) []*ChoiceStream {
+	return demux(s, n, func(resp *CompletionCreateResponse) []choiceChunk {
+		chunks := make([]choiceChunk, 0, len(resp.Choices))
+		for _, choice := range resp.Choices {
+			chunks = append(chunks, choiceChunk{
+				index:        choice.Index,
+				text:         choice.Text,
+				finishReason: choice.FinishReason,
+			})
+		}
+		return chunks
+	})
+}
+
+func demux[T any](s *Stream[T], n int, split func(T) []choiceChunk) []*ChoiceStream {
+	if n < 1 {
+		n = 1
+	}
+
+	streams := make([]*ChoiceStream, n)
+	for i := range streams {
+		streams[i] = newChoiceStream(int64(i))
+	}
+
+	go func() {
+		defer s.Close()
+
+		for s.Next() {
+			for _, c := range split(s.Current()) {
+				if c.index < 0 || c.index >= int64(n) {
+					continue
+				}
+				streams[c.index].push(c.text, c.finishReason)
+			}
+		}
+
+		err := s.Err()
+		for _, cs := range streams {
+			cs.finish(err)
+		}
+	}()
+
+	return streams
+}
@@ -0,0 +1,168 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDemuxChatStream(t *testing.T) {
+	testCase := []struct {
+		name             string
+		chunks           []string
+		done             bool
+		wantTexts        []string
+		wantFinishReason []string
+		wantErr          error
+	}{
+		{
+			name: "test demux interleaved choices",
+			chunks: []string{
+				mockChatChunk(0, "Hello", ""),
+				mockChatChunk(1, "Hi", ""),
+				mockChatChunk(1, " there", ""),
+				mockChatChunk(0, " world", ""),
+				mockChatChunk(1, "", FinishReasonStop),
+				mockChatChunk(0, "", "length"),
+				mockChatChunk(5, "ignored", ""),
+			},
+			done:             true,
+			wantTexts:        []string{"Hello world", "Hi there"},
+			wantFinishReason: []string{"length", FinishReasonStop},
+		},
+		{
+			name: "test demux truncated stream",
+			chunks: []string{
+				mockChatChunk(0, "Hello", ""),
+				mockChatChunk(1, "Hi", ""),
+			},
+			wantTexts:        []string{"Hello", "Hi"},
+			wantFinishReason: []string{"", ""},
+			wantErr:          io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newMockServer(newMockStreamServer(tc.chunks, 0, tc.done, nil))
+			defer server.Close()
+
+			client := newMockClient(server.URL)
+
+			s, err := client.Chat.CreateStream(context.TODO(), &ChatCreateRequest{Model: GPT35Turbo, N: 2})
+			require.NoError(t, err)
+
+			streams := DemuxChatStream(s, 2)
+			require.Len(t, streams, 2)
+
+			texts := make([]string, len(streams))
+
+			var wg sync.WaitGroup
+			for i, cs := range streams {
+				wg.Add(1)
+				go func(i int, cs *ChoiceStream) {
+					defer wg.Done()
+					var sb strings.Builder
+					for cs.Next() {
+						sb.WriteString(cs.Current())
+					}
+					texts[i] = sb.String()
+				}(i, cs)
+			}
+			wg.Wait()
+
+			require.Equal(t, tc.wantTexts, texts)
+
+			for i, cs := range streams {
+				require.Equal(t, int64(i), cs.Index)
+				require.Equal(t, tc.wantTexts[i], cs.Text())
+				require.Equal(t, tc.wantFinishReason[i], cs.FinishReason())
+				require.ErrorIs(t, cs.Err(), tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestDemuxChatStream_FinishEarly(t *testing.T) {
+	// choice 1 很快结束，choice 0 还需要较长时间
+	server := newMockServer(newMockStreamServer([]string{
+		mockChatChunk(1, "Hi", FinishReasonStop),
+		mockChatChunk(0, "Hello", ""),
+		mockChatChunk(0, " world", ""),
+		mockChatChunk(0, "", FinishReasonStop),
+	}, 100*time.Millisecond, true, nil))
+	defer server.Close()
+
+	client := newMockClient(server.URL)
+
+	s, err := client.Chat.CreateStream(context.TODO(), &ChatCreateRequest{Model: GPT35Turbo, N: 2})
+	require.NoError(t, err)
+
+	streams := DemuxChatStream(s, 2)
+
+	for streams[1].Next() {
+	}
+
+	require.Equal(t, "Hi", streams[1].Text())
+	require.Equal(t, FinishReasonStop, streams[1].FinishReason())
+	require.NoError(t, streams[1].Err())
+	// choice 1 结束时 choice 0 还没有结束
+	require.Empty(t, streams[0].FinishReason())
+
+	for streams[0].Next() {
+	}
+
+	require.Equal(t, "Hello world", streams[0].Text())
+	require.Equal(t, FinishReasonStop, streams[0].FinishReason())
+	require.NoError(t, streams[0].Err())
+}
+
+func TestDemuxCompletionStream(t *testing.T) {
+	chunk := func(index int64, text string) string {
+		return fmt.Sprintf(`{"id":"cmpl-123","object":"text_completion","created":1589478378,"choices":[{"text":%q,"index":%d,"finish_reason":null}]}`, text, index)
+	}
+
+	server := newMockServer(newMockStreamServer([]string{
+		chunk(1, "B"),
+		chunk(0, "A"),
+		chunk(0, "a"),
+		chunk(1, "b"),
+	}, 0, true, nil))
+	defer server.Close()
+
+	client := newMockClient(server.URL)
+
+	s, err := client.Completions.CreateStream(context.TODO(), &CompletionCreateRequest{Model: TextDaVinci003, N: 2})
+	require.NoError(t, err)
+
+	streams := DemuxCompletionStream(s, 2)
+
+	// 先把第二个子流读完，确认不会被第一个子流阻塞
+	for streams[1].Next() {
+	}
+	for streams[0].Next() {
+	}
+
+	require.Equal(t, "Aa", streams[0].Text())
+	require.Equal(t, "Bb", streams[1].Text())
+	require.NoError(t, streams[0].Err())
+}
@@ -0,0 +1,357 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrSlowSubscriber 订阅者消费过慢被断开
+	ErrSlowSubscriber = errors.New("openai: subscriber is too slow and has been disconnected")
+	// ErrFanOutNotStarted 没有调用 Start 就获取结果
+	ErrFanOutNotStarted = errors.New("openai: fan-out is not started")
+)
+
+type SubscribePolicy int
+
+const (
+	// SubscribeBlock 订阅者的缓冲满时阻塞分发，不会丢失 chunk，但是会拖慢其他订阅者
+	SubscribeBlock SubscribePolicy = iota
+	// SubscribeDrop 订阅者的缓冲满时丢弃发给该订阅者的 chunk
+	SubscribeDrop
+	// SubscribeDisconnect 订阅者的缓冲满时断开该订阅者，Err 返回 ErrSlowSubscriber
+	SubscribeDisconnect
+
+	defaultSubscribeBuffer = 16
+)
+
+type subscribeConfig struct {
+	policy SubscribePolicy
+	buffer int
+}
+
+type SubscribeOption func(*subscribeConfig)
+
+// WithSubscribePolicy 设置订阅者的缓冲策略，默认为 SubscribeBlock
+func WithSubscribePolicy(policy SubscribePolicy) SubscribeOption {
+	return func(c *subscribeConfig) {
+		c.policy = policy
+	}
+}
+
+// WithSubscribeBuffer 设置订阅者的缓冲大小，默认为 16
+func WithSubscribeBuffer(size int) SubscribeOption {
+	return func(c *subscribeConfig) {
+		if size >= 0 {
+			c.buffer = size
+		}
+	}
+}
+
+// FanOut 将一个 Stream 的每个 chunk 分发给多个订阅者，例如同时交给 UI、持久化和审核，用法如下：
+//
+//	f := openai.NewChatFanOut(stream)
+//	ui := f.Subscribe()
+//	store := f.Subscribe(openai.WithSubscribePolicy(openai.SubscribeDrop))
+//	f.Start()
+//
+//	for chunk := range ui.C() {
+//		// handle chunk
+//	}
+//
+// 在 Start 之前订阅的订阅者能收到全部 chunk，之后订阅的只能收到订阅之后的 chunk，
+// 流结束之后订阅的订阅者不会收到任何 chunk，但是可以通过 Result 获取合并后的最终结果
+type FanOut[T any] struct {
+	stream *Stream[T]
+	acc    Accumulator[T]
+
+	startOnce sync.Once
+	done      chan struct{}
+	closeOnce sync.Once
+	closing   chan struct{}
+
+	mu       sync.Mutex
+	subs     []*Subscription[T]
+	started  bool
+	finished bool
+	result   T
+	err      error
+}
+
+// NewFanOut 基于 Stream 创建一个 FanOut，acc 用于合并最终结果，可以为 nil
+func NewFanOut[T any](s *Stream[T], acc Accumulator[T]) *FanOut[T] {
+	return &FanOut[T]{
+		stream:  s,
+		acc:     acc,
+		done:    make(chan struct{}),
+		closing: make(chan struct{}),
+	}
+}
+
+// NewChatFanOut 创建一个分发 chat stream 的 FanOut，最终结果为合并后的 ChatCreateResponse
+func NewChatFanOut(s *Stream[*ChatCreateResponse]) *FanOut[*ChatCreateResponse] {
+	return NewFanOut[*ChatCreateResponse](s, NewChatAccumulator())
+}
+
+// NewCompletionFanOut 创建一个分发 completion stream 的 FanOut，最终结果为合并后的 CompletionCreateResponse
+func NewCompletionFanOut(s *Stream[*CompletionCreateResponse]) *FanOut[*CompletionCreateResponse] {
+	return NewFanOut[*CompletionCreateResponse](s, NewCompletionAccumulator())
+}
+
+// Subscribe 添加一个订阅者
+func (f *FanOut[T]) Subscribe(opts ...SubscribeOption) *Subscription[T] {
+	cfg := &subscribeConfig{
+		policy: SubscribeBlock,
+		buffer: defaultSubscribeBuffer,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	sub := &Subscription[T]{
+		fanOut: f,
+		policy: cfg.policy,
+		ch:     make(chan T, cfg.buffer),
+		quit:   make(chan struct{}),
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// 流已经结束，直接关闭，订阅者可以通过 Result 获取最终结果
+	if f.finished {
+		sub.finish(f.err)
+		return sub
+	}
+
+	f.subs = append(f.subs, sub)
+
+	return sub
+}
+
+// Start 开始分发，可以重复调用
+func (f *FanOut[T]) Start() {
+	f.startOnce.Do(func() {
+		f.mu.Lock()
+		f.started = true
+		f.mu.Unlock()
+
+		go f.run()
+	})
+}
+
+// Done 返回一个在流结束并且所有订阅者都被关闭之后关闭的 channel
+func (f *FanOut[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result 阻塞直到流结束，返回合并后的最终结果和上游的错误，如果没有设置 Accumulator，结果为零值，
+// 没有调用 Start 时返回 ErrFanOutNotStarted
+func (f *FanOut[T]) Result() (T, error) {
+	f.mu.Lock()
+	started := f.started
+	f.mu.Unlock()
+
+	if !started {
+		var zero T
+		return zero, ErrFanOutNotStarted
+	}
+
+	<-f.done
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.result, f.err
+}
+
+// Close 关闭上游的 Stream，所有订阅者都会随之结束，阻塞在 SubscribeBlock 订阅者上的分发也会立即停止，
+// 没有调用 Start 时同样会关闭所有订阅者
+func (f *FanOut[T]) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.closing)
+	})
+
+	err := f.stream.Close()
+
+	// 确保分发的 goroutine 运行，由它关闭所有订阅者和 Done
+	f.Start()
+
+	return err
+}
+
+func (f *FanOut[T]) run() {
+	defer f.stream.Close()
+
+	for f.stream.Next() {
+		select {
+		case <-f.closing:
+			// 已经关闭，不再分发剩余的 chunk
+			continue
+		default:
+		}
+
+		chunk := f.stream.Current()
+
+		if f.acc != nil {
+			_ = f.acc.Add(chunk)
+		}
+
+		f.mu.Lock()
+		subs := make([]*Subscription[T], len(f.subs))
+		copy(subs, f.subs)
+		f.mu.Unlock()
+
+		for _, sub := range subs {
+			if !sub.deliver(chunk) {
+				f.remove(sub)
+			}
+		}
+	}
+
+	f.mu.Lock()
+	f.finished = true
+	f.err = f.stream.Err()
+	if f.acc != nil {
+		f.result = f.acc.Result()
+	}
+	subs := f.subs
+	f.subs = nil
+	f.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.finish(f.err)
+	}
+
+	close(f.done)
+}
+
+func (f *FanOut[T]) remove(sub *Subscription[T]) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, s := range f.subs {
+		if s == sub {
+			f.subs = append(f.subs[:i], f.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Subscription FanOut 的订阅者
+type Subscription[T any] struct {
+	fanOut *FanOut[T]
+	policy SubscribePolicy
+	ch     chan T
+
+	quit     chan struct{}
+	quitOnce sync.Once
+
+	dropped int64
+
+	// mu 保护 ch 的发送和关闭，以及 err
+	mu     sync.Mutex
+	closed bool
+	err    error
+}
+
+// C 返回接收 chunk 的 channel，流结束、订阅者被断开或者取消订阅后会被关闭
+func (s *Subscription[T]) C() <-chan T {
+	return s.ch
+}
+
+// Err 返回 C 被关闭的原因，为 nil 表示流正常结束或者主动取消订阅
+func (s *Subscription[T]) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Dropped 返回 SubscribeDrop 策略下被丢弃的 chunk 数量
+func (s *Subscription[T]) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Result 阻塞直到流结束，返回合并后的最终结果，与 FanOut.Result 相同
+func (s *Subscription[T]) Result() (T, error) {
+	return s.fanOut.Result()
+}
+
+// Unsubscribe 取消订阅，C 会被立即关闭，不会影响其他订阅者
+func (s *Subscription[T]) Unsubscribe() {
+	s.quitOnce.Do(func() {
+		// 先关闭 quit，让阻塞在发送上的分发退出并释放锁
+		close(s.quit)
+		s.finish(nil)
+		s.fanOut.remove(s)
+	})
+}
+
+// deliver 将 chunk 发送给订阅者，返回 false 表示订阅者已经被关闭，需要从 FanOut 中移除
+func (s *Subscription[T]) deliver(chunk T) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	switch s.policy {
+	case SubscribeDrop:
+		select {
+		case s.ch <- chunk:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	case SubscribeDisconnect:
+		select {
+		case s.ch <- chunk:
+		default:
+			s.finishLocked(ErrSlowSubscriber)
+			return false
+		}
+	default:
+		select {
+		case s.ch <- chunk:
+		case <-s.quit:
+			// Unsubscribe 会关闭 ch
+			return false
+		case <-s.fanOut.closing:
+			// FanOut 已经关闭，由分发的 goroutine 在流结束后关闭 ch
+		}
+	}
+
+	return true
+}
+
+// finish 关闭 ch，可以重复调用，只有第一次的 err 会被记录
+func (s *Subscription[T]) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finishLocked(err)
+}
+
+func (s *Subscription[T]) finishLocked(err error) {
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	s.err = err
+	close(s.ch)
+}
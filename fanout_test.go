@@ -0,0 +1,225 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFanOut(t *testing.T) {
+	chunks := []string{
+		mockChatChunk(0, "Hello", ""),
+		mockChatChunk(0, " world", ""),
+		mockChatChunk(0, "", FinishReasonStop),
+	}
+
+	newChatStream := func(t *testing.T, done bool) *Stream[*ChatCreateResponse] {
+		server := newMockServer(newMockStreamServer(chunks, 0, done, nil))
+		t.Cleanup(server.Close)
+
+		client := newMockClient(server.URL)
+
+		s, err := client.Chat.CreateStream(context.TODO(), &ChatCreateRequest{Model: GPT35Turbo})
+		require.NoError(t, err)
+
+		return s
+	}
+
+	collect := func(sub *Subscription[*ChatCreateResponse]) string {
+		var sb strings.Builder
+		for chunk := range sub.C() {
+			sb.WriteString(chunk.Choices[0].Delta.Content)
+		}
+		return sb.String()
+	}
+
+	t.Run("test fan out to multiple subscribers", func(t *testing.T) {
+		f := NewChatFanOut(newChatStream(t, true))
+
+		subs := []*Subscription[*ChatCreateResponse]{f.Subscribe(), f.Subscribe(WithSubscribeBuffer(0))}
+		f.Start()
+
+		texts := make([]string, len(subs))
+		var wg sync.WaitGroup
+		for i, sub := range subs {
+			wg.Add(1)
+			go func(i int, sub *Subscription[*ChatCreateResponse]) {
+				defer wg.Done()
+				texts[i] = collect(sub)
+			}(i, sub)
+		}
+		wg.Wait()
+
+		require.Equal(t, []string{"Hello world", "Hello world"}, texts)
+
+		res, err := f.Result()
+		require.NoError(t, err)
+		require.Equal(t, "Hello world", res.Choices[0].Message.Content)
+		require.Equal(t, FinishReasonStop, res.Choices[0].FinishReason)
+	})
+
+	t.Run("test fan out with slow subscribers", func(t *testing.T) {
+		f := NewChatFanOut(newChatStream(t, true))
+
+		fast := f.Subscribe()
+		drop := f.Subscribe(WithSubscribePolicy(SubscribeDrop), WithSubscribeBuffer(1))
+		disconnect := f.Subscribe(WithSubscribePolicy(SubscribeDisconnect), WithSubscribeBuffer(1))
+		f.Start()
+
+		require.Equal(t, "Hello world", collect(fast))
+		<-f.Done()
+
+		require.Equal(t, "Hello", collect(drop))
+		require.Equal(t, int64(2), drop.Dropped())
+		require.NoError(t, drop.Err())
+
+		require.Equal(t, "Hello", collect(disconnect))
+		require.ErrorIs(t, disconnect.Err(), ErrSlowSubscriber)
+	})
+
+	t.Run("test fan out unsubscribe", func(t *testing.T) {
+		f := NewChatFanOut(newChatStream(t, true))
+
+		sub := f.Subscribe(WithSubscribeBuffer(0))
+		other := f.Subscribe()
+		sub.Unsubscribe()
+		f.Start()
+
+		require.Equal(t, "Hello world", collect(other))
+		require.Equal(t, "", collect(sub))
+		require.NoError(t, sub.Err())
+	})
+
+	t.Run("test fan out late subscriber", func(t *testing.T) {
+		f := NewChatFanOut(newChatStream(t, true))
+		f.Start()
+		<-f.Done()
+
+		late := f.Subscribe()
+		_, ok := <-late.C()
+		require.False(t, ok)
+
+		res, err := late.Result()
+		require.NoError(t, err)
+		require.Equal(t, "Hello world", res.Choices[0].Message.Content)
+	})
+
+	t.Run("test fan out upstream error", func(t *testing.T) {
+		f := NewChatFanOut(newChatStream(t, false))
+		sub := f.Subscribe()
+		f.Start()
+
+		require.Equal(t, "Hello world", collect(sub))
+		require.ErrorIs(t, sub.Err(), io.ErrUnexpectedEOF)
+
+		_, err := f.Result()
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestFanOut_Close(t *testing.T) {
+	chunks := make([]string, 0, 50)
+	for i := 0; i < 50; i++ {
+		chunks = append(chunks, mockChatChunk(0, "Hello", ""))
+	}
+
+	newChatStream := func(t *testing.T) *Stream[*ChatCreateResponse] {
+		server := newMockServer(newMockStreamServer(chunks, 20*time.Millisecond, true, nil))
+		t.Cleanup(server.Close)
+
+		client := newMockClient(server.URL)
+
+		s, err := client.Chat.CreateStream(context.TODO(), &ChatCreateRequest{Model: GPT35Turbo})
+		require.NoError(t, err)
+
+		return s
+	}
+
+	waitClosed := func(t *testing.T, ch <-chan *ChatCreateResponse) {
+		timeout := time.After(time.Second)
+		for {
+			select {
+			case _, ok := <-ch:
+				if !ok {
+					return
+				}
+			case <-timeout:
+				t.Fatal("subscription is not closed")
+			}
+		}
+	}
+
+	t.Run("test close with stalled blocking subscriber", func(t *testing.T) {
+		f := NewChatFanOut(newChatStream(t))
+
+		// 不消费的订阅者，分发会在 other 收到第一个 chunk 之后阻塞在它上面
+		other := f.Subscribe()
+		stalled := f.Subscribe(WithSubscribeBuffer(0))
+		f.Start()
+
+		<-other.C()
+		require.NoError(t, f.Close())
+
+		select {
+		case <-f.Done():
+		case <-time.After(time.Second):
+			t.Fatal("fan out is not finished after close")
+		}
+
+		waitClosed(t, stalled.C())
+		waitClosed(t, other.C())
+	})
+
+	t.Run("test close before start", func(t *testing.T) {
+		f := NewChatFanOut(newChatStream(t))
+		sub := f.Subscribe()
+
+		require.NoError(t, f.Close())
+
+		waitClosed(t, sub.C())
+		<-f.Done()
+	})
+
+	t.Run("test unsubscribe closes channel immediately", func(t *testing.T) {
+		f := NewChatFanOut(newChatStream(t))
+		defer f.Close()
+
+		sub := f.Subscribe(WithSubscribeBuffer(0))
+		f.Start()
+
+		<-sub.C()
+		sub.Unsubscribe()
+
+		waitClosed(t, sub.C())
+		require.NoError(t, sub.Err())
+	})
+
+	t.Run("test result without start", func(t *testing.T) {
+		f := NewChatFanOut(newChatStream(t))
+		defer f.Close()
+
+		_, err := f.Result()
+		require.ErrorIs(t, err, ErrFanOutNotStarted)
+
+		_, err = f.Subscribe().Result()
+		require.ErrorIs(t, err, ErrFanOutNotStarted)
+	})
+}
@@ -16,7 +16,6 @@ package openai
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 )
 
@@ -29,6 +28,7 @@ const (
 	ModelDeletePath      = "/models/%s"
 )
 
+// FineTuneService 对应已经废弃的 /fine-tunes 接口，只用于兼容旧的账号，新的训练任务请使用 FineTuningJobService
 type FineTuneService interface {
 	Create(ctx context.Context, req *FineTuneCreateRequest) (*FineTune, error)
 	List(ctx context.Context) (*FineTuneListResponse, error)
@@ -58,7 +58,7 @@ type FineTune struct {
 	Id              string           `json:"id"`
 	Object          string           `json:"object"`
 	Model           string           `json:"model"`
-	CreateAt        int64            `json:"create_at"`
+	CreateAt        int64            `json:"created_at"`
 	Events          []*FineTuneEvent `json:"events"`
 	FineTunedModel  string           `json:"fine_tuned_model"`
 	Hyperparams     Hyperparams      `json:"hyperparams"`
@@ -79,7 +79,7 @@ type Hyperparams struct {
 
 type FineTuneEvent struct {
 	Object   string `json:"object"`
-	CreateAt int64  `json:"create_at"`
+	CreateAt int64  `json:"created_at"`
 	Level    string `json:"level"`
 	Message  string `json:"message"`
 }
@@ -92,6 +92,14 @@ type FineTuneListResponse struct {
 type EventListResponse struct {
 	Object string           `json:"object"`
 	Data   []*FineTuneEvent `json:"data"`
+
+	// err 流式模式下流异常中断时的错误，只会出现在 channel 的最后一个元素上
+	err error
+}
+
+// Err 返回流异常中断的原因，为 nil 表示这是一个正常的响应
+func (e *EventListResponse) Err() error {
+	return e.err
 }
 
 type ModelDeleteResponse struct {
@@ -136,6 +144,7 @@ func (f FineTuneServiceOp) Cancel(ctx context.Context, id string) (*FineTune, er
 // ListEvents Returns a list of events for a fine-tuning job.
 // If stream=true, the response will be a stream of events as they are generated.
 // by default, the response will be a list of all events generated so far.
+// If the stream is interrupted, the last element of the channel carries the error, see EventListResponse.Err.
 func (f FineTuneServiceOp) ListEvents(ctx context.Context, id string, stream ...bool) (chan *EventListResponse, error) {
 	type Stream struct {
 		Stream bool `url:"stream"`
@@ -158,7 +167,9 @@ func (f FineTuneServiceOp) ListEvents(ctx context.Context, id string, stream ...
 		return ch, nil
 	}
 
-	es, err := f.client.GetByStream(ctx, fmt.Sprintf(EventsListPath, id), s)
+	events, err := newStream[*EventListResponse](ctx, func(ctx context.Context) (EventSource, error) {
+		return f.client.GetByStream(ctx, fmt.Sprintf(EventsListPath, id), s)
+	})
 
 	if err != nil {
 		return nil, err
@@ -167,29 +178,32 @@ func (f FineTuneServiceOp) ListEvents(ctx context.Context, id string, stream ...
 	ch := make(chan *EventListResponse)
 
 	go func() {
-		defer close(ch)
+		defer func() {
+			_ = events.Close()
+			close(ch)
+		}()
 
-		for {
+		for events.Next() {
 			select {
 			case <-ctx.Done():
 				return
-			case e, ok := <-es:
-				if !ok {
-					return
-				}
-				var resp EventListResponse
-				err := json.Unmarshal([]byte(e.Data), &resp)
-				if err != nil {
-					f.client.logger.Error(err, "failed to unmarshal chat response")
-					continue
-				}
-				select {
-				case <-ctx.Done():
-					return
-				case ch <- &resp:
-				}
+			case ch <- events.Current():
 			}
 		}
+
+		err := events.Err()
+
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		// 流异常中断，通过最后一个元素将错误返回给消费者
+		f.client.logger.Error(err, "fine-tune events stream interrupted")
+
+		select {
+		case <-ctx.Done():
+		case ch <- &EventListResponse{err: err}:
+		}
 	}()
 
 	return ch, nil
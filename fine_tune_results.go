@@ -0,0 +1,482 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 结果文件中的指标名称，旧版 /fine-tunes 和新版 /fine_tuning/jobs 的列名会统一为以下名称
+const (
+	FineTuneMetricStep                   = "step"
+	FineTuneMetricElapsedTokens          = "elapsed_tokens"
+	FineTuneMetricElapsedExamples        = "elapsed_examples"
+	FineTuneMetricTrainingLoss           = "train_loss"
+	FineTuneMetricTrainingAccuracy       = "train_accuracy"
+	FineTuneMetricValidationLoss         = "valid_loss"
+	FineTuneMetricValidationAccuracy     = "valid_accuracy"
+	FineTuneMetricFullValidationLoss     = "full_valid_loss"
+	FineTuneMetricFullValidationAccuracy = "full_valid_accuracy"
+
+	defaultEarlyStoppingPatience = 3
+)
+
+// ErrInvalidFineTuneResults 结果文件不是训练指标的 CSV
+var ErrInvalidFineTuneResults = errors.New("openai: invalid fine-tune results")
+
+// fineTuneMetricAliases 结果文件中的列名到统一名称的映射
+var fineTuneMetricAliases = map[string]string{
+	"step":                           FineTuneMetricStep,
+	"elapsed_tokens":                 FineTuneMetricElapsedTokens,
+	"elapsed_examples":               FineTuneMetricElapsedExamples,
+	"train_loss":                     FineTuneMetricTrainingLoss,
+	"training_loss":                  FineTuneMetricTrainingLoss,
+	"train_accuracy":                 FineTuneMetricTrainingAccuracy,
+	"train_mean_token_accuracy":      FineTuneMetricTrainingAccuracy,
+	"training_token_accuracy":        FineTuneMetricTrainingAccuracy,
+	"valid_loss":                     FineTuneMetricValidationLoss,
+	"validation_loss":                FineTuneMetricValidationLoss,
+	"valid_accuracy":                 FineTuneMetricValidationAccuracy,
+	"valid_mean_token_accuracy":      FineTuneMetricValidationAccuracy,
+	"validation_token_accuracy":      FineTuneMetricValidationAccuracy,
+	"full_valid_loss":                FineTuneMetricFullValidationLoss,
+	"full_valid_mean_token_accuracy": FineTuneMetricFullValidationAccuracy,
+}
+
+// fineTuneMetricColumns 导出 CSV 时的列顺序
+var fineTuneMetricColumns = []string{
+	FineTuneMetricStep,
+	FineTuneMetricElapsedTokens,
+	FineTuneMetricElapsedExamples,
+	FineTuneMetricTrainingLoss,
+	FineTuneMetricTrainingAccuracy,
+	FineTuneMetricValidationLoss,
+	FineTuneMetricValidationAccuracy,
+	FineTuneMetricFullValidationLoss,
+	FineTuneMetricFullValidationAccuracy,
+}
+
+// FineTuneResultRow 结果文件中的一行，没有记录的指标为 nil，例如大部分 step 没有验证集的指标
+type FineTuneResultRow struct {
+	Step                   int64              `json:"step"`
+	ElapsedTokens          *int64             `json:"elapsed_tokens,omitempty"`
+	ElapsedExamples        *int64             `json:"elapsed_examples,omitempty"`
+	TrainingLoss           *float64           `json:"train_loss,omitempty"`
+	TrainingAccuracy       *float64           `json:"train_accuracy,omitempty"`
+	ValidationLoss         *float64           `json:"valid_loss,omitempty"`
+	ValidationAccuracy     *float64           `json:"valid_accuracy,omitempty"`
+	FullValidationLoss     *float64           `json:"full_valid_loss,omitempty"`
+	FullValidationAccuracy *float64           `json:"full_valid_accuracy,omitempty"`
+	Extra                  map[string]float64 `json:"extra,omitempty"` // 无法识别的数值列，例如分类任务的指标
+}
+
+// value 返回指标的值，ok 为 false 表示这一行没有记录该指标
+func (r *FineTuneResultRow) value(metric string) (float64, bool) {
+	var p *float64
+	switch metric {
+	case FineTuneMetricStep:
+		return float64(r.Step), true
+	case FineTuneMetricElapsedTokens:
+		if r.ElapsedTokens == nil {
+			return 0, false
+		}
+		return float64(*r.ElapsedTokens), true
+	case FineTuneMetricElapsedExamples:
+		if r.ElapsedExamples == nil {
+			return 0, false
+		}
+		return float64(*r.ElapsedExamples), true
+	case FineTuneMetricTrainingLoss:
+		p = r.TrainingLoss
+	case FineTuneMetricTrainingAccuracy:
+		p = r.TrainingAccuracy
+	case FineTuneMetricValidationLoss:
+		p = r.ValidationLoss
+	case FineTuneMetricValidationAccuracy:
+		p = r.ValidationAccuracy
+	case FineTuneMetricFullValidationLoss:
+		p = r.FullValidationLoss
+	case FineTuneMetricFullValidationAccuracy:
+		p = r.FullValidationAccuracy
+	default:
+		v, ok := r.Extra[metric]
+		return v, ok
+	}
+
+	if p == nil {
+		return 0, false
+	}
+	return *p, true
+}
+
+func (r *FineTuneResultRow) set(metric string, v float64) {
+	switch metric {
+	case FineTuneMetricStep:
+		r.Step = int64(v)
+	case FineTuneMetricElapsedTokens:
+		n := int64(v)
+		r.ElapsedTokens = &n
+	case FineTuneMetricElapsedExamples:
+		n := int64(v)
+		r.ElapsedExamples = &n
+	case FineTuneMetricTrainingLoss:
+		r.TrainingLoss = &v
+	case FineTuneMetricTrainingAccuracy:
+		r.TrainingAccuracy = &v
+	case FineTuneMetricValidationLoss:
+		r.ValidationLoss = &v
+	case FineTuneMetricValidationAccuracy:
+		r.ValidationAccuracy = &v
+	case FineTuneMetricFullValidationLoss:
+		r.FullValidationLoss = &v
+	case FineTuneMetricFullValidationAccuracy:
+		r.FullValidationAccuracy = &v
+	default:
+		if r.Extra == nil {
+			r.Extra = make(map[string]float64)
+		}
+		r.Extra[metric] = v
+	}
+}
+
+// FineTuneMetricPoint 指标序列中的一个点
+type FineTuneMetricPoint struct {
+	Step  int64   `json:"step"`
+	Value float64 `json:"value"`
+}
+
+// FineTuneResults 训练任务的结果文件，即 FineTune.ResultFiles 或者 FineTuningJob.ResultFiles 对应的 CSV，用法如下：
+//
+//	results, err := openai.RetrieveFineTuneResults(ctx, client.Files, job.ResultFiles[0])
+//	summary := results.Summary()
+//	fmt.Println(summary.BestValidationLoss, summary.Hint)
+type FineTuneResults struct {
+	// Metrics 文件中出现的指标，已经统一为 FineTuneMetric* 的名称，按照文件中的顺序排列
+	Metrics []string
+	Rows    []*FineTuneResultRow
+}
+
+// ParseFineTuneResults 解析结果文件，同时支持旧版和新版的列名，空白的单元格表示这一行没有记录该指标
+func ParseFineTuneResults(r io.Reader) (*FineTuneResults, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: empty file", ErrInvalidFineTuneResults)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]string, len(header))
+	hasStep := false
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if alias, ok := fineTuneMetricAliases[name]; ok {
+			name = alias
+		}
+		metrics[i] = name
+		hasStep = hasStep || name == FineTuneMetricStep
+	}
+
+	if !hasStep {
+		return nil, fmt.Errorf("%w: missing step column", ErrInvalidFineTuneResults)
+	}
+
+	results := &FineTuneResults{Metrics: metrics}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row := &FineTuneResultRow{}
+		for i, cell := range record {
+			cell = strings.TrimSpace(cell)
+			if cell == "" {
+				continue
+			}
+
+			v, err := strconv.ParseFloat(cell, 64)
+			if err != nil {
+				line, _ := reader.FieldPos(i)
+				return nil, fmt.Errorf("%w: line %d: column %s: %v", ErrInvalidFineTuneResults, line, header[i], err)
+			}
+			if math.IsNaN(v) {
+				continue
+			}
+			row.set(metrics[i], v)
+		}
+		results.Rows = append(results.Rows, row)
+	}
+
+	return results, nil
+}
+
+// RetrieveFineTuneResults 下载并解析结果文件
+func RetrieveFineTuneResults(ctx context.Context, files FileService, fileId string) (*FineTuneResults, error) {
+	data, err := files.RetrieveContent(ctx, fileId)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFineTuneResults(bytes.NewReader(data))
+}
+
+// Series 返回指标的序列，只包括记录了该指标的 step
+func (r *FineTuneResults) Series(metric string) []*FineTuneMetricPoint {
+	var points []*FineTuneMetricPoint
+	for _, row := range r.Rows {
+		if v, ok := row.value(metric); ok {
+			points = append(points, &FineTuneMetricPoint{Step: row.Step, Value: v})
+		}
+	}
+	return points
+}
+
+// validationMetric 用于判断训练效果的验证集 loss，优先使用每个 step 的验证集 loss，没有时使用完整验证集的 loss
+func (r *FineTuneResults) validationMetric() string {
+	for _, row := range r.Rows {
+		if row.ValidationLoss != nil {
+			return FineTuneMetricValidationLoss
+		}
+	}
+	return FineTuneMetricFullValidationLoss
+}
+
+type fineTuneSummaryConfig struct {
+	patience int
+	minDelta float64
+}
+
+type FineTuneSummaryOption func(*fineTuneSummaryConfig)
+
+// WithEarlyStoppingPatience 验证集 loss 连续 n 次评估没有改善时建议提前停止，默认为 3
+func WithEarlyStoppingPatience(n int) FineTuneSummaryOption {
+	return func(c *fineTuneSummaryConfig) {
+		if n > 0 {
+			c.patience = n
+		}
+	}
+}
+
+// WithEarlyStoppingMinDelta 验证集 loss 至少降低 delta 才算改善，默认为 0
+func WithEarlyStoppingMinDelta(delta float64) FineTuneSummaryOption {
+	return func(c *fineTuneSummaryConfig) {
+		if delta > 0 {
+			c.minDelta = delta
+		}
+	}
+}
+
+// FineTuneResultSummary 结果文件的摘要，没有记录的指标为 nil
+type FineTuneResultSummary struct {
+	Steps     int   `json:"steps"`
+	FinalStep int64 `json:"final_step"`
+
+	FinalTrainingLoss       *float64 `json:"final_train_loss,omitempty"`
+	FinalTrainingAccuracy   *float64 `json:"final_train_accuracy,omitempty"`
+	FinalValidationLoss     *float64 `json:"final_valid_loss,omitempty"`
+	FinalValidationAccuracy *float64 `json:"final_valid_accuracy,omitempty"`
+
+	BestValidationLoss *float64 `json:"best_valid_loss,omitempty"`
+	BestValidationStep int64    `json:"best_valid_step,omitempty"`
+
+	// EvaluationsSinceBest 最好的验证集 loss 之后的评估次数
+	EvaluationsSinceBest int `json:"evaluations_since_best"`
+	// EarlyStop 验证集 loss 已经连续多次没有改善，训练到 BestValidationStep 就足够了
+	EarlyStop bool `json:"early_stop"`
+	// Overfitting 验证集 loss 没有改善的同时训练集 loss 仍在下降，通常意味着过拟合，可以减少 epoch
+	Overfitting bool `json:"overfitting"`
+	// Hint 可读的建议，没有建议时为空
+	Hint string `json:"hint,omitempty"`
+}
+
+// Summary 计算最终和最好的指标，并根据验证集 loss 给出提前停止的建议
+func (r *FineTuneResults) Summary(opts ...FineTuneSummaryOption) *FineTuneResultSummary {
+	cfg := fineTuneSummaryConfig{patience: defaultEarlyStoppingPatience}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	s := &FineTuneResultSummary{Steps: len(r.Rows)}
+	if len(r.Rows) == 0 {
+		return s
+	}
+
+	s.FinalStep = r.Rows[len(r.Rows)-1].Step
+
+	last := func(metric string) *FineTuneMetricPoint {
+		points := r.Series(metric)
+		if len(points) == 0 {
+			return nil
+		}
+		return points[len(points)-1]
+	}
+	value := func(p *FineTuneMetricPoint) *float64 {
+		if p == nil {
+			return nil
+		}
+		v := p.Value
+		return &v
+	}
+
+	validLoss := r.validationMetric()
+	s.FinalTrainingLoss = value(last(FineTuneMetricTrainingLoss))
+	s.FinalTrainingAccuracy = value(last(FineTuneMetricTrainingAccuracy))
+	s.FinalValidationLoss = value(last(validLoss))
+	if validLoss == FineTuneMetricValidationLoss {
+		s.FinalValidationAccuracy = value(last(FineTuneMetricValidationAccuracy))
+	} else {
+		s.FinalValidationAccuracy = value(last(FineTuneMetricFullValidationAccuracy))
+	}
+
+	evals := r.Series(validLoss)
+	if len(evals) == 0 {
+		return s
+	}
+
+	best := 0
+	for i, p := range evals {
+		if p.Value < evals[best].Value-cfg.minDelta {
+			best = i
+		}
+	}
+
+	s.BestValidationLoss = value(evals[best])
+	s.BestValidationStep = evals[best].Step
+	s.EvaluationsSinceBest = len(evals) - 1 - best
+	s.EarlyStop = s.EvaluationsSinceBest >= cfg.patience
+
+	if s.EarlyStop {
+		s.Overfitting = r.trainingLossDecreased(s.BestValidationStep)
+
+		s.Hint = fmt.Sprintf("validation loss has not improved for %d evaluations since step %d", s.EvaluationsSinceBest, s.BestValidationStep)
+		if s.Overfitting {
+			s.Hint += " while training loss kept decreasing, the model is likely overfitting; consider fewer epochs"
+		} else {
+			s.Hint += "; consider fewer epochs"
+		}
+	}
+
+	return s
+}
+
+// trainingLossDecreased 最后的训练集 loss 是否低于 step 附近的训练集 loss，训练集 loss 波动较大，取平均值比较
+func (r *FineTuneResults) trainingLossDecreased(step int64) bool {
+	points := r.Series(FineTuneMetricTrainingLoss)
+	if len(points) < 2 {
+		return false
+	}
+
+	i := sort.Search(len(points), func(i int) bool { return points[i].Step >= step })
+	if i >= len(points)-1 {
+		return false
+	}
+
+	window := (len(points) + 9) / 10
+	mean := func(points []*FineTuneMetricPoint) float64 {
+		sum := 0.0
+		for _, p := range points {
+			sum += p.Value
+		}
+		return sum / float64(len(points))
+	}
+
+	lo := i - window/2
+	if lo < 0 {
+		lo = 0
+	}
+	hi := lo + window
+	if hi > len(points) {
+		hi = len(points)
+	}
+
+	return mean(points[len(points)-window:]) < mean(points[lo:hi])
+}
+
+// WriteJSON 以 JSON 格式导出摘要和所有的行，便于比较不同的训练任务
+func (r *FineTuneResults) WriteJSON(w io.Writer, opts ...FineTuneSummaryOption) error {
+	rows := r.Rows
+	if rows == nil {
+		rows = []*FineTuneResultRow{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Summary *FineTuneResultSummary `json:"summary"`
+		Rows    []*FineTuneResultRow   `json:"rows"`
+	}{
+		Summary: r.Summary(opts...),
+		Rows:    rows,
+	})
+}
+
+// WriteCSV 以统一的列名导出 CSV，只包括文件中出现的指标，无法识别的列按照名称排序放在最后
+func (r *FineTuneResults) WriteCSV(w io.Writer) error {
+	present := make(map[string]bool, len(r.Metrics))
+	for _, m := range r.Metrics {
+		present[m] = true
+	}
+
+	var columns, extra []string
+	for _, m := range fineTuneMetricColumns {
+		if present[m] {
+			columns = append(columns, m)
+			delete(present, m)
+		}
+	}
+	for m := range present {
+		extra = append(extra, m)
+	}
+	sort.Strings(extra)
+	columns = append(columns, extra...)
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+
+	record := make([]string, len(columns))
+	for _, row := range r.Rows {
+		for i, m := range columns {
+			record[i] = ""
+			if v, ok := row.value(m); ok {
+				record[i] = strconv.FormatFloat(v, 'g', -1, 64)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,273 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func TestParseFineTuneResults(t *testing.T) {
+	testCase := []struct {
+		name        string
+		data        string
+		wantMetrics []string
+		wantRows    []*FineTuneResultRow
+		wantErr     error
+	}{
+		{
+			name: "test parse fine-tuning job results",
+			data: "step,train_loss,train_accuracy,valid_loss,valid_mean_token_accuracy\n" +
+				"1,1.5,0.4,,\n" +
+				"2,1.25,0.5,1.1,0.55\n",
+			wantMetrics: []string{
+				FineTuneMetricStep,
+				FineTuneMetricTrainingLoss,
+				FineTuneMetricTrainingAccuracy,
+				FineTuneMetricValidationLoss,
+				FineTuneMetricValidationAccuracy,
+			},
+			wantRows: []*FineTuneResultRow{
+				{Step: 1, TrainingLoss: float64Ptr(1.5), TrainingAccuracy: float64Ptr(0.4)},
+				{Step: 2, TrainingLoss: float64Ptr(1.25), TrainingAccuracy: float64Ptr(0.5), ValidationLoss: float64Ptr(1.1), ValidationAccuracy: float64Ptr(0.55)},
+			},
+		},
+		{
+			name: "test parse legacy fine-tune results",
+			data: "step,elapsed_tokens,elapsed_examples,training_loss,training_sequence_accuracy,training_token_accuracy,validation_loss\n" +
+				"1,2048,8,0.75,0.0,0.5,nan\n" +
+				"2,4096,16,0.5,0.25,0.75,0.625\n",
+			wantMetrics: []string{
+				FineTuneMetricStep,
+				FineTuneMetricElapsedTokens,
+				FineTuneMetricElapsedExamples,
+				FineTuneMetricTrainingLoss,
+				"training_sequence_accuracy",
+				FineTuneMetricTrainingAccuracy,
+				FineTuneMetricValidationLoss,
+			},
+			wantRows: []*FineTuneResultRow{
+				{
+					Step:             1,
+					ElapsedTokens:    int64Ptr(2048),
+					ElapsedExamples:  int64Ptr(8),
+					TrainingLoss:     float64Ptr(0.75),
+					TrainingAccuracy: float64Ptr(0.5),
+					Extra:            map[string]float64{"training_sequence_accuracy": 0},
+				},
+				{
+					Step:             2,
+					ElapsedTokens:    int64Ptr(4096),
+					ElapsedExamples:  int64Ptr(16),
+					TrainingLoss:     float64Ptr(0.5),
+					TrainingAccuracy: float64Ptr(0.75),
+					ValidationLoss:   float64Ptr(0.625),
+					Extra:            map[string]float64{"training_sequence_accuracy": 0.25},
+				},
+			},
+		},
+		{
+			name:    "test parse file without step column",
+			data:    string(loadTestdata("mock_file_content.csv")),
+			wantErr: ErrInvalidFineTuneResults,
+		},
+		{
+			name:    "test parse empty file",
+			data:    "",
+			wantErr: ErrInvalidFineTuneResults,
+		},
+		{
+			name:    "test parse invalid value",
+			data:    "step,train_loss\n1,abc\n",
+			wantErr: ErrInvalidFineTuneResults,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			results, err := ParseFineTuneResults(strings.NewReader(tc.data))
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.wantMetrics, results.Metrics)
+			require.Equal(t, tc.wantRows, results.Rows)
+		})
+	}
+}
+
+func TestFineTuneResults_Series(t *testing.T) {
+	results, err := ParseFineTuneResults(bytes.NewReader(loadTestdata("fine_tune_results.csv")))
+	require.NoError(t, err)
+	require.Len(t, results.Rows, 12)
+
+	require.Len(t, results.Series(FineTuneMetricTrainingLoss), 12)
+	require.Equal(t, []*FineTuneMetricPoint{
+		{Step: 2, Value: 1.05},
+		{Step: 4, Value: 0.82},
+		{Step: 6, Value: 0.70},
+		{Step: 8, Value: 0.75},
+		{Step: 10, Value: 0.78},
+		{Step: 12, Value: 0.80},
+	}, results.Series(FineTuneMetricValidationLoss))
+	require.Equal(t, []*FineTuneMetricPoint{{Step: 12, Value: 0.79}}, results.Series(FineTuneMetricFullValidationLoss))
+	require.Empty(t, results.Series(FineTuneMetricElapsedTokens))
+}
+
+func TestFineTuneResults_Summary(t *testing.T) {
+	results, err := ParseFineTuneResults(bytes.NewReader(loadTestdata("fine_tune_results.csv")))
+	require.NoError(t, err)
+
+	testCase := []struct {
+		name    string
+		results *FineTuneResults
+		opts    []FineTuneSummaryOption
+		want    *FineTuneResultSummary
+	}{
+		{
+			name:    "test summary with early stopping",
+			results: results,
+			want: &FineTuneResultSummary{
+				Steps:                   12,
+				FinalStep:               12,
+				FinalTrainingLoss:       float64Ptr(0.22),
+				FinalTrainingAccuracy:   float64Ptr(0.93),
+				FinalValidationLoss:     float64Ptr(0.80),
+				FinalValidationAccuracy: float64Ptr(0.65),
+				BestValidationLoss:      float64Ptr(0.70),
+				BestValidationStep:      6,
+				EvaluationsSinceBest:    3,
+				EarlyStop:               true,
+				Overfitting:             true,
+				Hint:                    "validation loss has not improved for 3 evaluations since step 6 while training loss kept decreasing, the model is likely overfitting; consider fewer epochs",
+			},
+		},
+		{
+			name:    "test summary with larger patience",
+			results: results,
+			opts:    []FineTuneSummaryOption{WithEarlyStoppingPatience(4)},
+			want: &FineTuneResultSummary{
+				Steps:                   12,
+				FinalStep:               12,
+				FinalTrainingLoss:       float64Ptr(0.22),
+				FinalTrainingAccuracy:   float64Ptr(0.93),
+				FinalValidationLoss:     float64Ptr(0.80),
+				FinalValidationAccuracy: float64Ptr(0.65),
+				BestValidationLoss:      float64Ptr(0.70),
+				BestValidationStep:      6,
+				EvaluationsSinceBest:    3,
+			},
+		},
+		{
+			name: "test summary with full validation loss only",
+			results: &FineTuneResults{Rows: []*FineTuneResultRow{
+				{Step: 1, TrainingLoss: float64Ptr(1)},
+				{Step: 2, TrainingLoss: float64Ptr(0.5), FullValidationLoss: float64Ptr(0.75), FullValidationAccuracy: float64Ptr(0.5)},
+			}},
+			want: &FineTuneResultSummary{
+				Steps:                   2,
+				FinalStep:               2,
+				FinalTrainingLoss:       float64Ptr(0.5),
+				FinalValidationLoss:     float64Ptr(0.75),
+				FinalValidationAccuracy: float64Ptr(0.5),
+				BestValidationLoss:      float64Ptr(0.75),
+				BestValidationStep:      2,
+			},
+		},
+		{
+			name: "test summary without validation",
+			results: &FineTuneResults{Rows: []*FineTuneResultRow{
+				{Step: 1, TrainingLoss: float64Ptr(1)},
+			}},
+			want: &FineTuneResultSummary{
+				Steps:             1,
+				FinalStep:         1,
+				FinalTrainingLoss: float64Ptr(1),
+			},
+		},
+		{
+			name:    "test summary of empty results",
+			results: &FineTuneResults{},
+			want:    &FineTuneResultSummary{},
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, tc.results.Summary(tc.opts...))
+		})
+	}
+}
+
+func TestFineTuneResults_Export(t *testing.T) {
+	data := "step,elapsed_tokens,training_loss,validation_loss,custom\n" +
+		"1,2048,0.75,,3\n" +
+		"2,4096,0.5,0.625,\n"
+
+	results, err := ParseFineTuneResults(strings.NewReader(data))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, results.WriteCSV(&buf))
+	require.Equal(t, "step,elapsed_tokens,train_loss,valid_loss,custom\n"+
+		"1,2048,0.75,,3\n"+
+		"2,4096,0.5,0.625,\n", buf.String())
+
+	// 导出的 CSV 可以重新解析
+	parsed, err := ParseFineTuneResults(&buf)
+	require.NoError(t, err)
+	require.Equal(t, results.Rows, parsed.Rows)
+
+	buf.Reset()
+	require.NoError(t, results.WriteJSON(&buf))
+
+	var exported struct {
+		Summary *FineTuneResultSummary `json:"summary"`
+		Rows    []*FineTuneResultRow   `json:"rows"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &exported))
+	require.Equal(t, results.Summary(), exported.Summary)
+	require.Equal(t, results.Rows, exported.Rows)
+	require.Contains(t, buf.String(), `"extra": {`)
+}
+
+func TestRetrieveFineTuneResults(t *testing.T) {
+	server := newMockServer(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/files/file-abc123/content", r.URL.Path)
+		_, _ = w.Write(loadTestdata("fine_tune_results.csv"))
+	})
+	defer server.Close()
+
+	client := newMockClient(server.URL)
+
+	results, err := RetrieveFineTuneResults(context.TODO(), client.Files, "file-abc123")
+	require.NoError(t, err)
+	require.Len(t, results.Rows, 12)
+	require.Equal(t, int64(6), results.Summary().BestValidationStep)
+}
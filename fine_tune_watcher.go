@@ -0,0 +1,390 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	FineTuneStatusPending   = "pending"
+	FineTuneStatusRunning   = "running"
+	FineTuneStatusSucceeded = "succeeded"
+	FineTuneStatusFailed    = "failed"
+	FineTuneStatusCancelled = "cancelled"
+
+	defaultWatchInterval    = 10 * time.Second
+	defaultWatchMaxInterval = 2 * time.Minute
+	defaultWatchMaxErrors   = 10
+)
+
+var (
+	// ErrFineTuneFailed 训练任务以 failed 状态结束
+	ErrFineTuneFailed = errors.New("openai: fine-tune failed")
+	// ErrFineTuneCancelled 训练任务被取消
+	ErrFineTuneCancelled = errors.New("openai: fine-tune cancelled")
+
+	fineTuneStepPattern  = regexp.MustCompile(`^Step (\d+)(?:/(\d+))?: (.+)$`)
+	fineTuneEpochPattern = regexp.MustCompile(`^Completed epoch (\d+)/(\d+)`)
+	fineTuneValuePattern = regexp.MustCompile(`([a-z_]+(?: [a-z_]+)*)\s*=\s*([-+0-9.eE]+)`)
+)
+
+// FineTuneDone 判断训练任务是否处于终止状态
+func FineTuneDone(ft *FineTune) bool {
+	switch ft.Status {
+	case FineTuneStatusSucceeded, FineTuneStatusFailed, FineTuneStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// FineTuneMetrics 从事件中解析的训练指标，没有出现的指标为 nil
+type FineTuneMetrics struct {
+	Step               int64
+	TotalSteps         int64
+	Epoch              int64
+	TotalEpochs        int64
+	TrainingLoss       *float64
+	ValidationLoss     *float64
+	TrainingAccuracy   *float64
+	ValidationAccuracy *float64
+	Event              *FineTuneEvent
+}
+
+// ParseFineTuneMetrics 解析指标事件，例如 "Step 10/100: training loss=0.42, validation loss=0.51" 和 "Completed epoch 1/4"，
+// 不是指标事件时返回 false
+func ParseFineTuneMetrics(e *FineTuneEvent) (*FineTuneMetrics, bool) {
+	if m := fineTuneEpochPattern.FindStringSubmatch(e.Message); m != nil {
+		epoch, _ := strconv.ParseInt(m[1], 10, 64)
+		total, _ := strconv.ParseInt(m[2], 10, 64)
+		return &FineTuneMetrics{Epoch: epoch, TotalEpochs: total, Event: e}, true
+	}
+
+	m := fineTuneStepPattern.FindStringSubmatch(e.Message)
+	if m == nil {
+		return nil, false
+	}
+
+	metrics := &FineTuneMetrics{Event: e}
+	metrics.Step, _ = strconv.ParseInt(m[1], 10, 64)
+	if m[2] != "" {
+		metrics.TotalSteps, _ = strconv.ParseInt(m[2], 10, 64)
+	}
+
+	found := false
+	for _, kv := range fineTuneValuePattern.FindAllStringSubmatch(m[3], -1) {
+		v, err := strconv.ParseFloat(kv[2], 64)
+		if err != nil {
+			continue
+		}
+
+		switch kv[1] {
+		case "training loss", "train_loss":
+			metrics.TrainingLoss = &v
+		case "validation loss", "valid_loss":
+			metrics.ValidationLoss = &v
+		case "training accuracy", "train_accuracy":
+			metrics.TrainingAccuracy = &v
+		case "validation accuracy", "valid_accuracy":
+			metrics.ValidationAccuracy = &v
+		default:
+			continue
+		}
+		found = true
+	}
+
+	return metrics, found
+}
+
+type fineTuneWatchConfig struct {
+	interval    time.Duration
+	maxInterval time.Duration
+	maxErrors   int
+	stream      bool
+	onStatus    func(ft *FineTune, previous string)
+	onEvent     func(e *FineTuneEvent)
+	onMetrics   func(m *FineTuneMetrics)
+}
+
+type WatchOption func(*fineTuneWatchConfig)
+
+// WithWatchInterval 设置轮询间隔，默认为 10s
+func WithWatchInterval(interval time.Duration) WatchOption {
+	return func(c *fineTuneWatchConfig) {
+		if interval > 0 {
+			c.interval = interval
+		}
+	}
+}
+
+// WithWatchBackoff 设置出错时的退避策略，每次出错轮询间隔翻倍，最多为 maxInterval，
+// 连续出错 maxErrors 次后放弃，默认为 2m 和 10 次
+func WithWatchBackoff(maxInterval time.Duration, maxErrors int) WatchOption {
+	return func(c *fineTuneWatchConfig) {
+		if maxInterval > 0 {
+			c.maxInterval = maxInterval
+		}
+		if maxErrors > 0 {
+			c.maxErrors = maxErrors
+		}
+	}
+}
+
+// WithWatchStream 使用 stream 模式的 ListEvents 实时接收事件，流中断时退回到轮询
+func WithWatchStream(stream bool) WatchOption {
+	return func(c *fineTuneWatchConfig) {
+		c.stream = stream
+	}
+}
+
+// WithWatchOnStatus 状态变化时回调，第一次获取到状态时 previous 为空
+func WithWatchOnStatus(fn func(ft *FineTune, previous string)) WatchOption {
+	return func(c *fineTuneWatchConfig) {
+		c.onStatus = fn
+	}
+}
+
+// WithWatchOnEvent 收到新事件时回调，每个事件只会回调一次，按照时间顺序回调
+func WithWatchOnEvent(fn func(e *FineTuneEvent)) WatchOption {
+	return func(c *fineTuneWatchConfig) {
+		c.onEvent = fn
+	}
+}
+
+// WithWatchOnMetrics 收到指标事件时回调，见 ParseFineTuneMetrics
+func WithWatchOnMetrics(fn func(m *FineTuneMetrics)) WatchOption {
+	return func(c *fineTuneWatchConfig) {
+		c.onMetrics = fn
+	}
+}
+
+// FineTuneWatcher 跟踪训练任务直到结束，用法如下：
+//
+//	w := openai.NewFineTuneWatcher(client.FineTunes,
+//		openai.WithWatchOnEvent(func(e *openai.FineTuneEvent) {
+//			fmt.Println(e.Message)
+//		}),
+//	)
+//	ft, err := w.Watch(ctx, "ft-xxx")
+//
+// 所有的回调都在调用 Watch 的协程中执行
+type FineTuneWatcher struct {
+	svc FineTuneService
+	cfg fineTuneWatchConfig
+}
+
+func NewFineTuneWatcher(svc FineTuneService, opts ...WatchOption) *FineTuneWatcher {
+	cfg := fineTuneWatchConfig{
+		interval:    defaultWatchInterval,
+		maxInterval: defaultWatchMaxInterval,
+		maxErrors:   defaultWatchMaxErrors,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return &FineTuneWatcher{
+		svc: svc,
+		cfg: cfg,
+	}
+}
+
+// WatchFineTune 使用默认配置跟踪训练任务，见 FineTuneWatcher
+func WatchFineTune(ctx context.Context, svc FineTuneService, id string, opts ...WatchOption) (*FineTune, error) {
+	return NewFineTuneWatcher(svc, opts...).Watch(ctx, id)
+}
+
+// fineTuneWatch 一次 Watch 的状态
+type fineTuneWatch struct {
+	*FineTuneWatcher
+	id     string
+	status string
+	since  int64               // 已经交付的事件中最新的时间
+	seen   map[string]struct{} // 时间等于 since 的已经交付的事件，同一秒内可能有多个事件
+}
+
+// Watch 跟踪训练任务直到 succeeded、failed 或者 cancelled，返回最终的 FineTune，
+// 任务失败或者被取消时同时返回 ErrFineTuneFailed 或者 ErrFineTuneCancelled，
+// 临时错误会按照退避策略重试，连续出错超过上限时返回最后一次的错误
+func (w *FineTuneWatcher) Watch(ctx context.Context, id string) (*FineTune, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	watch := &fineTuneWatch{
+		FineTuneWatcher: w,
+		id:              id,
+		seen:            make(map[string]struct{}),
+	}
+
+	var events <-chan *EventListResponse
+	if w.cfg.stream {
+		// 建立连接失败时退回到轮询
+		events, _ = w.svc.ListEvents(ctx, id, true)
+	}
+
+	interval := w.cfg.interval
+	errs := 0
+
+	for {
+		ft, err := watch.poll(ctx, events == nil)
+
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			errs++
+			if errs >= w.cfg.maxErrors {
+				return nil, err
+			}
+
+			if interval *= 2; interval > w.cfg.maxInterval {
+				interval = w.cfg.maxInterval
+			}
+		case FineTuneDone(ft):
+			return watch.finish(ctx, ft)
+		default:
+			errs = 0
+			interval = w.cfg.interval
+		}
+
+		timer := time.NewTimer(interval)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+				break wait
+			case resp, ok := <-events:
+				// 流结束或者中断后退回到轮询
+				if !ok || resp.Err() != nil {
+					events = nil
+					continue
+				}
+				watch.deliver(resp.Data)
+			}
+		}
+	}
+}
+
+// poll 查询一次状态，listEvents 为 true 时同时查询事件
+func (w *fineTuneWatch) poll(ctx context.Context, listEvents bool) (*FineTune, error) {
+	ft, err := w.svc.Retrieve(ctx, w.id)
+	if err != nil {
+		return nil, err
+	}
+
+	if ft.Status != w.status {
+		previous := w.status
+		w.status = ft.Status
+		if w.cfg.onStatus != nil {
+			w.cfg.onStatus(ft, previous)
+		}
+	}
+
+	// 任务结束时在 finish 中获取剩余的事件
+	if listEvents && !FineTuneDone(ft) {
+		if err := w.listEvents(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return ft, nil
+}
+
+func (w *fineTuneWatch) listEvents(ctx context.Context) error {
+	res, err := w.svc.ListEvents(ctx, w.id)
+	if err != nil {
+		return err
+	}
+
+	for resp := range res {
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		w.deliver(resp.Data)
+	}
+
+	return nil
+}
+
+// finish 交付剩余的事件并返回最终结果，获取事件失败时会重试，仍然失败时忽略，不影响最终结果
+func (w *fineTuneWatch) finish(ctx context.Context, ft *FineTune) (*FineTune, error) {
+	w.deliver(ft.Events)
+
+	backoff := w.cfg.interval
+	for i := 0; i < w.cfg.maxErrors; i++ {
+		if w.listEvents(ctx) == nil || ctx.Err() != nil {
+			break
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		if backoff *= 2; backoff > w.cfg.maxInterval {
+			backoff = w.cfg.maxInterval
+		}
+	}
+
+	switch ft.Status {
+	case FineTuneStatusFailed:
+		return ft, fmt.Errorf("%w: %s", ErrFineTuneFailed, ft.Id)
+	case FineTuneStatusCancelled:
+		return ft, fmt.Errorf("%w: %s", ErrFineTuneCancelled, ft.Id)
+	}
+
+	return ft, nil
+}
+
+// deliver 交付没有交付过的事件，早于 since 的事件视为已经交付
+func (w *fineTuneWatch) deliver(events []*FineTuneEvent) {
+	for _, e := range events {
+		if e.CreateAt < w.since {
+			continue
+		}
+
+		key := e.Level + "\x00" + e.Message
+		if e.CreateAt > w.since {
+			w.since = e.CreateAt
+			w.seen = make(map[string]struct{})
+		} else if _, ok := w.seen[key]; ok {
+			continue
+		}
+		w.seen[key] = struct{}{}
+
+		if w.cfg.onEvent != nil {
+			w.cfg.onEvent(e)
+		}
+
+		if w.cfg.onMetrics != nil {
+			if m, ok := ParseFineTuneMetrics(e); ok {
+				w.cfg.onMetrics(m)
+			}
+		}
+	}
+}
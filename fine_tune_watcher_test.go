@@ -0,0 +1,264 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// fakeFineTuneService 第 i 次 Retrieve 返回 statuses[i]，ListEvents 返回到目前为止的所有事件，
+// errors 中对应次数的 Retrieve 返回错误
+type fakeFineTuneService struct {
+	FineTuneService
+
+	statuses  []string
+	events    [][]*FineTuneEvent // 第 i 次 Retrieve 之后新增的事件
+	errs      map[int]error
+	stream    chan *EventListResponse
+	retrieves int
+	listed    int
+}
+
+func (f *fakeFineTuneService) Retrieve(ctx context.Context, id string) (*FineTune, error) {
+	i := f.retrieves
+	f.retrieves++
+
+	if err := f.errs[i]; err != nil {
+		return nil, err
+	}
+
+	status := f.statuses[len(f.statuses)-1]
+	if i < len(f.statuses) {
+		status = f.statuses[i]
+	}
+
+	ft := &FineTune{Id: id, Status: status}
+	if status == FineTuneStatusSucceeded {
+		ft.FineTunedModel = "curie:ft-acmeco-2021-03-03-21-44-20"
+	}
+	return ft, nil
+}
+
+func (f *fakeFineTuneService) ListEvents(ctx context.Context, id string, stream ...bool) (chan *EventListResponse, error) {
+	if len(stream) > 0 && stream[0] {
+		if f.stream == nil {
+			return nil, errors.New("stream not supported")
+		}
+		return f.stream, nil
+	}
+
+	f.listed++
+
+	var all []*FineTuneEvent
+	for i := 0; i < f.retrieves && i < len(f.events); i++ {
+		all = append(all, f.events[i]...)
+	}
+
+	ch := make(chan *EventListResponse, 1)
+	ch <- &EventListResponse{Object: "list", Data: all}
+	close(ch)
+	return ch, nil
+}
+
+func fineTuneEvent(at int64, message string) *FineTuneEvent {
+	return &FineTuneEvent{Object: "fine-tune-event", CreateAt: at, Level: "info", Message: message}
+}
+
+func TestFineTuneWatcher_Watch(t *testing.T) {
+	events := [][]*FineTuneEvent{
+		{fineTuneEvent(1, "Job enqueued.")},
+		{fineTuneEvent(2, "Job started."), fineTuneEvent(3, "Step 1/2: training loss=0.80")},
+		// 同一秒内的两个事件
+		{fineTuneEvent(4, "Step 2/2: training loss=0.40, validation loss=0.50"), fineTuneEvent(4, "Completed epoch 1/1")},
+		{fineTuneEvent(5, "Job succeeded.")},
+	}
+
+	testCase := []struct {
+		name       string
+		statuses   []string
+		errs       map[int]error
+		maxErrors  int
+		wantStatus []string
+		wantEvents []string
+		wantErr    error
+		wantModel  string
+	}{
+		{
+			name:       "test watch succeeded",
+			statuses:   []string{FineTuneStatusPending, FineTuneStatusRunning, FineTuneStatusRunning, FineTuneStatusSucceeded},
+			wantStatus: []string{FineTuneStatusPending, FineTuneStatusRunning, FineTuneStatusSucceeded},
+			wantEvents: []string{"Job enqueued.", "Job started.", "Step 1/2: training loss=0.80", "Step 2/2: training loss=0.40, validation loss=0.50", "Completed epoch 1/1", "Job succeeded."},
+			wantModel:  "curie:ft-acmeco-2021-03-03-21-44-20",
+		},
+		{
+			name:       "test watch survives transient errors",
+			statuses:   []string{FineTuneStatusPending, FineTuneStatusRunning, FineTuneStatusRunning, FineTuneStatusRunning, FineTuneStatusSucceeded},
+			errs:       map[int]error{1: errors.New("502"), 2: errors.New("503")},
+			wantStatus: []string{FineTuneStatusPending, FineTuneStatusRunning, FineTuneStatusSucceeded},
+			wantEvents: []string{"Job enqueued.", "Job started.", "Step 1/2: training loss=0.80", "Step 2/2: training loss=0.40, validation loss=0.50", "Completed epoch 1/1", "Job succeeded."},
+			wantModel:  "curie:ft-acmeco-2021-03-03-21-44-20",
+		},
+		{
+			name:       "test watch failed",
+			statuses:   []string{FineTuneStatusRunning, FineTuneStatusFailed},
+			wantStatus: []string{FineTuneStatusRunning, FineTuneStatusFailed},
+			wantEvents: []string{"Job enqueued.", "Job started.", "Step 1/2: training loss=0.80"},
+			wantErr:    ErrFineTuneFailed,
+		},
+		{
+			name:       "test watch too many errors",
+			statuses:   []string{FineTuneStatusRunning},
+			errs:       map[int]error{1: errors.New("502"), 2: errors.New("503")},
+			maxErrors:  2,
+			wantStatus: []string{FineTuneStatusRunning},
+			wantEvents: []string{"Job enqueued."},
+			wantErr:    errors.New("503"),
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := &fakeFineTuneService{statuses: tc.statuses, events: events, errs: tc.errs}
+
+			var (
+				statuses []string
+				messages []string
+				metrics  []*FineTuneMetrics
+			)
+
+			ft, err := WatchFineTune(context.TODO(), svc, "ft-abc",
+				WithWatchInterval(time.Millisecond),
+				WithWatchBackoff(4*time.Millisecond, tc.maxErrors),
+				WithWatchOnStatus(func(ft *FineTune, previous string) {
+					if len(statuses) > 0 {
+						require.Equal(t, statuses[len(statuses)-1], previous)
+					}
+					statuses = append(statuses, ft.Status)
+				}),
+				WithWatchOnEvent(func(e *FineTuneEvent) {
+					messages = append(messages, e.Message)
+				}),
+				WithWatchOnMetrics(func(m *FineTuneMetrics) {
+					metrics = append(metrics, m)
+				}),
+			)
+
+			require.Equal(t, tc.wantStatus, statuses)
+			require.Equal(t, tc.wantEvents, messages)
+
+			switch {
+			case tc.wantErr == nil:
+				require.NoError(t, err)
+				require.Equal(t, tc.wantModel, ft.FineTunedModel)
+				require.Len(t, metrics, 3)
+				require.Equal(t, 0.8, *metrics[0].TrainingLoss)
+				require.Equal(t, 0.5, *metrics[1].ValidationLoss)
+				require.Equal(t, int64(1), metrics[2].TotalEpochs)
+			case errors.Is(tc.wantErr, ErrFineTuneFailed):
+				require.ErrorIs(t, err, ErrFineTuneFailed)
+				require.Equal(t, FineTuneStatusFailed, ft.Status)
+			default:
+				require.EqualError(t, err, tc.wantErr.Error())
+			}
+		})
+	}
+}
+
+func TestFineTuneWatcher_Stream(t *testing.T) {
+	stream := make(chan *EventListResponse, 3)
+	stream <- &EventListResponse{Data: []*FineTuneEvent{fineTuneEvent(1, "Job enqueued."), fineTuneEvent(2, "Job started.")}}
+	// 重连之后重复发送的事件
+	stream <- &EventListResponse{Data: []*FineTuneEvent{fineTuneEvent(2, "Job started."), fineTuneEvent(3, "Job succeeded.")}}
+	close(stream)
+
+	svc := &fakeFineTuneService{
+		statuses: []string{FineTuneStatusRunning, FineTuneStatusRunning, FineTuneStatusSucceeded},
+		events:   [][]*FineTuneEvent{{fineTuneEvent(1, "Job enqueued.")}, {fineTuneEvent(2, "Job started.")}, {fineTuneEvent(3, "Job succeeded.")}},
+		stream:   stream,
+	}
+
+	var messages []string
+	ft, err := WatchFineTune(context.TODO(), svc, "ft-abc",
+		WithWatchStream(true),
+		WithWatchInterval(10*time.Millisecond),
+		WithWatchOnEvent(func(e *FineTuneEvent) {
+			messages = append(messages, e.Message)
+		}),
+	)
+	require.NoError(t, err)
+	require.Equal(t, FineTuneStatusSucceeded, ft.Status)
+	require.Equal(t, []string{"Job enqueued.", "Job started.", "Job succeeded."}, messages)
+}
+
+func TestFineTuneWatcher_Cancel(t *testing.T) {
+	svc := &fakeFineTuneService{statuses: []string{FineTuneStatusRunning}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := WatchFineTune(ctx, svc, "ft-abc", WithWatchInterval(time.Millisecond))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestParseFineTuneMetrics(t *testing.T) {
+	testCase := []struct {
+		name    string
+		message string
+		want    *FineTuneMetrics
+	}{
+		{
+			name:    "test parse step metrics",
+			message: "Step 10/100: training loss=0.42, validation loss=0.51, training accuracy=0.9",
+			want: &FineTuneMetrics{
+				Step:             10,
+				TotalSteps:       100,
+				TrainingLoss:     func() *float64 { v := 0.42; return &v }(),
+				ValidationLoss:   func() *float64 { v := 0.51; return &v }(),
+				TrainingAccuracy: func() *float64 { v := 0.9; return &v }(),
+			},
+		},
+		{
+			name:    "test parse epoch",
+			message: "Completed epoch 2/4",
+			want:    &FineTuneMetrics{Epoch: 2, TotalEpochs: 4},
+		},
+		{
+			name:    "test parse step without metrics",
+			message: "Step 10/100: checkpoint saved",
+		},
+		{
+			name:    "test parse plain message",
+			message: "Job started.",
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			e := fineTuneEvent(1, tc.message)
+			got, ok := ParseFineTuneMetrics(e)
+			if tc.want == nil {
+				require.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			tc.want.Event = e
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
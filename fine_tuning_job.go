@@ -0,0 +1,353 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+const (
+	FineTuningJobCreatePath          = "/fine_tuning/jobs"
+	FineTuningJobListPath            = "/fine_tuning/jobs"
+	FineTuningJobRetrievePath        = "/fine_tuning/jobs/%s"
+	FineTuningJobCancelPath          = "/fine_tuning/jobs/%s/cancel"
+	FineTuningJobPausePath           = "/fine_tuning/jobs/%s/pause"
+	FineTuningJobResumePath          = "/fine_tuning/jobs/%s/resume"
+	FineTuningJobEventsPath          = "/fine_tuning/jobs/%s/events"
+	FineTuningJobCheckpointsListPath = "/fine_tuning/jobs/%s/checkpoints"
+
+	FineTuningMethodSupervised = "supervised"
+	FineTuningMethodDPO        = "dpo"
+
+	FineTuningIntegrationWandb = "wandb"
+
+	// FilePurposeFineTune 训练文件和验证文件的 purpose
+	FilePurposeFineTune = "fine-tune"
+
+	hyperparameterAuto = "auto"
+)
+
+// FineTuningJobStatus 训练任务的状态
+type FineTuningJobStatus string
+
+const (
+	FineTuningJobStatusValidatingFiles FineTuningJobStatus = "validating_files"
+	FineTuningJobStatusQueued          FineTuningJobStatus = "queued"
+	FineTuningJobStatusRunning         FineTuningJobStatus = "running"
+	FineTuningJobStatusPaused          FineTuningJobStatus = "paused"
+	FineTuningJobStatusSucceeded       FineTuningJobStatus = "succeeded"
+	FineTuningJobStatusFailed          FineTuningJobStatus = "failed"
+	FineTuningJobStatusCancelled       FineTuningJobStatus = "cancelled"
+)
+
+// Done 判断是否为终止状态，paused 的任务可以通过 Resume 恢复，不是终止状态
+func (s FineTuningJobStatus) Done() bool {
+	switch s {
+	case FineTuningJobStatusSucceeded, FineTuningJobStatusFailed, FineTuningJobStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// FineTuningJobService 对应 /fine_tuning/jobs 接口，取代已经废弃的 /fine-tunes 接口（FineTuneService）
+type FineTuningJobService interface {
+	Create(ctx context.Context, req *FineTuningJobCreateRequest) (*FineTuningJob, error)
+	List(ctx context.Context, req *FineTuningListRequest) (*FineTuningJobListResponse, error)
+	Retrieve(ctx context.Context, id string) (*FineTuningJob, error)
+	Cancel(ctx context.Context, id string) (*FineTuningJob, error)
+	Pause(ctx context.Context, id string) (*FineTuningJob, error)
+	Resume(ctx context.Context, id string) (*FineTuningJob, error)
+	ListEvents(ctx context.Context, id string, req *FineTuningListRequest) (*FineTuningJobEventListResponse, error)
+	ListCheckpoints(ctx context.Context, id string, req *FineTuningListRequest) (*FineTuningJobCheckpointListResponse, error)
+}
+
+// Hyperparameter 超参数的取值，可以是 "auto"（由服务端根据数据集决定）或者具体的数值
+type Hyperparameter struct {
+	Auto  bool
+	Value float64
+}
+
+// HyperparameterAuto 由服务端决定超参数的取值
+func HyperparameterAuto() *Hyperparameter {
+	return &Hyperparameter{Auto: true}
+}
+
+// HyperparameterValue 指定超参数的取值
+func HyperparameterValue(v float64) *Hyperparameter {
+	return &Hyperparameter{Value: v}
+}
+
+func (h Hyperparameter) MarshalJSON() ([]byte, error) {
+	if h.Auto {
+		return json.Marshal(hyperparameterAuto)
+	}
+	return json.Marshal(h.Value)
+}
+
+func (h *Hyperparameter) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if s == hyperparameterAuto {
+			*h = Hyperparameter{Auto: true}
+			return nil
+		}
+		// 兼容以字符串表示的数值
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("openai: invalid hyperparameter %q", s)
+		}
+		*h = Hyperparameter{Value: v}
+		return nil
+	}
+
+	var v float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*h = Hyperparameter{Value: v}
+	return nil
+}
+
+// FineTuningHyperparameters 训练的超参数，没有设置的参数由服务端决定，Beta 只用于 DPO
+type FineTuningHyperparameters struct {
+	BatchSize              *Hyperparameter `json:"batch_size,omitempty"`
+	LearningRateMultiplier *Hyperparameter `json:"learning_rate_multiplier,omitempty"`
+	NEpochs                *Hyperparameter `json:"n_epochs,omitempty"`
+	Beta                   *Hyperparameter `json:"beta,omitempty"`
+}
+
+// FineTuningMethod 训练方法，Type 为 FineTuningMethodSupervised 或者 FineTuningMethodDPO，只需要设置对应的字段
+type FineTuningMethod struct {
+	Type       string                      `json:"type"`
+	Supervised *FineTuningMethodParameters `json:"supervised,omitempty"`
+	DPO        *FineTuningMethodParameters `json:"dpo,omitempty"`
+}
+
+type FineTuningMethodParameters struct {
+	Hyperparameters *FineTuningHyperparameters `json:"hyperparameters,omitempty"`
+}
+
+// NewSupervisedMethod 创建监督学习的训练方法，hyperparameters 可以为 nil
+func NewSupervisedMethod(hyperparameters *FineTuningHyperparameters) *FineTuningMethod {
+	return &FineTuningMethod{
+		Type:       FineTuningMethodSupervised,
+		Supervised: &FineTuningMethodParameters{Hyperparameters: hyperparameters},
+	}
+}
+
+// NewDPOMethod 创建 DPO（Direct Preference Optimization）训练方法，hyperparameters 可以为 nil
+func NewDPOMethod(hyperparameters *FineTuningHyperparameters) *FineTuningMethod {
+	return &FineTuningMethod{
+		Type: FineTuningMethodDPO,
+		DPO:  &FineTuningMethodParameters{Hyperparameters: hyperparameters},
+	}
+}
+
+// FineTuningIntegration 训练任务的第三方集成，目前只支持 Weights and Biases
+type FineTuningIntegration struct {
+	Type  string            `json:"type"`
+	Wandb *WandbIntegration `json:"wandb,omitempty"`
+}
+
+type WandbIntegration struct {
+	Project string   `json:"project"`
+	Name    string   `json:"name,omitempty"`
+	Entity  string   `json:"entity,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// NewWandbIntegration 创建 Weights and Biases 集成
+func NewWandbIntegration(wandb *WandbIntegration) *FineTuningIntegration {
+	return &FineTuningIntegration{
+		Type:  FineTuningIntegrationWandb,
+		Wandb: wandb,
+	}
+}
+
+type FineTuningJobCreateRequest struct {
+	Model          string `json:"model"`
+	TrainingFile   string `json:"training_file"`
+	ValidationFile string `json:"validation_file,omitempty"`
+	// Hyperparameters 已经废弃，建议使用 Method 中的超参数
+	Hyperparameters *FineTuningHyperparameters `json:"hyperparameters,omitempty"`
+	Method          *FineTuningMethod          `json:"method,omitempty"`
+	Integrations    []*FineTuningIntegration   `json:"integrations,omitempty"`
+	Seed            *int64                     `json:"seed,omitempty"`
+	Suffix          string                     `json:"suffix,omitempty"` // 最多 64 个字符，会出现在模型名称中
+	Metadata        map[string]string          `json:"metadata,omitempty"`
+}
+
+// FineTuningListRequest 分页参数，After 为上一页最后一个元素的 id
+type FineTuningListRequest struct {
+	After string `url:"after,omitempty"`
+	Limit int64  `url:"limit,omitempty"`
+}
+
+// FineTuningJobError 训练任务失败的原因
+type FineTuningJobError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param"`
+}
+
+func (e *FineTuningJobError) Error() string {
+	if e.Param != "" {
+		return fmt.Sprintf("openai: fine-tuning job failed: %s (code: %s, param: %s)", e.Message, e.Code, e.Param)
+	}
+	return fmt.Sprintf("openai: fine-tuning job failed: %s (code: %s)", e.Message, e.Code)
+}
+
+type FineTuningJob struct {
+	Id              string                     `json:"id"`
+	Object          string                     `json:"object"`
+	CreatedAt       int64                      `json:"created_at"`
+	Error           *FineTuningJobError        `json:"error"`
+	FineTunedModel  string                     `json:"fine_tuned_model"`
+	FinishedAt      int64                      `json:"finished_at"`
+	Hyperparameters *FineTuningHyperparameters `json:"hyperparameters"`
+	Model           string                     `json:"model"`
+	OrganizationId  string                     `json:"organization_id"`
+	ResultFiles     []string                   `json:"result_files"`
+	Status          FineTuningJobStatus        `json:"status"`
+	TrainedTokens   int64                      `json:"trained_tokens"`
+	TrainingFile    string                     `json:"training_file"`
+	ValidationFile  string                     `json:"validation_file"`
+	Integrations    []*FineTuningIntegration   `json:"integrations"`
+	Seed            int64                      `json:"seed"`
+	EstimatedFinish int64                      `json:"estimated_finish"`
+	Method          *FineTuningMethod          `json:"method"`
+	Metadata        map[string]string          `json:"metadata"`
+}
+
+// Err 返回任务失败的原因，任务没有失败时返回 nil
+func (j *FineTuningJob) Err() error {
+	if j.Status != FineTuningJobStatusFailed {
+		return nil
+	}
+	if j.Error == nil {
+		return &FineTuningJobError{Message: "unknown error"}
+	}
+	return j.Error
+}
+
+type FineTuningJobListResponse struct {
+	Object  string           `json:"object"`
+	Data    []*FineTuningJob `json:"data"`
+	HasMore bool             `json:"has_more"`
+}
+
+// FineTuningJobEvent 训练任务的事件，Type 为 message 或者 metrics，metrics 事件的 Data 包含训练指标
+type FineTuningJobEvent struct {
+	Id        string          `json:"id"`
+	Object    string          `json:"object"`
+	CreatedAt int64           `json:"created_at"`
+	Level     string          `json:"level"`
+	Message   string          `json:"message"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+type FineTuningJobEventListResponse struct {
+	Object  string                `json:"object"`
+	Data    []*FineTuningJobEvent `json:"data"`
+	HasMore bool                  `json:"has_more"`
+}
+
+// FineTuningJobCheckpoint 训练过程中保存的模型，FineTunedModelCheckpoint 可以直接作为模型名称使用
+type FineTuningJobCheckpoint struct {
+	Id                       string            `json:"id"`
+	Object                   string            `json:"object"`
+	CreatedAt                int64             `json:"created_at"`
+	FineTunedModelCheckpoint string            `json:"fine_tuned_model_checkpoint"`
+	StepNumber               int64             `json:"step_number"`
+	Metrics                  CheckpointMetrics `json:"metrics"`
+	FineTuningJobId          string            `json:"fine_tuning_job_id"`
+}
+
+type CheckpointMetrics struct {
+	Step                       float64 `json:"step"`
+	TrainLoss                  float64 `json:"train_loss"`
+	TrainMeanTokenAccuracy     float64 `json:"train_mean_token_accuracy"`
+	ValidLoss                  float64 `json:"valid_loss"`
+	ValidMeanTokenAccuracy     float64 `json:"valid_mean_token_accuracy"`
+	FullValidLoss              float64 `json:"full_valid_loss"`
+	FullValidMeanTokenAccuracy float64 `json:"full_valid_mean_token_accuracy"`
+}
+
+type FineTuningJobCheckpointListResponse struct {
+	Object  string                     `json:"object"`
+	Data    []*FineTuningJobCheckpoint `json:"data"`
+	FirstId string                     `json:"first_id"`
+	LastId  string                     `json:"last_id"`
+	HasMore bool                       `json:"has_more"`
+}
+
+type FineTuningJobServiceOp struct {
+	client *Client
+}
+
+func (f FineTuningJobServiceOp) Create(ctx context.Context, req *FineTuningJobCreateRequest) (*FineTuningJob, error) {
+	var resp FineTuningJob
+	err := f.client.Post(ctx, FineTuningJobCreatePath, req, &resp)
+	return &resp, err
+}
+
+func (f FineTuningJobServiceOp) List(ctx context.Context, req *FineTuningListRequest) (*FineTuningJobListResponse, error) {
+	var resp FineTuningJobListResponse
+	err := f.client.Get(ctx, FineTuningJobListPath, req, &resp)
+	return &resp, err
+}
+
+func (f FineTuningJobServiceOp) Retrieve(ctx context.Context, id string) (*FineTuningJob, error) {
+	var resp FineTuningJob
+	err := f.client.Get(ctx, fmt.Sprintf(FineTuningJobRetrievePath, id), nil, &resp)
+	return &resp, err
+}
+
+func (f FineTuningJobServiceOp) Cancel(ctx context.Context, id string) (*FineTuningJob, error) {
+	var resp FineTuningJob
+	err := f.client.Post(ctx, fmt.Sprintf(FineTuningJobCancelPath, id), nil, &resp)
+	return &resp, err
+}
+
+// Pause 暂停正在运行的任务，暂停期间不会继续计费，可以通过 Resume 恢复
+func (f FineTuningJobServiceOp) Pause(ctx context.Context, id string) (*FineTuningJob, error) {
+	var resp FineTuningJob
+	err := f.client.Post(ctx, fmt.Sprintf(FineTuningJobPausePath, id), nil, &resp)
+	return &resp, err
+}
+
+func (f FineTuningJobServiceOp) Resume(ctx context.Context, id string) (*FineTuningJob, error) {
+	var resp FineTuningJob
+	err := f.client.Post(ctx, fmt.Sprintf(FineTuningJobResumePath, id), nil, &resp)
+	return &resp, err
+}
+
+// ListEvents 返回任务的事件，按照时间倒序排列
+func (f FineTuningJobServiceOp) ListEvents(ctx context.Context, id string, req *FineTuningListRequest) (*FineTuningJobEventListResponse, error) {
+	var resp FineTuningJobEventListResponse
+	err := f.client.Get(ctx, fmt.Sprintf(FineTuningJobEventsPath, id), req, &resp)
+	return &resp, err
+}
+
+// ListCheckpoints 返回任务保存的模型，只有训练完成的任务才有
+func (f FineTuningJobServiceOp) ListCheckpoints(ctx context.Context, id string, req *FineTuningListRequest) (*FineTuningJobCheckpointListResponse, error) {
+	var resp FineTuningJobCheckpointListResponse
+	err := f.client.Get(ctx, fmt.Sprintf(FineTuningJobCheckpointsListPath, id), req, &resp)
+	return &resp, err
+}
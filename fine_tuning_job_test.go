@@ -0,0 +1,259 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestFineTuningJobServiceOp(t *testing.T) {
+	testCase := []struct {
+		name       string
+		wantMethod string
+		wantPath   string
+		wantQuery  string
+		wantBody   string
+		filename   string
+		call       func(ctx context.Context, client *Client) (any, error)
+		wantRes    any
+	}{
+		{
+			name:       "test fine-tuning job create",
+			wantMethod: http.MethodPost,
+			wantPath:   "/v1/fine_tuning/jobs",
+			wantBody: `{
+				"model": "gpt-4o-mini",
+				"training_file": "file-abc123",
+				"method": {"type": "dpo", "dpo": {"hyperparameters": {"beta": 0.1, "n_epochs": "auto"}}},
+				"integrations": [{"type": "wandb", "wandb": {"project": "my-wandb-project"}}],
+				"seed": 42,
+				"suffix": "custom_suffix"
+			}`,
+			filename: "fine_tuning_job_response.json",
+			call: func(ctx context.Context, client *Client) (any, error) {
+				seed := int64(42)
+				return client.FineTuningJobs.Create(ctx, &FineTuningJobCreateRequest{
+					Model:        "gpt-4o-mini",
+					TrainingFile: "file-abc123",
+					Method: NewDPOMethod(&FineTuningHyperparameters{
+						Beta:    HyperparameterValue(0.1),
+						NEpochs: HyperparameterAuto(),
+					}),
+					Integrations: []*FineTuningIntegration{NewWandbIntegration(&WandbIntegration{Project: "my-wandb-project"})},
+					Seed:         &seed,
+					Suffix:       "custom_suffix",
+				})
+			},
+			wantRes: &FineTuningJob{},
+		},
+		{
+			name:       "test fine-tuning job list",
+			wantMethod: http.MethodGet,
+			wantPath:   "/v1/fine_tuning/jobs",
+			wantQuery:  "after=ftjob-abc122&limit=1",
+			call: func(ctx context.Context, client *Client) (any, error) {
+				return client.FineTuningJobs.List(ctx, &FineTuningListRequest{After: "ftjob-abc122", Limit: 1})
+			},
+			wantRes: &FineTuningJobListResponse{},
+		},
+		{
+			name:       "test fine-tuning job retrieve",
+			wantMethod: http.MethodGet,
+			wantPath:   "/v1/fine_tuning/jobs/ftjob-abc123",
+			filename:   "fine_tuning_job_response.json",
+			call: func(ctx context.Context, client *Client) (any, error) {
+				return client.FineTuningJobs.Retrieve(ctx, "ftjob-abc123")
+			},
+			wantRes: &FineTuningJob{},
+		},
+		{
+			name:       "test fine-tuning job cancel",
+			wantMethod: http.MethodPost,
+			wantPath:   "/v1/fine_tuning/jobs/ftjob-abc123/cancel",
+			filename:   "fine_tuning_job_response.json",
+			call: func(ctx context.Context, client *Client) (any, error) {
+				return client.FineTuningJobs.Cancel(ctx, "ftjob-abc123")
+			},
+			wantRes: &FineTuningJob{},
+		},
+		{
+			name:       "test fine-tuning job pause",
+			wantMethod: http.MethodPost,
+			wantPath:   "/v1/fine_tuning/jobs/ftjob-abc123/pause",
+			filename:   "fine_tuning_job_response.json",
+			call: func(ctx context.Context, client *Client) (any, error) {
+				return client.FineTuningJobs.Pause(ctx, "ftjob-abc123")
+			},
+			wantRes: &FineTuningJob{},
+		},
+		{
+			name:       "test fine-tuning job resume",
+			wantMethod: http.MethodPost,
+			wantPath:   "/v1/fine_tuning/jobs/ftjob-abc123/resume",
+			filename:   "fine_tuning_job_response.json",
+			call: func(ctx context.Context, client *Client) (any, error) {
+				return client.FineTuningJobs.Resume(ctx, "ftjob-abc123")
+			},
+			wantRes: &FineTuningJob{},
+		},
+		{
+			name:       "test fine-tuning job list events",
+			wantMethod: http.MethodGet,
+			wantPath:   "/v1/fine_tuning/jobs/ftjob-abc123/events",
+			wantQuery:  "limit=2",
+			filename:   "fine_tuning_job_events_response.json",
+			call: func(ctx context.Context, client *Client) (any, error) {
+				return client.FineTuningJobs.ListEvents(ctx, "ftjob-abc123", &FineTuningListRequest{Limit: 2})
+			},
+			wantRes: &FineTuningJobEventListResponse{},
+		},
+		{
+			name:       "test fine-tuning job list checkpoints",
+			wantMethod: http.MethodGet,
+			wantPath:   "/v1/fine_tuning/jobs/ftjob-abc123/checkpoints",
+			filename:   "fine_tuning_job_checkpoints_response.json",
+			call: func(ctx context.Context, client *Client) (any, error) {
+				return client.FineTuningJobs.ListCheckpoints(ctx, "ftjob-abc123", nil)
+			},
+			wantRes: &FineTuningJobCheckpointListResponse{},
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			data := []byte(`{"object": "list", "data": [], "has_more": false}`)
+			if tc.filename != "" {
+				data = loadTestdata(tc.filename)
+			}
+			require.NoError(t, json.Unmarshal(data, tc.wantRes))
+
+			server := newMockServer(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, tc.wantMethod, r.Method)
+				require.Equal(t, tc.wantPath, r.URL.Path)
+				require.Equal(t, tc.wantQuery, r.URL.RawQuery)
+
+				if tc.wantBody != "" {
+					body, err := io.ReadAll(r.Body)
+					require.NoError(t, err)
+					require.JSONEq(t, tc.wantBody, string(body))
+				}
+
+				_, _ = w.Write(data)
+			})
+			defer server.Close()
+
+			res, err := tc.call(context.TODO(), newMockClient(server.URL))
+			require.NoError(t, err)
+			require.Equal(t, tc.wantRes, res)
+		})
+	}
+}
+
+func TestFineTuningJob_Decode(t *testing.T) {
+	var job FineTuningJob
+	loadMockData("fine_tuning_job_response.json", &job)
+
+	require.Equal(t, FineTuningJobStatusSucceeded, job.Status)
+	require.True(t, job.Status.Done())
+	require.NoError(t, job.Err())
+	require.Equal(t, &FineTuningHyperparameters{
+		NEpochs:                HyperparameterValue(4),
+		BatchSize:              HyperparameterValue(1),
+		LearningRateMultiplier: HyperparameterAuto(),
+	}, job.Hyperparameters)
+	require.Equal(t, FineTuningMethodDPO, job.Method.Type)
+	require.Equal(t, 0.1, job.Method.DPO.Hyperparameters.Beta.Value)
+	require.Equal(t, "my-wandb-project", job.Integrations[0].Wandb.Project)
+
+	var events FineTuningJobEventListResponse
+	loadMockData("fine_tuning_job_events_response.json", &events)
+	require.JSONEq(t, `{"step": 100, "train_loss": 0.14}`, string(events.Data[1].Data))
+
+	var checkpoints FineTuningJobCheckpointListResponse
+	loadMockData("fine_tuning_job_checkpoints_response.json", &checkpoints)
+	require.Equal(t, 0.134, checkpoints.Data[0].Metrics.FullValidLoss)
+}
+
+func TestFineTuningJob_Err(t *testing.T) {
+	testCase := []struct {
+		name    string
+		job     *FineTuningJob
+		wantErr string
+	}{
+		{
+			name: "test running job",
+			job:  &FineTuningJob{Status: FineTuningJobStatusRunning},
+		},
+		{
+			name:    "test failed job",
+			job:     &FineTuningJob{Status: FineTuningJobStatusFailed, Error: &FineTuningJobError{Code: "invalid_training_file", Message: "bad line", Param: "training_file"}},
+			wantErr: "openai: fine-tuning job failed: bad line (code: invalid_training_file, param: training_file)",
+		},
+		{
+			name:    "test failed job without error",
+			job:     &FineTuningJob{Status: FineTuningJobStatusFailed},
+			wantErr: "openai: fine-tuning job failed: unknown error (code: )",
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.job.Err()
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestHyperparameter(t *testing.T) {
+	testCase := []struct {
+		name     string
+		data     string
+		want     *Hyperparameter
+		wantJSON string
+		wantErr  bool
+	}{
+		{name: "test hyperparameter auto", data: `"auto"`, want: HyperparameterAuto(), wantJSON: `"auto"`},
+		{name: "test hyperparameter number", data: `0.5`, want: HyperparameterValue(0.5), wantJSON: `0.5`},
+		{name: "test hyperparameter numeric string", data: `"3"`, want: HyperparameterValue(3), wantJSON: `3`},
+		{name: "test hyperparameter invalid", data: `"many"`, wantErr: true},
+		{name: "test hyperparameter invalid type", data: `true`, wantErr: true},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			var got Hyperparameter
+			err := json.Unmarshal([]byte(tc.data), &got)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, &got)
+
+			b, err := json.Marshal(&got)
+			require.NoError(t, err)
+			require.JSONEq(t, tc.wantJSON, string(b))
+		})
+	}
+}
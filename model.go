@@ -17,6 +17,7 @@ package openai
 import (
 	"context"
 	"fmt"
+	"strings"
 )
 
 const (
@@ -79,3 +80,62 @@ func (m ModelServiceOp) Retrieve(ctx context.Context, model string) (*Model, err
 	err := m.client.Get(ctx, fmt.Sprintf(ModelRetrievePath, model), nil, &resp)
 	return &resp, err
 }
+
+// contextWindows 模型的上下文长度，带日期的快照版本按照最长的前缀匹配
+var contextWindows = map[string]int{
+	"gpt-3.5-turbo":          16385,
+	"gpt-3.5-turbo-0301":     4096,
+	"gpt-3.5-turbo-0613":     4096,
+	"gpt-3.5-turbo-16k":      16385,
+	"gpt-3.5-turbo-instruct": 4096,
+	"gpt-4":                  8192,
+	"gpt-4-32k":              32768,
+	"gpt-4-turbo":            128000,
+	"gpt-4-1106":             128000,
+	"gpt-4-0125":             128000,
+	"gpt-4o":                 128000,
+	"gpt-4.1":                1047576,
+	"o1":                     200000,
+	"o1-mini":                128000,
+	"o1-preview":             128000,
+	"o3":                     200000,
+	"o4-mini":                200000,
+	"davinci-002":            16384,
+	"babbage-002":            16384,
+	"text-davinci-003":       4097,
+	"text-davinci-002":       4097,
+	"text-davinci-001":       2049,
+	"text-curie-001":         2049,
+	"text-babbage-001":       2049,
+	"text-ada-001":           2049,
+	"davinci":                2049,
+	"curie":                  2049,
+	"babbage":                2049,
+	"ada":                    2049,
+}
+
+// ContextWindow 返回模型的上下文长度，包括 prompt 和生成的 token，未知的模型返回 0。
+// 微调的模型使用基础模型的上下文长度
+func ContextWindow(model string) int {
+	// ft:gpt-3.5-turbo-0613:org::id 或者 curie:ft-org-2023-06-01
+	if strings.HasPrefix(model, "ft:") {
+		model = strings.TrimPrefix(model, "ft:")
+	}
+	if i := strings.Index(model, ":"); i > 0 {
+		model = model[:i]
+	}
+
+	for m := model; m != ""; {
+		if n, ok := contextWindows[m]; ok {
+			return n
+		}
+
+		i := strings.LastIndex(m, "-")
+		if i < 0 {
+			break
+		}
+		m = m[:i]
+	}
+
+	return 0
+}
@@ -0,0 +1,617 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-logr/logr"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ModerationCategoryHate                  = "hate"
+	ModerationCategoryHateThreatening       = "hate/threatening"
+	ModerationCategoryHarassment            = "harassment"
+	ModerationCategoryHarassmentThreatening = "harassment/threatening"
+	ModerationCategorySelfHarm              = "self-harm"
+	ModerationCategorySelfHarmIntent        = "self-harm/intent"
+	ModerationCategorySelfHarmInstructions  = "self-harm/instructions"
+	ModerationCategorySexual                = "sexual"
+	ModerationCategorySexualMinors          = "sexual/minors"
+	ModerationCategoryViolence              = "violence"
+	ModerationCategoryViolenceGraphic       = "violence/graphic"
+	ModerationCategoryIllicit               = "illicit"
+	ModerationCategoryIllicitViolent        = "illicit/violent"
+	// ModerationCategoryFlagged 服务端标记为违规，但是违规的类别不在 ModerationCategory 中，例如服务端新增的类别
+	ModerationCategoryFlagged = "flagged"
+
+	defaultModerationWindow    = 512
+	defaultModerationOverlap   = 64
+	defaultModerationRedaction = "[redacted]"
+)
+
+// ErrModerationBlocked 内容没有通过审核，具体原因见 ModerationError
+var ErrModerationBlocked = errors.New("openai: content blocked by moderation")
+
+// moderationCategories 按照固定顺序列出所有的类别，用于遍历 ModerationCategory 和 ModerationCategoryScore
+var moderationCategories = []struct {
+	name    string
+	flagged func(c *ModerationCategory) bool
+	score   func(s *ModerationCategoryScore) *float64
+}{
+	{ModerationCategoryHate, func(c *ModerationCategory) bool { return c.Hate }, func(s *ModerationCategoryScore) *float64 { return &s.Hate }},
+	{ModerationCategoryHateThreatening, func(c *ModerationCategory) bool { return c.HateThreatening }, func(s *ModerationCategoryScore) *float64 { return &s.HateThreatening }},
+	{ModerationCategoryHarassment, func(c *ModerationCategory) bool { return c.Harassment }, func(s *ModerationCategoryScore) *float64 { return &s.Harassment }},
+	{ModerationCategoryHarassmentThreatening, func(c *ModerationCategory) bool { return c.HarassmentThreatening }, func(s *ModerationCategoryScore) *float64 { return &s.HarassmentThreatening }},
+	{ModerationCategorySelfHarm, func(c *ModerationCategory) bool { return c.SelfHarm }, func(s *ModerationCategoryScore) *float64 { return &s.SelfHarm }},
+	{ModerationCategorySelfHarmIntent, func(c *ModerationCategory) bool { return c.SelfHarmIntent }, func(s *ModerationCategoryScore) *float64 { return &s.SelfHarmIntent }},
+	{ModerationCategorySelfHarmInstructions, func(c *ModerationCategory) bool { return c.SelfHarmInstructions }, func(s *ModerationCategoryScore) *float64 { return &s.SelfHarmInstructions }},
+	{ModerationCategorySexual, func(c *ModerationCategory) bool { return c.Sexual }, func(s *ModerationCategoryScore) *float64 { return &s.Sexual }},
+	{ModerationCategorySexualMinors, func(c *ModerationCategory) bool { return c.SexualMinors }, func(s *ModerationCategoryScore) *float64 { return &s.SexualMinors }},
+	{ModerationCategoryViolence, func(c *ModerationCategory) bool { return c.Violence }, func(s *ModerationCategoryScore) *float64 { return &s.Violence }},
+	{ModerationCategoryViolenceGraphic, func(c *ModerationCategory) bool { return c.ViolenceGraphic }, func(s *ModerationCategoryScore) *float64 { return &s.ViolenceGraphic }},
+	{ModerationCategoryIllicit, func(c *ModerationCategory) bool { return c.Illicit }, func(s *ModerationCategoryScore) *float64 { return &s.Illicit }},
+	{ModerationCategoryIllicitViolent, func(c *ModerationCategory) bool { return c.IllicitViolent }, func(s *ModerationCategoryScore) *float64 { return &s.IllicitViolent }},
+}
+
+// ModerationAction 内容没有通过审核时的处理方式
+type ModerationAction int
+
+const (
+	// ModerationBlock 中断请求，返回 *ModerationError
+	ModerationBlock ModerationAction = iota
+	// ModerationRedact 将没有通过审核的内容替换为 WithModerationRedaction 指定的文本，请求继续
+	ModerationRedact
+	// ModerationFlag 内容保持不变，通过 WithModerationOnFlag 指定的回调通知调用方
+	ModerationFlag
+)
+
+// ModerationStage 审核发生的阶段
+type ModerationStage string
+
+const (
+	ModerationStageInput  ModerationStage = "input"
+	ModerationStageOutput ModerationStage = "output"
+)
+
+// ModerationError 内容没有通过审核，Index 在输入阶段为消息在 Messages 中的下标，在输出阶段为 choice 的 index
+type ModerationError struct {
+	Stage      ModerationStage
+	Index      int64
+	Categories []string
+	// Scores 各个类别的得分，分段审核时取各段的最大值
+	Scores ModerationCategoryScore
+	Input  string
+}
+
+func (e *ModerationError) Error() string {
+	return fmt.Sprintf("openai: %s %d blocked by moderation: %s", e.Stage, e.Index, strings.Join(e.Categories, ", "))
+}
+
+func (e *ModerationError) Unwrap() error {
+	return ErrModerationBlocked
+}
+
+type moderationGuardConfig struct {
+	model      string
+	thresholds ModerationCategoryScore
+	action     ModerationAction
+	redaction  string
+	onFlag     func(ctx context.Context, v *ModerationError)
+	roles      []string
+	input      bool
+	output     bool
+	window     int
+	overlap    int
+}
+
+type ModerationGuardOption func(*moderationGuardConfig)
+
+// WithModerationModel 设置审核使用的模型，默认使用服务端的默认模型
+func WithModerationModel(model string) ModerationGuardOption {
+	return func(c *moderationGuardConfig) {
+		c.model = model
+	}
+}
+
+// WithModerationThresholds 设置各个类别的得分阈值，得分大于等于阈值即视为违规，
+// 阈值为 0 的类别使用服务端返回的 categories 判断
+func WithModerationThresholds(thresholds ModerationCategoryScore) ModerationGuardOption {
+	return func(c *moderationGuardConfig) {
+		c.thresholds = thresholds
+	}
+}
+
+// WithModerationAction 设置违规时的处理方式，默认为 ModerationBlock
+func WithModerationAction(action ModerationAction) ModerationGuardOption {
+	return func(c *moderationGuardConfig) {
+		c.action = action
+	}
+}
+
+// WithModerationRedaction 设置 ModerationRedact 时替换的文本，默认为 [redacted]
+func WithModerationRedaction(text string) ModerationGuardOption {
+	return func(c *moderationGuardConfig) {
+		c.redaction = text
+	}
+}
+
+// WithModerationOnFlag 设置 ModerationFlag 时的回调，回调可能在 stream 的协程中调用
+func WithModerationOnFlag(fn func(ctx context.Context, v *ModerationError)) ModerationGuardOption {
+	return func(c *moderationGuardConfig) {
+		c.onFlag = fn
+	}
+}
+
+// WithModerationInputRoles 设置需要审核的输入消息的角色，默认只审核 user 消息
+func WithModerationInputRoles(roles ...string) ModerationGuardOption {
+	return func(c *moderationGuardConfig) {
+		c.roles = roles
+	}
+}
+
+// WithModerationInput 设置是否审核输入，默认审核
+func WithModerationInput(enabled bool) ModerationGuardOption {
+	return func(c *moderationGuardConfig) {
+		c.input = enabled
+	}
+}
+
+// WithModerationOutput 设置是否审核输出，默认审核
+func WithModerationOutput(enabled bool) ModerationGuardOption {
+	return func(c *moderationGuardConfig) {
+		c.output = enabled
+	}
+}
+
+// WithModerationWindow 设置 stream 模式下的审核窗口，单位为字符，默认为 512 和 64
+// 每个 choice 累积 size 个字符后审核一次，审核通过之前 chunk 不会交给消费者，
+// 每次审核会带上上一个窗口末尾的 overlap 个字符，避免违规内容被窗口截断
+func WithModerationWindow(size, overlap int) ModerationGuardOption {
+	return func(c *moderationGuardConfig) {
+		if size > 0 {
+			c.window = size
+		}
+		if overlap >= 0 {
+			c.overlap = overlap
+		}
+	}
+}
+
+// ModerationGuard 在聊天前后自动调用审核接口的 ChatService，用法如下：
+//
+//	client.Chat = openai.NewModerationGuard(client.Chat, client.Moderations,
+//		openai.WithModerationThresholds(openai.ModerationCategoryScore{Violence: 0.5}),
+//	)
+//
+// 输入中没有通过审核的消息不会发送给模型；stream 模式下输出按照窗口分段审核，
+// 违规内容在审核之前不会交给消费者，ModerationBlock 时流以 *ModerationError 中断
+type ModerationGuard struct {
+	chat       ChatService
+	moderation ModerationService
+	cfg        moderationGuardConfig
+}
+
+func NewModerationGuard(chat ChatService, moderation ModerationService, opts ...ModerationGuardOption) *ModerationGuard {
+	cfg := moderationGuardConfig{
+		redaction: defaultModerationRedaction,
+		roles:     []string{RoleUser},
+		input:     true,
+		output:    true,
+		window:    defaultModerationWindow,
+		overlap:   defaultModerationOverlap,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return &ModerationGuard{
+		chat:       chat,
+		moderation: moderation,
+		cfg:        cfg,
+	}
+}
+
+func (g *ModerationGuard) Create(ctx context.Context, req *ChatCreateRequest) (chan *ChatCreateResponse, error) {
+	if req.Stream {
+		stream, err := g.CreateStream(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return chatStreamToChannel(ctx, stream, logr.Discard()), nil
+	}
+
+	r, err := g.moderateInput(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := g.chat.Create(ctx, r)
+	if err != nil || !g.cfg.output {
+		return res, err
+	}
+
+	resp, ok := <-res
+	if !ok {
+		return res, nil
+	}
+
+	if resp.Err() == nil {
+		if err := g.moderateOutput(ctx, resp); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make(chan *ChatCreateResponse, 1)
+	out <- resp
+	close(out)
+	return out, nil
+}
+
+func (g *ModerationGuard) CreateStream(ctx context.Context, req *ChatCreateRequest) (*Stream[*ChatCreateResponse], error) {
+	r, err := g.moderateInput(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	inner, err := g.chat.CreateStream(ctx, r)
+	if err != nil || !g.cfg.output {
+		return inner, err
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	es := make(EventSource)
+
+	go g.guardStream(streamCtx, inner, es)
+
+	// 同时关闭 inner，否则上游没有新数据时 guardStream 会一直阻塞在 inner.Next
+	return NewStream[*ChatCreateResponse](ctx, es, func() {
+		cancel()
+		_ = inner.Close()
+	}), nil
+}
+
+// moderateInput 审核输入消息，需要脱敏时返回修改后的请求副本，不会修改 req
+func (g *ModerationGuard) moderateInput(ctx context.Context, req *ChatCreateRequest) (*ChatCreateRequest, error) {
+	if !g.cfg.input {
+		return req, nil
+	}
+
+	r := req
+	for i, m := range req.Messages {
+		if !g.shouldModerate(m) {
+			continue
+		}
+
+		v, err := g.check(ctx, ModerationStageInput, int64(i), m.Text())
+		if err != nil {
+			return nil, err
+		}
+
+		redact, err := g.handle(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+
+		if !redact {
+			continue
+		}
+
+		if r == req {
+			copied := *req
+			copied.Messages = append([]*Message(nil), req.Messages...)
+			r = &copied
+		}
+		r.Messages[i] = g.redactMessage(m)
+	}
+
+	return r, nil
+}
+
+func (g *ModerationGuard) shouldModerate(m *Message) bool {
+	for _, role := range g.cfg.roles {
+		if m.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// redactMessage 返回替换了文本内容的消息副本，图片等非文本内容保持不变
+func (g *ModerationGuard) redactMessage(m *Message) *Message {
+	copied := *m
+
+	if len(m.MultiContent) == 0 {
+		copied.Content = g.cfg.redaction
+		return &copied
+	}
+
+	if copied.Content != "" {
+		copied.Content = g.cfg.redaction
+	}
+
+	copied.MultiContent = make([]*ContentPart, 0, len(m.MultiContent))
+	for _, part := range m.MultiContent {
+		if part.Type == ContentPartTypeText {
+			part = NewTextPart(g.cfg.redaction)
+		}
+		copied.MultiContent = append(copied.MultiContent, part)
+	}
+	return &copied
+}
+
+// moderateOutput 审核非 stream 模式下的输出，脱敏时直接修改 resp
+func (g *ModerationGuard) moderateOutput(ctx context.Context, resp *ChatCreateResponse) error {
+	for i, choice := range resp.Choices {
+		if choice.Message == nil {
+			continue
+		}
+
+		v, err := g.check(ctx, ModerationStageOutput, choice.Index, choice.Message.Text())
+		if err != nil {
+			return err
+		}
+
+		redact, err := g.handle(ctx, v)
+		if err != nil {
+			return err
+		}
+
+		if redact {
+			copied := *choice
+			copied.Message = g.redactMessage(choice.Message)
+			resp.Choices[i] = &copied
+		}
+	}
+
+	return nil
+}
+
+// check 调用审核接口，text 违规时返回 *ModerationError，否则返回 nil
+func (g *ModerationGuard) check(ctx context.Context, stage ModerationStage, index int64, text string) (*ModerationError, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	resp, err := g.moderation.Create(ctx, &ModerationCreateRequest{
+		Input: text,
+		Model: g.cfg.model,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v := &ModerationError{
+		Stage: stage,
+		Index: index,
+		Input: text,
+	}
+
+	for _, result := range resp.Results {
+		var violated, overridden bool
+
+		for _, c := range moderationCategories {
+			score := *c.score(&result.CategoryScores)
+			if s := c.score(&v.Scores); score > *s {
+				*s = score
+			}
+
+			// 设置了阈值的类别按照得分判断，否则使用服务端的判断结果
+			flagged := c.flagged(&result.Categories)
+			categoryViolated := flagged
+			if threshold := *c.score(&g.cfg.thresholds); threshold > 0 {
+				categoryViolated = score >= threshold
+				overridden = overridden || (flagged && !categoryViolated)
+			}
+
+			if categoryViolated {
+				violated = true
+				v.addCategory(c.name)
+			}
+		}
+
+		// 服务端标记为违规，但是没有已知的类别违规，并且不是因为阈值放行，使用服务端的判断结果
+		if result.Flagged && !violated && !overridden {
+			v.addCategory(ModerationCategoryFlagged)
+		}
+	}
+
+	if len(v.Categories) == 0 {
+		return nil, nil
+	}
+
+	return v, nil
+}
+
+func (e *ModerationError) addCategory(name string) {
+	for _, c := range e.Categories {
+		if c == name {
+			return
+		}
+	}
+	e.Categories = append(e.Categories, name)
+}
+
+// handle 按照配置的方式处理违规，返回是否需要脱敏
+func (g *ModerationGuard) handle(ctx context.Context, v *ModerationError) (bool, error) {
+	if v == nil {
+		return false, nil
+	}
+
+	switch g.cfg.action {
+	case ModerationRedact:
+		return true, nil
+	case ModerationFlag:
+		if g.cfg.onFlag != nil {
+			g.cfg.onFlag(ctx, v)
+		}
+		return false, nil
+	default:
+		return false, v
+	}
+}
+
+// moderationWindow stream 模式下一个 choice 的审核窗口
+type moderationWindow struct {
+	pending strings.Builder
+	runes   int
+	tail    string // 上一个窗口末尾的 overlap 个字符
+}
+
+// guardStream 按照窗口审核 inner 的输出并转发到 es，审核通过之前 chunk 暂存在 held 中
+func (g *ModerationGuard) guardStream(ctx context.Context, inner *Stream[*ChatCreateResponse], es EventSource) {
+	defer func() {
+		_ = inner.Close()
+		close(es)
+	}()
+
+	var (
+		held    []*ChatCreateResponse
+		indexes []int64 // 按照出现的顺序记录 choice 的 index，保证审核的顺序固定
+		windows = make(map[int64]*moderationWindow)
+	)
+
+	send := func(e Event) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case es <- e:
+			return true
+		}
+	}
+
+	// flush 审核所有窗口中的内容，通过之后将暂存的 chunk 交给消费者
+	flush := func() bool {
+		for _, index := range indexes {
+			w := windows[index]
+			if w.runes == 0 {
+				continue
+			}
+
+			text := w.tail + w.pending.String()
+			w.tail = lastRunes(text, g.cfg.overlap)
+			w.pending.Reset()
+			w.runes = 0
+
+			v, err := g.check(ctx, ModerationStageOutput, index, text)
+			if err == nil {
+				var redact bool
+				redact, err = g.handle(ctx, v)
+				if redact {
+					g.redactChunks(held, index)
+				}
+			}
+
+			if err != nil {
+				send(Event{Err: err})
+				return false
+			}
+		}
+
+		for _, chunk := range held {
+			data, err := json.Marshal(chunk)
+			if err != nil {
+				send(Event{Err: err})
+				return false
+			}
+			if !send(Event{Data: string(data)}) {
+				return false
+			}
+		}
+		held = held[:0]
+
+		return true
+	}
+
+	for inner.Next() {
+		chunk := inner.Current()
+		held = append(held, chunk)
+
+		full := false
+		for _, choice := range chunk.Choices {
+			if choice.Delta == nil || choice.Delta.Content == "" {
+				continue
+			}
+
+			w, ok := windows[choice.Index]
+			if !ok {
+				w = &moderationWindow{}
+				windows[choice.Index] = w
+				indexes = append(indexes, choice.Index)
+			}
+
+			w.pending.WriteString(choice.Delta.Content)
+			w.runes += utf8.RuneCountInString(choice.Delta.Content)
+			if w.runes >= g.cfg.window {
+				full = true
+			}
+		}
+
+		if full && !flush() {
+			return
+		}
+	}
+
+	// 调用方取消时不再审核剩余内容
+	if ctx.Err() != nil {
+		return
+	}
+
+	// 流异常中断时已经收到的内容审核通过后仍然交给消费者，然后返回中断的原因
+	if !flush() {
+		return
+	}
+
+	if err := inner.Err(); err != nil {
+		send(Event{Err: err})
+	}
+}
+
+// redactChunks 将 chunks 中 index 对应 choice 的内容替换为脱敏文本，脱敏文本只出现在第一个有内容的 chunk 中
+func (g *ModerationGuard) redactChunks(chunks []*ChatCreateResponse, index int64) {
+	redacted := false
+	for _, chunk := range chunks {
+		for _, choice := range chunk.Choices {
+			if choice.Index != index || choice.Delta == nil || choice.Delta.Content == "" {
+				continue
+			}
+
+			if redacted {
+				choice.Delta.Content = ""
+				continue
+			}
+			choice.Delta.Content = g.cfg.redaction
+			redacted = true
+		}
+	}
+}
+
+func lastRunes(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+
+	i := len(s)
+	for ; n > 0 && i > 0; n-- {
+		_, size := utf8.DecodeLastRuneInString(s[:i])
+		i -= size
+	}
+	return s[i:]
+}
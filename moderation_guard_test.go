@@ -0,0 +1,416 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeModerationService 文本包含 kill 时 violence 违规，包含 fight 时 violence 得分为 0.3 但不违规，
+// 包含 idiot 时 harassment 违规，包含 scam 时只设置 flagged，模拟不在 ModerationCategory 中的类别
+type fakeModerationService struct {
+	inputs []string
+}
+
+func (f *fakeModerationService) Create(ctx context.Context, req *ModerationCreateRequest) (*ModerationCreateResponse, error) {
+	f.inputs = append(f.inputs, req.Input)
+
+	result := &Moderation{}
+	switch {
+	case strings.Contains(req.Input, "kill"):
+		result.Flagged = true
+		result.Categories.Violence = true
+		result.CategoryScores.Violence = 0.9
+	case strings.Contains(req.Input, "fight"):
+		result.CategoryScores.Violence = 0.3
+	case strings.Contains(req.Input, "idiot"):
+		result.Flagged = true
+		result.Categories.Harassment = true
+		result.CategoryScores.Harassment = 0.8
+	case strings.Contains(req.Input, "scam"):
+		result.Flagged = true
+	}
+
+	return &ModerationCreateResponse{Results: []*Moderation{result}}, nil
+}
+
+// fakeChatService 非 stream 模式返回 content，stream 模式将 chunks 依次返回，最后返回 err
+type fakeChatService struct {
+	content  string
+	chunks   []string
+	err      error
+	requests []*ChatCreateRequest
+}
+
+func (f *fakeChatService) Create(ctx context.Context, req *ChatCreateRequest) (chan *ChatCreateResponse, error) {
+	f.requests = append(f.requests, req)
+
+	res := make(chan *ChatCreateResponse, 1)
+	res <- &ChatCreateResponse{
+		Id:      "chatcmpl-1",
+		Choices: []*ChatCompletion{{Message: &Message{Role: RoleAssistant, Content: f.content}, FinishReason: FinishReasonStop}},
+	}
+	close(res)
+	return res, nil
+}
+
+func (f *fakeChatService) CreateStream(ctx context.Context, req *ChatCreateRequest) (*Stream[*ChatCreateResponse], error) {
+	f.requests = append(f.requests, req)
+
+	es := make(EventSource, len(f.chunks)+1)
+	for _, chunk := range f.chunks {
+		data, _ := json.Marshal(&ChatCreateResponse{
+			Id:      "chatcmpl-1",
+			Choices: []*ChatCompletion{{Delta: &Delta{Content: chunk}}},
+		})
+		es <- Event{Data: string(data)}
+	}
+	if f.err != nil {
+		es <- Event{Err: f.err}
+	}
+	close(es)
+
+	return NewStream[*ChatCreateResponse](ctx, es, nil), nil
+}
+
+// idleChatService stream 模式建立连接之后不再返回任何数据，直到被 Close
+type idleChatService struct {
+	fakeChatService
+	closed chan struct{}
+}
+
+func (f *idleChatService) CreateStream(ctx context.Context, req *ChatCreateRequest) (*Stream[*ChatCreateResponse], error) {
+	es := make(EventSource)
+
+	var once sync.Once
+	return NewStream[*ChatCreateResponse](ctx, es, func() {
+		once.Do(func() {
+			close(es)
+			close(f.closed)
+		})
+	}), nil
+}
+
+func moderationTestRequest(content string) *ChatCreateRequest {
+	return &ChatCreateRequest{
+		Model: "gpt-4o",
+		Messages: []*Message{
+			{Role: RoleSystem, Content: "you can kill processes"},
+			{Role: RoleUser, Content: content},
+		},
+	}
+}
+
+func TestModerationGuard_Input(t *testing.T) {
+	testCase := []struct {
+		name        string
+		opts        []ModerationGuardOption
+		content     string
+		wantErr     *ModerationError
+		wantContent string
+		wantFlagged int
+	}{
+		{
+			name:        "test input pass",
+			content:     "hello",
+			wantContent: "hello",
+		},
+		{
+			name:    "test input block",
+			content: "I will kill you",
+			wantErr: &ModerationError{
+				Stage:      ModerationStageInput,
+				Index:      1,
+				Categories: []string{ModerationCategoryViolence},
+				Scores:     ModerationCategoryScore{Violence: 0.9},
+				Input:      "I will kill you",
+			},
+		},
+		{
+			name:        "test input redact",
+			opts:        []ModerationGuardOption{WithModerationAction(ModerationRedact), WithModerationRedaction("***")},
+			content:     "I will kill you",
+			wantContent: "***",
+		},
+		{
+			name:        "test input flag",
+			opts:        []ModerationGuardOption{WithModerationAction(ModerationFlag)},
+			content:     "I will kill you",
+			wantContent: "I will kill you",
+			wantFlagged: 1,
+		},
+		{
+			name:        "test input below threshold",
+			opts:        []ModerationGuardOption{WithModerationThresholds(ModerationCategoryScore{Violence: 0.95})},
+			content:     "I will kill you",
+			wantContent: "I will kill you",
+		},
+		{
+			name:    "test input above threshold",
+			opts:    []ModerationGuardOption{WithModerationThresholds(ModerationCategoryScore{Violence: 0.2})},
+			content: "let's fight",
+			wantErr: &ModerationError{
+				Stage:      ModerationStageInput,
+				Index:      1,
+				Categories: []string{ModerationCategoryViolence},
+				Scores:     ModerationCategoryScore{Violence: 0.3},
+				Input:      "let's fight",
+			},
+		},
+		{
+			name:    "test input block harassment",
+			content: "you idiot",
+			wantErr: &ModerationError{
+				Stage:      ModerationStageInput,
+				Index:      1,
+				Categories: []string{ModerationCategoryHarassment},
+				Scores:     ModerationCategoryScore{Harassment: 0.8},
+				Input:      "you idiot",
+			},
+		},
+		{
+			name:    "test input block flagged without known category",
+			content: "buy this scam",
+			wantErr: &ModerationError{
+				Stage:      ModerationStageInput,
+				Index:      1,
+				Categories: []string{ModerationCategoryFlagged},
+				Input:      "buy this scam",
+			},
+		},
+		{
+			name:        "test input disabled",
+			opts:        []ModerationGuardOption{WithModerationInput(false)},
+			content:     "I will kill you",
+			wantContent: "I will kill you",
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			chat := &fakeChatService{content: "ok"}
+			flagged := 0
+			opts := append([]ModerationGuardOption{WithModerationOnFlag(func(ctx context.Context, v *ModerationError) {
+				flagged++
+			})}, tc.opts...)
+			guard := NewModerationGuard(chat, &fakeModerationService{}, opts...)
+
+			req := moderationTestRequest(tc.content)
+			_, err := guard.Create(context.TODO(), req)
+			if tc.wantErr != nil {
+				require.True(t, errors.Is(err, ErrModerationBlocked))
+				var v *ModerationError
+				require.True(t, errors.As(err, &v))
+				require.Equal(t, tc.wantErr, v)
+				require.Empty(t, chat.requests)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, chat.requests, 1)
+			require.Equal(t, tc.wantContent, chat.requests[0].Messages[1].Content)
+			require.Equal(t, tc.wantFlagged, flagged)
+			// 默认不审核 system 消息，也不会修改调用方的请求
+			require.Equal(t, "you can kill processes", chat.requests[0].Messages[0].Content)
+			require.Equal(t, tc.content, req.Messages[1].Content)
+		})
+	}
+}
+
+func TestModerationGuard_RedactMultiContent(t *testing.T) {
+	chat := &fakeChatService{content: "ok"}
+	guard := NewModerationGuard(chat, &fakeModerationService{}, WithModerationAction(ModerationRedact))
+
+	image := NewImageURLPart("https://example.com/a.png", ImageDetailLow)
+	_, err := guard.Create(context.TODO(), &ChatCreateRequest{
+		Messages: []*Message{{Role: RoleUser, MultiContent: []*ContentPart{NewTextPart("kill it"), image}}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []*ContentPart{NewTextPart(defaultModerationRedaction), image}, chat.requests[0].Messages[0].MultiContent)
+}
+
+func TestModerationGuard_Output(t *testing.T) {
+	testCase := []struct {
+		name        string
+		opts        []ModerationGuardOption
+		content     string
+		wantErr     bool
+		wantContent string
+	}{
+		{
+			name:        "test output pass",
+			content:     "hello",
+			wantContent: "hello",
+		},
+		{
+			name:    "test output block",
+			content: "kill them all",
+			wantErr: true,
+		},
+		{
+			name:        "test output redact",
+			opts:        []ModerationGuardOption{WithModerationAction(ModerationRedact)},
+			content:     "kill them all",
+			wantContent: defaultModerationRedaction,
+		},
+		{
+			name:        "test output disabled",
+			opts:        []ModerationGuardOption{WithModerationOutput(false)},
+			content:     "kill them all",
+			wantContent: "kill them all",
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			guard := NewModerationGuard(&fakeChatService{content: tc.content}, &fakeModerationService{}, tc.opts...)
+
+			res, err := guard.Create(context.TODO(), moderationTestRequest("hi"))
+			if tc.wantErr {
+				var v *ModerationError
+				require.True(t, errors.As(err, &v))
+				require.Equal(t, ModerationStageOutput, v.Stage)
+				return
+			}
+			require.NoError(t, err)
+
+			resp, err := AccumulateChat(res)
+			require.NoError(t, err)
+			require.Equal(t, tc.wantContent, resp.Choices[0].Message.Content)
+		})
+	}
+}
+
+func TestModerationGuard_Stream(t *testing.T) {
+	streamErr := errors.New("connection reset")
+
+	testCase := []struct {
+		name        string
+		opts        []ModerationGuardOption
+		chunks      []string
+		err         error
+		wantChunks  []string
+		wantInputs  []string
+		wantErr     error
+		wantBlocked bool
+	}{
+		{
+			name:       "test stream pass",
+			chunks:     []string{"hel", "lo ", "wor", "ld"},
+			wantChunks: []string{"hel", "lo ", "wor", "ld"},
+			wantInputs: []string{"hello ", "o world"},
+		},
+		{
+			name:        "test stream block",
+			chunks:      []string{"hel", "lo ", "I k", "ill", " you"},
+			wantChunks:  []string{"hel", "lo "},
+			wantInputs:  []string{"hello ", "o I kill"},
+			wantBlocked: true,
+		},
+		{
+			name:       "test stream redact",
+			opts:       []ModerationGuardOption{WithModerationAction(ModerationRedact), WithModerationRedaction("*")},
+			chunks:     []string{"hel", "lo ", "I k", "ill", " you"},
+			wantChunks: []string{"hel", "lo ", "*", "", " you"},
+			wantInputs: []string{"hello ", "o I kill", "ll you"},
+		},
+		{
+			name:       "test stream interrupted",
+			chunks:     []string{"hel", "lo"},
+			err:        streamErr,
+			wantChunks: []string{"hel", "lo"},
+			wantInputs: []string{"hello"},
+			wantErr:    streamErr,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			moderation := &fakeModerationService{}
+			opts := append([]ModerationGuardOption{WithModerationWindow(5, 2)}, tc.opts...)
+			guard := NewModerationGuard(&fakeChatService{chunks: tc.chunks, err: tc.err}, moderation, opts...)
+
+			s, err := guard.CreateStream(context.TODO(), moderationTestRequest("hi"))
+			require.NoError(t, err)
+			defer s.Close()
+
+			var chunks []string
+			for s.Next() {
+				chunks = append(chunks, s.Current().Choices[0].Delta.Content)
+			}
+
+			require.Equal(t, tc.wantChunks, chunks)
+			// 第一个输入为 user 消息
+			require.Equal(t, tc.wantInputs, moderation.inputs[1:])
+
+			switch {
+			case tc.wantBlocked:
+				require.True(t, errors.Is(s.Err(), ErrModerationBlocked))
+			case tc.wantErr != nil:
+				require.ErrorIs(t, s.Err(), tc.wantErr)
+			default:
+				require.NoError(t, s.Err())
+			}
+		})
+	}
+}
+
+func TestModerationGuard_CreateWithStream(t *testing.T) {
+	guard := NewModerationGuard(&fakeChatService{chunks: []string{"kill ", "them"}}, &fakeModerationService{})
+
+	req := moderationTestRequest("hi")
+	req.Stream = true
+
+	res, err := guard.Create(context.TODO(), req)
+	require.NoError(t, err)
+
+	_, err = AccumulateChat(res)
+	require.True(t, errors.Is(err, ErrModerationBlocked))
+}
+
+func TestModerationGuard_StreamClose(t *testing.T) {
+	chat := &idleChatService{closed: make(chan struct{})}
+	guard := NewModerationGuard(chat, &fakeModerationService{})
+
+	s, err := guard.CreateStream(context.TODO(), moderationTestRequest("hi"))
+	require.NoError(t, err)
+
+	require.NoError(t, s.Close())
+
+	select {
+	case <-chat.closed:
+	case <-time.After(time.Second):
+		t.Fatal("upstream stream is not closed")
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- s.Next()
+	}()
+
+	select {
+	case next := <-done:
+		require.False(t, next)
+		require.NoError(t, s.Err())
+	case <-time.After(time.Second):
+		t.Fatal("stream is not finished after close")
+	}
+}
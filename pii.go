@@ -0,0 +1,613 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	PIIEmail      = "EMAIL"
+	PIIPhone      = "PHONE"
+	PIICreditCard = "CREDIT_CARD"
+	PIINationalID = "NATIONAL_ID"
+)
+
+// PIIDetector 识别一类敏感信息，Validate 不为 nil 时只有校验通过的匹配才会被脱敏，用于过滤误报
+type PIIDetector struct {
+	Kind     string
+	Pattern  *regexp.Regexp
+	Validate func(match string) bool
+}
+
+var (
+	emailDetector = &PIIDetector{
+		Kind:    PIIEmail,
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`),
+	}
+	// nationalIDDetectors 中国居民身份证号码和美国社会安全号码
+	nationalIDDetectors = []*PIIDetector{
+		{
+			Kind:     PIINationalID,
+			Pattern:  regexp.MustCompile(`\b\d{17}[\dXx]\b`),
+			Validate: validChineseID,
+		},
+		{
+			Kind:    PIINationalID,
+			Pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`),
+		},
+	}
+	creditCardDetector = &PIIDetector{
+		Kind:     PIICreditCard,
+		Pattern:  regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`),
+		Validate: validLuhn,
+	}
+	// phoneDetector 依次匹配不带分隔符的国际号码、分组的号码和中国大陆的手机号码
+	phoneDetector = &PIIDetector{
+		Kind:     PIIPhone,
+		Pattern:  regexp.MustCompile(`\+\d{8,15}\b|(?:\+\d{1,3}[ .\-])?(?:\(\d{1,4}\)[ .\-]?)?\d{2,4}(?:[ .\-]\d{2,4}){1,4}\b|\b1[3-9]\d{9}\b`),
+		Validate: validPhone,
+	}
+)
+
+// DefaultPIIDetectors 默认的识别规则，依次为邮箱、身份证号码、信用卡号和电话号码，
+// 顺序很重要，前面的规则替换后的内容不会再被后面的规则匹配
+func DefaultPIIDetectors() []*PIIDetector {
+	detectors := []*PIIDetector{emailDetector}
+	detectors = append(detectors, nationalIDDetectors...)
+	return append(detectors, creditCardDetector, phoneDetector)
+}
+
+type RedactorOption func(*Redactor)
+
+// WithPIIDetectors 替换默认的识别规则
+func WithPIIDetectors(detectors ...*PIIDetector) RedactorOption {
+	return func(r *Redactor) {
+		r.detectors = detectors
+	}
+}
+
+// WithPIIPattern 追加一个自定义的识别规则，kind 会作为占位符的前缀，例如 EMPLOYEE 对应 [EMPLOYEE_1]
+func WithPIIPattern(kind string, pattern *regexp.Regexp) RedactorOption {
+	return func(r *Redactor) {
+		r.detectors = append(r.detectors, &PIIDetector{Kind: kind, Pattern: pattern})
+	}
+}
+
+// Redactor 识别文本中的敏感信息，本身没有状态，可以并发使用，每个请求通过 NewVault 创建独立的 PIIVault
+type Redactor struct {
+	detectors []*PIIDetector
+}
+
+func NewRedactor(opts ...RedactorOption) *Redactor {
+	r := &Redactor{
+		detectors: DefaultPIIDetectors(),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// NewVault 创建一个新的 PIIVault，同一个请求的所有内容应该使用同一个 PIIVault，这样才能在响应中还原
+func (r *Redactor) NewVault() *PIIVault {
+	return &PIIVault{
+		redactor:     r,
+		originals:    make(map[string]string),
+		placeholders: make(map[string]string),
+		counters:     make(map[string]int),
+	}
+}
+
+// PIIEntity 被脱敏的一个敏感信息
+type PIIEntity struct {
+	Kind        string
+	Value       string
+	Placeholder string
+}
+
+// PIIVault 保存占位符与原始内容的对应关系，同一个内容总是被替换为同一个占位符，可以并发使用
+type PIIVault struct {
+	redactor *Redactor
+
+	mu           sync.RWMutex
+	originals    map[string]string // 占位符 -> 原始内容
+	placeholders map[string]string // kind + 原始内容 -> 占位符
+	counters     map[string]int
+	entities     []*PIIEntity
+	replacer     *strings.Replacer
+}
+
+// Redact 将 text 中的敏感信息替换为 [KIND_n] 格式的占位符
+func (v *PIIVault) Redact(text string) string {
+	if text == "" {
+		return text
+	}
+
+	for _, d := range v.redactor.detectors {
+		text = d.Pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if d.Validate != nil && !d.Validate(match) {
+				return match
+			}
+			return v.placeholder(d.Kind, match)
+		})
+	}
+
+	return text
+}
+
+func (v *PIIVault) placeholder(kind, value string) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key := kind + "\x00" + value
+	if p, ok := v.placeholders[key]; ok {
+		return p
+	}
+
+	v.counters[kind]++
+	p := fmt.Sprintf("[%s_%d]", kind, v.counters[kind])
+
+	v.placeholders[key] = p
+	v.originals[p] = value
+	v.entities = append(v.entities, &PIIEntity{Kind: kind, Value: value, Placeholder: p})
+	v.replacer = nil
+
+	return p
+}
+
+// Restore 将 text 中的占位符还原为原始内容，不认识的占位符保持不变
+func (v *PIIVault) Restore(text string) string {
+	if text == "" {
+		return text
+	}
+	return v.getReplacer().Replace(text)
+}
+
+func (v *PIIVault) getReplacer() *strings.Replacer {
+	v.mu.RLock()
+	r := v.replacer
+	v.mu.RUnlock()
+
+	if r != nil {
+		return r
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	oldnew := make([]string, 0, len(v.originals)*2)
+	for p, value := range v.originals {
+		oldnew = append(oldnew, p, value)
+	}
+	v.replacer = strings.NewReplacer(oldnew...)
+
+	return v.replacer
+}
+
+// Entities 返回目前为止被脱敏的所有内容，按照出现的顺序排列
+func (v *PIIVault) Entities() []*PIIEntity {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return append([]*PIIEntity(nil), v.entities...)
+}
+
+// isPlaceholderPrefix 判断 s 是否可能是某个占位符的前半部分，用于 stream 模式下占位符被拆分到多个 chunk 的情况
+func (v *PIIVault) isPlaceholderPrefix(s string) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	for p := range v.originals {
+		if len(s) < len(p) && strings.HasPrefix(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactChatRequest 返回脱敏后的请求副本，消息的文本内容和 function call 的参数都会被脱敏，不会修改 req
+func (v *PIIVault) RedactChatRequest(req *ChatCreateRequest) *ChatCreateRequest {
+	r := *req
+	r.Messages = make([]*Message, 0, len(req.Messages))
+
+	for _, m := range req.Messages {
+		copied := *m
+		copied.Content = v.Redact(m.Content)
+
+		if len(m.MultiContent) > 0 {
+			copied.MultiContent = make([]*ContentPart, 0, len(m.MultiContent))
+			for _, part := range m.MultiContent {
+				if part.Type == ContentPartTypeText {
+					part = NewTextPart(v.Redact(part.Text))
+				}
+				copied.MultiContent = append(copied.MultiContent, part)
+			}
+		}
+
+		if m.HasFunctionCall() {
+			copied.FunctionCall = FunctionCall{Name: m.FunctionCall.Name, Arguments: v.Redact(m.FunctionCall.Arguments)}
+		}
+
+		if len(m.ToolCalls) > 0 {
+			copied.ToolCalls = make([]*ToolCall, 0, len(m.ToolCalls))
+			for _, tc := range m.ToolCalls {
+				c := *tc
+				c.Function.Arguments = v.Redact(tc.Function.Arguments)
+				copied.ToolCalls = append(copied.ToolCalls, &c)
+			}
+		}
+
+		r.Messages = append(r.Messages, &copied)
+	}
+
+	return &r
+}
+
+// RedactCompletionRequest 返回脱敏后的请求副本，Prompt 和 Suffix 会被脱敏
+func (v *PIIVault) RedactCompletionRequest(req *CompletionCreateRequest) *CompletionCreateRequest {
+	r := *req
+	r.Prompt = v.Redact(req.Prompt)
+	r.Suffix = v.Redact(req.Suffix)
+	return &r
+}
+
+// RedactEmbeddingRequest 返回脱敏后的请求副本，Input 中的每一项都会被脱敏
+func (v *PIIVault) RedactEmbeddingRequest(req *EmbeddingCreateRequest) *EmbeddingCreateRequest {
+	r := *req
+	r.Input = make([]string, 0, len(req.Input))
+	for _, input := range req.Input {
+		r.Input = append(r.Input, v.Redact(input))
+	}
+	return &r
+}
+
+// RestoreChatResponse 还原响应中的占位符，会直接修改 resp，消息的内容和 function call 的参数都会被还原
+func (v *PIIVault) RestoreChatResponse(resp *ChatCreateResponse) {
+	for _, choice := range resp.Choices {
+		if choice.Message != nil {
+			v.restoreMessage(choice.Message)
+		}
+	}
+}
+
+func (v *PIIVault) restoreMessage(m *Message) {
+	m.Content = v.Restore(m.Content)
+	m.FunctionCall.Arguments = v.Restore(m.FunctionCall.Arguments)
+	for _, tc := range m.ToolCalls {
+		tc.Function.Arguments = v.Restore(tc.Function.Arguments)
+	}
+}
+
+// RestoreCompletionResponse 还原响应中的占位符，会直接修改 resp
+func (v *PIIVault) RestoreCompletionResponse(resp *CompletionCreateResponse) {
+	for _, choice := range resp.Choices {
+		choice.Text = v.Restore(choice.Text)
+	}
+}
+
+// chatStreamField stream 模式下需要还原的一个字段，tool 为 -1 表示 content，-2 表示 function call 的参数，其他为 tool call 的 index
+type chatStreamField struct {
+	choice int64
+	tool   int64
+}
+
+const (
+	chatStreamContent  = -1
+	chatStreamFunction = -2
+)
+
+// RestoreChatStream 返回一个还原了占位符的 Stream，被拆分到多个 chunk 的占位符会暂存到完整之后再还原，
+// 暂存的内容在 choice 结束时或者流结束时输出
+func (v *PIIVault) RestoreChatStream(s *Stream[*ChatCreateResponse]) *Stream[*ChatCreateResponse] {
+	restorers := make(map[chatStreamField]*piiStreamRestorer)
+	var fields []chatStreamField
+
+	restore := func(field chatStreamField, text string) string {
+		r, ok := restorers[field]
+		if !ok {
+			r = &piiStreamRestorer{vault: v}
+			restorers[field] = r
+			fields = append(fields, field)
+		}
+		return r.write(text)
+	}
+
+	return mapStream(s, func(chunk *ChatCreateResponse) *ChatCreateResponse {
+		for _, choice := range chunk.Choices {
+			if choice.Message != nil {
+				v.restoreMessage(choice.Message)
+			}
+
+			d := choice.Delta
+			if d == nil {
+				continue
+			}
+
+			d.Content = restore(chatStreamField{choice.Index, chatStreamContent}, d.Content)
+			if d.FunctionCall != nil {
+				d.FunctionCall.Arguments = restore(chatStreamField{choice.Index, chatStreamFunction}, d.FunctionCall.Arguments)
+			}
+			for i, tc := range d.ToolCalls {
+				index := int64(i)
+				if tc.Index != nil {
+					index = *tc.Index
+				}
+				tc.Function.Arguments = restore(chatStreamField{choice.Index, index}, tc.Function.Arguments)
+			}
+
+			// choice 结束时输出暂存的内容
+			if r, ok := restorers[chatStreamField{choice.Index, chatStreamContent}]; ok && choice.FinishReason != "" {
+				d.Content += r.flush()
+			}
+		}
+		return chunk
+	}, func() []*ChatCreateResponse {
+		// 流结束时仍然暂存的内容，说明并不是占位符，原样输出
+		var chunks []*ChatCreateResponse
+		for _, field := range fields {
+			rest := restorers[field].flush()
+			if rest == "" {
+				continue
+			}
+
+			delta := &Delta{}
+			switch field.tool {
+			case chatStreamContent:
+				delta.Content = rest
+			case chatStreamFunction:
+				delta.FunctionCall = &FunctionCall{Arguments: rest}
+			default:
+				index := field.tool
+				delta.ToolCalls = []*ToolCall{{Index: &index, Function: FunctionCall{Arguments: rest}}}
+			}
+
+			chunks = append(chunks, &ChatCreateResponse{
+				Choices: []*ChatCompletion{{Index: field.choice, Delta: delta}},
+			})
+		}
+		return chunks
+	})
+}
+
+// RestoreCompletionStream 与 RestoreChatStream 相同，用于 Completion 的 Stream
+func (v *PIIVault) RestoreCompletionStream(s *Stream[*CompletionCreateResponse]) *Stream[*CompletionCreateResponse] {
+	restorers := make(map[int64]*piiStreamRestorer)
+	var indexes []int64
+
+	return mapStream(s, func(chunk *CompletionCreateResponse) *CompletionCreateResponse {
+		for _, choice := range chunk.Choices {
+			r, ok := restorers[choice.Index]
+			if !ok {
+				r = &piiStreamRestorer{vault: v}
+				restorers[choice.Index] = r
+				indexes = append(indexes, choice.Index)
+			}
+
+			choice.Text = r.write(choice.Text)
+			if choice.FinishReason != "" {
+				choice.Text += r.flush()
+			}
+		}
+		return chunk
+	}, func() []*CompletionCreateResponse {
+		var chunks []*CompletionCreateResponse
+		for _, index := range indexes {
+			if rest := restorers[index].flush(); rest != "" {
+				chunks = append(chunks, &CompletionCreateResponse{
+					Choices: []*Completion{{Index: index, Text: rest}},
+				})
+			}
+		}
+		return chunks
+	})
+}
+
+// piiStreamRestorer 还原 stream 模式下一个字段的占位符，可能是占位符前半部分的内容会被暂存
+type piiStreamRestorer struct {
+	vault   *PIIVault
+	pending string
+}
+
+func (r *piiStreamRestorer) write(s string) string {
+	s = r.pending + s
+	r.pending = ""
+
+	if i := strings.LastIndexByte(s, '['); i >= 0 && r.vault.isPlaceholderPrefix(s[i:]) {
+		r.pending = s[i:]
+		s = s[:i]
+	}
+
+	return r.vault.Restore(s)
+}
+
+func (r *piiStreamRestorer) flush() string {
+	s := r.pending
+	r.pending = ""
+	return s
+}
+
+// PIIRedactingChatService 在请求发送之前脱敏，在响应中还原的 ChatService，每个请求使用独立的 PIIVault，用法如下：
+//
+//	client.Chat = openai.NewPIIRedactingChatService(client.Chat, openai.NewRedactor())
+type PIIRedactingChatService struct {
+	chat     ChatService
+	redactor *Redactor
+}
+
+func NewPIIRedactingChatService(chat ChatService, redactor *Redactor) *PIIRedactingChatService {
+	return &PIIRedactingChatService{
+		chat:     chat,
+		redactor: redactor,
+	}
+}
+
+func (p *PIIRedactingChatService) Create(ctx context.Context, req *ChatCreateRequest) (chan *ChatCreateResponse, error) {
+	vault := p.redactor.NewVault()
+
+	if req.Stream {
+		s, err := p.chat.CreateStream(ctx, vault.RedactChatRequest(req))
+		if err != nil {
+			return nil, err
+		}
+		return chatStreamToChannel(ctx, vault.RestoreChatStream(s), logr.Discard()), nil
+	}
+
+	res, err := p.chat.Create(ctx, vault.RedactChatRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *ChatCreateResponse, 1)
+	go func() {
+		defer close(out)
+		for resp := range res {
+			vault.RestoreChatResponse(resp)
+			out <- resp
+		}
+	}()
+	return out, nil
+}
+
+func (p *PIIRedactingChatService) CreateStream(ctx context.Context, req *ChatCreateRequest) (*Stream[*ChatCreateResponse], error) {
+	vault := p.redactor.NewVault()
+
+	s, err := p.chat.CreateStream(ctx, vault.RedactChatRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return vault.RestoreChatStream(s), nil
+}
+
+// validLuhn 使用 Luhn 算法校验信用卡号
+func validLuhn(s string) bool {
+	var digits []int
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			digits = append(digits, int(c-'0'))
+		}
+	}
+
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := digits[i]
+		if (len(digits)-1-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// validChineseID 校验 18 位居民身份证号码的校验码
+func validChineseID(s string) bool {
+	weights := []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	const checks = "10X98765432"
+
+	sum := 0
+	for i, w := range weights {
+		sum += int(s[i]-'0') * w
+	}
+	return strings.ToUpper(s[17:]) == string(checks[sum%11])
+}
+
+// validPhone 校验电话号码：可选的国际区号和括号中的区号之后，各组数字必须使用同一种分隔符，
+// 不带国际区号时至少包含 10 位数字，带国际区号时至少 8 位。
+// 看起来像日期、时间（分隔符不一致）或者 IP 地址的数字不会被识别为电话号码
+func validPhone(s string) bool {
+	n := 0
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			n++
+		}
+	}
+
+	body := s
+	international := strings.HasPrefix(body, "+")
+	if international {
+		if n < 8 || n > 15 {
+			return false
+		}
+
+		body = body[1:]
+		if i := strings.IndexAny(body, " .-"); i > 0 && i <= 3 {
+			body = body[i+1:]
+		}
+	} else if n < 10 || n > 15 {
+		return false
+	}
+
+	area := strings.HasPrefix(body, "(")
+	if area {
+		i := strings.IndexByte(body, ')')
+		body = strings.TrimLeft(body[i+1:], " .-")
+	}
+
+	// 分隔符必须一致，例如 "2024-01-15 10" 不是电话号码
+	var sep rune
+	for _, c := range body {
+		if c == ' ' || c == '.' || c == '-' {
+			if sep != 0 && c != sep {
+				return false
+			}
+			sep = c
+		}
+	}
+	groups := strings.FieldsFunc(body, func(c rune) bool { return c == sep })
+
+	if len(groups) == 1 || international || area {
+		return true
+	}
+
+	// IP 地址，例如 "192.168.100.200"
+	if sep == '.' && len(groups) == 4 {
+		ip := true
+		for _, g := range groups {
+			if len(g) > 3 {
+				ip = false
+			}
+		}
+		if ip {
+			return false
+		}
+	}
+
+	// 以年月日开头的日期或者版本号，例如 "2023.10.05.1234"
+	if len(groups) >= 3 && len(groups[0]) == 4 && (strings.HasPrefix(groups[0], "19") || strings.HasPrefix(groups[0], "20")) {
+		month, _ := strconv.Atoi(groups[1])
+		day, _ := strconv.Atoi(groups[2])
+		if len(groups[1]) <= 2 && month >= 1 && month <= 12 && len(groups[2]) <= 2 && day >= 1 && day <= 31 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,288 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"testing"
+)
+
+func TestPIIVault_Redact(t *testing.T) {
+	testCase := []struct {
+		name     string
+		redactor *Redactor
+		text     string
+		want     string
+	}{
+		{
+			name: "test redact email",
+			text: "mail me at ken.lin+ai@example.com.cn please",
+			want: "mail me at [EMAIL_1] please",
+		},
+		{
+			name: "test redact phone",
+			text: "call 13812345678 or +1 (555) 123-4567",
+			want: "call [PHONE_1] or [PHONE_2]",
+		},
+		{
+			name: "test redact credit card",
+			text: "card 4111 1111 1111 1111, not 4111 1111 1111 1112",
+			want: "card [CREDIT_CARD_1], not 4111 1111 1111 1112",
+		},
+		{
+			name: "test redact national id",
+			text: "id 11010519491231002X and ssn 123-45-6789",
+			want: "id [NATIONAL_ID_1] and ssn [NATIONAL_ID_2]",
+		},
+		{
+			name: "test keep dates and short numbers",
+			text: "on 2023-10-18 order 12345 costs 99.50",
+			want: "on 2023-10-18 order 12345 costs 99.50",
+		},
+		{
+			name: "test redact phone layouts",
+			text: "tel +8613800138000, 555.123.4567, 020-8888-6666 and +44 20 7946 0958",
+			want: "tel [PHONE_1], [PHONE_2], [PHONE_3] and [PHONE_4]",
+		},
+		{
+			name: "test keep date time",
+			text: "meeting at 2024-01-15 10:30",
+			want: "meeting at 2024-01-15 10:30",
+		},
+		{
+			name: "test keep ip address",
+			text: "server 192.168.100.200 is down",
+			want: "server 192.168.100.200 is down",
+		},
+		{
+			name: "test keep version",
+			text: "release 2023.10.05.1234",
+			want: "release 2023.10.05.1234",
+		},
+		{
+			name: "test keep timestamp",
+			text: "created at 1700000000123",
+			want: "created at 1700000000123",
+		},
+		{
+			name: "test redact same value",
+			text: "a@b.io, c@d.io, a@b.io",
+			want: "[EMAIL_1], [EMAIL_2], [EMAIL_1]",
+		},
+		{
+			name:     "test redact custom pattern",
+			redactor: NewRedactor(WithPIIPattern("EMPLOYEE", regexp.MustCompile(`EMP-\d{5}`))),
+			text:     "EMP-00042 emailed a@b.io",
+			want:     "[EMPLOYEE_1] emailed [EMAIL_1]",
+		},
+		{
+			name:     "test redact only custom detectors",
+			redactor: NewRedactor(WithPIIDetectors(&PIIDetector{Kind: "NAME", Pattern: regexp.MustCompile(`Ken`)})),
+			text:     "Ken a@b.io",
+			want:     "[NAME_1] a@b.io",
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			r := tc.redactor
+			if r == nil {
+				r = NewRedactor()
+			}
+
+			v := r.NewVault()
+			got := v.Redact(tc.text)
+			require.Equal(t, tc.want, got)
+			require.Equal(t, tc.text, v.Restore(got))
+		})
+	}
+}
+
+func TestPIIVault_Requests(t *testing.T) {
+	v := NewRedactor().NewVault()
+
+	chatReq := &ChatCreateRequest{
+		Model: "gpt-4o",
+		Messages: []*Message{
+			{Role: RoleUser, Content: "my email is a@b.io"},
+			{Role: RoleUser, MultiContent: []*ContentPart{NewTextPart("call 13812345678"), NewImageURLPart("https://example.com/a.png", "")}},
+			{Role: RoleAssistant, ToolCalls: []*ToolCall{{Id: "call_1", Type: ToolTypeFunction, Function: FunctionCall{Name: "send", Arguments: `{"to":"a@b.io"}`}}}},
+		},
+	}
+
+	got := v.RedactChatRequest(chatReq)
+	require.Equal(t, "my email is [EMAIL_1]", got.Messages[0].Content)
+	require.Equal(t, "call [PHONE_1]", got.Messages[1].MultiContent[0].Text)
+	require.Equal(t, chatReq.Messages[1].MultiContent[1], got.Messages[1].MultiContent[1])
+	require.Equal(t, `{"to":"[EMAIL_1]"}`, got.Messages[2].ToolCalls[0].Function.Arguments)
+	// 不会修改原请求
+	require.Equal(t, "my email is a@b.io", chatReq.Messages[0].Content)
+	require.Equal(t, `{"to":"a@b.io"}`, chatReq.Messages[2].ToolCalls[0].Function.Arguments)
+
+	completionReq := v.RedactCompletionRequest(&CompletionCreateRequest{Prompt: "write to a@b.io", Suffix: "or c@d.io"})
+	require.Equal(t, "write to [EMAIL_1]", completionReq.Prompt)
+	require.Equal(t, "or [EMAIL_2]", completionReq.Suffix)
+
+	embeddingReq := v.RedactEmbeddingRequest(&EmbeddingCreateRequest{Input: []string{"a@b.io", "hello"}})
+	require.Equal(t, []string{"[EMAIL_1]", "hello"}, embeddingReq.Input)
+
+	require.Equal(t, []*PIIEntity{
+		{Kind: PIIEmail, Value: "a@b.io", Placeholder: "[EMAIL_1]"},
+		{Kind: PIIPhone, Value: "13812345678", Placeholder: "[PHONE_1]"},
+		{Kind: PIIEmail, Value: "c@d.io", Placeholder: "[EMAIL_2]"},
+	}, v.Entities())
+
+	chatResp := &ChatCreateResponse{Choices: []*ChatCompletion{{Message: &Message{
+		Content:   "sent to [EMAIL_1], unknown [EMAIL_9]",
+		ToolCalls: []*ToolCall{{Function: FunctionCall{Arguments: `{"phone":"[PHONE_1]"}`}}},
+	}}}}
+	v.RestoreChatResponse(chatResp)
+	require.Equal(t, "sent to a@b.io, unknown [EMAIL_9]", chatResp.Choices[0].Message.Content)
+	require.Equal(t, `{"phone":"13812345678"}`, chatResp.Choices[0].Message.ToolCalls[0].Function.Arguments)
+
+	completionResp := &CompletionCreateResponse{Choices: []*Completion{{Text: "[EMAIL_2]"}}}
+	v.RestoreCompletionResponse(completionResp)
+	require.Equal(t, "c@d.io", completionResp.Choices[0].Text)
+}
+
+func TestPIIVault_RestoreChatStream(t *testing.T) {
+	testCase := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{
+			name:   "test restore split placeholder",
+			chunks: []string{"mail [EM", "AIL", "_1] or [", "EMAIL_2] now"},
+			want:   "mail a@b.io or c@d.io now",
+		},
+		{
+			name:   "test restore unknown placeholder",
+			chunks: []string{"see [EMAIL_", "3] and [link]"},
+			want:   "see [EMAIL_3] and [link]",
+		},
+		{
+			name:   "test restore pending at end",
+			chunks: []string{"mail [EMAIL_"},
+			want:   "mail [EMAIL_",
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			v := NewRedactor().NewVault()
+			v.Redact("a@b.io c@d.io")
+
+			chat := &fakeChatService{chunks: tc.chunks}
+			s, err := chat.CreateStream(context.TODO(), &ChatCreateRequest{})
+			require.NoError(t, err)
+
+			var chunks []string
+			restored := v.RestoreChatStream(s)
+			for restored.Next() {
+				chunks = append(chunks, restored.Current().Choices[0].Delta.Content)
+			}
+			require.NoError(t, restored.Err())
+
+			// 每个输出的 chunk 都不包含被拆分的占位符
+			for _, chunk := range chunks {
+				require.NotContains(t, chunk, "[EMAIL_1")
+			}
+
+			got := ""
+			for _, chunk := range chunks {
+				got += chunk
+			}
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestPIIVault_RestoreCompletionStream(t *testing.T) {
+	v := NewRedactor().NewVault()
+	v.Redact("a@b.io")
+
+	es := make(EventSource, 3)
+	es <- Event{Data: `{"choices":[{"index":0,"text":"to [EMA"}]}`}
+	es <- Event{Data: `{"choices":[{"index":0,"text":"IL_1]","finish_reason":"stop"}]}`}
+	close(es)
+
+	s := v.RestoreCompletionStream(NewStream[*CompletionCreateResponse](context.TODO(), es, nil))
+	acc := NewCompletionAccumulator()
+	for s.Next() {
+		require.NoError(t, acc.Add(s.Current()))
+	}
+	require.NoError(t, s.Err())
+	require.Equal(t, "to a@b.io", acc.Result().Choices[0].Text)
+}
+
+type echoChatService struct {
+	fakeChatService
+}
+
+func (e *echoChatService) Create(ctx context.Context, req *ChatCreateRequest) (chan *ChatCreateResponse, error) {
+	e.content = "echo: " + req.Messages[0].Content
+	return e.fakeChatService.Create(ctx, req)
+}
+
+func (e *echoChatService) CreateStream(ctx context.Context, req *ChatCreateRequest) (*Stream[*ChatCreateResponse], error) {
+	e.chunks = []string{"echo: ", req.Messages[0].Content[:3], req.Messages[0].Content[3:]}
+	return e.fakeChatService.CreateStream(ctx, req)
+}
+
+func TestPIIRedactingChatService(t *testing.T) {
+	testCase := []struct {
+		name   string
+		stream bool
+	}{
+		{
+			name: "test redacting chat create",
+		},
+		{
+			name:   "test redacting chat create with stream",
+			stream: true,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			chat := &echoChatService{}
+			svc := NewPIIRedactingChatService(chat, NewRedactor())
+
+			res, err := svc.Create(context.TODO(), &ChatCreateRequest{
+				Messages: []*Message{{Role: RoleUser, Content: "a@b.io"}},
+				Stream:   tc.stream,
+			})
+			require.NoError(t, err)
+
+			resp, err := AccumulateChat(res)
+			require.NoError(t, err)
+			require.Equal(t, "[EMAIL_1]", chat.requests[0].Messages[0].Content)
+			require.Equal(t, "echo: a@b.io", resp.Choices[0].Message.Content)
+		})
+	}
+
+	chat := &echoChatService{}
+	s, err := NewPIIRedactingChatService(chat, NewRedactor()).CreateStream(context.TODO(), &ChatCreateRequest{
+		Messages: []*Message{{Role: RoleUser, Content: "13812345678"}},
+	})
+	require.NoError(t, err)
+
+	resp, err := AccumulateChatStream(s)
+	require.NoError(t, err)
+	require.Equal(t, "echo: 13812345678", resp.Choices[0].Message.Content)
+}
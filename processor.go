@@ -0,0 +1,578 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProcessorConcurrency = 4
+	defaultProcessorRetries     = 3
+	defaultProcessorBackoff     = time.Second
+	maxProcessorBackoff         = time.Minute
+
+	// checkpointSuffix 默认的 checkpoint 文件为输出文件加上该后缀
+	checkpointSuffix = ".checkpoint"
+
+	ProcessorErrorInvalidRequest = "invalid_request"
+	ProcessorErrorRequestFailed  = "request_failed"
+)
+
+// ErrUnsupportedEndpoint Processor 不支持请求中的 url
+var ErrUnsupportedEndpoint = errors.New("openai: unsupported endpoint")
+
+type ProcessorOption func(*Processor)
+
+// WithProcessorConcurrency 设置同时执行的请求数量，默认为 4
+func WithProcessorConcurrency(n int) ProcessorOption {
+	return func(p *Processor) {
+		if n > 0 {
+			p.concurrency = n
+		}
+	}
+}
+
+// WithProcessorRateLimit 设置每分钟的请求数量和 token 数量上限，为 0 表示不限制，
+// token 数量按照请求的 prompt token 数量加上最大输出 token 数量估算，与服务端的计算方式一致
+func WithProcessorRateLimit(rpm, tpm int) ProcessorOption {
+	return func(p *Processor) {
+		p.requests = newRateLimiter(rpm)
+		p.tokens = newRateLimiter(tpm)
+	}
+}
+
+// WithProcessorRetries 设置单个请求失败后的重试次数，默认为 3，
+// 4xx 错误（408、409 和 429 除外）以及请求格式错误不会重试
+func WithProcessorRetries(n int) ProcessorOption {
+	return func(p *Processor) {
+		if n >= 0 {
+			p.retries = n
+		}
+	}
+}
+
+// WithProcessorBackoff 设置第一次重试的等待时间，之后每次翻倍，最多 1 分钟，默认为 1s
+func WithProcessorBackoff(d time.Duration) ProcessorOption {
+	return func(p *Processor) {
+		if d > 0 {
+			p.backoff = d
+		}
+	}
+}
+
+// WithProcessorCheckpoint 设置 checkpoint 文件的路径，默认为输出文件加上 .checkpoint 后缀
+func WithProcessorCheckpoint(path string) ProcessorOption {
+	return func(p *Processor) {
+		p.checkpoint = path
+	}
+}
+
+// WithProcessorOnResult 每个请求结束（成功或者重试之后仍然失败）时回调，可能在多个协程中同时调用
+func WithProcessorOnResult(fn func(result *ProcessorResult)) ProcessorOption {
+	return func(p *Processor) {
+		p.onResult = fn
+	}
+}
+
+// ProcessorResult 单个请求的执行结果
+type ProcessorResult struct {
+	Line     int
+	CustomId string
+	Attempts int
+	Err      error
+}
+
+// ProcessorStats Run 的统计信息，Skipped 为之前已经完成、本次跳过的请求数量
+type ProcessorStats struct {
+	Total     int64
+	Skipped   int64
+	Succeeded int64
+	Failed    int64
+}
+
+// Processor 在本地并发执行 JSONL 文件中的请求，适用于不能等待 Batch API 的任务，用法如下：
+//
+//	p := openai.NewProcessor(client, openai.WithProcessorRateLimit(500, 200000))
+//	stats, err := p.Run(ctx, "requests.jsonl", "results.jsonl")
+//
+// 输入文件与 Batch API 的输入文件格式相同，可以通过 BatchInput 生成，缺少 custom_id 时使用 line-行号；
+// 输出文件与 Batch API 的结果文件格式相同，可以通过 DecodeBatchResults 解析，结果按照完成的顺序写入。
+// 每个结果写入后会记录到 checkpoint 文件，再次 Run 时会跳过已经完成的行，因此进程崩溃后可以直接重新 Run，
+// 跳过时会校验 custom_id 与 checkpoint 中记录的是否一致，输入文件被修改导致不一致时会重新执行该行，
+// 极端情况下（结果已经写入但是 checkpoint 没有写入）一个请求可能被执行两次
+type Processor struct {
+	client      *Client
+	concurrency int
+	retries     int
+	backoff     time.Duration
+	requests    *rateLimiter
+	tokens      *rateLimiter
+	checkpoint  string
+	onResult    func(result *ProcessorResult)
+}
+
+func NewProcessor(client *Client, opts ...ProcessorOption) *Processor {
+	p := &Processor{
+		client:      client,
+		concurrency: defaultProcessorConcurrency,
+		retries:     defaultProcessorRetries,
+		backoff:     defaultProcessorBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+type processorJob struct {
+	line     int
+	customId string
+	request  *BatchRequestLine[json.RawMessage]
+	err      error // 解析失败的原因
+}
+
+type checkpointLine struct {
+	Line     int    `json:"line"`
+	CustomId string `json:"custom_id"`
+}
+
+// Run 执行 inputPath 中的请求，结果追加到 outputPath，ctx 被取消时正在执行的请求会被放弃，下次 Run 时重新执行
+func (p *Processor) Run(ctx context.Context, inputPath, outputPath string) (*ProcessorStats, error) {
+	checkpointPath := p.checkpoint
+	if checkpointPath == "" {
+		checkpointPath = outputPath + checkpointSuffix
+	}
+
+	done, err := loadCheckpoint(checkpointPath)
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	output, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer output.Close()
+
+	checkpoint, err := os.OpenFile(checkpointPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer checkpoint.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		stats    ProcessorStats
+		statsMu  sync.Mutex
+		writeMu  sync.Mutex
+		writeErr error
+	)
+
+	// write 写入结果和 checkpoint，写入失败时停止所有请求
+	write := func(job *processorJob, out *batchOutputLine) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		if writeErr != nil {
+			return
+		}
+
+		err := appendJSONLine(output, out)
+		if err == nil {
+			err = appendJSONLine(checkpoint, &checkpointLine{Line: job.line, CustomId: job.customId})
+		}
+
+		if err != nil {
+			writeErr = err
+			cancel()
+		}
+	}
+
+	jobs := make(chan *processorJob)
+	var wg sync.WaitGroup
+
+	for i := 0; i < p.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for job := range jobs {
+				out, result := p.process(ctx, job)
+				// ctx 被取消导致的失败不记录，下次 Run 时重新执行
+				if ctx.Err() != nil {
+					continue
+				}
+
+				write(job, out)
+
+				statsMu.Lock()
+				if result.Err != nil {
+					stats.Failed++
+				} else {
+					stats.Succeeded++
+				}
+				statsMu.Unlock()
+
+				if p.onResult != nil {
+					p.onResult(result)
+				}
+			}
+		}()
+	}
+
+	readErr := p.read(ctx, input, done, jobs, &stats)
+	close(jobs)
+	wg.Wait()
+
+	if writeErr != nil {
+		return &stats, writeErr
+	}
+	if readErr != nil {
+		return &stats, readErr
+	}
+
+	return &stats, ctx.Err()
+}
+
+// read 逐行读取输入，跳过已经完成并且 custom_id 一致的行，读取完成或者 ctx 被取消时返回
+func (p *Processor) read(ctx context.Context, input io.Reader, done map[int]string, jobs chan<- *processorJob, stats *ProcessorStats) error {
+	reader := bufio.NewReader(input)
+
+	for n := 1; ; n++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if line := bytes.TrimSpace(data); len(line) > 0 {
+			stats.Total++
+
+			job := &processorJob{line: n, customId: "line-" + strconv.Itoa(n)}
+
+			var req BatchRequestLine[json.RawMessage]
+			if job.err = json.Unmarshal(line, &req); job.err == nil {
+				job.request = &req
+				if req.CustomId != "" {
+					job.customId = req.CustomId
+				}
+			}
+
+			if customId, ok := done[n]; ok && customId == job.customId {
+				stats.Skipped++
+			} else {
+				select {
+				case <-ctx.Done():
+					return nil
+				case jobs <- job:
+				}
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+}
+
+// process 执行一个请求，失败时按照退避策略重试
+func (p *Processor) process(ctx context.Context, job *processorJob) (*batchOutputLine, *ProcessorResult) {
+	out := &batchOutputLine{
+		Id:       fmt.Sprintf("local_req_%d", job.line),
+		CustomId: job.customId,
+	}
+	result := &ProcessorResult{
+		Line:     job.line,
+		CustomId: job.customId,
+	}
+
+	call, tokens, err := p.prepare(job)
+	if err != nil {
+		out.Error = &BatchResultError{Code: ProcessorErrorInvalidRequest, Message: err.Error()}
+		result.Err = err
+		return out, result
+	}
+
+	backoff := p.backoff
+	for {
+		result.Attempts++
+
+		var body any
+		err = p.requests.wait(ctx, 1)
+		if err == nil {
+			err = p.tokens.wait(ctx, tokens)
+		}
+		if err == nil {
+			body, err = call(ctx)
+		}
+
+		var data []byte
+		if err == nil {
+			data, err = json.Marshal(body)
+		}
+
+		if err == nil {
+			out.Response = &batchOutputResponse{StatusCode: http.StatusOK, Body: data}
+			return out, result
+		}
+
+		if ctx.Err() != nil || result.Attempts > p.retries || !retryable(err) {
+			break
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		if backoff *= 2; backoff > maxProcessorBackoff {
+			backoff = maxProcessorBackoff
+		}
+	}
+
+	code := ProcessorErrorRequestFailed
+	if status, ok := httpStatusCode(err); ok {
+		code = strconv.Itoa(status)
+	}
+	out.Error = &BatchResultError{Code: code, Message: err.Error()}
+	result.Err = err
+
+	return out, result
+}
+
+// prepare 根据 url 解析请求，返回执行请求的函数和估算的 token 数量
+func (p *Processor) prepare(job *processorJob) (func(ctx context.Context) (any, error), int, error) {
+	if job.err != nil {
+		return nil, 0, job.err
+	}
+
+	switch job.request.Url {
+	case BatchEndpointChatCompletions:
+		var req ChatCreateRequest
+		if err := json.Unmarshal(job.request.Body, &req); err != nil {
+			return nil, 0, err
+		}
+		// 只需要最终的结果，不使用 stream 模式
+		req.Stream = false
+		req.StreamOptions = nil
+
+		tokens, err := CountChatRequestTokens(&req)
+		if err != nil {
+			tokens = approximateMessageTokens(req.Messages, req.Functions)
+		}
+		if req.MaxCompletionTokens > 0 {
+			tokens += int(req.MaxCompletionTokens)
+		} else {
+			tokens += int(req.MaxTokens)
+		}
+
+		return func(ctx context.Context) (any, error) {
+			res, err := p.client.Chat.Create(ctx, &req)
+			if err != nil {
+				return nil, err
+			}
+			return AccumulateChat(res)
+		}, tokens, nil
+	case BatchEndpointEmbeddings:
+		var req EmbeddingCreateRequest
+		if err := json.Unmarshal(job.request.Body, &req); err != nil {
+			return nil, 0, err
+		}
+
+		tokens, err := CountEmbeddingTokens(&req)
+		if err != nil {
+			tokens = 0
+			for _, input := range req.Input {
+				tokens += (len(input) + 3) / 4
+			}
+		}
+
+		return func(ctx context.Context) (any, error) {
+			return p.client.Embeddings.Create(ctx, &req)
+		}, tokens, nil
+	case BatchEndpointCompletions:
+		var req CompletionCreateRequest
+		if err := json.Unmarshal(job.request.Body, &req); err != nil {
+			return nil, 0, err
+		}
+		req.Stream = false
+
+		tokens, err := CountTokens(req.Model, req.Prompt)
+		if err != nil {
+			tokens = (len(req.Prompt) + 3) / 4
+		}
+		tokens += int(req.MaxTokens)
+
+		return func(ctx context.Context) (any, error) {
+			res, err := p.client.Completions.Create(ctx, &req)
+			if err != nil {
+				return nil, err
+			}
+
+			acc := NewCompletionAccumulator()
+			for chunk := range res {
+				if err := acc.Add(chunk); err != nil {
+					return nil, err
+				}
+			}
+			return acc.Result(), nil
+		}, tokens, nil
+	}
+
+	return nil, 0, fmt.Errorf("%w: %s", ErrUnsupportedEndpoint, job.request.Url)
+}
+
+// httpStatusCode 从 Client 返回的错误中解析 HTTP 状态码，Client 以状态码作为错误信息
+func httpStatusCode(err error) (int, bool) {
+	code, convErr := strconv.Atoi(err.Error())
+	if convErr != nil || code < 100 || code > 599 {
+		return 0, false
+	}
+	return code, true
+}
+
+// retryable 判断请求失败后是否需要重试，客户端错误中只有超时、冲突和限流需要重试
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return true
+	}
+
+	status, ok := httpStatusCode(err)
+	if !ok {
+		return true
+	}
+
+	switch status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return true
+	}
+	return status >= 500
+}
+
+// loadCheckpoint 读取已经完成的行号及其 custom_id，同一行有多条记录时以最后一条为准，
+// 文件不存在时返回空集合，最后一行不完整时忽略（写入时进程崩溃）
+func loadCheckpoint(path string) (map[int]string, error) {
+	done := make(map[int]string)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var c checkpointLine
+		if len(bytes.TrimSpace(line)) > 0 && json.Unmarshal(line, &c) == nil {
+			done[c.Line] = c.CustomId
+		}
+	}
+
+	return done, nil
+}
+
+func appendJSONLine(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// rateLimiter 令牌桶，容量为每分钟的上限，为 nil 时不限制
+type rateLimiter struct {
+	mu       sync.Mutex
+	capacity float64
+	rate     float64 // 每秒补充的数量
+	tokens   float64
+	last     time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		capacity: float64(perMinute),
+		rate:     float64(perMinute) / 60,
+		tokens:   float64(perMinute),
+		last:     time.Now(),
+	}
+}
+
+// wait 等待直到可以消耗 n 个令牌，n 超过容量时按照容量计算，避免永远等待
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	need := float64(n)
+	if need > l.capacity {
+		need = l.capacity
+	}
+
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.capacity {
+			l.tokens = l.capacity
+		}
+		l.last = now
+
+		if l.tokens >= need {
+			l.tokens -= need
+			l.mu.Unlock()
+			return nil
+		}
+
+		delay := time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
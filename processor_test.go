@@ -0,0 +1,245 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newMockProcessorServer 以消息内容或者 embedding 的输入作为请求的标识，flaky 前两次返回 500，bad 返回 400
+func newMockProcessorServer(t *testing.T, calls map[string]int, mu *sync.Mutex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var key string
+		switch r.URL.Path {
+		case "/v1/chat/completions":
+			var req ChatCreateRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			require.False(t, req.Stream)
+			key = req.Messages[0].Content
+		case "/v1/embeddings":
+			var req EmbeddingCreateRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			key = req.Input[0]
+		default:
+			t.Fatalf("unexpected path %s", r.URL.Path)
+		}
+
+		mu.Lock()
+		calls[key]++
+		n := calls[key]
+		mu.Unlock()
+
+		switch {
+		case key == "flaky" && n <= 2:
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		case key == "bad":
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if r.URL.Path == "/v1/embeddings" {
+			_ = json.NewEncoder(w).Encode(&EmbeddingCreateResponse{Object: "list", Data: []*Embedding{{Index: 0}}})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(mockStopResponse("echo " + key))
+	}
+}
+
+func writeProcessorInput(t *testing.T, dir string, lines ...string) string {
+	path := filepath.Join(dir, "requests.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644))
+	return path
+}
+
+func chatRequestLine(customId string) string {
+	b, _ := json.Marshal(&BatchRequestLine[*ChatCreateRequest]{
+		CustomId: customId,
+		Method:   http.MethodPost,
+		Url:      BatchEndpointChatCompletions,
+		Body: &ChatCreateRequest{
+			Model:    "gpt-4o-mini",
+			Messages: []*Message{{Role: RoleUser, Content: customId}},
+			Stream:   true,
+		},
+	})
+	return string(b)
+}
+
+func TestProcessor_Run(t *testing.T) {
+	calls := make(map[string]int)
+	var mu sync.Mutex
+	server := newMockServer(newMockProcessorServer(t, calls, &mu))
+	defer server.Close()
+
+	dir := t.TempDir()
+	input := writeProcessorInput(t, dir,
+		chatRequestLine("ok"),
+		chatRequestLine("flaky"),
+		"",
+		chatRequestLine("bad"),
+		`{"method": "POST", "url": "/v1/embeddings", "body": {"model": "text-embedding-3-small", "input": ["embed"]}}`,
+		`{"custom_id": "edit", "method": "POST", "url": "/v1/edits", "body": {}}`,
+		`{"custom_id": "broken"`,
+	)
+	output := filepath.Join(dir, "results.jsonl")
+
+	var resultsMu sync.Mutex
+	results := make(map[string]*ProcessorResult)
+
+	p := NewProcessor(newMockClient(server.URL, WithRetries(0)),
+		WithProcessorConcurrency(3),
+		WithProcessorBackoff(time.Millisecond),
+		WithProcessorRateLimit(6000, 1000000),
+		WithProcessorOnResult(func(result *ProcessorResult) {
+			resultsMu.Lock()
+			results[result.CustomId] = result
+			resultsMu.Unlock()
+		}),
+	)
+
+	stats, err := p.Run(context.TODO(), input, output)
+	require.NoError(t, err)
+	require.Equal(t, &ProcessorStats{Total: 6, Succeeded: 3, Failed: 3}, stats)
+
+	require.Equal(t, 3, results["flaky"].Attempts)
+	require.Equal(t, 1, results["bad"].Attempts)
+	require.ErrorIs(t, results["edit"].Err, ErrUnsupportedEndpoint)
+	require.Equal(t, 7, results["line-7"].Line)
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+
+	decoded, err := DecodeBatchResults[json.RawMessage](data)
+	require.NoError(t, err)
+	require.Len(t, decoded, 6)
+	require.NoError(t, decoded["ok"].Err())
+	require.NoError(t, decoded["flaky"].Err())
+	require.NoError(t, decoded["line-5"].Err())
+	require.Equal(t, "400", decoded["bad"].Error.Code)
+	require.Equal(t, ProcessorErrorInvalidRequest, decoded["edit"].Error.Code)
+	require.Equal(t, ProcessorErrorInvalidRequest, decoded["line-7"].Error.Code)
+
+	var resp ChatCreateResponse
+	require.NoError(t, json.Unmarshal(*decoded["ok"].Response, &resp))
+	require.Equal(t, "echo ok", resp.Choices[0].Message.Content)
+
+	// 再次执行时所有的行都已经完成
+	stats, err = p.Run(context.TODO(), input, output)
+	require.NoError(t, err)
+	require.Equal(t, &ProcessorStats{Total: 6, Skipped: 6}, stats)
+	require.Equal(t, map[string]int{"ok": 1, "flaky": 3, "bad": 1, "embed": 1}, calls)
+}
+
+func TestProcessor_Resume(t *testing.T) {
+	calls := make(map[string]int)
+	var mu sync.Mutex
+	server := newMockServer(newMockProcessorServer(t, calls, &mu))
+	defer server.Close()
+
+	dir := t.TempDir()
+	input := writeProcessorInput(t, dir, chatRequestLine("a"), chatRequestLine("b"), chatRequestLine("c"))
+	output := filepath.Join(dir, "results.jsonl")
+	checkpoint := filepath.Join(dir, "progress")
+
+	// 模拟第一个请求完成之后进程崩溃
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := NewProcessor(newMockClient(server.URL, WithRetries(0)),
+		WithProcessorConcurrency(1),
+		WithProcessorCheckpoint(checkpoint),
+		WithProcessorOnResult(func(result *ProcessorResult) {
+			cancel()
+		}),
+	)
+
+	stats, err := p.Run(ctx, input, output)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, int64(1), stats.Succeeded)
+
+	// checkpoint 文件的最后一行写了一半
+	f, err := os.OpenFile(checkpoint, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"line": 3`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	stats, err = NewProcessor(newMockClient(server.URL, WithRetries(0)), WithProcessorCheckpoint(checkpoint)).Run(context.TODO(), input, output)
+	require.NoError(t, err)
+	require.Equal(t, &ProcessorStats{Total: 3, Skipped: 1, Succeeded: 2}, stats)
+	require.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1}, calls)
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	decoded, err := DecodeBatchResults[ChatCreateResponse](data)
+	require.NoError(t, err)
+	require.Len(t, decoded, 3)
+}
+
+func TestProcessor_ResumeModifiedInput(t *testing.T) {
+	calls := make(map[string]int)
+	var mu sync.Mutex
+	server := newMockServer(newMockProcessorServer(t, calls, &mu))
+	defer server.Close()
+
+	dir := t.TempDir()
+	input := writeProcessorInput(t, dir, chatRequestLine("a"), chatRequestLine("b"), chatRequestLine("c"))
+	output := filepath.Join(dir, "results.jsonl")
+
+	stats, err := NewProcessor(newMockClient(server.URL, WithRetries(0))).Run(context.TODO(), input, output)
+	require.NoError(t, err)
+	require.Equal(t, &ProcessorStats{Total: 3, Succeeded: 3}, stats)
+
+	// 第二行被替换为其他请求，行号相同但是 custom_id 不同，需要重新执行
+	input = writeProcessorInput(t, dir, chatRequestLine("a"), chatRequestLine("d"), chatRequestLine("c"))
+
+	stats, err = NewProcessor(newMockClient(server.URL, WithRetries(0))).Run(context.TODO(), input, output)
+	require.NoError(t, err)
+	require.Equal(t, &ProcessorStats{Total: 3, Skipped: 2, Succeeded: 1}, stats)
+	require.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1, "d": 1}, calls)
+
+	// 新的记录覆盖旧的记录，再次执行时全部跳过
+	stats, err = NewProcessor(newMockClient(server.URL, WithRetries(0))).Run(context.TODO(), input, output)
+	require.NoError(t, err)
+	require.Equal(t, &ProcessorStats{Total: 3, Skipped: 3}, stats)
+}
+
+func TestRateLimiter(t *testing.T) {
+	var unlimited *rateLimiter
+	require.NoError(t, unlimited.wait(context.TODO(), 100))
+
+	l := newRateLimiter(60)
+	require.NoError(t, l.wait(context.TODO(), 60))
+
+	// 令牌已经用完，每秒只补充一个
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, l.wait(ctx, 1), context.DeadlineExceeded)
+
+	// 超过容量的请求按照容量计算
+	l = newRateLimiter(60)
+	require.NoError(t, l.wait(context.TODO(), 1000))
+}
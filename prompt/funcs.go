@@ -0,0 +1,97 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prompt
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// funcs 模板中可以使用的函数
+var funcs = template.FuncMap{
+	"default": defaultValue,
+	"join":    join,
+	"json":    toJSON,
+	"indent":  indent,
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"trim":    strings.TrimSpace,
+	"bullets": bullets,
+}
+
+// defaultValue value 为空时返回 def，用法 {{default "English" .language}}
+func defaultValue(def any, value any) any {
+	if value == nil {
+		return def
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if v.Len() == 0 {
+			return def
+		}
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return def
+		}
+	}
+
+	return value
+}
+
+// join 使用 sep 连接列表中的元素，用法 {{join ", " .items}}
+func join(sep string, items any) (string, error) {
+	v := reflect.ValueOf(items)
+	if !v.IsValid() {
+		return "", nil
+	}
+
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: %T is not a list", items)
+	}
+
+	parts := make([]string, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		parts = append(parts, fmt.Sprint(v.Index(i).Interface()))
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// bullets 将列表中的元素渲染为 markdown 的无序列表
+func bullets(items any) (string, error) {
+	s, err := join("\n- ", items)
+	if err != nil || s == "" {
+		return s, err
+	}
+	return "- " + s, nil
+}
+
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// indent 将每一行缩进 n 个空格
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
@@ -0,0 +1,263 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prompt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// TemplateExt 模板文件的扩展名
+	TemplateExt = ".prompt"
+	// PartialExt 局部模板文件的扩展名，文件名去掉扩展名即为局部模板的名称
+	PartialExt = ".tmpl"
+	// ExamplesExt 示例集合文件的扩展名，内容为 Example 的 JSON 数组，文件名去掉扩展名即为集合的名称
+	ExamplesExt = ".examples.json"
+)
+
+// ErrTemplateNotFound 模板不存在
+var ErrTemplateNotFound = errors.New("prompt: template not found")
+
+// Library 管理模板、局部模板和示例集合，同名的模板可以有多个版本，可以并发使用
+type Library struct {
+	mu        sync.RWMutex
+	partials  map[string]string
+	examples  map[string]*ExampleSet
+	templates map[string][]*ChatTemplate // 按照版本升序排列
+}
+
+func NewLibrary() *Library {
+	return &Library{
+		partials:  make(map[string]string),
+		examples:  make(map[string]*ExampleSet),
+		templates: make(map[string][]*ChatTemplate),
+	}
+}
+
+// LoadDir 从目录中加载模板，见 LoadFS
+func LoadDir(dir string) (*Library, error) {
+	return LoadFS(os.DirFS(dir), ".")
+}
+
+// LoadFS 递归加载 root 目录下所有的 .prompt 模板、.tmpl 局部模板和 .examples.json 示例集合，
+// 可以配合 embed.FS 将模板打包到程序中
+func LoadFS(fsys fs.FS, root string) (*Library, error) {
+	lib := NewLibrary()
+
+	var templates []string
+	err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		name := d.Name()
+		switch {
+		case strings.HasSuffix(name, TemplateExt):
+			// 模板需要在所有的局部模板加载之后再编译
+			templates = append(templates, p)
+		case strings.HasSuffix(name, PartialExt):
+			b, err := fs.ReadFile(fsys, p)
+			if err != nil {
+				return err
+			}
+			return lib.AddPartial(strings.TrimSuffix(name, PartialExt), string(b))
+		case strings.HasSuffix(name, ExamplesExt):
+			b, err := fs.ReadFile(fsys, p)
+			if err != nil {
+				return err
+			}
+
+			set := &ExampleSet{Name: strings.TrimSuffix(name, ExamplesExt)}
+			if err := json.Unmarshal(b, &set.Examples); err != nil {
+				return fmt.Errorf("prompt: parse %s: %w", p, err)
+			}
+			lib.AddExamples(set)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range templates {
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := lib.Parse(strings.TrimSuffix(path.Base(p), TemplateExt), string(b)); err != nil {
+			return nil, err
+		}
+	}
+
+	return lib, nil
+}
+
+// AddPartial 添加局部模板，只对之后添加的模板生效
+func (l *Library) AddPartial(name string, text string) error {
+	if name == "" {
+		return errors.New("prompt: partial name is empty")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.partials[name] = text
+	return nil
+}
+
+// AddExamples 添加示例集合，同名的集合会被替换
+func (l *Library) AddExamples(set *ExampleSet) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.examples[set.Name] = set
+}
+
+// ExampleSet 返回名为 name 的示例集合
+func (l *Library) ExampleSet(name string) (*ExampleSet, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	set, ok := l.examples[name]
+	return set, ok
+}
+
+// Parse 解析模板并添加到 Library 中，模板可以引用已经添加的局部模板
+func (l *Library) Parse(name string, text string) (*ChatTemplate, error) {
+	t, err := parse(name, text)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := t.compile(l.partials); err != nil {
+		return nil, err
+	}
+
+	versions := l.templates[t.Name]
+	for _, v := range versions {
+		if v.Version == t.Version {
+			return nil, fmt.Errorf("prompt: template %s version %q already exists", t.Name, t.Version)
+		}
+	}
+
+	t.lib = l
+	versions = append(versions, t)
+	sort.SliceStable(versions, func(i, j int) bool {
+		return compareVersions(versions[i].Version, versions[j].Version) < 0
+	})
+	l.templates[t.Name] = versions
+
+	return t, nil
+}
+
+// Get 返回名为 name 的最新版本的模板
+func (l *Library) Get(name string) (*ChatTemplate, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	versions := l.templates[name]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	return versions[len(versions)-1], nil
+}
+
+// GetVersion 返回名为 name 的指定版本的模板
+func (l *Library) GetVersion(name string, version string) (*ChatTemplate, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, t := range l.templates[name] {
+		if t.Version == version {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s@%s", ErrTemplateNotFound, name, version)
+}
+
+// Versions 返回名为 name 的模板的所有版本，按照升序排列
+func (l *Library) Versions(name string) []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	versions := make([]string, 0, len(l.templates[name]))
+	for _, t := range l.templates[name] {
+		versions = append(versions, t.Version)
+	}
+	return versions
+}
+
+// Names 返回所有模板的名称
+func (l *Library) Names() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	names := make([]string, 0, len(l.templates))
+	for name := range l.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compareVersions 按照点分隔的各部分比较版本，数字部分按照数值比较，其他部分按照字符串比较，
+// 允许带 v 前缀，比如 v1.10.0 大于 1.9
+func compareVersions(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y string
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+
+		nx, errx := strconv.Atoi(x)
+		ny, erry := strconv.Atoi(y)
+		switch {
+		case x == y:
+			continue
+		case errx == nil && erry == nil:
+			if nx < ny {
+				return -1
+			}
+			if nx > ny {
+				return 1
+			}
+		case x < y:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	return 0
+}
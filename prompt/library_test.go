@@ -0,0 +1,162 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prompt
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"github.com/uzziahlin/openai"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadDir(t *testing.T) {
+	lib, err := LoadDir("testdata")
+	require.NoError(t, err)
+	require.Equal(t, []string{"summarize"}, lib.Names())
+	require.Equal(t, []string{"1.2.0", "1.10.0"}, lib.Versions("summarize"))
+
+	tpl, err := lib.Get("summarize")
+	require.NoError(t, err)
+	require.Equal(t, "1.10.0", tpl.Version)
+
+	req, err := tpl.Request(map[string]any{"text": "Go is fun."})
+	require.NoError(t, err)
+	require.Equal(t, &openai.ChatCreateRequest{
+		Model: "gpt-4o",
+		Messages: []*openai.Message{
+			{Role: openai.RoleSystem, Content: "You are a helpful assistant. Be brief."},
+			{Role: openai.RoleUser, Content: "Summarize: The sky is blue because of Rayleigh scattering."},
+			{Role: openai.RoleAssistant, Content: "Rayleigh scattering makes the sky blue."},
+			{Role: openai.RoleUser, Content: "Summarize: Go is fun."},
+		},
+	}, req)
+
+	tpl, err = lib.GetVersion("summarize", "1.2.0")
+	require.NoError(t, err)
+	require.Equal(t, "gpt-4o-mini", tpl.Model)
+
+	_, err = lib.GetVersion("summarize", "2.0.0")
+	require.True(t, errors.Is(err, ErrTemplateNotFound))
+
+	_, err = lib.Get("unknown")
+	require.True(t, errors.Is(err, ErrTemplateNotFound))
+}
+
+func TestLoadFS(t *testing.T) {
+	testCase := []struct {
+		name    string
+		fsys    fstest.MapFS
+		wantErr bool
+	}{
+		{
+			name: "test load success",
+			fsys: fstest.MapFS{
+				"prompts/greet.prompt": {Data: []byte("[user]\n{{template \"hello\" .}}")},
+				"prompts/hello.tmpl":   {Data: []byte("Hello {{.name}}")},
+				"prompts/ignored.txt":  {Data: []byte("ignored")},
+				"other/outside.prompt": {Data: []byte("[user]\nignored")},
+			},
+		},
+		{
+			name: "test load invalid examples",
+			fsys: fstest.MapFS{
+				"prompts/bad.examples.json": {Data: []byte("{")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "test load undefined partial",
+			fsys: fstest.MapFS{
+				"prompts/greet.prompt": {Data: []byte("[user]\n{{template \"missing\" .}}")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "test load duplicated version",
+			fsys: fstest.MapFS{
+				"prompts/a.prompt": {Data: []byte("name: greet\n[user]\nhi")},
+				"prompts/b.prompt": {Data: []byte("name: greet\n[user]\nhello")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			lib, err := LoadFS(tc.fsys, "prompts")
+			if tc.wantErr {
+				if err == nil {
+					// 引用不存在的局部模板在渲染时才会报错
+					tpl, getErr := lib.Get("greet")
+					require.NoError(t, getErr)
+					_, err = tpl.Render(nil)
+				}
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, []string{"greet"}, lib.Names())
+
+			tpl, err := lib.Get("greet")
+			require.NoError(t, err)
+			got, err := tpl.Render(map[string]any{"name": "Ken"})
+			require.NoError(t, err)
+			require.Equal(t, []*openai.Message{{Role: openai.RoleUser, Content: "Hello Ken"}}, got)
+		})
+	}
+}
+
+func TestLibrary_ExampleSet(t *testing.T) {
+	lib := NewLibrary()
+	lib.AddExamples(&ExampleSet{Name: "a", Examples: []*Example{{Input: "1", Output: "2"}, {Input: "3", Output: "4"}}})
+	lib.AddExamples(&ExampleSet{Name: "b", Examples: []*Example{{Input: "x", Output: "y"}}})
+
+	tpl, err := lib.Parse("echo", "examples: a\n[examples]\n[user]\n{{.q}}")
+	require.NoError(t, err)
+
+	got, err := tpl.Render(map[string]any{"q": "5"}, WithMaxExamples(1))
+	require.NoError(t, err)
+	require.Len(t, got, 3)
+	require.Equal(t, "1", got[0].Content)
+
+	got, err = tpl.Render(map[string]any{"q": "z"}, WithExampleSet("b"))
+	require.NoError(t, err)
+	require.Equal(t, "x", got[0].Content)
+
+	_, err = tpl.Render(map[string]any{"q": "z"}, WithExampleSet("c"))
+	require.Error(t, err)
+
+	require.Error(t, lib.AddPartial("", "x"))
+}
+
+func TestCompareVersions(t *testing.T) {
+	testCase := []struct {
+		a, b string
+		want int
+	}{
+		{a: "1.10.0", b: "1.9", want: 1},
+		{a: "v1.2", b: "1.2.0", want: -1},
+		{a: "1.2.0", b: "1.2.0", want: 0},
+		{a: "1.0-beta", b: "1.0-alpha", want: 1},
+		{a: "", b: "1", want: -1},
+	}
+
+	for _, tc := range testCase {
+		t.Run("test compare "+tc.a+" "+tc.b, func(t *testing.T) {
+			require.Equal(t, tc.want, compareVersions(tc.a, tc.b))
+		})
+	}
+}
@@ -0,0 +1,523 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prompt 基于 text/template 的提示词模板，一个模板渲染为一组聊天消息，支持局部模板、few-shot 示例、
+// 变量校验和版本管理，渲染的结果可以直接作为 openai.ChatCreateRequest 发送。
+//
+// 模板文件由头部和若干消息段组成，头部为 key: value 格式，消息段以单独一行的 [system]、[user] 或者 [assistant] 开始，
+// [examples] 表示在该位置插入 few-shot 示例：
+//
+//	name: translate
+//	version: 1.2.0
+//	variables: text, target?
+//	model: gpt-4o
+//	temperature: 0.2
+//
+//	[system]
+//	Translate the text into {{default "English" .target}}.
+//	[examples]
+//	[user]
+//	{{.text}}
+package prompt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/uzziahlin/openai"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const (
+	sectionExamples = "examples"
+)
+
+var (
+	// ErrMissingVariable 缺少必须的变量
+	ErrMissingVariable = errors.New("prompt: missing required variable")
+	// ErrUnknownVariable 传入了模板没有声明的变量
+	ErrUnknownVariable = errors.New("prompt: unknown variable")
+)
+
+// Variable 模板声明的变量，声明时变量名后面加 ? 表示可选
+type Variable struct {
+	Name     string
+	Required bool
+}
+
+// Example 一个 few-shot 示例，渲染为一条 user 消息和一条 assistant 消息
+type Example struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+}
+
+// ExampleSet 一组 few-shot 示例
+type ExampleSet struct {
+	Name     string
+	Examples []*Example
+}
+
+type section struct {
+	role string
+	body string
+	name string // 编译后的模板名称，examples 段为空
+}
+
+// ChatTemplate 聊天消息的模板，可以并发渲染
+type ChatTemplate struct {
+	Name        string
+	Version     string
+	Description string
+	Model       string
+	Temperature *float64
+	MaxTokens   int64
+	// Examples 默认使用的示例集合的名称，从所属的 Library 中查找
+	Examples string
+	// Variables 声明的变量，为空时不校验传入的变量
+	Variables []*Variable
+
+	sections []*section
+	tmpl     *template.Template
+	lib      *Library
+}
+
+// Parse 解析模板，name 在头部没有指定 name 时使用。解析出的模板不属于任何 Library，不能引用局部模板和示例集合
+func Parse(name string, text string) (*ChatTemplate, error) {
+	t, err := parse(name, text)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.compile(nil); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// MustParse 与 Parse 相同，出错时 panic
+func MustParse(name string, text string) *ChatTemplate {
+	t, err := Parse(name, text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func parse(name string, text string) (*ChatTemplate, error) {
+	t := &ChatTemplate{Name: name}
+
+	var cur *section
+	var body []string
+
+	flush := func() {
+		if cur != nil {
+			cur.body = strings.Join(trimBlankLines(body), "\n")
+			t.sections = append(t.sections, cur)
+		}
+		body = nil
+	}
+
+	for i, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if role, ok := sectionRole(line); ok {
+			flush()
+			cur = &section{role: role}
+			continue
+		}
+
+		if cur != nil {
+			body = append(body, line)
+			continue
+		}
+
+		if err := t.parseHeader(line); err != nil {
+			return nil, fmt.Errorf("prompt: %s:%d: %w", name, i+1, err)
+		}
+	}
+	flush()
+
+	if len(t.sections) == 0 {
+		return nil, fmt.Errorf("prompt: %s: no message section", name)
+	}
+
+	return t, nil
+}
+
+func sectionRole(line string) (string, bool) {
+	switch strings.TrimSpace(line) {
+	case "[system]":
+		return openai.RoleSystem, true
+	case "[user]":
+		return openai.RoleUser, true
+	case "[assistant]":
+		return openai.RoleAssistant, true
+	case "[examples]":
+		return sectionExamples, true
+	}
+	return "", false
+}
+
+func (t *ChatTemplate) parseHeader(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	key, value, ok := strings.Cut(line, ":")
+	if !ok {
+		return fmt.Errorf("invalid header %q", line)
+	}
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+	switch key {
+	case "name":
+		t.Name = value
+	case "version":
+		t.Version = value
+	case "description":
+		t.Description = value
+	case "model":
+		t.Model = value
+	case "examples":
+		t.Examples = value
+	case "temperature":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid temperature: %w", err)
+		}
+		t.Temperature = &f
+	case "max_tokens":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid max_tokens: %w", err)
+		}
+		t.MaxTokens = n
+	case "variables":
+		for _, v := range strings.Split(value, ",") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			required := !strings.HasSuffix(v, "?")
+			t.Variables = append(t.Variables, &Variable{Name: strings.TrimSuffix(v, "?"), Required: required})
+		}
+	default:
+		return fmt.Errorf("unknown header %q", key)
+	}
+
+	return nil
+}
+
+// compile 将所有的消息段编译到同一个模板集合中，partials 中的局部模板可以通过 {{template "name" .}} 引用
+func (t *ChatTemplate) compile(partials map[string]string) error {
+	root := template.New(t.Name).Funcs(funcs).Option("missingkey=error")
+
+	for name, text := range partials {
+		if _, err := root.New(name).Parse(text); err != nil {
+			return fmt.Errorf("prompt: parse partial %s: %w", name, err)
+		}
+	}
+
+	for i, s := range t.sections {
+		if s.role == sectionExamples {
+			continue
+		}
+
+		s.name = fmt.Sprintf("%s/%d:%s", t.Name, i, s.role)
+		if _, err := root.New(s.name).Parse(s.body); err != nil {
+			return fmt.Errorf("prompt: parse %s: %w", t.Name, err)
+		}
+	}
+
+	t.tmpl = root
+	return nil
+}
+
+type renderConfig struct {
+	examples    *ExampleSet
+	exampleName string
+	maxExamples int
+}
+
+type RenderOption func(*renderConfig)
+
+// WithExamples 使用指定的示例集合，优先于模板头部的 examples
+func WithExamples(set *ExampleSet) RenderOption {
+	return func(c *renderConfig) {
+		c.examples = set
+	}
+}
+
+// WithExampleSet 使用所属 Library 中名为 name 的示例集合
+func WithExampleSet(name string) RenderOption {
+	return func(c *renderConfig) {
+		c.exampleName = name
+	}
+}
+
+// WithMaxExamples 最多使用前 n 个示例
+func WithMaxExamples(n int) RenderOption {
+	return func(c *renderConfig) {
+		c.maxExamples = n
+	}
+}
+
+// Render 使用 vars 渲染消息，vars 可以是 map[string]any 或者结构体，结构体的字段名使用 json tag。
+// 渲染结果为空的消息段会被忽略，可以用来根据条件生成消息
+func (t *ChatTemplate) Render(vars any, opts ...RenderOption) ([]*openai.Message, error) {
+	cfg := &renderConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	data, err := toVars(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.validate(data); err != nil {
+		return nil, err
+	}
+
+	var messages []*openai.Message
+	for _, s := range t.sections {
+		if s.role == sectionExamples {
+			examples, err := t.examples(cfg)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range examples {
+				messages = append(messages,
+					&openai.Message{Role: openai.RoleUser, Content: e.Input},
+					&openai.Message{Role: openai.RoleAssistant, Content: e.Output},
+				)
+			}
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := t.tmpl.ExecuteTemplate(&buf, s.name, data); err != nil {
+			return nil, fmt.Errorf("prompt: render %s: %w", t.Name, err)
+		}
+
+		content := strings.TrimSpace(buf.String())
+		if content == "" {
+			continue
+		}
+
+		messages = append(messages, &openai.Message{Role: s.role, Content: content})
+	}
+
+	return messages, nil
+}
+
+// Request 渲染消息并生成 ChatCreateRequest，模型、temperature 和 max_tokens 使用模板头部的设置
+func (t *ChatTemplate) Request(vars any, opts ...RenderOption) (*openai.ChatCreateRequest, error) {
+	messages, err := t.Render(vars, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &openai.ChatCreateRequest{
+		Model:     t.Model,
+		Messages:  messages,
+		MaxTokens: t.MaxTokens,
+	}
+	if t.Temperature != nil {
+		req.Temperature = *t.Temperature
+	}
+
+	return req, nil
+}
+
+func (t *ChatTemplate) examples(cfg *renderConfig) ([]*Example, error) {
+	set := cfg.examples
+	if set == nil {
+		name := cfg.exampleName
+		if name == "" {
+			name = t.Examples
+		}
+
+		if name != "" {
+			if t.lib == nil {
+				return nil, fmt.Errorf("prompt: %s: example set %s requires a library", t.Name, name)
+			}
+
+			var ok bool
+			if set, ok = t.lib.ExampleSet(name); !ok {
+				return nil, fmt.Errorf("prompt: %s: example set %s not found", t.Name, name)
+			}
+		}
+	}
+
+	if set == nil {
+		return nil, nil
+	}
+
+	examples := set.Examples
+	if cfg.maxExamples > 0 && len(examples) > cfg.maxExamples {
+		examples = examples[:cfg.maxExamples]
+	}
+	return examples, nil
+}
+
+// validate 校验变量，缺少的可选变量会被设置为空字符串，避免 missingkey=error 报错
+func (t *ChatTemplate) validate(data map[string]any) error {
+	if len(t.Variables) == 0 {
+		return nil
+	}
+
+	declared := make(map[string]bool, len(t.Variables))
+	for _, v := range t.Variables {
+		declared[v.Name] = true
+
+		value, ok := data[v.Name]
+		if !ok || value == nil {
+			if v.Required {
+				return fmt.Errorf("%w: %s", ErrMissingVariable, v.Name)
+			}
+			data[v.Name] = ""
+		}
+	}
+
+	for name := range data {
+		if !declared[name] {
+			return fmt.Errorf("%w: %s", ErrUnknownVariable, name)
+		}
+	}
+
+	return nil
+}
+
+// toVars 将变量转换为 map，结构体的字段名使用 json tag，返回的 map 是新创建的
+func toVars(vars any) (map[string]any, error) {
+	data := make(map[string]any)
+	if vars == nil {
+		return data, nil
+	}
+
+	v := reflect.ValueOf(vars)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return data, nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("prompt: unsupported variables type %s", v.Type())
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			data[iter.Key().String()] = iter.Value().Interface()
+		}
+	case reflect.Struct:
+		for name, index := range structFields(v.Type()) {
+			// 嵌入的结构体指针为 nil 时，与 encoding/json 一样忽略其中的字段
+			f, err := v.FieldByIndexErr(index)
+			if err != nil {
+				continue
+			}
+			data[name] = f.Interface()
+		}
+	default:
+		return nil, fmt.Errorf("prompt: unsupported variables type %s", v.Type())
+	}
+
+	return data, nil
+}
+
+// structFields 返回结构体导出字段的变量名，优先使用 json tag，嵌入的结构体字段会被展开
+func structFields(t reflect.Type) map[string][]int {
+	fields := make(map[string][]int)
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+
+		fields[name] = f.Index
+	}
+	return fields
+}
+
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Typed 使用结构体 T 作为变量的模板，创建时校验 T 的字段与模板声明的变量一致
+type Typed[T any] struct {
+	*ChatTemplate
+}
+
+// NewTyped 创建 Typed，T 必须是结构体，模板声明了变量时，必须的变量都需要有对应的字段，并且 T 不能有未声明的字段
+func NewTyped[T any](t *ChatTemplate) (*Typed[T], error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("prompt: %s is not a struct", typ)
+	}
+
+	if len(t.Variables) > 0 {
+		fields := structFields(typ)
+
+		declared := make(map[string]bool, len(t.Variables))
+		for _, v := range t.Variables {
+			declared[v.Name] = true
+			if _, ok := fields[v.Name]; !ok && v.Required {
+				return nil, fmt.Errorf("%w: %s has no field for %s", ErrMissingVariable, typ, v.Name)
+			}
+		}
+
+		for name := range fields {
+			if !declared[name] {
+				return nil, fmt.Errorf("%w: %s.%s", ErrUnknownVariable, typ, name)
+			}
+		}
+	}
+
+	return &Typed[T]{ChatTemplate: t}, nil
+}
+
+func (t *Typed[T]) Render(vars T, opts ...RenderOption) ([]*openai.Message, error) {
+	return t.ChatTemplate.Render(vars, opts...)
+}
+
+func (t *Typed[T]) Request(vars T, opts ...RenderOption) (*openai.ChatCreateRequest, error) {
+	return t.ChatTemplate.Request(vars, opts...)
+}
@@ -0,0 +1,267 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prompt
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/uzziahlin/openai"
+	"testing"
+)
+
+const translateTemplate = `name: translate
+version: 1.0.0
+description: translate text
+variables: text, target?
+model: gpt-4o
+temperature: 0.2
+max_tokens: 100
+
+[system]
+Translate the text into {{default "English" .target}}.
+[examples]
+[user]
+{{.text}}
+`
+
+type translateVars struct {
+	Text   string `json:"text"`
+	Target string `json:"target,omitempty"`
+}
+
+type TranslateBase struct {
+	Target string `json:"target,omitempty"`
+}
+
+func TestParse(t *testing.T) {
+	testCase := []struct {
+		name    string
+		text    string
+		wantErr bool
+	}{
+		{
+			name: "test parse success",
+			text: translateTemplate,
+		},
+		{
+			name:    "test parse without section",
+			text:    "name: empty\n",
+			wantErr: true,
+		},
+		{
+			name:    "test parse unknown header",
+			text:    "foo: bar\n[user]\nhi",
+			wantErr: true,
+		},
+		{
+			name:    "test parse invalid temperature",
+			text:    "temperature: hot\n[user]\nhi",
+			wantErr: true,
+		},
+		{
+			name:    "test parse invalid template",
+			text:    "[user]\n{{.text",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			tpl, err := Parse("test", tc.text)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "translate", tpl.Name)
+			require.Equal(t, "1.0.0", tpl.Version)
+			require.Equal(t, "translate text", tpl.Description)
+			require.Equal(t, "gpt-4o", tpl.Model)
+			require.Equal(t, 0.2, *tpl.Temperature)
+			require.Equal(t, int64(100), tpl.MaxTokens)
+			require.Equal(t, []*Variable{{Name: "text", Required: true}, {Name: "target"}}, tpl.Variables)
+		})
+	}
+}
+
+func TestChatTemplate_Render(t *testing.T) {
+	tpl := MustParse("translate", translateTemplate)
+
+	examples := &ExampleSet{
+		Name: "fr",
+		Examples: []*Example{
+			{Input: "hello", Output: "bonjour"},
+			{Input: "thanks", Output: "merci"},
+		},
+	}
+
+	testCase := []struct {
+		name    string
+		vars    any
+		opts    []RenderOption
+		want    []*openai.Message
+		wantErr error
+	}{
+		{
+			name: "test render with map",
+			vars: map[string]any{"text": "你好", "target": "French"},
+			want: []*openai.Message{
+				{Role: openai.RoleSystem, Content: "Translate the text into French."},
+				{Role: openai.RoleUser, Content: "你好"},
+			},
+		},
+		{
+			name: "test render with struct and default",
+			vars: &translateVars{Text: "你好"},
+			want: []*openai.Message{
+				{Role: openai.RoleSystem, Content: "Translate the text into English."},
+				{Role: openai.RoleUser, Content: "你好"},
+			},
+		},
+		{
+			name: "test render with nil embedded struct",
+			vars: struct {
+				*TranslateBase
+				Text string `json:"text"`
+			}{Text: "你好"},
+			want: []*openai.Message{
+				{Role: openai.RoleSystem, Content: "Translate the text into English."},
+				{Role: openai.RoleUser, Content: "你好"},
+			},
+		},
+		{
+			name: "test render with embedded struct",
+			vars: struct {
+				*TranslateBase
+				Text string `json:"text"`
+			}{TranslateBase: &TranslateBase{Target: "French"}, Text: "你好"},
+			want: []*openai.Message{
+				{Role: openai.RoleSystem, Content: "Translate the text into French."},
+				{Role: openai.RoleUser, Content: "你好"},
+			},
+		},
+		{
+			name: "test render with examples",
+			vars: translateVars{Text: "你好", Target: "French"},
+			opts: []RenderOption{WithExamples(examples), WithMaxExamples(1)},
+			want: []*openai.Message{
+				{Role: openai.RoleSystem, Content: "Translate the text into French."},
+				{Role: openai.RoleUser, Content: "hello"},
+				{Role: openai.RoleAssistant, Content: "bonjour"},
+				{Role: openai.RoleUser, Content: "你好"},
+			},
+		},
+		{
+			name:    "test render missing variable",
+			vars:    map[string]any{"target": "French"},
+			wantErr: ErrMissingVariable,
+		},
+		{
+			name:    "test render unknown variable",
+			vars:    map[string]any{"text": "你好", "source": "Chinese"},
+			wantErr: ErrUnknownVariable,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tpl.Render(tc.vars, tc.opts...)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestChatTemplate_RenderSkipEmpty(t *testing.T) {
+	tpl := MustParse("qa", `[system]
+{{if .context}}Answer with the context:
+{{indent 2 .context}}{{end}}
+[user]
+{{.question}}
+Keywords: {{join ", " .keywords}}
+{{bullets .keywords}}
+{{json .keywords}} {{upper "a"}}{{lower "B"}}{{trim "  c  "}}`)
+
+	got, err := tpl.Render(map[string]any{
+		"context":  "",
+		"question": "why?",
+		"keywords": []string{"x", "y"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []*openai.Message{
+		{Role: openai.RoleUser, Content: "why?\nKeywords: x, y\n- x\n- y\n[\"x\",\"y\"] Abc"},
+	}, got)
+
+	got, err = tpl.Render(map[string]any{
+		"context":  "line1\nline2",
+		"question": "why?",
+		"keywords": nil,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Answer with the context:\n  line1\n  line2", got[0].Content)
+	require.Equal(t, "why?\nKeywords: \n\nnull Abc", got[1].Content)
+
+	// 没有声明变量时，使用模板中不存在的变量会报错
+	_, err = tpl.Render(map[string]any{"question": "why?"})
+	require.Error(t, err)
+}
+
+func TestChatTemplate_Request(t *testing.T) {
+	tpl := MustParse("translate", translateTemplate)
+
+	req, err := tpl.Request(translateVars{Text: "你好"})
+	require.NoError(t, err)
+	require.Equal(t, &openai.ChatCreateRequest{
+		Model: "gpt-4o",
+		Messages: []*openai.Message{
+			{Role: openai.RoleSystem, Content: "Translate the text into English."},
+			{Role: openai.RoleUser, Content: "你好"},
+		},
+		MaxTokens:   100,
+		Temperature: 0.2,
+	}, req)
+
+	// 独立解析的模板没有 Library，不能使用示例集合名称
+	_, err = tpl.Request(translateVars{Text: "你好"}, WithExampleSet("fr"))
+	require.Error(t, err)
+}
+
+func TestNewTyped(t *testing.T) {
+	tpl := MustParse("translate", translateTemplate)
+
+	typed, err := NewTyped[translateVars](tpl)
+	require.NoError(t, err)
+
+	got, err := typed.Render(translateVars{Text: "你好", Target: "German"})
+	require.NoError(t, err)
+	require.Equal(t, "Translate the text into German.", got[0].Content)
+
+	_, err = NewTyped[struct {
+		Target string `json:"target"`
+	}](tpl)
+	require.ErrorIs(t, err, ErrMissingVariable)
+
+	_, err = NewTyped[struct {
+		Text   string `json:"text"`
+		Source string `json:"source"`
+	}](tpl)
+	require.ErrorIs(t, err, ErrUnknownVariable)
+
+	_, err = NewTyped[string](tpl)
+	require.Error(t, err)
+}
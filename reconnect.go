@@ -0,0 +1,192 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"time"
+)
+
+const (
+	lastEventIdHeader   = "Last-Event-ID"
+	defaultReconnectGap = 3 * time.Second
+
+	// reconnectDedupeWindow 用于去重的最近事件数量
+	reconnectDedupeWindow = 1024
+)
+
+// ReconnectPolicy 流式连接断开后的自动重连策略
+// 重连时会带上 Last-Event-ID 请求头，优先使用服务端通过 retry 指定的间隔。
+// 重连后服务端重放的、已经收到过的事件会被丢弃，有 id 时按照 id 判断，没有 id 时按照 event 和 data 判断，
+// 只会丢弃重连后开头的重复事件，收到第一个新事件后不再去重。整个过程对消费者透明
+type ReconnectPolicy struct {
+	// MaxAttempts 连续重连的最大次数，收到新的事件后重新计数，小于等于 0 表示不限制，此时只能通过 ctx 结束
+	MaxAttempts int
+	// Retry 服务端没有指定 retry 时的重连间隔，为 0 时使用默认值 3s
+	Retry time.Duration
+}
+
+// streamWithReconnect 建立一个会自动重连的流式连接，首次连接失败直接返回错误，不会重连
+func (c *Client) streamWithReconnect(ctx context.Context, method, relPath string, params, body any, policy ReconnectPolicy) (EventSource, error) {
+	connect := func(lastEventId string) (EventSource, error) {
+		var headers map[string]string
+		if lastEventId != "" {
+			headers = map[string]string{lastEventIdHeader: lastEventId}
+		}
+		return c.Stream(ctx, method, relPath, headers, params, body)
+	}
+
+	es, err := connect("")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newReconnectingEventSource(ctx, es, connect, policy, c.logger), nil
+}
+
+func newReconnectingEventSource(ctx context.Context, es EventSource, connect func(lastEventId string) (EventSource, error), policy ReconnectPolicy, logger logr.Logger) EventSource {
+	out := make(EventSource)
+
+	retry := policy.Retry
+	if retry <= 0 {
+		retry = defaultReconnectGap
+	}
+
+	go func() {
+		defer close(out)
+
+		var (
+			lastEventId string
+			attempts    int
+			replaying   bool
+			seen        = newEventWindow(reconnectDedupeWindow)
+		)
+
+		for {
+			var streamErr error
+
+			for e := range es {
+				if e.Err != nil {
+					streamErr = e.Err
+					continue
+				}
+
+				if e.Retry > 0 {
+					retry = e.Retry
+				}
+
+				key := e.Id
+				if key == "" {
+					key = e.Event + "\n" + e.Data
+				}
+
+				// 重连后跳过服务端重放的事件，直到收到第一个新事件
+				if replaying {
+					if seen.contains(key) {
+						continue
+					}
+					replaying = false
+				}
+				seen.add(key)
+
+				if e.Id != "" {
+					lastEventId = e.Id
+				}
+
+				attempts = 0
+
+				select {
+				case <-ctx.Done():
+					return
+				case out <- e:
+				}
+			}
+
+			// 正常结束或者被取消
+			if streamErr == nil || ctx.Err() != nil {
+				return
+			}
+
+			// 重连，直到成功或者超过最大次数
+			for {
+				attempts++
+
+				if policy.MaxAttempts > 0 && attempts > policy.MaxAttempts {
+					select {
+					case <-ctx.Done():
+					case out <- Event{Err: streamErr}:
+					}
+					return
+				}
+
+				logger.Info("stream interrupted, reconnecting", "error", streamErr.Error(), "attempts", attempts, "lastEventId", lastEventId)
+
+				timer := time.NewTimer(retry)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+
+				var err error
+				es, err = connect(lastEventId)
+				if err == nil {
+					replaying = true
+					break
+				}
+
+				streamErr = err
+			}
+		}
+	}()
+
+	return out
+}
+
+// eventWindow 记录最近 size 个事件的 key，超出时淘汰最早的 key
+type eventWindow struct {
+	size  int
+	keys  map[string]struct{}
+	order []string
+}
+
+func newEventWindow(size int) *eventWindow {
+	return &eventWindow{
+		size: size,
+		keys: make(map[string]struct{}, size),
+	}
+}
+
+func (w *eventWindow) contains(key string) bool {
+	_, ok := w.keys[key]
+	return ok
+}
+
+func (w *eventWindow) add(key string) {
+	if w.contains(key) {
+		return
+	}
+
+	if len(w.order) >= w.size {
+		delete(w.keys, w.order[0])
+		w.order = w.order[1:]
+	}
+
+	w.keys[key] = struct{}{}
+	w.order = append(w.order, key)
+}
@@ -0,0 +1,144 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFineTuneServiceOp_ListEvents_Reconnect(t *testing.T) {
+	event := func(id int) string {
+		return fmt.Sprintf("id: %d\ndata: {\"object\":\"list\",\"data\":[{\"object\":\"fine-tune-event\",\"level\":\"info\",\"message\":\"event %d\"}]}\n\n", id, id)
+	}
+	// 旧版的 fine-tune 事件流不会发送 id
+	eventWithoutId := func(id int) string {
+		return fmt.Sprintf("data: {\"object\":\"list\",\"data\":[{\"object\":\"fine-tune-event\",\"level\":\"info\",\"message\":\"event %d\"}]}\n\n", id)
+	}
+
+	testCase := []struct {
+		name            string
+		responses       []string
+		maxAttempts     int
+		wantMessages    []string
+		wantLastEventId []string
+		wantErr         error
+	}{
+		{
+			name: "test reconnect with last event id",
+			responses: []string{
+				"retry: 10\n\n" + event(1) + event(2),
+				event(2) + event(3) + "data: [DONE]\n\n",
+			},
+			wantMessages:    []string{"event 1", "event 2", "event 3"},
+			wantLastEventId: []string{"", "2"},
+		},
+		{
+			name: "test reconnect without event id",
+			responses: []string{
+				"retry: 10\n\n" + eventWithoutId(1) + eventWithoutId(2),
+				eventWithoutId(1) + eventWithoutId(2) + eventWithoutId(3) + "data: [DONE]\n\n",
+			},
+			wantMessages:    []string{"event 1", "event 2", "event 3"},
+			wantLastEventId: []string{"", ""},
+		},
+		{
+			name: "test reconnect exceed max attempts",
+			responses: []string{
+				"retry: 10\n\n" + event(1),
+				"",
+				"",
+			},
+			maxAttempts:     2,
+			wantMessages:    []string{"event 1"},
+			wantLastEventId: []string{"", "1", "1"},
+			wantErr:         io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mu           sync.Mutex
+				lastEventIds []string
+			)
+
+			server := newMockServer(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempt := len(lastEventIds)
+				lastEventIds = append(lastEventIds, r.Header.Get("Last-Event-ID"))
+				mu.Unlock()
+
+				require.Equal(t, "true", r.URL.Query().Get("stream"))
+
+				w.Header().Set("Content-Type", "text/event-stream")
+				if attempt < len(tc.responses) {
+					_, _ = io.WriteString(w, tc.responses[attempt])
+				}
+			})
+			defer server.Close()
+
+			client := newMockClient(server.URL, WithStreamReconnect(ReconnectPolicy{
+				MaxAttempts: tc.maxAttempts,
+				Retry:       time.Minute,
+			}))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			res, err := client.FineTunes.ListEvents(ctx, "ft-AF1WoRqd3aJAHsqc9NY7iL8F", true)
+			require.NoError(t, err)
+
+			var (
+				messages  []string
+				streamErr error
+			)
+			for r := range res {
+				if r.Err() != nil {
+					streamErr = r.Err()
+					continue
+				}
+				messages = append(messages, r.Data[0].Message)
+			}
+
+			require.Equal(t, tc.wantMessages, messages)
+			require.ErrorIs(t, streamErr, tc.wantErr)
+
+			mu.Lock()
+			defer mu.Unlock()
+			require.Equal(t, tc.wantLastEventId, lastEventIds)
+		})
+	}
+}
+
+func TestEventWindow(t *testing.T) {
+	w := newEventWindow(2)
+	w.add("1")
+	w.add("2")
+	w.add("2")
+	require.True(t, w.contains("1"))
+
+	w.add("3")
+	require.False(t, w.contains("1"))
+	require.True(t, w.contains("2"))
+	require.True(t, w.contains("3"))
+	require.Len(t, w.order, 2)
+}
@@ -0,0 +1,379 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type RelayMode int
+
+const (
+	// RelayModePassThrough 原样转发每个 chunk 的 JSON，最后发送 [DONE]，与 OpenAI 的 SSE 格式一致
+	RelayModePassThrough RelayMode = iota
+	// RelayModeText 只转发文本片段，适合浏览器直接展示
+	RelayModeText
+
+	relayDoneData       = "[DONE]"
+	relayErrorEvent     = "error"
+	relayHeartbeatEvery = 15 * time.Second
+	relayMaxBodyBytes   = 1 << 20
+)
+
+// ErrRelayBodyTooLarge 请求 body 超过 WithRelayMaxBodyBytes 设置的上限
+var ErrRelayBodyTooLarge = errors.New("openai: relay request body too large")
+
+type relayConfig struct {
+	mode         RelayMode
+	heartbeat    time.Duration
+	maxBodyBytes int64
+}
+
+type RelayOption func(*relayConfig)
+
+// WithRelayMode 设置转发模式，默认为 RelayModePassThrough
+func WithRelayMode(mode RelayMode) RelayOption {
+	return func(c *relayConfig) {
+		c.mode = mode
+	}
+}
+
+// WithRelayHeartbeat 设置心跳间隔，心跳以 SSE 注释的形式发送，用于避免中间代理断开空闲连接，小于等于 0 表示不发送心跳
+func WithRelayHeartbeat(interval time.Duration) RelayOption {
+	return func(c *relayConfig) {
+		c.heartbeat = interval
+	}
+}
+
+// WithRelayMaxBodyBytes 设置 NewChatRelayHandler 和 NewCompletionRelayHandler 读取请求 body 的上限，默认为 1MB，
+// 超出时返回 413，小于等于 0 表示不限制
+func WithRelayMaxBodyBytes(n int64) RelayOption {
+	return func(c *relayConfig) {
+		c.maxBodyBytes = n
+	}
+}
+
+func newRelayConfig(opts ...RelayOption) *relayConfig {
+	cfg := &relayConfig{
+		mode:         RelayModePassThrough,
+		heartbeat:    relayHeartbeatEvery,
+		maxBodyBytes: relayMaxBodyBytes,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// RelayChatStream 将 chat stream 以 SSE 的形式写入 w，每个 chunk 都会 flush，
+// 客户端断开连接时会关闭上游的 stream，上游出错时会发送一个 error 事件，data 为 {"error": {...}}
+// 返回值为上游的错误或者写入 w 的错误，正常结束时返回 nil
+func RelayChatStream(w http.ResponseWriter, r *http.Request, s *Stream[*ChatCreateResponse], opts ...RelayOption) error {
+	return relay(w, r, s, chatDeltaText, newRelayConfig(opts...))
+}
+
+// RelayCompletionStream 将 completion stream 以 SSE 的形式写入 w，规则同 RelayChatStream
+func RelayCompletionStream(w http.ResponseWriter, r *http.Request, s *Stream[*CompletionCreateResponse], opts ...RelayOption) error {
+	return relay(w, r, s, completionText, newRelayConfig(opts...))
+}
+
+// NewChatRelayHandler 返回一个将 chat stream 转发给浏览器的 http.Handler，
+// decode 用于从请求中解析出 ChatCreateRequest，不能为 nil。
+// 注意：上游请求使用服务端的 API key，decode 必须限制浏览器能够控制的内容，例如模型、max_tokens、n 和 tools，
+// 直接将请求 body 作为 ChatCreateRequest 解析相当于一个开放的代理，可以使用 ChatRelayDecoder
+func NewChatRelayHandler(chat ChatService, decode func(r *http.Request) (*ChatCreateRequest, error), opts ...RelayOption) http.Handler {
+	if decode == nil {
+		panic("openai: nil relay decode")
+	}
+
+	cfg := newRelayConfig(opts...)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := decodeRelayRequest(w, r, decode, cfg)
+		if err != nil {
+			return
+		}
+
+		// 使用请求的 context，客户端断开连接时上游请求也会被取消
+		s, err := chat.CreateStream(r.Context(), req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		_ = relay(w, r, s, chatDeltaText, cfg)
+	})
+}
+
+// NewCompletionRelayHandler 返回一个将 completion stream 转发给浏览器的 http.Handler，规则同 NewChatRelayHandler，
+// 可以使用 CompletionRelayDecoder
+func NewCompletionRelayHandler(completion CompletionService, decode func(r *http.Request) (*CompletionCreateRequest, error), opts ...RelayOption) http.Handler {
+	if decode == nil {
+		panic("openai: nil relay decode")
+	}
+
+	cfg := newRelayConfig(opts...)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := decodeRelayRequest(w, r, decode, cfg)
+		if err != nil {
+			return
+		}
+
+		s, err := completion.CreateStream(r.Context(), req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		_ = relay(w, r, s, completionText, cfg)
+	})
+}
+
+// ChatRelayDecoder 返回一个只从请求 body 中读取 {"messages": [...]} 的 decode，
+// 模型和生成的 token 上限由服务端指定，body 中的其他字段都会被忽略
+func ChatRelayDecoder(model string, maxTokens int64) func(r *http.Request) (*ChatCreateRequest, error) {
+	return func(r *http.Request) (*ChatCreateRequest, error) {
+		body, err := decodeJSONBody[struct {
+			Messages []*Message `json:"messages"`
+		}](r)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(body.Messages) == 0 {
+			return nil, errors.New("openai: messages is empty")
+		}
+
+		return &ChatCreateRequest{
+			Model:               model,
+			Messages:            body.Messages,
+			MaxCompletionTokens: maxTokens,
+		}, nil
+	}
+}
+
+// CompletionRelayDecoder 返回一个只从请求 body 中读取 {"prompt": "..."} 的 decode，规则同 ChatRelayDecoder
+func CompletionRelayDecoder(model string, maxTokens int64) func(r *http.Request) (*CompletionCreateRequest, error) {
+	return func(r *http.Request) (*CompletionCreateRequest, error) {
+		body, err := decodeJSONBody[struct {
+			Prompt string `json:"prompt"`
+		}](r)
+		if err != nil {
+			return nil, err
+		}
+
+		if body.Prompt == "" {
+			return nil, errors.New("openai: prompt is empty")
+		}
+
+		return &CompletionCreateRequest{
+			Model:     model,
+			Prompt:    body.Prompt,
+			MaxTokens: maxTokens,
+		}, nil
+	}
+}
+
+// decodeRelayRequest 限制请求 body 的大小后调用 decode，出错时写入错误响应，body 过大时返回 413，其他错误返回 400
+func decodeRelayRequest[T any](w http.ResponseWriter, r *http.Request, decode func(r *http.Request) (*T, error), cfg *relayConfig) (*T, error) {
+	if cfg.maxBodyBytes > 0 {
+		r.Body = &relayBody{
+			ReadCloser: http.MaxBytesReader(w, r.Body, cfg.maxBodyBytes),
+			limit:      cfg.maxBodyBytes,
+		}
+	}
+
+	req, err := decode(r)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrRelayBodyTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// relayBody 将 http.MaxBytesReader 超出上限的错误转换为 ErrRelayBodyTooLarge，便于判断
+type relayBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *relayBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && !errors.Is(err, io.EOF) && b.read >= b.limit {
+		err = ErrRelayBodyTooLarge
+	}
+	return n, err
+}
+
+func decodeJSONBody[T any](r *http.Request) (*T, error) {
+	var v T
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func chatDeltaText(resp *ChatCreateResponse) string {
+	if len(resp.Choices) == 0 || resp.Choices[0].Delta == nil {
+		return ""
+	}
+	return resp.Choices[0].Delta.Content
+}
+
+func completionText(resp *CompletionCreateResponse) string {
+	if len(resp.Choices) == 0 {
+		return ""
+	}
+	return resp.Choices[0].Text
+}
+
+func relay[T any](w http.ResponseWriter, r *http.Request, s *Stream[T], text func(T) string, cfg *relayConfig) error {
+	defer s.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// 避免 nginx 之类的反向代理缓冲响应
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	flush()
+
+	// Next 会阻塞，放到单独的 goroutine 中，以便同时处理心跳和客户端断开
+	chunks := make(chan T)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		defer close(chunks)
+		for s.Next() {
+			select {
+			case <-stop:
+				return
+			case chunks <- s.Current():
+			}
+		}
+	}()
+
+	var heartbeat <-chan time.Time
+	if cfg.heartbeat > 0 {
+		ticker := time.NewTicker(cfg.heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			// 客户端断开连接，立即关闭上游
+			_ = s.Close()
+			return r.Context().Err()
+		case <-heartbeat:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				_ = s.Close()
+				return err
+			}
+			flush()
+		case chunk, ok := <-chunks:
+			if !ok {
+				if err := s.Err(); err != nil {
+					_ = writeRelayError(w, err)
+					flush()
+					return err
+				}
+				if _, err := writeSSE(w, "", relayDoneData); err != nil {
+					return err
+				}
+				flush()
+				return nil
+			}
+
+			var data string
+			if cfg.mode == RelayModeText {
+				data = text(chunk)
+				if data == "" {
+					continue
+				}
+			} else {
+				b, err := json.Marshal(chunk)
+				if err != nil {
+					_ = s.Close()
+					return err
+				}
+				data = string(b)
+			}
+
+			if _, err := writeSSE(w, "", data); err != nil {
+				_ = s.Close()
+				return err
+			}
+			flush()
+		}
+	}
+}
+
+func writeRelayError(w io.Writer, err error) error {
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) {
+		apiErr = &APIError{
+			Message: err.Error(),
+			Type:    "upstream_error",
+		}
+	}
+
+	b, e := json.Marshal(map[string]*APIError{"error": apiErr})
+	if e != nil {
+		return e
+	}
+
+	_, e = writeSSE(w, relayErrorEvent, string(b))
+	return e
+}
+
+// writeSSE 写入一个 SSE 事件，data 中的换行会被拆分成多个 data 行，接收方会按照规范重新拼接
+func writeSSE(w io.Writer, event, data string) (int, error) {
+	var sb strings.Builder
+	if event != "" {
+		sb.WriteString(fmt.Sprintf("event: %s\n", event))
+	}
+	for _, line := range strings.Split(data, "\n") {
+		sb.WriteString("data: ")
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n")
+	return io.WriteString(w, sb.String())
+}
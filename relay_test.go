@@ -0,0 +1,245 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewChatRelayHandler(t *testing.T) {
+	chunks := []string{
+		mockChatChunk(0, "Hello", ""),
+		mockChatChunk(0, "\nworld", ""),
+		mockChatChunk(0, "", FinishReasonStop),
+	}
+
+	testCase := []struct {
+		name     string
+		done     bool
+		interval time.Duration
+		opts     []RelayOption
+		check    func(t *testing.T, body string)
+	}{
+		{
+			name: "test relay pass through",
+			done: true,
+			check: func(t *testing.T, body string) {
+				es := NewEventSource(context.TODO(), io.NopCloser(strings.NewReader(body)), "[DONE]")
+				count := 0
+				for e := range es {
+					require.NoError(t, e.Err)
+					var resp ChatCreateResponse
+					require.NoError(t, json.Unmarshal([]byte(e.Data), &resp))
+					count++
+				}
+				require.Equal(t, len(chunks), count)
+			},
+		},
+		{
+			name: "test relay text only",
+			done: true,
+			opts: []RelayOption{WithRelayMode(RelayModeText)},
+			check: func(t *testing.T, body string) {
+				require.Equal(t, "data: Hello\n\ndata: \ndata: world\n\ndata: [DONE]\n\n", body)
+			},
+		},
+		{
+			name: "test relay upstream error",
+			done: false,
+			opts: []RelayOption{WithRelayMode(RelayModeText)},
+			check: func(t *testing.T, body string) {
+				require.True(t, strings.HasSuffix(body, "event: error\ndata: {\"error\":{\"message\":\"unexpected EOF\",\"type\":\"upstream_error\"}}\n\n"), body)
+				require.NotContains(t, body, "[DONE]")
+			},
+		},
+		{
+			name:     "test relay heartbeat",
+			done:     true,
+			interval: 200 * time.Millisecond,
+			opts:     []RelayOption{WithRelayMode(RelayModeText), WithRelayHeartbeat(50 * time.Millisecond)},
+			check: func(t *testing.T, body string) {
+				require.Contains(t, body, ": ping\n\n")
+				require.True(t, strings.HasSuffix(body, "data: [DONE]\n\n"), body)
+			},
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream := newMockServer(newMockStreamServer(chunks, tc.interval, tc.done, nil))
+			defer upstream.Close()
+
+			client := newMockClient(upstream.URL)
+
+			server := newMockServer(NewChatRelayHandler(client.Chat, ChatRelayDecoder(GPT35Turbo, 256), tc.opts...).ServeHTTP)
+			defer server.Close()
+
+			resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{"messages":[{"role":"user","content":"hi"}]}`))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			tc.check(t, string(body))
+		})
+	}
+}
+
+func TestNewChatRelayHandler_ClientDisconnect(t *testing.T) {
+	disconnected := make(chan struct{})
+
+	chunks := make([]string, 0, 50)
+	for i := 0; i < 50; i++ {
+		chunks = append(chunks, mockChatChunk(0, "Hello", ""))
+	}
+
+	upstream := newMockServer(newMockStreamServer(chunks, 50*time.Millisecond, true, disconnected))
+	defer upstream.Close()
+
+	client := newMockClient(upstream.URL)
+
+	server := newMockServer(NewChatRelayHandler(client.Chat, ChatRelayDecoder(GPT35Turbo, 256), WithRelayMode(RelayModeText)).ServeHTTP)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, strings.NewReader(`{"messages":[{"role":"user","content":"hi"}]}`))
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "data: Hello\n", line)
+
+	cancel()
+
+	select {
+	case <-disconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream is not closed after client disconnected")
+	}
+}
+
+func TestNewChatRelayHandler_BadRequest(t *testing.T) {
+	client := newMockClient("http://127.0.0.1:0")
+
+	server := newMockServer(NewChatRelayHandler(client.Chat, ChatRelayDecoder(GPT35Turbo, 256)).ServeHTTP)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{invalid`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestNewChatRelayHandler_BodyTooLarge(t *testing.T) {
+	client := newMockClient("http://127.0.0.1:0")
+
+	server := newMockServer(NewChatRelayHandler(client.Chat, ChatRelayDecoder(GPT35Turbo, 256), WithRelayMaxBodyBytes(64)).ServeHTTP)
+	defer server.Close()
+
+	body := `{"messages": [{"role": "user", "content": "` + strings.Repeat("a", 128) + `"}]}`
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestNewChatRelayHandler_NilDecode(t *testing.T) {
+	client := newMockClient("http://127.0.0.1:0")
+
+	require.Panics(t, func() {
+		NewChatRelayHandler(client.Chat, nil)
+	})
+	require.Panics(t, func() {
+		NewCompletionRelayHandler(client.Completions, nil)
+	})
+}
+
+func TestChatRelayDecoder(t *testing.T) {
+	testCase := []struct {
+		name    string
+		body    string
+		want    *ChatCreateRequest
+		wantErr bool
+	}{
+		{
+			name: "test decode ignores client controlled fields",
+			body: `{"model":"gpt-4","n":10,"max_tokens":100000,"tools":[{"type":"function","function":{"name":"rm"}}],"messages":[{"role":"user","content":"hi"}]}`,
+			want: &ChatCreateRequest{
+				Model:               GPT35Turbo,
+				Messages:            []*Message{{Role: RoleUser, Content: "hi"}},
+				MaxCompletionTokens: 256,
+			},
+		},
+		{
+			name:    "test decode without messages",
+			body:    `{"model":"gpt-4"}`,
+			wantErr: true,
+		},
+		{
+			name:    "test decode invalid json",
+			body:    `{invalid`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+			require.NoError(t, err)
+
+			got, err := ChatRelayDecoder(GPT35Turbo, 256)(r)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestCompletionRelayDecoder(t *testing.T) {
+	r, err := http.NewRequest(http.MethodPost, "/", strings.NewReader(`{"model":"text-davinci-003","n":5,"prompt":"Say this is a test"}`))
+	require.NoError(t, err)
+
+	got, err := CompletionRelayDecoder(TextDaVinci003, 16)(r)
+	require.NoError(t, err)
+	require.Equal(t, &CompletionCreateRequest{Model: TextDaVinci003, Prompt: "Say this is a test", MaxTokens: 16}, got)
+
+	r, err = http.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+	require.NoError(t, err)
+
+	_, err = CompletionRelayDecoder(TextDaVinci003, 16)(r)
+	require.Error(t, err)
+}
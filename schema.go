@@ -0,0 +1,626 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SchemaTypeObject  = "object"
+	SchemaTypeArray   = "array"
+	SchemaTypeString  = "string"
+	SchemaTypeInteger = "integer"
+	SchemaTypeNumber  = "number"
+	SchemaTypeBoolean = "boolean"
+	SchemaTypeNull    = "null"
+
+	schemaDefsPrefix = "#/$defs/"
+)
+
+// UnmarshalJSON additionalProperties 既可能是 bool 也可能是 schema，需要区分处理
+func (p *Property) UnmarshalJSON(data []byte) error {
+	type alias Property
+	aux := struct {
+		*alias
+		AdditionalProperties json.RawMessage `json:"additionalProperties,omitempty"`
+	}{
+		alias: (*alias)(p),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	p.AdditionalProperties = nil
+	if len(aux.AdditionalProperties) == 0 || string(aux.AdditionalProperties) == "null" {
+		return nil
+	}
+
+	var b bool
+	if err := json.Unmarshal(aux.AdditionalProperties, &b); err == nil {
+		p.AdditionalProperties = b
+		return nil
+	}
+
+	var schema Property
+	if err := json.Unmarshal(aux.AdditionalProperties, &schema); err != nil {
+		return err
+	}
+	p.AdditionalProperties = &schema
+
+	return nil
+}
+
+// JSONSchemer 自定义类型的 JSON Schema，实现了该接口的类型会直接使用返回的 schema
+type JSONSchemer interface {
+	JSONSchema() *Property
+}
+
+// SchemaReflector 通过反射将 Go 的类型转换为 JSON Schema，规则如下：
+//   - 字段名使用 json tag，json:"-" 的字段和未导出的字段会被忽略，匿名嵌入的结构体会被展开
+//   - 没有 omitempty 的字段为必填，也可以通过 jsonschema tag 中的 required 或 optional 指定
+//   - jsonschema tag 支持 title、description、enum、default、format、pattern、minimum、maximum、
+//     exclusiveMinimum、exclusiveMaximum、multipleOf、minLength、maxLength、minItems、maxItems、uniqueItems，
+//     多个值用逗号分隔，值中的逗号用 \, 转义，enum 可以重复出现，例如 jsonschema:"description=城市,enum=北京,enum=上海"
+//   - 描述中包含较多逗号时也可以使用单独的 jsonschema_description tag
+//   - 递归引用的结构体会放到 $defs 中并通过 $ref 引用，UseRefs 为 true 时所有具名的结构体都会这样处理
+type SchemaReflector struct {
+	// UseRefs 将所有具名的结构体放到 $defs 中，通过 $ref 引用
+	UseRefs bool
+	// DisallowAdditionalProperties 所有 object 都设置 additionalProperties: false
+	DisallowAdditionalProperties bool
+	// Strict 生成满足 structured outputs strict 模式的 schema：所有字段都是必填，可选的字段改为可以为 null，
+	// 并且所有 object 都设置 additionalProperties: false
+	Strict bool
+}
+
+// GenerateSchema 使用默认的 SchemaReflector 生成 T 的 JSON Schema，可以直接用于 Function.Parameters
+func GenerateSchema[T any]() (*Parameter, error) {
+	var reflector SchemaReflector
+	return reflector.Reflect(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// MustGenerateSchema 与 GenerateSchema 相同，出错时 panic，适合在包初始化时使用
+func MustGenerateSchema[T any]() *Parameter {
+	schema, err := GenerateSchema[T]()
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// Reflect 生成 t 的 JSON Schema
+func (r *SchemaReflector) Reflect(t reflect.Type) (*Property, error) {
+	ctx := &schemaContext{
+		reflector: r,
+		root:      derefType(t),
+		defs:      make(map[string]*Property),
+		visiting:  make(map[reflect.Type]bool),
+		recursive: make(map[reflect.Type]bool),
+		names:     make(map[reflect.Type]string),
+		taken:     make(map[string]bool),
+	}
+
+	schema, err := ctx.reflectType(t, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ctx.defs) > 0 {
+		schema.Defs = ctx.defs
+	}
+
+	return schema, nil
+}
+
+type schemaContext struct {
+	reflector *SchemaReflector
+	root      reflect.Type
+	defs      map[string]*Property
+	visiting  map[reflect.Type]bool
+	recursive map[reflect.Type]bool
+	names     map[reflect.Type]string // 类型在 $defs 中的名称
+	taken     map[string]bool
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	rawMessageType  = reflect.TypeOf(json.RawMessage{})
+	schemerType     = reflect.TypeOf((*JSONSchemer)(nil)).Elem()
+	textMarshalType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func (c *schemaContext) reflectType(t reflect.Type, isRoot bool) (*Property, error) {
+	t = derefType(t)
+
+	if t.Implements(schemerType) {
+		return reflect.Zero(t).Interface().(JSONSchemer).JSONSchema(), nil
+	}
+	if reflect.PtrTo(t).Implements(schemerType) {
+		return reflect.New(t).Interface().(JSONSchemer).JSONSchema(), nil
+	}
+
+	switch t {
+	case timeType:
+		return &Property{Type: SchemaTypeString, Format: "date-time"}, nil
+	case rawMessageType:
+		return &Property{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Property{Type: SchemaTypeBoolean}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Property{Type: SchemaTypeInteger}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &Property{Type: SchemaTypeInteger, Minimum: &zero}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Property{Type: SchemaTypeNumber}, nil
+	case reflect.String:
+		return &Property{Type: SchemaTypeString}, nil
+	case reflect.Interface:
+		return &Property{}, nil
+	case reflect.Slice, reflect.Array:
+		// []byte 会被 encoding/json 编码为 base64 字符串
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			return &Property{Type: SchemaTypeString, Format: "byte"}, nil
+		}
+		items, err := c.reflectType(t.Elem(), false)
+		if err != nil {
+			return nil, err
+		}
+		p := &Property{Type: SchemaTypeArray, Items: items}
+		if t.Kind() == reflect.Array {
+			n := int64(t.Len())
+			p.MinItems, p.MaxItems = &n, &n
+		}
+		return p, nil
+	case reflect.Map:
+		key := t.Key()
+		if key.Kind() != reflect.String && !key.Implements(textMarshalType) && !isIntegerKind(key.Kind()) {
+			return nil, fmt.Errorf("openai: unsupported map key type %s", key)
+		}
+		value, err := c.reflectType(t.Elem(), false)
+		if err != nil {
+			return nil, err
+		}
+		return &Property{Type: SchemaTypeObject, AdditionalProperties: value}, nil
+	case reflect.Struct:
+		return c.reflectStructRef(t, isRoot)
+	default:
+		return nil, fmt.Errorf("openai: unsupported type %s", t)
+	}
+}
+
+func isIntegerKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// reflectStructRef 处理结构体的引用，递归引用或者 UseRefs 时放到 $defs 中
+func (c *schemaContext) reflectStructRef(t reflect.Type, isRoot bool) (*Property, error) {
+	named := t.Name() != ""
+
+	// 递归引用自身
+	if c.visiting[t] {
+		c.recursive[t] = true
+		if t == c.root {
+			return &Property{Ref: "#"}, nil
+		}
+		return &Property{Ref: schemaDefsPrefix + c.defName(t)}, nil
+	}
+
+	if !isRoot && named && c.reflector.UseRefs {
+		name := c.defName(t)
+		if _, ok := c.defs[name]; !ok {
+			// 先占位，避免递归时重复生成
+			c.defs[name] = &Property{}
+			schema, err := c.reflectStruct(t)
+			if err != nil {
+				return nil, err
+			}
+			c.defs[name] = schema
+		}
+		return &Property{Ref: schemaDefsPrefix + name}, nil
+	}
+
+	schema, err := c.reflectStruct(t)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isRoot && c.recursive[t] {
+		name := c.defName(t)
+		c.defs[name] = schema
+		return &Property{Ref: schemaDefsPrefix + name}, nil
+	}
+
+	return schema, nil
+}
+
+// defName 返回类型在 $defs 中的名称，优先使用类型名，与其他类型重名时依次尝试加上包名和数字后缀
+func (c *schemaContext) defName(t reflect.Type) string {
+	if name, ok := c.names[t]; ok {
+		return name
+	}
+
+	base := t.Name()
+	if base == "" {
+		base = "anonymous"
+	}
+
+	candidates := []string{base}
+	if t.Name() != "" && t.PkgPath() != "" {
+		pkg := strings.NewReplacer(".", "_", "-", "_").Replace(path.Base(t.PkgPath()))
+		candidates = append(candidates, pkg+"_"+base)
+	}
+
+	name := ""
+	for _, candidate := range candidates {
+		if !c.taken[candidate] {
+			name = candidate
+			break
+		}
+	}
+
+	for i := 2; name == ""; i++ {
+		if candidate := fmt.Sprintf("%s_%d", base, i); !c.taken[candidate] {
+			name = candidate
+		}
+	}
+
+	c.names[t] = name
+	c.taken[name] = true
+
+	return name
+}
+
+func (c *schemaContext) reflectStruct(t reflect.Type) (*Property, error) {
+	c.visiting[t] = true
+	defer delete(c.visiting, t)
+
+	schema := &Property{
+		Type:       SchemaTypeObject,
+		Properties: make(map[string]*Property),
+	}
+
+	if c.reflector.DisallowAdditionalProperties || c.reflector.Strict {
+		schema.AdditionalProperties = false
+	}
+
+	if err := c.reflectFields(t, schema); err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+func (c *schemaContext) reflectFields(t reflect.Type, schema *Property) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		jsonTag := f.Tag.Get("json")
+		if jsonTag == "-" {
+			continue
+		}
+
+		name, opts := parseJSONTag(jsonTag)
+
+		// 匿名嵌入且没有指定名称的结构体，与 encoding/json 一样展开
+		if f.Anonymous && name == "" {
+			ft := derefType(f.Type)
+			if ft.Kind() == reflect.Struct {
+				if err := c.reflectFields(ft, schema); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		prop, err := c.reflectType(f.Type, false)
+		if err != nil {
+			return fmt.Errorf("openai: field %s.%s: %w", t.Name(), f.Name, err)
+		}
+
+		required := !opts.contains("omitempty")
+
+		tag, err := parseSchemaTag(f.Tag.Get("jsonschema"))
+		if err != nil {
+			return fmt.Errorf("openai: field %s.%s: %w", t.Name(), f.Name, err)
+		}
+
+		if desc := f.Tag.Get("jsonschema_description"); desc != "" {
+			tag.description = desc
+		}
+
+		if tag.required != nil {
+			required = *tag.required
+		}
+
+		// $ref 不能和其他关键字一起使用，需要包一层 allOf
+		if prop.Ref != "" && tag.hasKeywords() {
+			prop = &Property{AllOf: []*Property{prop}}
+		}
+
+		if err := tag.apply(prop, derefType(f.Type)); err != nil {
+			return fmt.Errorf("openai: field %s.%s: %w", t.Name(), f.Name, err)
+		}
+
+		// strict 模式下所有字段都必须是必填，可选的字段通过允许 null 来表示
+		if c.reflector.Strict {
+			if !required {
+				prop = &Property{AnyOf: []*Property{prop, {Type: SchemaTypeNull}}}
+			}
+			required = true
+		}
+
+		if _, ok := schema.Properties[name]; !ok && required {
+			schema.Required = append(schema.Required, name)
+		}
+
+		schema.Properties[name] = prop
+	}
+
+	return nil
+}
+
+type jsonTagOptions string
+
+func parseJSONTag(tag string) (string, jsonTagOptions) {
+	if i := strings.Index(tag, ","); i >= 0 {
+		return tag[:i], jsonTagOptions(tag[i+1:])
+	}
+	return tag, ""
+}
+
+func (o jsonTagOptions) contains(name string) bool {
+	for _, opt := range strings.Split(string(o), ",") {
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+type schemaTag struct {
+	required    *bool
+	title       string
+	description string
+	format      string
+	pattern     string
+	enum        []string
+	def         *string
+	numbers     map[string]float64
+	ints        map[string]int64
+	uniqueItems bool
+}
+
+func (t *schemaTag) hasKeywords() bool {
+	return t.title != "" || t.description != "" || t.format != "" || t.pattern != "" ||
+		len(t.enum) > 0 || t.def != nil || len(t.numbers) > 0 || len(t.ints) > 0 || t.uniqueItems
+}
+
+// parseSchemaTag 解析 jsonschema tag，值中的逗号需要用 \, 转义
+func parseSchemaTag(tag string) (*schemaTag, error) {
+	st := &schemaTag{
+		numbers: make(map[string]float64),
+		ints:    make(map[string]int64),
+	}
+
+	if tag == "" {
+		return st, nil
+	}
+
+	for _, part := range splitEscaped(tag, ',') {
+		key, value, hasValue := strings.Cut(part, "=")
+		key = strings.TrimSpace(key)
+
+		switch key {
+		case "required":
+			b := true
+			st.required = &b
+		case "optional":
+			b := false
+			st.required = &b
+		case "uniqueItems":
+			st.uniqueItems = true
+		case "title":
+			st.title = value
+		case "description":
+			st.description = value
+		case "format":
+			st.format = value
+		case "pattern":
+			st.pattern = value
+		case "enum":
+			st.enum = append(st.enum, value)
+		case "default":
+			v := value
+			st.def = &v
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			st.numbers[key] = n
+		case "minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			st.ints[key] = n
+		case "":
+		default:
+			if !hasValue {
+				return nil, fmt.Errorf("unknown jsonschema option %q", key)
+			}
+			return nil, fmt.Errorf("unknown jsonschema keyword %q", key)
+		}
+	}
+
+	return st, nil
+}
+
+func splitEscaped(s string, sep byte) []string {
+	var (
+		parts []string
+		sb    strings.Builder
+	)
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && s[i+1] == sep {
+			sb.WriteByte(sep)
+			i++
+			continue
+		}
+		if s[i] == sep {
+			parts = append(parts, sb.String())
+			sb.Reset()
+			continue
+		}
+		sb.WriteByte(s[i])
+	}
+	return append(parts, sb.String())
+}
+
+// apply 将 tag 中的关键字应用到 p 上，enum 和 default 会按照字段的类型转换
+func (t *schemaTag) apply(p *Property, ft reflect.Type) error {
+	if t.title != "" {
+		p.Title = t.title
+	}
+	if t.description != "" {
+		p.Description = t.description
+	}
+	if t.format != "" {
+		p.Format = t.format
+	}
+	if t.pattern != "" {
+		p.Pattern = t.pattern
+	}
+	if t.uniqueItems {
+		p.UniqueItems = true
+	}
+
+	// 数组的 enum 和 default 作用于元素
+	valueType := ft
+	if ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array {
+		valueType = derefType(ft.Elem())
+	}
+
+	for _, e := range t.enum {
+		v, err := parseSchemaValue(e, valueType)
+		if err != nil {
+			return fmt.Errorf("invalid enum %q: %w", e, err)
+		}
+		if valueType != ft && p.Items != nil {
+			p.Items.Enum = append(p.Items.Enum, v)
+		} else {
+			p.Enum = append(p.Enum, v)
+		}
+	}
+
+	if t.def != nil {
+		v, err := parseSchemaValue(*t.def, ft)
+		if err != nil {
+			return fmt.Errorf("invalid default %q: %w", *t.def, err)
+		}
+		p.Default = v
+	}
+
+	for key, n := range t.numbers {
+		n := n
+		switch key {
+		case "minimum":
+			p.Minimum = &n
+		case "maximum":
+			p.Maximum = &n
+		case "exclusiveMinimum":
+			p.ExclusiveMinimum = &n
+		case "exclusiveMaximum":
+			p.ExclusiveMaximum = &n
+		case "multipleOf":
+			p.MultipleOf = &n
+		}
+	}
+
+	for key, n := range t.ints {
+		n := n
+		switch key {
+		case "minLength":
+			p.MinLength = &n
+		case "maxLength":
+			p.MaxLength = &n
+		case "minItems":
+			p.MinItems = &n
+		case "maxItems":
+			p.MaxItems = &n
+		case "minProperties":
+			p.MinProperties = &n
+		case "maxProperties":
+			p.MaxProperties = &n
+		}
+	}
+
+	return nil
+}
+
+func parseSchemaValue(s string, t reflect.Type) (any, error) {
+	switch t.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(s, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(s, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(s, 64)
+	case reflect.String:
+		return s, nil
+	default:
+		// 复杂类型的 default 使用 JSON 表示
+		var v any
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type schemaTestBase struct {
+	Id string `json:"id" jsonschema:"description=唯一标识"`
+}
+
+type schemaTestAddress struct {
+	City    string `json:"city" jsonschema:"enum=北京,enum=上海"`
+	Street  string `json:"street,omitempty"`
+	ZipCode string `json:"zip_code,omitempty" jsonschema:"required,pattern=^[0-9]{6}$"`
+}
+
+type schemaTestUser struct {
+	schemaTestBase
+	Name      string              `json:"name" jsonschema:"minLength=1,maxLength=20" jsonschema_description:"姓名, 不能为空"`
+	Age       int                 `json:"age,omitempty" jsonschema:"minimum=0,maximum=150,default=18"`
+	Score     uint                `json:"score"`
+	Ratio     float64             `json:"ratio" jsonschema:"description=比例\\, 0 到 1 之间,exclusiveMaximum=1"`
+	Active    bool                `json:"active" jsonschema:"optional"`
+	Tags      []string            `json:"tags" jsonschema:"enum=a,enum=b,uniqueItems"`
+	Matrix    [][]float64         `json:"matrix,omitempty"`
+	Address   *schemaTestAddress  `json:"address"`
+	Labels    map[string]string   `json:"labels,omitempty"`
+	CreatedAt time.Time           `json:"created_at"`
+	Extra     json.RawMessage     `json:"extra,omitempty"`
+	Ignored   string              `json:"-"`
+	private   string              //nolint:unused
+	Friends   []*schemaTestFriend `json:"friends,omitempty"`
+}
+
+type schemaTestFriend struct {
+	Name    string              `json:"name"`
+	Friends []*schemaTestFriend `json:"friends,omitempty"`
+}
+
+type schemaTestColor string
+
+func (schemaTestColor) JSONSchema() *Property {
+	return &Property{Type: SchemaTypeString, Enum: []any{"red", "green"}}
+}
+
+func TestSchemaReflector_Reflect(t *testing.T) {
+	testCase := []struct {
+		name      string
+		reflector SchemaReflector
+		typ       reflect.Type
+		wantJSON  string
+		wantErr   bool
+	}{
+		{
+			name: "test reflect struct",
+			typ:  reflect.TypeOf(schemaTestUser{}),
+			wantJSON: `{
+				"type": "object",
+				"properties": {
+					"id": {"type": "string", "description": "唯一标识"},
+					"name": {"type": "string", "description": "姓名, 不能为空", "minLength": 1, "maxLength": 20},
+					"age": {"type": "integer", "minimum": 0, "maximum": 150, "default": 18},
+					"score": {"type": "integer", "minimum": 0},
+					"ratio": {"type": "number", "description": "比例, 0 到 1 之间", "exclusiveMaximum": 1},
+					"active": {"type": "boolean"},
+					"tags": {"type": "array", "items": {"type": "string", "enum": ["a", "b"]}, "uniqueItems": true},
+					"matrix": {"type": "array", "items": {"type": "array", "items": {"type": "number"}}},
+					"address": {
+						"type": "object",
+						"properties": {
+							"city": {"type": "string", "enum": ["北京", "上海"]},
+							"street": {"type": "string"},
+							"zip_code": {"type": "string", "pattern": "^[0-9]{6}$"}
+						},
+						"required": ["city", "zip_code"]
+					},
+					"labels": {"type": "object", "additionalProperties": {"type": "string"}},
+					"created_at": {"type": "string", "format": "date-time"},
+					"extra": {},
+					"friends": {"type": "array", "items": {"$ref": "#/$defs/schemaTestFriend"}}
+				},
+				"required": ["id", "name", "score", "ratio", "tags", "address", "created_at"],
+				"$defs": {
+					"schemaTestFriend": {
+						"type": "object",
+						"properties": {
+							"name": {"type": "string"},
+							"friends": {"type": "array", "items": {"$ref": "#/$defs/schemaTestFriend"}}
+						},
+						"required": ["name"]
+					}
+				}
+			}`,
+		},
+		{
+			name:      "test reflect with refs and disallow additional properties",
+			reflector: SchemaReflector{UseRefs: true, DisallowAdditionalProperties: true},
+			typ: reflect.TypeOf(struct {
+				Home  schemaTestAddress  `json:"home"`
+				Work  *schemaTestAddress `json:"work,omitempty" jsonschema:"description=工作地址"`
+				Color schemaTestColor    `json:"color"`
+			}{}),
+			wantJSON: `{
+				"type": "object",
+				"additionalProperties": false,
+				"properties": {
+					"home": {"$ref": "#/$defs/schemaTestAddress"},
+					"work": {"allOf": [{"$ref": "#/$defs/schemaTestAddress"}], "description": "工作地址"},
+					"color": {"type": "string", "enum": ["red", "green"]}
+				},
+				"required": ["home", "color"],
+				"$defs": {
+					"schemaTestAddress": {
+						"type": "object",
+						"additionalProperties": false,
+						"properties": {
+							"city": {"type": "string", "enum": ["北京", "上海"]},
+							"street": {"type": "string"},
+							"zip_code": {"type": "string", "pattern": "^[0-9]{6}$"}
+						},
+						"required": ["city", "zip_code"]
+					}
+				}
+			}`,
+		},
+		{
+			name:      "test reflect strict",
+			reflector: SchemaReflector{Strict: true},
+			typ:       reflect.TypeOf(schemaTestAddress{}),
+			wantJSON: `{
+				"type": "object",
+				"additionalProperties": false,
+				"properties": {
+					"city": {"type": "string", "enum": ["北京", "上海"]},
+					"street": {"anyOf": [{"type": "string"}, {"type": "null"}]},
+					"zip_code": {"type": "string", "pattern": "^[0-9]{6}$"}
+				},
+				"required": ["city", "street", "zip_code"]
+			}`,
+		},
+		{
+			name:     "test reflect recursive root",
+			typ:      reflect.TypeOf(&schemaTestFriend{}),
+			wantJSON: `{"type": "object", "properties": {"name": {"type": "string"}, "friends": {"type": "array", "items": {"$ref": "#"}}}, "required": ["name"]}`,
+		},
+		{
+			name: "test reflect unsupported type",
+			typ: reflect.TypeOf(struct {
+				C chan int `json:"c"`
+			}{}),
+			wantErr: true,
+		},
+		{
+			name: "test reflect invalid tag",
+			typ: reflect.TypeOf(struct {
+				N int `json:"n" jsonschema:"minimum=abc"`
+			}{}),
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			schema, err := tc.reflector.Reflect(tc.typ)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			b, err := json.Marshal(schema)
+			require.NoError(t, err)
+			require.JSONEq(t, tc.wantJSON, string(b))
+
+			// 反序列化之后应该与原 schema 序列化的结果一致
+			var got Property
+			require.NoError(t, json.Unmarshal(b, &got))
+			b2, err := json.Marshal(&got)
+			require.NoError(t, err)
+			require.JSONEq(t, string(b), string(b2))
+		})
+	}
+}
+
+func TestGenerateSchema(t *testing.T) {
+	schema, err := GenerateSchema[weatherArgs]()
+	require.NoError(t, err)
+	require.Equal(t, &Parameter{
+		Type: SchemaTypeObject,
+		Properties: map[string]*Property{
+			"location": {Type: SchemaTypeString},
+		},
+		Required: []string{"location"},
+	}, schema)
+}
+
+func TestSchemaReflector_DefNameCollision(t *testing.T) {
+	// 与包级别的 schemaTestAddress 同名但是不同的类型
+	type schemaTestAddress struct {
+		Country string `json:"country"`
+	}
+	// 与 url.Userinfo 同名
+	type Userinfo struct {
+		Name string `json:"name"`
+	}
+
+	reflector := SchemaReflector{UseRefs: true}
+	schema, err := reflector.Reflect(reflect.TypeOf(struct {
+		Home    schemaTestAddress  `json:"home"`
+		Work    *schemaTestAddress `json:"work"`
+		User    Userinfo           `json:"user"`
+		URLUser url.Userinfo       `json:"url_user"`
+		Nested  struct {
+			City string `json:"city"`
+		} `json:"nested"`
+	}{}))
+	require.NoError(t, err)
+
+	require.Equal(t, "#/$defs/schemaTestAddress", schema.Properties["home"].Ref)
+	require.Equal(t, "#/$defs/schemaTestAddress", schema.Properties["work"].Ref)
+	require.Equal(t, "#/$defs/Userinfo", schema.Properties["user"].Ref)
+	require.Equal(t, "#/$defs/url_Userinfo", schema.Properties["url_user"].Ref)
+	require.Equal(t, SchemaTypeObject, schema.Properties["nested"].Type)
+
+	require.Len(t, schema.Defs, 3)
+	require.Contains(t, schema.Defs["schemaTestAddress"].Properties, "country")
+	require.Contains(t, schema.Defs["Userinfo"].Properties, "name")
+	require.Empty(t, schema.Defs["url_Userinfo"].Properties)
+
+	// 同一次生成中同名的不同类型不会互相覆盖
+	schema, err = reflector.Reflect(reflect.TypeOf(struct {
+		Local  schemaTestAddress      `json:"local"`
+		Global schemaTestAddressAlias `json:"global"`
+	}{}))
+	require.NoError(t, err)
+	require.Equal(t, "#/$defs/schemaTestAddress", schema.Properties["local"].Ref)
+	require.Equal(t, "#/$defs/openai_schemaTestAddress", schema.Properties["global"].Ref)
+	require.Contains(t, schema.Defs["schemaTestAddress"].Properties, "country")
+	require.Contains(t, schema.Defs["openai_schemaTestAddress"].Properties, "city")
+}
+
+// schemaTestAddressAlias 在函数内部引用被遮蔽的包级别 schemaTestAddress
+type schemaTestAddressAlias = schemaTestAddress
@@ -0,0 +1,186 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Stream 迭代器风格的流式响应，用法如下：
+//
+//	s, err := client.Chat.CreateStream(ctx, req)
+//	if err != nil {
+//		// handle error
+//	}
+//	defer s.Close()
+//
+//	for s.Next() {
+//		chunk := s.Current()
+//		// handle chunk
+//	}
+//
+//	if err := s.Err(); err != nil {
+//		// 流被截断或者出错
+//	}
+//
+// 与 channel 风格的 API 不同，消费者可以随时调用 Close 提前结束，底层连接会被立即断开
+type Stream[T any] struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	events EventSource
+
+	cur  T
+	err  error
+	done bool
+}
+
+// NewStream 基于 EventSource 创建一个 Stream
+// ctx 为调用方的 context，用于区分调用方取消和 Close；cancel 用于在 Close 时断开底层连接，可以为 nil
+func NewStream[T any](ctx context.Context, es EventSource, cancel context.CancelFunc) *Stream[T] {
+	if cancel == nil {
+		cancel = func() {}
+	}
+	return &Stream[T]{
+		ctx:    ctx,
+		cancel: cancel,
+		events: es,
+	}
+}
+
+// newStream 通过 connect 建立流式连接，connect 使用的 context 会在 Close 时被取消
+func newStream[T any](ctx context.Context, connect func(ctx context.Context) (EventSource, error)) (*Stream[T], error) {
+	streamCtx, cancel := context.WithCancel(ctx)
+
+	es, err := connect(streamCtx)
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return NewStream[T](ctx, es, cancel), nil
+}
+
+// Next 读取下一个元素，返回 false 表示流已经结束、出错或者被关闭，此时可以通过 Err 判断原因
+func (s *Stream[T]) Next() bool {
+	if s.done {
+		return false
+	}
+
+	e, ok := <-s.events
+
+	// 没有数据的事件（例如只有 id 或者 retry）不需要交给消费者
+	for ok && e.Err == nil && e.Data == "" {
+		e, ok = <-s.events
+	}
+
+	if !ok {
+		s.done = true
+		// 调用方取消，流并没有完整结束，需要告知调用方
+		if err := s.ctx.Err(); err != nil {
+			s.err = err
+		}
+		s.cancel()
+		return false
+	}
+
+	var cur T
+	if err := decodeEvent(e, &cur); err != nil {
+		s.done = true
+		s.err = err
+		s.cancel()
+		return false
+	}
+
+	s.cur = cur
+
+	return true
+}
+
+// Current 返回当前元素，只有在 Next 返回 true 之后调用才有意义
+func (s *Stream[T]) Current() T {
+	return s.cur
+}
+
+// Err 返回流结束的原因，为 nil 表示流正常结束或者被 Close
+func (s *Stream[T]) Err() error {
+	return s.err
+}
+
+// Close 立即断开底层连接，可以重复调用，也可以在其他 goroutine 中调用来打断阻塞中的 Next
+func (s *Stream[T]) Close() error {
+	s.cancel()
+	return nil
+}
+
+// mapStream 返回一个新的 Stream，s 中的每个 chunk 经过 fn 转换后交给消费者，
+// s 正常结束后 finish 返回的 chunk 会追加在最后，finish 可以为 nil。
+// 返回的 Stream 被 Close 时会同时关闭 s，避免 s 没有新数据时转换的 goroutine 一直阻塞
+func mapStream[T any](s *Stream[T], fn func(chunk T) T, finish func() []T) *Stream[T] {
+	ctx, cancel := context.WithCancel(s.ctx)
+	es := make(EventSource)
+
+	go func() {
+		defer func() {
+			_ = s.Close()
+			close(es)
+		}()
+
+		send := func(e Event) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case es <- e:
+				return true
+			}
+		}
+
+		emit := func(chunk T) bool {
+			data, err := json.Marshal(chunk)
+			if err != nil {
+				send(Event{Err: err})
+				return false
+			}
+			return send(Event{Data: string(data)})
+		}
+
+		for s.Next() {
+			if !emit(fn(s.Current())) {
+				return
+			}
+		}
+
+		if err := s.Err(); err != nil {
+			send(Event{Err: err})
+			return
+		}
+
+		if finish == nil {
+			return
+		}
+
+		for _, chunk := range finish() {
+			if !emit(chunk) {
+				return
+			}
+		}
+	}()
+
+	return NewStream[T](s.ctx, es, func() {
+		cancel()
+		_ = s.Close()
+	})
+}
@@ -0,0 +1,223 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newMockStreamServer 模拟一个流式响应的服务端，每个 chunk 之后都会 flush，
+// 如果 done 为 true，最后会发送 [DONE]，连接断开时会通知 disconnected
+func newMockStreamServer(chunks []string, interval time.Duration, done bool, disconnected chan<- struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		flusher := w.(http.Flusher)
+		flusher.Flush()
+
+		for _, chunk := range chunks {
+			select {
+			case <-r.Context().Done():
+				if disconnected != nil {
+					close(disconnected)
+				}
+				return
+			case <-time.After(interval):
+			}
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", chunk)
+			flusher.Flush()
+		}
+
+		if done {
+			_, _ = io.WriteString(w, "data: [DONE]\n\n")
+		}
+	}
+}
+
+func mockChatChunk(index int64, content, finishReason string) string {
+	fr := "null"
+	if finishReason != "" {
+		fr = fmt.Sprintf("%q", finishReason)
+	}
+	return fmt.Sprintf(`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1694268190,"choices":[{"index":%d,"delta":{"content":%q},"finish_reason":%s}]}`, index, content, fr)
+}
+
+func TestChatServiceOp_CreateStream(t *testing.T) {
+	chunks := []string{
+		mockChatChunk(0, "Hello", ""),
+		mockChatChunk(0, " world", ""),
+		mockChatChunk(0, "", FinishReasonStop),
+	}
+
+	req := &ChatCreateRequest{
+		Model:    GPT35Turbo,
+		Messages: []*Message{{Role: "user", Content: "Hello"}},
+	}
+
+	t.Run("test chat create stream finished", func(t *testing.T) {
+		server := newMockServer(newMockStreamServer(chunks, 0, true, nil))
+		defer server.Close()
+
+		client := newMockClient(server.URL)
+
+		s, err := client.Chat.CreateStream(context.TODO(), req)
+		require.NoError(t, err)
+		defer s.Close()
+
+		var sb strings.Builder
+		for s.Next() {
+			sb.WriteString(s.Current().Choices[0].Delta.Content)
+		}
+
+		require.NoError(t, s.Err())
+		require.Equal(t, "Hello world", sb.String())
+		require.False(t, req.Stream, "request of caller should not be modified")
+	})
+
+	t.Run("test chat create stream truncated", func(t *testing.T) {
+		server := newMockServer(newMockStreamServer(chunks, 0, false, nil))
+		defer server.Close()
+
+		client := newMockClient(server.URL)
+
+		s, err := client.Chat.CreateStream(context.TODO(), req)
+		require.NoError(t, err)
+		defer s.Close()
+
+		count := 0
+		for s.Next() {
+			count++
+		}
+
+		require.Equal(t, len(chunks), count)
+		require.ErrorIs(t, s.Err(), io.ErrUnexpectedEOF)
+	})
+
+	t.Run("test chat create stream close early", func(t *testing.T) {
+		disconnected := make(chan struct{})
+		server := newMockServer(newMockStreamServer(chunks, 200*time.Millisecond, true, disconnected))
+		defer server.Close()
+
+		client := newMockClient(server.URL)
+
+		s, err := client.Chat.CreateStream(context.TODO(), req)
+		require.NoError(t, err)
+
+		require.True(t, s.Next())
+		require.NoError(t, s.Close())
+
+		select {
+		case <-disconnected:
+		case <-time.After(time.Second):
+			t.Fatal("connection is not closed after Close")
+		}
+
+		require.False(t, s.Next())
+		require.NoError(t, s.Err())
+	})
+
+	t.Run("test chat create stream canceled", func(t *testing.T) {
+		server := newMockServer(newMockStreamServer(chunks, 200*time.Millisecond, true, nil))
+		defer server.Close()
+
+		client := newMockClient(server.URL)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s, err := client.Chat.CreateStream(ctx, req)
+		require.NoError(t, err)
+		defer s.Close()
+
+		require.True(t, s.Next())
+		cancel()
+
+		for s.Next() {
+		}
+
+		require.ErrorIs(t, s.Err(), context.Canceled)
+	})
+}
+
+func TestCompletionServiceOp_CreateStream(t *testing.T) {
+	chunks := []string{
+		`{"id":"cmpl-123","object":"text_completion","created":1589478378,"choices":[{"text":"This is","index":0,"finish_reason":null}]}`,
+		`{"id":"cmpl-123","object":"text_completion","created":1589478378,"choices":[{"text":" a test","index":0,"finish_reason":"length"}]}`,
+	}
+
+	server := newMockServer(newMockStreamServer(chunks, 0, true, nil))
+	defer server.Close()
+
+	client := newMockClient(server.URL)
+
+	s, err := client.Completions.CreateStream(context.TODO(), &CompletionCreateRequest{
+		Model:  TextDaVinci003,
+		Prompt: "Say this is a test",
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	var sb strings.Builder
+	for s.Next() {
+		sb.WriteString(s.Current().Choices[0].Text)
+	}
+
+	require.NoError(t, s.Err())
+	require.Equal(t, "This is a test", sb.String())
+}
+
+func TestMapStream_Close(t *testing.T) {
+	chunks := []string{
+		mockChatChunk(0, "Hello", ""),
+		mockChatChunk(0, " world", ""),
+	}
+
+	disconnected := make(chan struct{})
+	// 上游在第一个 chunk 之前长时间没有数据
+	server := newMockServer(newMockStreamServer(chunks, 10*time.Second, true, disconnected))
+	defer server.Close()
+
+	client := newMockClient(server.URL)
+
+	s, err := client.Chat.CreateStream(context.TODO(), &ChatCreateRequest{
+		Model:    GPT35Turbo,
+		Messages: []*Message{{Role: "user", Content: "Hello"}},
+	})
+	require.NoError(t, err)
+
+	mapped := mapStream(s, func(chunk *ChatCreateResponse) *ChatCreateResponse {
+		return chunk
+	}, nil)
+
+	start := time.Now()
+	require.NoError(t, mapped.Close())
+
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("upstream connection is not closed after Close")
+	}
+
+	require.False(t, mapped.Next())
+	require.NoError(t, mapped.Err())
+	require.Less(t, time.Since(start), time.Second)
+}
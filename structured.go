@@ -0,0 +1,198 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultStructuredName = "response"
+)
+
+var (
+	// ErrStructuredNotObject CreateStructured 的 T 不是结构体，strict 模式要求根节点的 schema 为 object
+	ErrStructuredNotObject = errors.New("openai: structured output type must be a struct")
+
+	schemaNameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+)
+
+// RefusalError 模型拒绝按照 schema 生成输出时返回的错误
+type RefusalError struct {
+	Refusal string
+}
+
+func (e *RefusalError) Error() string {
+	return "openai: model refused to respond: " + e.Refusal
+}
+
+type structuredConfig struct {
+	name        string
+	description string
+	retries     int
+}
+
+type StructuredOption func(*structuredConfig)
+
+// WithStructuredName 设置 json_schema 的名称，默认使用 T 的类型名
+func WithStructuredName(name string) StructuredOption {
+	return func(c *structuredConfig) {
+		c.name = name
+	}
+}
+
+// WithStructuredDescription 设置 json_schema 的描述
+func WithStructuredDescription(description string) StructuredOption {
+	return func(c *structuredConfig) {
+		c.description = description
+	}
+}
+
+// WithStructuredRetries 输出没有通过校验时，将校验错误发送给模型并重新请求，最多重试 n 次，默认不重试
+func WithStructuredRetries(n int) StructuredOption {
+	return func(c *structuredConfig) {
+		if n > 0 {
+			c.retries = n
+		}
+	}
+}
+
+// CreateStructured 根据 T 生成 strict 模式的 JSON Schema 作为 response_format 发送请求，
+// 校验模型的输出并解码为 T。模型拒绝回答时返回 *RefusalError，输出不满足 schema 时返回 *SchemaValidationError，
+// 返回的 *ChatCreateResponse 为最后一次请求的响应。请求为流式时会先合并为完整的响应。
+// T 必须是结构体或者结构体指针，切片等其他类型可以包装为结构体的字段，否则返回 ErrStructuredNotObject
+func CreateStructured[T any](ctx context.Context, chat ChatService, req *ChatCreateRequest, opts ...StructuredOption) (*T, *ChatCreateResponse, error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+
+	cfg := &structuredConfig{
+		name: structuredSchemaName(t),
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	if derefType(t).Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("%w: %s", ErrStructuredNotObject, t)
+	}
+
+	reflector := SchemaReflector{Strict: true}
+	schema, err := reflector.Reflect(t)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 实现了 JSONSchemer 的结构体可能返回非 object 的 schema
+	if schema.Type != SchemaTypeObject {
+		return nil, nil, fmt.Errorf("%w: %s has a %q root schema", ErrStructuredNotObject, t, schema.Type)
+	}
+
+	r := *req
+	r.Messages = append(make([]*Message, 0, len(req.Messages)), req.Messages...)
+	r.ResponseFormat = &ResponseFormat{
+		Type: ResponseFormatJSONSchema,
+		JSONSchema: &ResponseJSONSchema{
+			Name:        cfg.name,
+			Description: cfg.description,
+			Schema:      schema,
+			Strict:      true,
+		},
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := chat.Create(ctx, &r)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		resp, err := AccumulateChat(res)
+		if err != nil {
+			return nil, resp, err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, resp, err
+		}
+
+		if len(resp.Choices) == 0 || resp.Choices[0].Message == nil {
+			return nil, resp, ErrNoChoices
+		}
+
+		msg := resp.Choices[0].Message
+		if msg.Refusal != "" {
+			return nil, resp, &RefusalError{Refusal: msg.Refusal}
+		}
+
+		if err := ValidateJSON(schema, []byte(msg.Content)); err != nil {
+			if attempt >= cfg.retries {
+				return nil, resp, err
+			}
+
+			r.Messages = append(r.Messages, msg, &Message{
+				Role:    RoleUser,
+				Content: structuredRetryPrompt(err),
+			})
+			continue
+		}
+
+		var v T
+		if err := json.Unmarshal([]byte(msg.Content), &v); err != nil {
+			return nil, resp, err
+		}
+
+		return &v, resp, nil
+	}
+}
+
+// structuredRetryPrompt 生成要求模型修正输出的消息
+func structuredRetryPrompt(err error) string {
+	var b strings.Builder
+	b.WriteString("The previous response does not match the required JSON schema")
+
+	var verr *SchemaValidationError
+	if errors.As(err, &verr) {
+		b.WriteString(":\n")
+		for _, v := range verr.Violations {
+			b.WriteString("- ")
+			b.WriteString(v.String())
+			b.WriteString("\n")
+		}
+	} else {
+		b.WriteString(": ")
+		b.WriteString(err.Error())
+		b.WriteString("\n")
+	}
+
+	b.WriteString("Please respond again with valid JSON only.")
+	return b.String()
+}
+
+// structuredSchemaName 根据类型名生成 json_schema 的名称，只能包含字母、数字、下划线和中划线
+func structuredSchemaName(t reflect.Type) string {
+	name := schemaNameInvalidChars.ReplaceAllString(derefType(t).Name(), "_")
+	name = strings.Trim(name, "_")
+	if name == "" {
+		return defaultStructuredName
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+type structuredTestEvent struct {
+	Name     string   `json:"name"`
+	Date     string   `json:"date"`
+	Attendee []string `json:"attendee"`
+	Location string   `json:"location,omitempty"`
+}
+
+func TestCreateStructured(t *testing.T) {
+	refusal := mockStopResponse("")
+	refusal.Choices[0].Message.Refusal = "I'm sorry, I cannot help with that."
+
+	testCase := []struct {
+		name      string
+		responses []*ChatCreateResponse
+		opts      []StructuredOption
+		check     func(t *testing.T, i int, req *ChatCreateRequest)
+		want      *structuredTestEvent
+		wantErr   func(t *testing.T, err error)
+	}{
+		{
+			name: "test create structured",
+			responses: []*ChatCreateResponse{
+				mockStopResponse(`{"name":"science fair","date":"Friday","attendee":["Alice","Bob"],"location":null}`),
+			},
+			check: func(t *testing.T, i int, req *ChatCreateRequest) {
+				require.Equal(t, ResponseFormatJSONSchema, req.ResponseFormat.Type)
+				require.Equal(t, "structuredTestEvent", req.ResponseFormat.JSONSchema.Name)
+				require.True(t, req.ResponseFormat.JSONSchema.Strict)
+				require.Equal(t, []string{"name", "date", "attendee", "location"}, req.ResponseFormat.JSONSchema.Schema.Required)
+			},
+			want: &structuredTestEvent{Name: "science fair", Date: "Friday", Attendee: []string{"Alice", "Bob"}},
+		},
+		{
+			name:      "test create structured refusal",
+			responses: []*ChatCreateResponse{refusal},
+			wantErr: func(t *testing.T, err error) {
+				var rerr *RefusalError
+				require.True(t, errors.As(err, &rerr))
+				require.Equal(t, "I'm sorry, I cannot help with that.", rerr.Refusal)
+			},
+		},
+		{
+			name:      "test create structured invalid without retry",
+			responses: []*ChatCreateResponse{mockStopResponse(`{"name":"science fair"}`)},
+			wantErr: func(t *testing.T, err error) {
+				var verr *SchemaValidationError
+				require.True(t, errors.As(err, &verr))
+			},
+		},
+		{
+			name: "test create structured retry",
+			responses: []*ChatCreateResponse{
+				mockStopResponse(`{"name":"science fair"}`),
+				mockStopResponse(`{"name":"science fair","date":"Friday","attendee":[],"location":"school"}`),
+			},
+			opts: []StructuredOption{WithStructuredRetries(1), WithStructuredName("event")},
+			check: func(t *testing.T, i int, req *ChatCreateRequest) {
+				require.Equal(t, "event", req.ResponseFormat.JSONSchema.Name)
+				if i == 0 {
+					require.Len(t, req.Messages, 1)
+					return
+				}
+				require.Len(t, req.Messages, 3)
+				require.Equal(t, RoleAssistant, req.Messages[1].Role)
+				require.Equal(t, RoleUser, req.Messages[2].Role)
+				require.True(t, strings.Contains(req.Messages[2].Content, `missing required property "date"`))
+			},
+			want: &structuredTestEvent{Name: "science fair", Date: "Friday", Attendee: []string{}, Location: "school"},
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newMockServer(newMockChatSequence(t, tc.responses, tc.check))
+			defer server.Close()
+
+			client := newMockClient(server.URL)
+
+			got, res, err := CreateStructured[structuredTestEvent](context.TODO(), client.Chat, &ChatCreateRequest{
+				Model:    GPT35Turbo,
+				Messages: []*Message{{Role: RoleUser, Content: "Alice and Bob are going to a science fair on Friday."}},
+			}, tc.opts...)
+			if tc.wantErr != nil {
+				require.Error(t, err)
+				tc.wantErr(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, res)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+type structuredTestScalar struct{}
+
+func (structuredTestScalar) JSONSchema() *Property {
+	return &Property{Type: SchemaTypeString}
+}
+
+func TestCreateStructured_NotObject(t *testing.T) {
+	chat := &fakeChatService{content: `["a"]`}
+	req := &ChatCreateRequest{Model: GPT35Turbo, Messages: []*Message{{Role: RoleUser, Content: "List fruits."}}}
+
+	_, _, err := CreateStructured[[]string](context.TODO(), chat, req)
+	require.ErrorIs(t, err, ErrStructuredNotObject)
+
+	_, _, err = CreateStructured[map[string]int](context.TODO(), chat, req)
+	require.ErrorIs(t, err, ErrStructuredNotObject)
+
+	_, _, err = CreateStructured[string](context.TODO(), chat, req)
+	require.ErrorIs(t, err, ErrStructuredNotObject)
+
+	_, _, err = CreateStructured[structuredTestScalar](context.TODO(), chat, req)
+	require.ErrorIs(t, err, ErrStructuredNotObject)
+
+	require.Empty(t, chat.requests)
+}
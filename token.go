@@ -0,0 +1,255 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"fmt"
+	"github.com/uzziahlin/openai/tokenizer"
+	"sort"
+	"strings"
+)
+
+// CountTokens 使用模型对应的 encoding 计算 text 的 token 数量
+func CountTokens(model string, text string) (int, error) {
+	enc, err := tokenizer.EncodingForModel(model)
+	if err != nil {
+		return 0, err
+	}
+	return enc.Count(text), nil
+}
+
+// CountEmbeddingTokens 计算 EmbeddingCreateRequest 中所有输入的 token 数量
+func CountEmbeddingTokens(req *EmbeddingCreateRequest) (int, error) {
+	enc, err := tokenizer.EncodingForModel(req.Model)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, input := range req.Input {
+		n += enc.Count(input)
+	}
+	return n, nil
+}
+
+// CountMessageTokens 按照 API 计费的方式估算 messages 和 functions 作为 prompt 时的 token 数量，
+// 包括每条消息的固定开销以及模型回复前的 3 个 token。functions 会按照 API 内部的方式格式化之后计算，
+// 每张图片按照 85 个 token 计算，结果与实际计费可能有少量偏差
+func CountMessageTokens(model string, messages []*Message, functions []*Function) (int, error) {
+	enc, err := tokenizer.EncodingForModel(model)
+	if err != nil {
+		return 0, err
+	}
+	return countPromptTokens(enc, model, messages, functions), nil
+}
+
+// CountChatRequestTokens 估算 ChatCreateRequest 的 prompt token 数量，
+// 会同时计算 Functions 和 Tools 中的函数定义以及强制调用的函数
+func CountChatRequestTokens(req *ChatCreateRequest) (int, error) {
+	enc, err := tokenizer.EncodingForModel(req.Model)
+	if err != nil {
+		return 0, err
+	}
+
+	functions := append(make([]*Function, 0, len(req.Functions)+len(req.Tools)), req.Functions...)
+	for _, tool := range req.Tools {
+		if tool.Function != nil {
+			functions = append(functions, tool.Function)
+		}
+	}
+
+	n := countPromptTokens(enc, req.Model, req.Messages, functions)
+
+	switch c := req.FunctionCall.(type) {
+	case FunctionCallString:
+		if c == "none" {
+			n++
+		}
+	case FunctionCall:
+		n += enc.Count(c.Name) + 4
+	case *FunctionCall:
+		n += enc.Count(c.Name) + 4
+	}
+
+	switch c := req.ToolChoice.(type) {
+	case ToolChoiceString:
+		if c == ToolChoiceNone {
+			n++
+		}
+	case ToolChoiceFunction:
+		n += enc.Count(c.Function.Name) + 4
+	case *ToolChoiceFunction:
+		n += enc.Count(c.Function.Name) + 4
+	}
+
+	return n, nil
+}
+
+func countPromptTokens(enc *tokenizer.Encoding, model string, messages []*Message, functions []*Function) int {
+	tokensPerMessage, tokensPerName := 3, 1
+	if model == GPT35Turbo0301 {
+		tokensPerMessage, tokensPerName = 4, -1
+	}
+
+	// 每次回复都以 <|start|>assistant<|message|> 开头
+	n := 3
+
+	hasSystem := false
+	for _, m := range messages {
+		n += tokensPerMessage
+		n += enc.Count(m.Role)
+
+		content := m.Text()
+		if m.Role == RoleSystem {
+			hasSystem = true
+			// 有函数定义时，函数定义会拼接在 system 消息之后
+			if len(functions) > 0 && content != "" {
+				content += "\n"
+			}
+		}
+		n += enc.Count(content)
+		n += countImageParts(m) * lowDetailImageTokens
+
+		if m.Name != "" {
+			n += enc.Count(m.Name) + tokensPerName
+		}
+
+		if m.Role == RoleFunction {
+			n -= 2
+		}
+
+		if m.HasFunctionCall() {
+			n += enc.Count(m.FunctionCall.Name) + enc.Count(m.FunctionCall.Arguments) + 3
+		}
+
+		for _, call := range m.ToolCalls {
+			n += enc.Count(call.Function.Name) + enc.Count(call.Function.Arguments) + 3
+		}
+	}
+
+	if len(functions) > 0 {
+		n += enc.Count(formatFunctionDefinitions(functions)) + 9
+		if hasSystem {
+			n -= 4
+		}
+	}
+
+	return n
+}
+
+// lowDetailImageTokens low detail 的图片固定消耗的 token 数量，其他 detail 与图片的尺寸有关，无法在本地计算，按照最低的消耗估算
+const lowDetailImageTokens = 85
+
+func countImageParts(m *Message) int {
+	n := 0
+	for _, part := range m.MultiContent {
+		if part.Type == ContentPartTypeImageURL {
+			n++
+		}
+	}
+	return n
+}
+
+// formatFunctionDefinitions 将函数定义格式化为 API 内部使用的 TypeScript 风格的声明
+func formatFunctionDefinitions(functions []*Function) string {
+	lines := []string{"namespace functions {", ""}
+
+	for _, f := range functions {
+		if f.Description != "" {
+			lines = append(lines, "// "+f.Description)
+		}
+
+		if f.Parameters != nil && len(f.Parameters.Properties) > 0 {
+			lines = append(lines, fmt.Sprintf("type %s = (_: {", f.Name))
+			lines = append(lines, formatObjectProperties(f.Parameters, 0))
+			lines = append(lines, "}) => any;")
+		} else {
+			lines = append(lines, fmt.Sprintf("type %s = () => any;", f.Name))
+		}
+
+		lines = append(lines, "")
+	}
+
+	lines = append(lines, "} // namespace functions")
+
+	return strings.Join(lines, "\n")
+}
+
+func formatObjectProperties(obj *Property, indent int) string {
+	required := make(map[string]bool, len(obj.Required))
+	for _, name := range obj.Required {
+		required[name] = true
+	}
+
+	names := make([]string, 0, len(obj.Properties))
+	for name := range obj.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	prefix := strings.Repeat(" ", indent)
+
+	var lines []string
+	for _, name := range names {
+		p := obj.Properties[name]
+
+		if p.Description != "" && indent < 2 {
+			lines = append(lines, prefix+"// "+p.Description)
+		}
+
+		if required[name] {
+			lines = append(lines, fmt.Sprintf("%s%s: %s,", prefix, name, formatPropertyType(p, indent)))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s%s?: %s,", prefix, name, formatPropertyType(p, indent)))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func formatPropertyType(p *Property, indent int) string {
+	switch p.Type {
+	case SchemaTypeString:
+		if len(p.Enum) > 0 {
+			values := make([]string, 0, len(p.Enum))
+			for _, v := range p.Enum {
+				values = append(values, fmt.Sprintf("%q", fmt.Sprint(v)))
+			}
+			return strings.Join(values, " | ")
+		}
+		return "string"
+	case SchemaTypeNumber, SchemaTypeInteger:
+		if len(p.Enum) > 0 {
+			values := make([]string, 0, len(p.Enum))
+			for _, v := range p.Enum {
+				values = append(values, fmt.Sprint(v))
+			}
+			return strings.Join(values, " | ")
+		}
+		return "number"
+	case SchemaTypeBoolean:
+		return "boolean"
+	case SchemaTypeNull:
+		return "null"
+	case SchemaTypeObject:
+		return strings.Join([]string{"{", formatObjectProperties(p, indent+2), "}"}, "\n")
+	case SchemaTypeArray:
+		if p.Items != nil {
+			return formatPropertyType(p.Items, indent) + "[]"
+		}
+		return "any[]"
+	}
+	return "any"
+}
@@ -0,0 +1,182 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"github.com/uzziahlin/openai/tokenizer"
+	"io"
+	"testing"
+)
+
+// registerMockEncoding 注册一个只包含单字节和 hello 的 cl100k_base，此时大部分文本的 token 数量等于字节数
+func registerMockEncoding(t *testing.T) *tokenizer.Encoding {
+	var buf bytes.Buffer
+	for i := 0; i < 256; i++ {
+		fmt.Fprintf(&buf, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(i)}), i)
+	}
+	fmt.Fprintf(&buf, "%s %d\n", base64.StdEncoding.EncodeToString([]byte("hello")), 256)
+
+	ranks := buf.Bytes()
+	tokenizer.RegisterEncodingSource(tokenizer.Cl100kBase, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(ranks)), nil
+	})
+
+	enc, err := tokenizer.GetEncoding(tokenizer.Cl100kBase)
+	require.NoError(t, err)
+	return enc
+}
+
+func TestCountTokens(t *testing.T) {
+	registerMockEncoding(t)
+
+	n, err := CountTokens(GPT4, "hello hi")
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+
+	n, err = CountEmbeddingTokens(&EmbeddingCreateRequest{Model: "text-embedding-ada-002", Input: []string{"hello", "hi"}})
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	_, err = CountTokens("unknown-model", "hello")
+	require.ErrorIs(t, err, tokenizer.ErrUnknownModel)
+}
+
+func TestCountMessageTokens(t *testing.T) {
+	enc := registerMockEncoding(t)
+
+	functions := []*Function{weatherFunction}
+	definitions := enc.Count(formatFunctionDefinitions(functions))
+
+	testCase := []struct {
+		name      string
+		model     string
+		messages  []*Message
+		functions []*Function
+		want      int
+	}{
+		{
+			name:     "test count messages",
+			model:    GPT35Turbo,
+			messages: []*Message{{Role: RoleUser, Content: "hello"}},
+			// 回复开销 3 + 消息开销 3 + user 4 + hello 1
+			want: 11,
+		},
+		{
+			name:  "test count multimodal messages",
+			model: GPT35Turbo,
+			messages: []*Message{{Role: RoleUser, MultiContent: []*ContentPart{
+				NewTextPart("hello"),
+				NewImageURLPart("https://example.com/a.png", ImageDetailLow),
+			}}},
+			// 3 + 3 + 4 + 1 + 85
+			want: 96,
+		},
+		{
+			name:     "test count messages with name on 0301",
+			model:    GPT35Turbo0301,
+			messages: []*Message{{Role: RoleUser, Content: "hello", Name: "ab"}},
+			// 3 + 4 + 4 + 1 + (2 - 1)
+			want: 13,
+		},
+		{
+			name:  "test count messages with function call",
+			model: GPT4,
+			messages: []*Message{
+				{Role: RoleAssistant, FunctionCall: FunctionCall{Name: "f", Arguments: "{}"}},
+				{Role: RoleFunction, Name: "f", Content: "ok"},
+			},
+			// 3 + (3 + 9 + (1 + 2 + 3)) + (3 + 8 + 2 + (1 + 1) - 2)
+			want: 34,
+		},
+		{
+			name:      "test count messages with functions",
+			model:     GPT4,
+			messages:  []*Message{{Role: RoleSystem, Content: "hi"}},
+			functions: functions,
+			// 3 + (3 + 6 + 3) + definitions + 9 - 4
+			want: 20 + definitions,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := CountMessageTokens(tc.model, tc.messages, tc.functions)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestCountChatRequestTokens(t *testing.T) {
+	registerMockEncoding(t)
+
+	messages := []*Message{{Role: RoleUser, Content: "hello"}}
+
+	base, err := CountMessageTokens(GPT4, messages, []*Function{weatherFunction})
+	require.NoError(t, err)
+
+	n, err := CountChatRequestTokens(&ChatCreateRequest{
+		Model:      GPT4,
+		Messages:   messages,
+		Tools:      []*Tool{NewFunctionTool(weatherFunction)},
+		ToolChoice: NewToolChoiceFunction("get_weather"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, base+len("get_weather")+4, n)
+}
+
+func TestFormatFunctionDefinitions(t *testing.T) {
+	got := formatFunctionDefinitions([]*Function{
+		{
+			Name:        "get_weather",
+			Description: "Get the current weather",
+			Parameters: &Parameter{
+				Type: SchemaTypeObject,
+				Properties: map[string]*Property{
+					"location": {Type: SchemaTypeString, Description: "The city"},
+					"unit":     {Type: SchemaTypeString, Enum: []any{"celsius", "fahrenheit"}},
+					"days":     {Type: SchemaTypeArray, Items: &Property{Type: SchemaTypeInteger}},
+					"options": {
+						Type:       SchemaTypeObject,
+						Properties: map[string]*Property{"detail": {Type: SchemaTypeBoolean, Description: "ignored"}},
+					},
+				},
+				Required: []string{"location"},
+			},
+		},
+		{Name: "get_time"},
+	})
+
+	require.Equal(t, `namespace functions {
+
+// Get the current weather
+type get_weather = (_: {
+days?: number[],
+// The city
+location: string,
+options?: {
+  detail?: boolean,
+},
+unit?: "celsius" | "fahrenheit",
+}) => any;
+
+type get_time = () => any;
+
+} // namespace functions`, got)
+}
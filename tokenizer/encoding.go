@@ -0,0 +1,311 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tokenizer 纯 Go 实现的 BPE 分词器，与 tiktoken 的结果保持一致，
+// 用于在请求之前估算 token 的数量
+package tokenizer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	R50kBase   = "r50k_base"
+	P50kBase   = "p50k_base"
+	P50kEdit   = "p50k_edit"
+	Cl100kBase = "cl100k_base"
+	O200kBase  = "o200k_base"
+)
+
+const (
+	EndOfText   = "<|endoftext|>"
+	FimPrefix   = "<|fim_prefix|>"
+	FimMiddle   = "<|fim_middle|>"
+	FimSuffix   = "<|fim_suffix|>"
+	EndOfPrompt = "<|endofprompt|>"
+)
+
+// encodingSpec encoding 除了 ranks 以外的定义
+type encodingSpec struct {
+	// ranksName 使用的 ranks 文件，p50k_edit 与 p50k_base 共用
+	ranksName string
+	pattern   string
+	special   map[string]int
+}
+
+var specs = map[string]*encodingSpec{
+	R50kBase: {
+		ranksName: R50kBase,
+		pattern:   gpt2Pattern,
+		special:   map[string]int{EndOfText: 50256},
+	},
+	P50kBase: {
+		ranksName: P50kBase,
+		pattern:   gpt2Pattern,
+		special:   map[string]int{EndOfText: 50256},
+	},
+	P50kEdit: {
+		ranksName: P50kBase,
+		pattern:   gpt2Pattern,
+		special:   map[string]int{EndOfText: 50256, FimPrefix: 50281, FimMiddle: 50282, FimSuffix: 50283},
+	},
+	Cl100kBase: {
+		ranksName: Cl100kBase,
+		pattern:   cl100kPattern,
+		special: map[string]int{
+			EndOfText:   100257,
+			FimPrefix:   100258,
+			FimMiddle:   100259,
+			FimSuffix:   100260,
+			EndOfPrompt: 100276,
+		},
+	},
+	O200kBase: {
+		ranksName: O200kBase,
+		pattern:   o200kPattern,
+		special:   map[string]int{EndOfText: 199999, EndOfPrompt: 200018},
+	},
+}
+
+// Encoding 一种 BPE 编码，可以并发使用
+type Encoding struct {
+	name           string
+	ranks          map[string]int
+	decoder        map[int]string
+	special        map[string]int
+	specialDecoder map[int]string
+	specialRe      *regexp.Regexp
+	splitter       *splitter
+}
+
+// NewEncoding 使用 tiktoken 格式的 ranks 创建名为 name 的 encoding，name 必须是已知的 encoding，
+// 预分词的规则和特殊 token 由 name 决定
+func NewEncoding(name string, ranks io.Reader) (*Encoding, error) {
+	spec, ok := specs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEncoding, name)
+	}
+
+	r, err := ParseRanks(ranks)
+	if err != nil {
+		return nil, fmt.Errorf("tokenizer: parse ranks of %s: %w", name, err)
+	}
+
+	return newEncoding(name, r, spec.special, spec.pattern), nil
+}
+
+func newEncoding(name string, ranks map[string]int, special map[string]int, pattern string) *Encoding {
+	e := &Encoding{
+		name:           name,
+		ranks:          ranks,
+		decoder:        make(map[int]string, len(ranks)),
+		special:        special,
+		specialDecoder: make(map[int]string, len(special)),
+		splitter:       newSplitter(pattern),
+	}
+
+	for token, rank := range ranks {
+		e.decoder[rank] = token
+	}
+
+	names := make([]string, 0, len(special))
+	for token, rank := range special {
+		e.specialDecoder[rank] = token
+		names = append(names, regexp.QuoteMeta(token))
+	}
+
+	if len(names) > 0 {
+		// 长的优先，避免一个特殊 token 是另一个的前缀时匹配错误
+		sort.Slice(names, func(i, j int) bool {
+			return len(names[i]) > len(names[j])
+		})
+		e.specialRe = regexp.MustCompile(strings.Join(names, "|"))
+	}
+
+	return e
+}
+
+// ParseRanks 解析 tiktoken 格式的 ranks，每一行为 base64 编码的 token 和它的 rank，以空格分隔
+func ParseRanks(r io.Reader) (map[string]int, error) {
+	ranks := make(map[string]int)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: invalid format", line)
+		}
+
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		ranks[string(token)] = rank
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ranks, nil
+}
+
+// Name encoding 的名称
+func (e *Encoding) Name() string {
+	return e.name
+}
+
+// SpecialTokens 返回所有特殊 token 及其 id
+func (e *Encoding) SpecialTokens() map[string]int {
+	res := make(map[string]int, len(e.special))
+	for k, v := range e.special {
+		res[k] = v
+	}
+	return res
+}
+
+// EncodeOrdinary 编码 text，特殊 token 按照普通文本处理
+func (e *Encoding) EncodeOrdinary(text string) []int {
+	var tokens []int
+	for _, piece := range e.splitter.split(text) {
+		tokens = e.encodePiece(tokens, piece)
+	}
+	return tokens
+}
+
+// Encode 编码 text，allowedSpecial 中的特殊 token 会编码为对应的 id，其他特殊 token 按照普通文本处理，
+// allowedSpecial 为 "all" 时允许所有特殊 token
+func (e *Encoding) Encode(text string, allowedSpecial ...string) []int {
+	if len(allowedSpecial) == 0 || e.specialRe == nil {
+		return e.EncodeOrdinary(text)
+	}
+
+	allowed := make(map[string]bool, len(allowedSpecial))
+	for _, s := range allowedSpecial {
+		if s == "all" {
+			for k := range e.special {
+				allowed[k] = true
+			}
+			continue
+		}
+		allowed[s] = true
+	}
+
+	var tokens []int
+	start := 0
+	for _, loc := range e.specialRe.FindAllStringIndex(text, -1) {
+		token := text[loc[0]:loc[1]]
+		if !allowed[token] {
+			continue
+		}
+
+		tokens = append(tokens, e.EncodeOrdinary(text[start:loc[0]])...)
+		tokens = append(tokens, e.special[token])
+		start = loc[1]
+	}
+
+	return append(tokens, e.EncodeOrdinary(text[start:])...)
+}
+
+// Count 返回 text 编码后 token 的数量，特殊 token 按照普通文本处理
+func (e *Encoding) Count(text string) int {
+	n := 0
+	for _, piece := range e.splitter.split(text) {
+		if _, ok := e.ranks[piece]; ok {
+			n++
+			continue
+		}
+		n += len(e.bytePairMerge(piece))
+	}
+	return n
+}
+
+// Decode 将 tokens 解码为文本，不完整的 UTF-8 序列会被替换为 U+FFFD
+func (e *Encoding) Decode(tokens []int) string {
+	return string(bytes.ToValidUTF8(e.DecodeBytes(tokens), []byte("�")))
+}
+
+// DecodeBytes 将 tokens 解码为原始的字节，未知的 token 会被忽略
+func (e *Encoding) DecodeBytes(tokens []int) []byte {
+	var buf bytes.Buffer
+	for _, t := range tokens {
+		if s, ok := e.decoder[t]; ok {
+			buf.WriteString(s)
+			continue
+		}
+		if s, ok := e.specialDecoder[t]; ok {
+			buf.WriteString(s)
+		}
+	}
+	return buf.Bytes()
+}
+
+func (e *Encoding) encodePiece(tokens []int, piece string) []int {
+	if rank, ok := e.ranks[piece]; ok {
+		return append(tokens, rank)
+	}
+
+	for _, part := range e.bytePairMerge(piece) {
+		tokens = append(tokens, e.ranks[part])
+	}
+	return tokens
+}
+
+// bytePairMerge 从单个字节开始，每次合并 rank 最小的相邻两部分，直到无法继续合并
+func (e *Encoding) bytePairMerge(piece string) []string {
+	parts := make([]string, len(piece))
+	for i := 0; i < len(piece); i++ {
+		parts[i] = piece[i : i+1]
+	}
+
+	for len(parts) > 1 {
+		minRank, minIdx := math.MaxInt, -1
+		for i := 0; i < len(parts)-1; i++ {
+			if rank, ok := e.ranks[parts[i]+parts[i+1]]; ok && rank < minRank {
+				minRank, minIdx = rank, i
+			}
+		}
+
+		if minIdx < 0 {
+			break
+		}
+
+		parts[minIdx] += parts[minIdx+1]
+		parts = append(parts[:minIdx+1], parts[minIdx+2:]...)
+	}
+
+	return parts
+}
@@ -0,0 +1,140 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tokenizer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+// mockRanks 生成一个很小的 tiktoken 格式的 ranks，包含所有的单字节和少量合并
+func mockRanks() []byte {
+	var buf bytes.Buffer
+	for i := 0; i < 256; i++ {
+		fmt.Fprintf(&buf, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(i)}), i)
+	}
+	for i, token := range []string{"ll", "he", "hell", "hello", " w", " wo", "or", " wor"} {
+		fmt.Fprintf(&buf, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), 256+i)
+	}
+	return buf.Bytes()
+}
+
+func newMockEncoding(t *testing.T, name string) *Encoding {
+	e, err := NewEncoding(name, bytes.NewReader(mockRanks()))
+	require.NoError(t, err)
+	return e
+}
+
+func TestEncoding_Encode(t *testing.T) {
+	e := newMockEncoding(t, Cl100kBase)
+
+	testCase := []struct {
+		name           string
+		text           string
+		allowedSpecial []string
+		want           []int
+	}{
+		{
+			name: "test encode",
+			text: "hello world",
+			want: []int{259, 263, 'l', 'd'},
+		},
+		{
+			name: "test encode empty",
+			text: "",
+			want: nil,
+		},
+		{
+			name:           "test encode special",
+			text:           "hello<|endoftext|>",
+			allowedSpecial: []string{"all"},
+			want:           []int{259, 100257},
+		},
+		{
+			name:           "test encode special not allowed",
+			text:           "<|endoftext|>hello",
+			allowedSpecial: []string{EndOfPrompt},
+			want:           []int{'<', '|', 'e', 'n', 'd', 'o', 'f', 't', 'e', 'x', 't', '|', '>', 259},
+		},
+		{
+			name: "test encode special as ordinary",
+			text: "<|endoftext|>",
+			want: []int{'<', '|', 'e', 'n', 'd', 'o', 'f', 't', 'e', 'x', 't', '|', '>'},
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens := e.Encode(tc.text, tc.allowedSpecial...)
+			require.Equal(t, tc.want, tokens)
+			require.Equal(t, tc.text, e.Decode(tokens))
+			if len(tc.allowedSpecial) == 0 {
+				require.Equal(t, len(tokens), e.Count(tc.text))
+			}
+		})
+	}
+}
+
+func TestEncoding_Decode(t *testing.T) {
+	e := newMockEncoding(t, O200kBase)
+
+	text := "你好, world!\n"
+	tokens := e.EncodeOrdinary(text)
+	require.Equal(t, text, e.Decode(tokens))
+
+	// 截断在多字节字符的中间
+	require.Equal(t, "�", e.Decode(tokens[:1]))
+	require.Equal(t, []byte(text)[:1], e.DecodeBytes(tokens[:1]))
+}
+
+func TestNewEncoding(t *testing.T) {
+	_, err := NewEncoding("unknown", bytes.NewReader(mockRanks()))
+	require.ErrorIs(t, err, ErrUnknownEncoding)
+
+	_, err = NewEncoding(Cl100kBase, bytes.NewBufferString("aGVsbG8=\n"))
+	require.Error(t, err)
+
+	_, err = NewEncoding(Cl100kBase, bytes.NewBufferString("!!! 1\n"))
+	require.Error(t, err)
+}
+
+func TestGetEncoding(t *testing.T) {
+	_, err := GetEncoding("unknown")
+	require.ErrorIs(t, err, ErrUnknownEncoding)
+
+	var opened int
+	RegisterEncodingSource(P50kBase, func() (io.ReadCloser, error) {
+		opened++
+		return io.NopCloser(bytes.NewReader(mockRanks())), nil
+	})
+
+	e, err := GetEncoding(P50kEdit)
+	require.NoError(t, err)
+	require.Equal(t, P50kEdit, e.Name())
+	require.Equal(t, 50283, e.SpecialTokens()[FimSuffix])
+
+	// 第二次从缓存中获取
+	e2, err := GetEncoding(P50kEdit)
+	require.NoError(t, err)
+	require.Same(t, e, e2)
+
+	_, err = GetEncoding(P50kBase)
+	require.NoError(t, err)
+	require.Equal(t, 2, opened)
+}
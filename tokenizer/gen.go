@@ -0,0 +1,85 @@
+//go:build ignore
+
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// gen 下载 encodings 的 ranks 文件到 encodings 目录，并校验 sha256，
+// 已经存在并且校验通过的文件会被跳过。通过 go generate ./tokenizer 运行
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const baseURL = "https://openaipublic.blob.core.windows.net/encodings/"
+
+// hashes 与 tiktoken 中 tiktoken_ext/openai_public.py 的 expected_hash 一致
+var hashes = map[string]string{
+	"r50k_base":   "306cd27f03c1a714eca7108e03d66b7dc042abe8c258b44c199a7ed9838dd930",
+	"p50k_base":   "94b5ca7dff4d00767bc256fdd1b27e5b17361d7b8a5f968547f9f23eb70d2069",
+	"cl100k_base": "223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7",
+	"o200k_base":  "446a9538cb6c348e3516120d7c08b09f57c36495e2acfffe59a5bf8b0cfb1a2d",
+}
+
+func main() {
+	for name, hash := range hashes {
+		path := filepath.Join("encodings", name+".tiktoken")
+
+		if b, err := os.ReadFile(path); err == nil && checksum(b) == hash {
+			log.Printf("%s is up to date", path)
+			continue
+		}
+
+		b, err := download(baseURL + name + ".tiktoken")
+		if err != nil {
+			log.Fatalf("download %s: %v", name, err)
+		}
+
+		if sum := checksum(b); sum != hash {
+			log.Fatalf("checksum of %s mismatch, expected %s, got %s", name, hash, sum)
+		}
+
+		if err := os.WriteFile(path, b, 0644); err != nil {
+			log.Fatalf("write %s: %v", path, err)
+		}
+
+		log.Printf("%s downloaded", path)
+	}
+}
+
+func download(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
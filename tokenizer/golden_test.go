@@ -0,0 +1,128 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tokenizer
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"os"
+	"sync"
+	"testing"
+)
+
+// golden testdata/golden.json 中的数据由 Python 版本的 tiktoken 生成
+type golden struct {
+	Tokens []struct {
+		Encoding       string   `json:"encoding"`
+		Text           string   `json:"text"`
+		AllowedSpecial []string `json:"allowed_special"`
+		Tokens         []int    `json:"tokens"`
+	} `json:"tokens"`
+	Counts []struct {
+		Encoding string `json:"encoding"`
+		Text     string `json:"text"`
+		Count    int    `json:"count"`
+	} `json:"counts"`
+	Models []struct {
+		Model string `json:"model"`
+		Text  string `json:"text"`
+		Count int    `json:"count"`
+	} `json:"models"`
+}
+
+func loadGolden(t *testing.T) *golden {
+	b, err := os.ReadFile("testdata/golden.json")
+	require.NoError(t, err)
+
+	var g golden
+	require.NoError(t, json.Unmarshal(b, &g))
+
+	return &g
+}
+
+// goldenEncodings 缓存从内嵌的 ranks 文件加载的 encoding，避免每个用例重复解析
+var (
+	goldenMu        sync.Mutex
+	goldenEncodings = make(map[string]*Encoding)
+)
+
+// embeddedEncoding 直接从内嵌的 ranks 文件加载 encoding，不受其他测试中注册的 source 的影响，
+// 没有 ranks 文件时跳过测试，可以通过 go generate ./tokenizer 下载
+func embeddedEncoding(t *testing.T, name string) *Encoding {
+	t.Helper()
+
+	goldenMu.Lock()
+	defer goldenMu.Unlock()
+
+	if e, ok := goldenEncodings[name]; ok {
+		return e
+	}
+
+	f, err := embedded.Open("encodings/" + specs[name].ranksName + ".tiktoken")
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Skipf("ranks of %s not found, run go generate ./tokenizer first", name)
+	}
+	require.NoError(t, err)
+	defer f.Close()
+
+	e, err := NewEncoding(name, f)
+	require.NoError(t, err)
+	goldenEncodings[name] = e
+
+	return e
+}
+
+func TestEncoding_Golden(t *testing.T) {
+	g := loadGolden(t)
+
+	for _, tc := range g.Tokens {
+		tc := tc
+		t.Run(tc.Encoding+"/tokens/"+tc.Text, func(t *testing.T) {
+			e := embeddedEncoding(t, tc.Encoding)
+
+			require.Equal(t, tc.Tokens, e.Encode(tc.Text, tc.AllowedSpecial...))
+			require.Equal(t, tc.Text, e.Decode(tc.Tokens))
+		})
+	}
+
+	for _, tc := range g.Counts {
+		tc := tc
+		t.Run(tc.Encoding+"/count/"+tc.Text, func(t *testing.T) {
+			e := embeddedEncoding(t, tc.Encoding)
+
+			tokens := e.EncodeOrdinary(tc.Text)
+			require.Len(t, tokens, tc.Count)
+			require.Equal(t, tc.Count, e.Count(tc.Text))
+			require.Equal(t, tc.Text, e.Decode(tokens))
+		})
+	}
+}
+
+func TestEncodingForModel_Golden(t *testing.T) {
+	g := loadGolden(t)
+
+	for _, tc := range g.Models {
+		tc := tc
+		t.Run(tc.Model+"/"+tc.Text, func(t *testing.T) {
+			name, err := EncodingNameForModel(tc.Model)
+			require.NoError(t, err)
+
+			e := embeddedEncoding(t, name)
+			require.Equal(t, tc.Count, e.Count(tc.Text))
+		})
+	}
+}
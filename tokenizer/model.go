@@ -0,0 +1,124 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tokenizer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownModel 无法确定模型使用的 encoding
+var ErrUnknownModel = errors.New("tokenizer: unknown model")
+
+var modelEncodings = map[string]string{
+	// chat
+	"o1":            O200kBase,
+	"o3":            O200kBase,
+	"gpt-4o":        O200kBase,
+	"gpt-4.1":       O200kBase,
+	"gpt-4":         Cl100kBase,
+	"gpt-3.5-turbo": Cl100kBase,
+	"gpt-3.5":       Cl100kBase,
+	"gpt-35-turbo":  Cl100kBase,
+	// base
+	"davinci-002": Cl100kBase,
+	"babbage-002": Cl100kBase,
+	// embeddings
+	"text-embedding-ada-002": Cl100kBase,
+	"text-embedding-3-small": Cl100kBase,
+	"text-embedding-3-large": Cl100kBase,
+	// text
+	"text-davinci-003": P50kBase,
+	"text-davinci-002": P50kBase,
+	"text-davinci-001": R50kBase,
+	"text-curie-001":   R50kBase,
+	"text-babbage-001": R50kBase,
+	"text-ada-001":     R50kBase,
+	"davinci":          R50kBase,
+	"curie":            R50kBase,
+	"babbage":          R50kBase,
+	"ada":              R50kBase,
+	// code
+	"code-davinci-002": P50kBase,
+	"code-davinci-001": P50kBase,
+	"code-cushman-002": P50kBase,
+	"code-cushman-001": P50kBase,
+	"davinci-codex":    P50kBase,
+	"cushman-codex":    P50kBase,
+	// edit
+	"text-davinci-edit-001": P50kEdit,
+	"code-davinci-edit-001": P50kEdit,
+	// moderation
+	"text-moderation-latest": Cl100kBase,
+	"text-moderation-stable": Cl100kBase,
+	"omni-moderation-latest": O200kBase,
+}
+
+// modelPrefixEncodings 按照前缀匹配，长的前缀需要放在前面
+var modelPrefixEncodings = []struct {
+	prefix   string
+	encoding string
+}{
+	{"o1-", O200kBase},
+	{"o3-", O200kBase},
+	{"o4-mini-", O200kBase},
+	{"gpt-4.1-", O200kBase},
+	{"gpt-4.5-", O200kBase},
+	{"gpt-4o-", O200kBase},
+	{"chatgpt-4o-", O200kBase},
+	{"gpt-4-", Cl100kBase},
+	{"gpt-3.5-turbo-", Cl100kBase},
+	{"gpt-35-turbo-", Cl100kBase},
+	{"ft:gpt-4o", O200kBase},
+	{"ft:gpt-4", Cl100kBase},
+	{"ft:gpt-3.5-turbo", Cl100kBase},
+	{"ft:davinci-002", Cl100kBase},
+	{"ft:babbage-002", Cl100kBase},
+	{"text-similarity-", R50kBase},
+	{"text-search-", R50kBase},
+	{"code-search-", R50kBase},
+}
+
+// EncodingNameForModel 返回模型使用的 encoding 名称，支持带日期的快照版本和 ft: 开头的微调模型
+func EncodingNameForModel(model string) (string, error) {
+	if name, ok := modelEncodings[model]; ok {
+		return name, nil
+	}
+
+	for _, p := range modelPrefixEncodings {
+		if strings.HasPrefix(model, p.prefix) {
+			return p.encoding, nil
+		}
+	}
+
+	// 旧版的微调模型，比如 curie:ft-personal-2023-06-01
+	if i := strings.Index(model, ":"); i > 0 {
+		if name, ok := modelEncodings[model[:i]]; ok {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnknownModel, model)
+}
+
+// EncodingForModel 返回模型使用的 encoding
+func EncodingForModel(model string) (*Encoding, error) {
+	name, err := EncodingNameForModel(model)
+	if err != nil {
+		return nil, err
+	}
+	return GetEncoding(name)
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tokenizer
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestEncodingNameForModel(t *testing.T) {
+	testCase := []struct {
+		model   string
+		want    string
+		wantErr bool
+	}{
+		{model: "gpt-4o", want: O200kBase},
+		{model: "gpt-4o-mini-2024-07-18", want: O200kBase},
+		{model: "o1-preview", want: O200kBase},
+		{model: "gpt-4", want: Cl100kBase},
+		{model: "gpt-4-0613", want: Cl100kBase},
+		{model: "gpt-3.5-turbo-16k", want: Cl100kBase},
+		{model: "ft:gpt-3.5-turbo-0613:org::abc123", want: Cl100kBase},
+		{model: "ft:gpt-4o-2024-08-06:org::abc123", want: O200kBase},
+		{model: "text-embedding-ada-002", want: Cl100kBase},
+		{model: "text-davinci-003", want: P50kBase},
+		{model: "text-davinci-edit-001", want: P50kEdit},
+		{model: "davinci", want: R50kBase},
+		{model: "curie:ft-personal-2023-06-01-00-00-00", want: R50kBase},
+		{model: "unknown-model", wantErr: true},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.model, func(t *testing.T) {
+			got, err := EncodingNameForModel(tc.model)
+			if tc.wantErr {
+				require.ErrorIs(t, err, ErrUnknownModel)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tokenizer
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	// ErrUnknownEncoding 未知的 encoding 名称
+	ErrUnknownEncoding = errors.New("tokenizer: unknown encoding")
+	// ErrEncodingNotFound 没有找到 encoding 的 ranks 文件，需要放到 encodings 目录下或者通过 RegisterEncodingSource 注册
+	ErrEncodingNotFound = errors.New("tokenizer: encoding ranks not found")
+)
+
+//go:generate go run gen.go
+
+//go:embed encodings
+var embedded embed.FS
+
+// EncodingSource 返回 tiktoken 格式的 ranks
+type EncodingSource func() (io.ReadCloser, error)
+
+var (
+	mu        sync.Mutex
+	sources   = make(map[string]EncodingSource)
+	encodings = make(map[string]*Encoding)
+)
+
+// RegisterEncodingSource 注册 ranks 的来源，优先于内嵌的文件，name 为 ranks 的名称，
+// 比如注册 p50k_base 之后 p50k_edit 也会使用它。已经加载过的 encoding 会被重新加载
+func RegisterEncodingSource(name string, source EncodingSource) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	sources[name] = source
+	for encName, spec := range specs {
+		if spec.ranksName == name {
+			delete(encodings, encName)
+		}
+	}
+}
+
+// RegisterEncodingFile 从本地文件加载 ranks
+func RegisterEncodingFile(name string, path string) {
+	RegisterEncodingSource(name, func() (io.ReadCloser, error) {
+		return os.Open(path)
+	})
+}
+
+// GetEncoding 返回名为 name 的 encoding，第一次调用时加载 ranks，之后会被缓存
+func GetEncoding(name string) (*Encoding, error) {
+	spec, ok := specs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEncoding, name)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if e, ok := encodings[name]; ok {
+		return e, nil
+	}
+
+	rc, err := openRanks(spec.ranksName)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	ranks, err := ParseRanks(rc)
+	if err != nil {
+		return nil, fmt.Errorf("tokenizer: parse ranks of %s: %w", name, err)
+	}
+
+	e := newEncoding(name, ranks, spec.special, spec.pattern)
+	encodings[name] = e
+
+	return e, nil
+}
+
+// MustGetEncoding 与 GetEncoding 相同，出错时 panic
+func MustGetEncoding(name string) *Encoding {
+	e, err := GetEncoding(name)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+func openRanks(name string) (io.ReadCloser, error) {
+	if source, ok := sources[name]; ok {
+		return source()
+	}
+
+	f, err := embedded.Open("encodings/" + name + ".tiktoken")
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrEncodingNotFound, name)
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
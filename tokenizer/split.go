@@ -0,0 +1,84 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tokenizer
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 以下为各个 encoding 预分词使用的正则，去掉了 Go 不支持的 \s+(?!\S) 和最后的 \s+ 两个分支，
+// 这两个分支只会匹配空白字符，由 splitter 单独处理
+const (
+	gpt2Pattern   = `^(?:'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+)`
+	cl100kPattern = `^(?:(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+)`
+	o200kPattern  = `^(?:[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+)`
+)
+
+// 与 tiktoken 使用的正则库保持一致，\s 需要匹配所有 Unicode 空白字符，而 Go 的 \s 只匹配 ASCII 空白字符
+var whitespaceReplacer = strings.NewReplacer(`[^\s`, `[^\s\x0B\x{85}\p{Z}`, `\s*`, `[\s\x0B\x{85}\p{Z}]*`)
+
+// splitter 按照 tiktoken 的规则将文本切分为若干片段，每个片段再单独进行 BPE 合并
+type splitter struct {
+	re *regexp.Regexp
+}
+
+func newSplitter(pattern string) *splitter {
+	return &splitter{re: regexp.MustCompile(whitespaceReplacer.Replace(pattern))}
+}
+
+// split 依次在当前位置尝试匹配，与 tiktoken 的行为保持一致：
+// 正则中的分支都无法匹配时当前位置一定是空白字符，此时等价于 \s+(?!\S)|\s+，
+// 即连续的空白如果后面还有非空白字符，最后一个空白字符留给下一个片段
+func (s *splitter) split(text string) []string {
+	var pieces []string
+
+	for pos := 0; pos < len(text); {
+		if loc := s.re.FindStringIndex(text[pos:]); loc != nil && loc[1] > 0 {
+			pieces = append(pieces, text[pos:pos+loc[1]])
+			pos += loc[1]
+			continue
+		}
+
+		end, last, n := pos, pos, 0
+		for end < len(text) {
+			r, size := utf8.DecodeRuneInString(text[end:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			last = end
+			end += size
+			n++
+		}
+
+		switch {
+		case n == 0:
+			// 无法识别的字节，单独作为一个片段，避免死循环
+			_, size := utf8.DecodeRuneInString(text[pos:])
+			end = pos + size
+		case end < len(text) && n > 1:
+			end = last
+		}
+
+		pieces = append(pieces, text[pos:end])
+		pos = end
+	}
+
+	return pieces
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tokenizer
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSplitter_Split(t *testing.T) {
+	testCase := []struct {
+		name    string
+		pattern string
+		text    string
+		want    []string
+	}{
+		{
+			name:    "test split gpt2",
+			pattern: gpt2Pattern,
+			text:    "Hello world  123456 I'm!!",
+			want:    []string{"Hello", " world", " ", " 123456", " I", "'m", "!!"},
+		},
+		{
+			name:    "test split cl100k",
+			pattern: cl100kPattern,
+			text:    "Hello world  foo\n\nbar 123456 I'M",
+			want:    []string{"Hello", " world", " ", " foo", "\n\n", "bar", " ", "123", "456", " I", "'M"},
+		},
+		{
+			name:    "test split cl100k trailing whitespace",
+			pattern: cl100kPattern,
+			text:    "a  b  ",
+			want:    []string{"a", " ", " b", "  "},
+		},
+		{
+			name:    "test split o200k",
+			pattern: o200kPattern,
+			text:    "HelloWorld's foo/bar\n",
+			want:    []string{"Hello", "World's", " foo", "/bar", "\n"},
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, newSplitter(tc.pattern).split(tc.text))
+		})
+	}
+}
@@ -0,0 +1,405 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxIterations = 10
+
+	// emptyToolResult 工具没有返回内容时发送给模型的结果
+	emptyToolResult = "{}"
+)
+
+var (
+	// ErrMaxIterations 超过最大轮数模型仍然在要求调用工具
+	ErrMaxIterations = errors.New("openai: conversation exceeds max iterations")
+	// ErrNoChoices 响应中没有任何 choice
+	ErrNoChoices = errors.New("openai: response has no choices")
+)
+
+// ToolHandler 工具的处理函数，arguments 为模型生成的 JSON 参数，返回值会作为 tool 消息的内容发送给模型
+type ToolHandler func(ctx context.Context, arguments string) (string, error)
+
+// NewToolHandler 将类型化的函数包装为 ToolHandler，参数会被解码为 T，
+// 返回值如果是 string 直接使用，否则编码为 JSON
+func NewToolHandler[T any, R any](fn func(ctx context.Context, args T) (R, error)) ToolHandler {
+	return func(ctx context.Context, arguments string) (string, error) {
+		var args T
+		if arguments != "" {
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+		}
+
+		res, err := fn(ctx, args)
+		if err != nil {
+			return "", err
+		}
+
+		if s, ok := any(res).(string); ok {
+			return s, nil
+		}
+
+		b, err := json.Marshal(res)
+		if err != nil {
+			return "", err
+		}
+
+		return string(b), nil
+	}
+}
+
+type registeredTool struct {
+	function *Function
+	handler  ToolHandler
+	timeout  time.Duration
+}
+
+type ToolOption func(*registeredTool)
+
+// WithToolTimeout 设置单个工具的执行超时时间
+func WithToolTimeout(timeout time.Duration) ToolOption {
+	return func(t *registeredTool) {
+		t.timeout = timeout
+	}
+}
+
+// ToolRegistry 工具注册表，保存函数的 schema 和对应的 Go 处理函数
+type ToolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]*registeredTool
+	names []string
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{
+		tools: make(map[string]*registeredTool),
+	}
+}
+
+// Register 注册一个工具，名称重复时返回错误
+func (r *ToolRegistry) Register(f *Function, handler ToolHandler, opts ...ToolOption) error {
+	if f == nil || f.Name == "" {
+		return errors.New("openai: tool function name is required")
+	}
+
+	if handler == nil {
+		return fmt.Errorf("openai: tool %s has no handler", f.Name)
+	}
+
+	t := &registeredTool{
+		function: f,
+		handler:  handler,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tools[f.Name]; ok {
+		return fmt.Errorf("openai: tool %s is already registered", f.Name)
+	}
+
+	r.tools[f.Name] = t
+	r.names = append(r.names, f.Name)
+
+	return nil
+}
+
+// RegisterTool 注册一个类型化的工具，参见 NewToolHandler
+func RegisterTool[T any, R any](r *ToolRegistry, f *Function, fn func(ctx context.Context, args T) (R, error), opts ...ToolOption) error {
+	return r.Register(f, NewToolHandler(fn), opts...)
+}
+
+// Tools 按照注册顺序返回所有工具，可以直接用于 ChatCreateRequest.Tools
+func (r *ToolRegistry) Tools() []*Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tools := make([]*Tool, 0, len(r.names))
+	for _, name := range r.names {
+		tools = append(tools, NewFunctionTool(r.tools[name].function))
+	}
+
+	return tools
+}
+
+// Functions 按照注册顺序返回所有函数，可以直接用于 ChatCreateRequest.Functions
+func (r *ToolRegistry) Functions() []*Function {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	functions := make([]*Function, 0, len(r.names))
+	for _, name := range r.names {
+		functions = append(functions, r.tools[name].function)
+	}
+
+	return functions
+}
+
+// Call 调用指定的工具，会应用工具的超时时间，处理函数 panic 时会转换为错误。
+// 超时或者 ctx 被取消时立即返回 ctx.Err()，不会等待忽略 ctx 的处理函数结束，处理函数会在后台继续运行直到返回
+func (r *ToolRegistry) Call(ctx context.Context, name, arguments string) (string, error) {
+	r.mu.RLock()
+	t, ok := r.tools[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return "", fmt.Errorf("openai: tool %s is not registered", name)
+	}
+
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
+	}
+
+	type callResult struct {
+		res string
+		err error
+	}
+
+	done := make(chan callResult, 1)
+
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- callResult{err: fmt.Errorf("openai: tool %s panic: %v", name, p)}
+			}
+		}()
+
+		res, err := t.handler(ctx, arguments)
+		done <- callResult{res: res, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-done:
+		return r.res, r.err
+	}
+}
+
+// RunHooks RunConversation 的回调，用于日志、监控等，所有字段都可以为 nil
+type RunHooks struct {
+	// OnRequest 每一轮发送请求之前调用
+	OnRequest func(ctx context.Context, iteration int, req *ChatCreateRequest)
+	// OnResponse 每一轮收到响应之后调用
+	OnResponse func(ctx context.Context, iteration int, resp *ChatCreateResponse)
+	// OnToolCall 执行工具之前调用，并行执行时会在不同的 goroutine 中调用
+	OnToolCall func(ctx context.Context, call *ToolCall)
+	// OnToolResult 工具执行完成之后调用，并行执行时会在不同的 goroutine 中调用
+	OnToolResult func(ctx context.Context, call *ToolCall, result string, err error, elapsed time.Duration)
+}
+
+type runConfig struct {
+	maxIterations int
+	parallel      bool
+	hooks         RunHooks
+}
+
+type RunOption func(*runConfig)
+
+// WithMaxIterations 设置最大轮数，默认为 10
+func WithMaxIterations(n int) RunOption {
+	return func(c *runConfig) {
+		if n > 0 {
+			c.maxIterations = n
+		}
+	}
+}
+
+// WithParallelToolExecution 设置同一轮中的多个工具调用是否并行执行，默认为 true
+func WithParallelToolExecution(parallel bool) RunOption {
+	return func(c *runConfig) {
+		c.parallel = parallel
+	}
+}
+
+// WithRunHooks 设置回调
+func WithRunHooks(hooks RunHooks) RunOption {
+	return func(c *runConfig) {
+		c.hooks = hooks
+	}
+}
+
+// RunResult RunConversation 的结果
+type RunResult struct {
+	// Response 最后一轮的响应
+	Response *ChatCreateResponse
+	// Messages 完整的消息列表，包括请求中的消息、模型的回复以及工具的结果
+	Messages []*Message
+	// Iterations 发送请求的次数
+	Iterations int
+}
+
+// RunConversation 驱动模型和工具之间的多轮交互，直到模型正常结束：
+// 发送请求，如果模型要求调用工具，则执行 registry 中对应的工具，将结果追加到消息中再次发送，
+// 工具的错误会转换为 {"error": "..."} 发送给模型，由模型决定如何处理
+// 如果 req 中设置了 Functions，使用旧的 function_call 方式，否则使用 tools，没有设置 Tools 时使用 registry 中的全部工具
+// 超过最大轮数时返回 ErrMaxIterations，此时 RunResult 中包含已经完成的部分
+func RunConversation(ctx context.Context, chat ChatService, req *ChatCreateRequest, registry *ToolRegistry, opts ...RunOption) (*RunResult, error) {
+	cfg := &runConfig{
+		maxIterations: defaultMaxIterations,
+		parallel:      true,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	r := *req
+	r.Messages = append(make([]*Message, 0, len(req.Messages)), req.Messages...)
+
+	if len(r.Functions) == 0 && len(r.Tools) == 0 {
+		r.Tools = registry.Tools()
+	}
+
+	result := &RunResult{}
+
+	finish := func(err error) (*RunResult, error) {
+		result.Messages = r.Messages
+		return result, err
+	}
+
+	for result.Iterations < cfg.maxIterations {
+		result.Iterations++
+
+		if cfg.hooks.OnRequest != nil {
+			cfg.hooks.OnRequest(ctx, result.Iterations, &r)
+		}
+
+		res, err := chat.Create(ctx, &r)
+		if err != nil {
+			return finish(err)
+		}
+
+		resp, err := AccumulateChat(res)
+		if err != nil {
+			return finish(err)
+		}
+
+		if err := ctx.Err(); err != nil {
+			return finish(err)
+		}
+
+		result.Response = resp
+
+		if cfg.hooks.OnResponse != nil {
+			cfg.hooks.OnResponse(ctx, result.Iterations, resp)
+		}
+
+		if len(resp.Choices) == 0 {
+			return finish(ErrNoChoices)
+		}
+
+		msg := resp.Choices[0].Message
+		r.Messages = append(r.Messages, msg)
+
+		var calls []*ToolCall
+		switch {
+		case len(msg.ToolCalls) > 0:
+			calls = msg.ToolCalls
+		case msg.FunctionCall.Name != "":
+			calls = []*ToolCall{{Type: ToolTypeFunction, Function: msg.FunctionCall}}
+		default:
+			return finish(nil)
+		}
+
+		r.Messages = append(r.Messages, runToolCalls(ctx, registry, calls, len(msg.ToolCalls) == 0, cfg)...)
+
+		// 强制调用工具只在第一轮生效，否则模型会一直调用工具
+		switch r.ToolChoice {
+		case nil, ToolChoiceNone, ToolChoiceAuto:
+		default:
+			r.ToolChoice = nil
+		}
+		if _, ok := r.FunctionCall.(FunctionCall); ok {
+			r.FunctionCall = nil
+		}
+	}
+
+	return finish(ErrMaxIterations)
+}
+
+// runToolCalls 执行工具调用，返回的消息顺序与 calls 一致
+func runToolCalls(ctx context.Context, registry *ToolRegistry, calls []*ToolCall, legacy bool, cfg *runConfig) []*Message {
+	messages := make([]*Message, len(calls))
+
+	run := func(i int, call *ToolCall) {
+		if cfg.hooks.OnToolCall != nil {
+			cfg.hooks.OnToolCall(ctx, call)
+		}
+
+		start := time.Now()
+		content, err := registry.Call(ctx, call.Function.Name, call.Function.Arguments)
+
+		if cfg.hooks.OnToolResult != nil {
+			cfg.hooks.OnToolResult(ctx, call, content, err, time.Since(start))
+		}
+
+		switch {
+		case err != nil:
+			b, _ := json.Marshal(map[string]string{"error": err.Error()})
+			content = string(b)
+		case content == "":
+			// API 不接受没有 content 的工具结果
+			content = emptyToolResult
+		}
+
+		if legacy {
+			messages[i] = &Message{
+				Role:    RoleFunction,
+				Name:    call.Function.Name,
+				Content: content,
+			}
+			return
+		}
+
+		messages[i] = &Message{
+			Role:       RoleTool,
+			Content:    content,
+			ToolCallId: call.Id,
+		}
+	}
+
+	if !cfg.parallel || len(calls) == 1 {
+		for i, call := range calls {
+			run(i, call)
+		}
+		return messages
+	}
+
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		go func(i int, call *ToolCall) {
+			defer wg.Done()
+			run(i, call)
+		}(i, call)
+	}
+	wg.Wait()
+
+	return messages
+}
@@ -0,0 +1,310 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type weatherArgs struct {
+	Location string `json:"location"`
+}
+
+type weatherResult struct {
+	Location    string `json:"location"`
+	Temperature int    `json:"temperature"`
+}
+
+var weatherFunction = &Function{
+	Name: "get_weather",
+	Parameters: &Parameter{
+		Type: "object",
+		Properties: map[string]*Property{
+			"location": {Type: "string"},
+		},
+		Required: []string{"location"},
+	},
+}
+
+func newWeatherRegistry(t *testing.T, opts ...ToolOption) *ToolRegistry {
+	registry := NewToolRegistry()
+	err := RegisterTool(registry, weatherFunction, func(ctx context.Context, args weatherArgs) (*weatherResult, error) {
+		if args.Location == "Nowhere" {
+			return nil, errors.New("unknown location")
+		}
+		if args.Location == "Slow" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &weatherResult{Location: args.Location, Temperature: 25}, nil
+	}, opts...)
+	require.NoError(t, err)
+	return registry
+}
+
+// newMockChatSequence 模拟一个按照顺序返回响应的 chat 服务，每次请求都会交给 check 检查
+func newMockChatSequence(t *testing.T, responses []*ChatCreateResponse, check func(t *testing.T, i int, req *ChatCreateRequest)) http.HandlerFunc {
+	var (
+		mu    sync.Mutex
+		count int
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCreateRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		mu.Lock()
+		i := count
+		count++
+		mu.Unlock()
+
+		if check != nil {
+			check(t, i, &req)
+		}
+
+		resp := responses[len(responses)-1]
+		if i < len(responses) {
+			resp = responses[i]
+		}
+
+		require.NoError(t, json.NewEncoder(w).Encode(resp))
+	}
+}
+
+func mockToolCallResponse(calls ...*ToolCall) *ChatCreateResponse {
+	return &ChatCreateResponse{
+		Id:     "chatcmpl-123",
+		Object: "chat.completion",
+		Choices: []*ChatCompletion{
+			{
+				Message:      &Message{Role: RoleAssistant, ToolCalls: calls},
+				FinishReason: FinishReasonToolCalls,
+			},
+		},
+	}
+}
+
+func mockStopResponse(content string) *ChatCreateResponse {
+	return &ChatCreateResponse{
+		Id:     "chatcmpl-456",
+		Object: "chat.completion",
+		Choices: []*ChatCompletion{
+			{
+				Message:      &Message{Role: RoleAssistant, Content: content},
+				FinishReason: FinishReasonStop,
+			},
+		},
+	}
+}
+
+func TestRunConversation(t *testing.T) {
+	weatherCall := func(id, location string) *ToolCall {
+		return &ToolCall{
+			Id:       id,
+			Type:     ToolTypeFunction,
+			Function: FunctionCall{Name: "get_weather", Arguments: `{"location":"` + location + `"}`},
+		}
+	}
+
+	t.Run("test run conversation with parallel tool calls", func(t *testing.T) {
+		server := newMockServer(newMockChatSequence(t, []*ChatCreateResponse{
+			mockToolCallResponse(
+				weatherCall("call_1", "Boston"),
+				weatherCall("call_2", "Nowhere"),
+				&ToolCall{Id: "call_3", Type: ToolTypeFunction, Function: FunctionCall{Name: "get_time", Arguments: "{}"}},
+			),
+			mockStopResponse("It's 25 degrees in Boston."),
+		}, func(t *testing.T, i int, req *ChatCreateRequest) {
+			require.Len(t, req.Tools, 1)
+			require.Equal(t, "get_weather", req.Tools[0].Function.Name)
+
+			if i == 0 {
+				require.Equal(t, ToolChoiceRequired, req.ToolChoice)
+				return
+			}
+
+			require.Nil(t, req.ToolChoice)
+			require.Len(t, req.Messages, 5)
+			require.Equal(t, []*Message{
+				{Role: RoleTool, ToolCallId: "call_1", Content: `{"location":"Boston","temperature":25}`},
+				{Role: RoleTool, ToolCallId: "call_2", Content: `{"error":"unknown location"}`},
+				{Role: RoleTool, ToolCallId: "call_3", Content: `{"error":"openai: tool get_time is not registered"}`},
+			}, req.Messages[2:])
+		}))
+		defer server.Close()
+
+		client := newMockClient(server.URL)
+
+		var toolCalls, toolResults int32
+		res, err := RunConversation(context.TODO(), client.Chat, &ChatCreateRequest{
+			Model:      GPT35Turbo,
+			Messages:   []*Message{{Role: RoleUser, Content: "What's the weather like?"}},
+			ToolChoice: ToolChoiceRequired,
+		}, newWeatherRegistry(t), WithRunHooks(RunHooks{
+			OnToolCall: func(ctx context.Context, call *ToolCall) {
+				atomic.AddInt32(&toolCalls, 1)
+			},
+			OnToolResult: func(ctx context.Context, call *ToolCall, result string, err error, elapsed time.Duration) {
+				atomic.AddInt32(&toolResults, 1)
+			},
+		}))
+		require.NoError(t, err)
+
+		require.Equal(t, 2, res.Iterations)
+		require.Len(t, res.Messages, 6)
+		require.Equal(t, "It's 25 degrees in Boston.", res.Response.Choices[0].Message.Content)
+		require.Equal(t, int32(3), toolCalls)
+		require.Equal(t, int32(3), toolResults)
+	})
+
+	t.Run("test run conversation with legacy function call", func(t *testing.T) {
+		server := newMockServer(newMockChatSequence(t, []*ChatCreateResponse{
+			{
+				Choices: []*ChatCompletion{
+					{
+						Message: &Message{
+							Role:         RoleAssistant,
+							FunctionCall: FunctionCall{Name: "get_weather", Arguments: `{"location":"Boston"}`},
+						},
+						FinishReason: FinishReasonFunctionCall,
+					},
+				},
+			},
+			mockStopResponse("It's 25 degrees in Boston."),
+		}, func(t *testing.T, i int, req *ChatCreateRequest) {
+			require.Nil(t, req.Tools)
+			if i == 1 {
+				require.Equal(t, &Message{Role: RoleFunction, Name: "get_weather", Content: `{"location":"Boston","temperature":25}`}, req.Messages[2])
+			}
+		}))
+		defer server.Close()
+
+		client := newMockClient(server.URL)
+		registry := newWeatherRegistry(t)
+
+		res, err := RunConversation(context.TODO(), client.Chat, &ChatCreateRequest{
+			Model:     GPT35Turbo,
+			Messages:  []*Message{{Role: RoleUser, Content: "What's the weather like in Boston?"}},
+			Functions: registry.Functions(),
+		}, registry)
+		require.NoError(t, err)
+		require.Equal(t, 2, res.Iterations)
+	})
+
+	t.Run("test run conversation with tool timeout", func(t *testing.T) {
+		server := newMockServer(newMockChatSequence(t, []*ChatCreateResponse{
+			mockToolCallResponse(weatherCall("call_1", "Slow")),
+			mockStopResponse("Sorry."),
+		}, func(t *testing.T, i int, req *ChatCreateRequest) {
+			if i == 1 {
+				require.Equal(t, `{"error":"context deadline exceeded"}`, req.Messages[2].Content)
+			}
+		}))
+		defer server.Close()
+
+		client := newMockClient(server.URL)
+
+		_, err := RunConversation(context.TODO(), client.Chat, &ChatCreateRequest{
+			Model:    GPT35Turbo,
+			Messages: []*Message{{Role: RoleUser, Content: "What's the weather like?"}},
+		}, newWeatherRegistry(t, WithToolTimeout(50*time.Millisecond)))
+		require.NoError(t, err)
+	})
+
+	t.Run("test run conversation with empty tool result", func(t *testing.T) {
+		server := newMockServer(newMockChatSequence(t, []*ChatCreateResponse{
+			mockToolCallResponse(&ToolCall{Id: "call_1", Type: ToolTypeFunction, Function: FunctionCall{Name: "noop", Arguments: "{}"}}),
+			mockStopResponse("Done."),
+		}, func(t *testing.T, i int, req *ChatCreateRequest) {
+			if i == 1 {
+				require.Equal(t, "{}", req.Messages[2].Content)
+			}
+		}))
+		defer server.Close()
+
+		client := newMockClient(server.URL)
+
+		registry := NewToolRegistry()
+		require.NoError(t, registry.Register(&Function{Name: "noop"}, func(ctx context.Context, arguments string) (string, error) {
+			return "", nil
+		}))
+
+		_, err := RunConversation(context.TODO(), client.Chat, &ChatCreateRequest{
+			Model:    GPT35Turbo,
+			Messages: []*Message{{Role: RoleUser, Content: "Do nothing."}},
+		}, registry)
+		require.NoError(t, err)
+	})
+
+	t.Run("test run conversation exceed max iterations", func(t *testing.T) {
+		server := newMockServer(newMockChatSequence(t, []*ChatCreateResponse{
+			mockToolCallResponse(weatherCall("call_1", "Boston")),
+		}, nil))
+		defer server.Close()
+
+		client := newMockClient(server.URL)
+
+		res, err := RunConversation(context.TODO(), client.Chat, &ChatCreateRequest{
+			Model:    GPT35Turbo,
+			Messages: []*Message{{Role: RoleUser, Content: "What's the weather like?"}},
+		}, newWeatherRegistry(t), WithMaxIterations(3))
+		require.ErrorIs(t, err, ErrMaxIterations)
+		require.Equal(t, 3, res.Iterations)
+		require.Len(t, res.Messages, 7)
+	})
+}
+
+func TestToolRegistry_Register(t *testing.T) {
+	registry := newWeatherRegistry(t)
+
+	err := registry.Register(weatherFunction, func(ctx context.Context, arguments string) (string, error) {
+		return "", nil
+	})
+	require.Error(t, err)
+
+	err = registry.Register(&Function{Name: "panic"}, func(ctx context.Context, arguments string) (string, error) {
+		panic("boom")
+	})
+	require.NoError(t, err)
+
+	_, err = registry.Call(context.TODO(), "panic", "{}")
+	require.EqualError(t, err, "openai: tool panic panic: boom")
+
+	_, err = registry.Call(context.TODO(), "get_weather", "{invalid")
+	require.Error(t, err)
+
+	// 忽略 ctx 的处理函数也会按时返回
+	block := make(chan struct{})
+	defer close(block)
+	err = registry.Register(&Function{Name: "stuck"}, func(ctx context.Context, arguments string) (string, error) {
+		<-block
+		return "", nil
+	}, WithToolTimeout(20*time.Millisecond))
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = registry.Call(context.TODO(), "stuck", "{}")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+
+	require.Len(t, registry.Tools(), 3)
+}
@@ -0,0 +1,383 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// SchemaViolation 一条 schema 校验失败的记录，Path 为 JSON Pointer 风格的路径，根节点为空
+type SchemaViolation struct {
+	Path    string
+	Message string
+}
+
+func (v *SchemaViolation) String() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// SchemaValidationError 校验失败时返回的错误，包含所有的失败记录
+type SchemaValidationError struct {
+	Violations []*SchemaViolation
+}
+
+func (e *SchemaValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.String())
+	}
+	return "openai: schema validation failed: " + strings.Join(msgs, "; ")
+}
+
+// ValidateJSON 校验 data 是否满足 schema，不满足时返回 *SchemaValidationError，data 不是合法的 JSON 时返回解析错误
+// 支持 type、properties、required、additionalProperties、items、enum、const、数值和长度的限制、pattern、
+// uniqueItems、anyOf、oneOf、allOf、not 以及指向 #/$defs 的 $ref，format 只作为注解不做校验
+func ValidateJSON(schema *Property, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+
+	return ValidateValue(schema, v)
+}
+
+// ValidateValue 校验已经解码的值是否满足 schema，数字可以是 json.Number 或者 float64
+func ValidateValue(schema *Property, v any) error {
+	vc := &schemaValidator{root: schema}
+	vc.validate(schema, v, "")
+
+	if len(vc.violations) > 0 {
+		return &SchemaValidationError{Violations: vc.violations}
+	}
+
+	return nil
+}
+
+type schemaValidator struct {
+	root       *Property
+	violations []*SchemaViolation
+}
+
+func (vc *schemaValidator) fail(path, format string, args ...any) {
+	vc.violations = append(vc.violations, &SchemaViolation{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// check 在独立的上下文中校验，用于 anyOf、oneOf 和 not
+func (vc *schemaValidator) check(schema *Property, v any, path string) bool {
+	sub := &schemaValidator{root: vc.root}
+	sub.validate(schema, v, path)
+	return len(sub.violations) == 0
+}
+
+func (vc *schemaValidator) resolve(ref string) (*Property, bool) {
+	if ref == "#" {
+		return vc.root, true
+	}
+	if strings.HasPrefix(ref, schemaDefsPrefix) && vc.root.Defs != nil {
+		s, ok := vc.root.Defs[strings.TrimPrefix(ref, schemaDefsPrefix)]
+		return s, ok
+	}
+	return nil, false
+}
+
+func (vc *schemaValidator) validate(schema *Property, v any, path string) {
+	if schema == nil {
+		return
+	}
+
+	if schema.Ref != "" {
+		target, ok := vc.resolve(schema.Ref)
+		if !ok {
+			vc.fail(path, "unresolvable $ref %q", schema.Ref)
+			return
+		}
+		vc.validate(target, v, path)
+	}
+
+	if schema.Type != "" && !matchSchemaType(schema.Type, v) {
+		vc.fail(path, "expected %s, got %s", schema.Type, jsonTypeOf(v))
+		return
+	}
+
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, e := range schema.Enum {
+			if jsonEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			vc.fail(path, "value %s is not one of the enum values", jsonString(v))
+		}
+	}
+
+	if schema.Const != nil && !jsonEqual(schema.Const, v) {
+		vc.fail(path, "value %s does not equal const %s", jsonString(v), jsonString(schema.Const))
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		vc.validateObject(schema, val, path)
+	case []any:
+		vc.validateArray(schema, val, path)
+	case string:
+		vc.validateString(schema, val, path)
+	case json.Number, float64:
+		vc.validateNumber(schema, toFloat(val), path)
+	}
+
+	for _, s := range schema.AllOf {
+		vc.validate(s, v, path)
+	}
+
+	if len(schema.AnyOf) > 0 {
+		matched := false
+		for _, s := range schema.AnyOf {
+			if vc.check(s, v, path) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			vc.fail(path, "value does not match any schema in anyOf")
+		}
+	}
+
+	if len(schema.OneOf) > 0 {
+		matched := 0
+		for _, s := range schema.OneOf {
+			if vc.check(s, v, path) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			vc.fail(path, "value matches %d schemas in oneOf, expected exactly 1", matched)
+		}
+	}
+
+	if schema.Not != nil && vc.check(schema.Not, v, path) {
+		vc.fail(path, "value must not match the schema in not")
+	}
+}
+
+func (vc *schemaValidator) validateObject(schema *Property, obj map[string]any, path string) {
+	for _, name := range schema.Required {
+		if _, ok := obj[name]; !ok {
+			vc.fail(path, "missing required property %q", name)
+		}
+	}
+
+	if schema.MinProperties != nil && int64(len(obj)) < *schema.MinProperties {
+		vc.fail(path, "expected at least %d properties", *schema.MinProperties)
+	}
+	if schema.MaxProperties != nil && int64(len(obj)) > *schema.MaxProperties {
+		vc.fail(path, "expected at most %d properties", *schema.MaxProperties)
+	}
+
+	// 保证错误的顺序稳定
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := path + "/" + k
+		if prop, ok := schema.Properties[k]; ok {
+			vc.validate(prop, obj[k], p)
+			continue
+		}
+
+		switch ap := schema.AdditionalProperties.(type) {
+		case bool:
+			if !ap {
+				vc.fail(path, "additional property %q is not allowed", k)
+			}
+		case *Property:
+			vc.validate(ap, obj[k], p)
+		}
+	}
+}
+
+func (vc *schemaValidator) validateArray(schema *Property, arr []any, path string) {
+	if schema.MinItems != nil && int64(len(arr)) < *schema.MinItems {
+		vc.fail(path, "expected at least %d items, got %d", *schema.MinItems, len(arr))
+	}
+	if schema.MaxItems != nil && int64(len(arr)) > *schema.MaxItems {
+		vc.fail(path, "expected at most %d items, got %d", *schema.MaxItems, len(arr))
+	}
+
+	if schema.UniqueItems {
+		for i := 0; i < len(arr); i++ {
+			for j := i + 1; j < len(arr); j++ {
+				if jsonEqual(arr[i], arr[j]) {
+					vc.fail(path, "items at %d and %d are not unique", i, j)
+				}
+			}
+		}
+	}
+
+	if schema.Items != nil {
+		for i, item := range arr {
+			vc.validate(schema.Items, item, fmt.Sprintf("%s/%d", path, i))
+		}
+	}
+}
+
+func (vc *schemaValidator) validateString(schema *Property, s string, path string) {
+	n := int64(utf8.RuneCountInString(s))
+
+	if schema.MinLength != nil && n < *schema.MinLength {
+		vc.fail(path, "expected at least %d characters, got %d", *schema.MinLength, n)
+	}
+	if schema.MaxLength != nil && n > *schema.MaxLength {
+		vc.fail(path, "expected at most %d characters, got %d", *schema.MaxLength, n)
+	}
+
+	if schema.Pattern != "" {
+		// Go 的正则不支持部分 ECMA 语法，无法编译时跳过
+		if re, err := regexp.Compile(schema.Pattern); err == nil && !re.MatchString(s) {
+			vc.fail(path, "value %q does not match pattern %q", s, schema.Pattern)
+		}
+	}
+}
+
+func (vc *schemaValidator) validateNumber(schema *Property, n float64, path string) {
+	if schema.Minimum != nil && n < *schema.Minimum {
+		vc.fail(path, "value %v is less than minimum %v", n, *schema.Minimum)
+	}
+	if schema.Maximum != nil && n > *schema.Maximum {
+		vc.fail(path, "value %v is greater than maximum %v", n, *schema.Maximum)
+	}
+	if schema.ExclusiveMinimum != nil && n <= *schema.ExclusiveMinimum {
+		vc.fail(path, "value %v must be greater than %v", n, *schema.ExclusiveMinimum)
+	}
+	if schema.ExclusiveMaximum != nil && n >= *schema.ExclusiveMaximum {
+		vc.fail(path, "value %v must be less than %v", n, *schema.ExclusiveMaximum)
+	}
+	if schema.MultipleOf != nil && *schema.MultipleOf != 0 {
+		q := n / *schema.MultipleOf
+		if math.Abs(q-math.Round(q)) > 1e-9 {
+			vc.fail(path, "value %v is not a multiple of %v", n, *schema.MultipleOf)
+		}
+	}
+}
+
+func matchSchemaType(typ string, v any) bool {
+	switch typ {
+	case SchemaTypeObject:
+		_, ok := v.(map[string]any)
+		return ok
+	case SchemaTypeArray:
+		_, ok := v.([]any)
+		return ok
+	case SchemaTypeString:
+		_, ok := v.(string)
+		return ok
+	case SchemaTypeBoolean:
+		_, ok := v.(bool)
+		return ok
+	case SchemaTypeNull:
+		return v == nil
+	case SchemaTypeNumber:
+		switch v.(type) {
+		case json.Number, float64:
+			return true
+		}
+		return false
+	case SchemaTypeInteger:
+		switch n := v.(type) {
+		case json.Number:
+			f, err := n.Float64()
+			return err == nil && f == math.Trunc(f)
+		case float64:
+			return n == math.Trunc(n)
+		}
+		return false
+	}
+	return true
+}
+
+func jsonTypeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return SchemaTypeNull
+	case map[string]any:
+		return SchemaTypeObject
+	case []any:
+		return SchemaTypeArray
+	case string:
+		return SchemaTypeString
+	case bool:
+		return SchemaTypeBoolean
+	case json.Number, float64:
+		return SchemaTypeNumber
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func toFloat(v any) float64 {
+	switch n := v.(type) {
+	case json.Number:
+		f, _ := n.Float64()
+		return f
+	case float64:
+		return n
+	}
+	return 0
+}
+
+// jsonEqual 按照 JSON 的语义比较两个值，数字统一按照 float64 比较
+func jsonEqual(a, b any) bool {
+	return reflect.DeepEqual(normalizeJSON(a), normalizeJSON(b))
+}
+
+func normalizeJSON(v any) any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out any
+	if err := json.Unmarshal(b, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+func jsonString(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
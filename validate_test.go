@@ -0,0 +1,127 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestValidateJSON(t *testing.T) {
+	int64Ptr := func(n int64) *int64 { return &n }
+	floatPtr := func(n float64) *float64 { return &n }
+
+	schema := &Property{
+		Type:                 SchemaTypeObject,
+		AdditionalProperties: false,
+		Properties: map[string]*Property{
+			"name":  {Type: SchemaTypeString, MinLength: int64Ptr(1), MaxLength: int64Ptr(4)},
+			"age":   {Type: SchemaTypeInteger, Minimum: floatPtr(0), ExclusiveMaximum: floatPtr(150)},
+			"color": {Enum: []any{"red", "green"}},
+			"code":  {Type: SchemaTypeString, Pattern: "^[0-9]{3}$"},
+			"tags":  {Type: SchemaTypeArray, Items: &Property{Type: SchemaTypeString}, MaxItems: int64Ptr(2), UniqueItems: true},
+			"note":  {AnyOf: []*Property{{Type: SchemaTypeString}, {Type: SchemaTypeNull}}},
+			"kind":  {Const: "user"},
+			"child": {Ref: "#"},
+			"addr":  {Ref: "#/$defs/address"},
+		},
+		Required: []string{"name", "age"},
+		Defs: map[string]*Property{
+			"address": {
+				Type:       SchemaTypeObject,
+				Properties: map[string]*Property{"city": {Type: SchemaTypeString}},
+				Required:   []string{"city"},
+			},
+		},
+	}
+
+	testCase := []struct {
+		name     string
+		data     string
+		wantErr  bool
+		wantMsgs []string
+	}{
+		{
+			name: "test validate valid",
+			data: `{"name":"张三","age":18,"color":"red","code":"123","tags":["a","b"],"note":null,"kind":"user",
+				"child":{"name":"李四","age":1},"addr":{"city":"北京"}}`,
+		},
+		{
+			name: "test validate violations",
+			data: `{"name":"","age":18.5,"color":"blue","code":"12a","tags":["a","a","b"],"note":1,"kind":"admin",
+				"child":{"age":-1},"addr":{},"extra":true}`,
+			wantMsgs: []string{
+				`/addr: missing required property "city"`,
+				`/age: expected integer, got number`,
+				`/child: missing required property "name"`,
+				`/child/age: value -1 is less than minimum 0`,
+				`/code: value "12a" does not match pattern "^[0-9]{3}$"`,
+				`/color: value "blue" is not one of the enum values`,
+				`additional property "extra" is not allowed`,
+				`/kind: value "admin" does not equal const "user"`,
+				`/name: expected at least 1 characters, got 0`,
+				`/note: value does not match any schema in anyOf`,
+				`/tags: expected at most 2 items, got 3`,
+				`/tags: items at 0 and 1 are not unique`,
+			},
+		},
+		{
+			name:     "test validate type mismatch",
+			data:     `[]`,
+			wantMsgs: []string{"expected object, got array"},
+		},
+		{
+			name:    "test validate invalid json",
+			data:    `{"name":`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateJSON(schema, []byte(tc.data))
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			if len(tc.wantMsgs) == 0 {
+				require.NoError(t, err)
+				return
+			}
+
+			verr, ok := err.(*SchemaValidationError)
+			require.True(t, ok)
+
+			msgs := make([]string, 0, len(verr.Violations))
+			for _, v := range verr.Violations {
+				msgs = append(msgs, v.String())
+			}
+			require.Equal(t, tc.wantMsgs, msgs)
+		})
+	}
+}
+
+func TestValidateJSON_OneOfNot(t *testing.T) {
+	schema := &Property{
+		OneOf: []*Property{{Type: SchemaTypeInteger}, {Type: SchemaTypeNumber}},
+		Not:   &Property{Const: 3.5},
+	}
+
+	require.NoError(t, ValidateJSON(schema, []byte(`1.5`)))
+	// 1 同时满足 integer 和 number
+	require.Error(t, ValidateJSON(schema, []byte(`1`)))
+	require.Error(t, ValidateJSON(schema, []byte(`3.5`)))
+}
@@ -0,0 +1,287 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+)
+
+const (
+	ImageFormatPNG  = "png"
+	ImageFormatJPEG = "jpeg"
+
+	defaultJPEGQuality = 85
+	minJPEGQuality     = 40
+	// minImageDimension 缩小图片时最短边的最小值，再小的图片已经没有意义
+	minImageDimension = 16
+)
+
+// ErrImageTooLarge 图片无法压缩到限制的大小以内
+var ErrImageTooLarge = errors.New("openai: image cannot be reduced under the size limit")
+
+type imageEncodeConfig struct {
+	maxDimension int
+	maxBytes     int
+	format       string
+	quality      int
+}
+
+type ImageEncodeOption func(*imageEncodeConfig)
+
+// WithImageMaxDimension 图片最长边超过 px 时等比例缩小
+func WithImageMaxDimension(px int) ImageEncodeOption {
+	return func(c *imageEncodeConfig) {
+		c.maxDimension = px
+	}
+}
+
+// WithImageMaxBytes 编码之后的图片超过 n 字节时，先降低 JPEG 的质量，再逐步缩小图片
+func WithImageMaxBytes(n int) ImageEncodeOption {
+	return func(c *imageEncodeConfig) {
+		c.maxBytes = n
+	}
+}
+
+// WithImageFormat 编码使用的格式，png 或者 jpeg，默认保持原有的格式，无法保持时使用 png
+func WithImageFormat(format string) ImageEncodeOption {
+	return func(c *imageEncodeConfig) {
+		c.format = format
+	}
+}
+
+// WithImageQuality JPEG 的编码质量，1 到 100，默认为 85
+func WithImageQuality(quality int) ImageEncodeOption {
+	return func(c *imageEncodeConfig) {
+		if quality > 0 && quality <= 100 {
+			c.quality = quality
+		}
+	}
+}
+
+func newImageEncodeConfig(opts []ImageEncodeOption) (*imageEncodeConfig, error) {
+	cfg := &imageEncodeConfig{
+		quality: defaultJPEGQuality,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	switch cfg.format {
+	case "", ImageFormatPNG, ImageFormatJPEG:
+	case "jpg":
+		cfg.format = ImageFormatJPEG
+	default:
+		return nil, fmt.Errorf("openai: unsupported image format %q", cfg.format)
+	}
+
+	return cfg, nil
+}
+
+// NewImagePart 将 image.Image 编码为 data URL 作为消息的一部分
+func NewImagePart(img image.Image, detail string, opts ...ImageEncodeOption) (*ContentPart, error) {
+	url, err := ImageToDataURL(img, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewImageURLPart(url, detail), nil
+}
+
+// NewImageFilePart 将本地的图片文件编码为 data URL 作为消息的一部分
+func NewImageFilePart(path string, detail string, opts ...ImageEncodeOption) (*ContentPart, error) {
+	url, err := ImageFileToDataURL(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewImageURLPart(url, detail), nil
+}
+
+// ImageFileToDataURL 读取本地的图片文件并编码为 base64 的 data URL
+func ImageFileToDataURL(path string, opts ...ImageEncodeOption) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return ImageBytesToDataURL(data, opts...)
+}
+
+// ImageBytesToDataURL 将图片文件的内容编码为 base64 的 data URL，不需要缩小或者转换格式时直接使用原始的内容，
+// 支持 png、jpeg 和 gif，webp 只能原样编码
+func ImageBytesToDataURL(data []byte, opts ...ImageEncodeOption) (string, error) {
+	cfg, err := newImageEncodeConfig(opts)
+	if err != nil {
+		return "", err
+	}
+
+	mime := http.DetectContentType(data)
+
+	sameFormat := cfg.format == "" || "image/"+cfg.format == mime
+	fitsBytes := cfg.maxBytes <= 0 || len(data) <= cfg.maxBytes
+
+	img, format, decodeErr := image.Decode(bytes.NewReader(data))
+	if decodeErr == nil {
+		b := img.Bounds()
+		fitsDimension := cfg.maxDimension <= 0 || (b.Dx() <= cfg.maxDimension && b.Dy() <= cfg.maxDimension)
+		if sameFormat && fitsBytes && fitsDimension {
+			return dataURL(mime, data), nil
+		}
+	} else {
+		if mime == "image/webp" && sameFormat && fitsBytes && cfg.maxDimension <= 0 {
+			return dataURL(mime, data), nil
+		}
+		return "", fmt.Errorf("openai: decode image: %w", decodeErr)
+	}
+
+	if cfg.format == "" && format == ImageFormatJPEG {
+		cfg.format = ImageFormatJPEG
+	}
+
+	return encodeImage(img, cfg)
+}
+
+// ImageToDataURL 将 image.Image 编码为 base64 的 data URL，默认使用 png
+func ImageToDataURL(img image.Image, opts ...ImageEncodeOption) (string, error) {
+	cfg, err := newImageEncodeConfig(opts)
+	if err != nil {
+		return "", err
+	}
+	return encodeImage(img, cfg)
+}
+
+func encodeImage(img image.Image, cfg *imageEncodeConfig) (string, error) {
+	if cfg.format == "" {
+		cfg.format = ImageFormatPNG
+	}
+
+	if cfg.maxDimension > 0 {
+		b := img.Bounds()
+		if w, h := fitDimension(b.Dx(), b.Dy(), cfg.maxDimension); w != b.Dx() || h != b.Dy() {
+			img = resizeImage(img, w, h)
+		}
+	}
+
+	for {
+		data, err := encodeWithinLimit(img, cfg)
+		if err != nil {
+			return "", err
+		}
+
+		if cfg.maxBytes <= 0 || len(data) <= cfg.maxBytes {
+			return dataURL("image/"+cfg.format, data), nil
+		}
+
+		b := img.Bounds()
+		w, h := b.Dx()*3/4, b.Dy()*3/4
+		if w < minImageDimension || h < minImageDimension {
+			return "", ErrImageTooLarge
+		}
+		img = resizeImage(img, w, h)
+	}
+}
+
+// encodeWithinLimit 编码图片，JPEG 超过大小限制时逐步降低质量
+func encodeWithinLimit(img image.Image, cfg *imageEncodeConfig) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if cfg.format == ImageFormatPNG {
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	for quality := cfg.quality; ; quality -= 15 {
+		if quality < minJPEGQuality {
+			quality = minJPEGQuality
+		}
+
+		buf.Reset()
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+
+		if cfg.maxBytes <= 0 || buf.Len() <= cfg.maxBytes || quality == minJPEGQuality {
+			return buf.Bytes(), nil
+		}
+	}
+}
+
+// fitDimension 等比例缩小 w 和 h，使最长边不超过 max，不会放大
+func fitDimension(w, h, max int) (int, int) {
+	if w <= max && h <= max {
+		return w, h
+	}
+
+	if w >= h {
+		nh := h * max / w
+		if nh < 1 {
+			nh = 1
+		}
+		return max, nh
+	}
+
+	nw := w * max / h
+	if nw < 1 {
+		nw = 1
+	}
+	return nw, max
+}
+
+// resizeImage 使用区域平均的方式缩小图片
+func resizeImage(src image.Image, w, h int) image.Image {
+	dst := image.NewRGBA64(image.Rect(0, 0, w, h))
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*sh/h, b.Min.Y+(y+1)*sh/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*sw/w, b.Min.X+(x+1)*sw/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+
+	return dst
+}
+
+func dataURL(mime string, data []byte) string {
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
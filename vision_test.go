@@ -0,0 +1,142 @@
+// Copyright 2023 Ken Lin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestImage 生成随机噪点的图片，压缩率很低，方便测试大小限制
+func newTestImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	r := rand.New(rand.NewSource(1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(r.Intn(256)), G: uint8(r.Intn(256)), B: uint8(r.Intn(256)), A: 255})
+		}
+	}
+	return img
+}
+
+func decodeDataURL(t *testing.T, url string) (string, []byte) {
+	require.True(t, strings.HasPrefix(url, "data:"))
+	header, payload, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ";base64,")
+	require.True(t, ok)
+
+	data, err := base64.StdEncoding.DecodeString(payload)
+	require.NoError(t, err)
+	return header, data
+}
+
+func TestImageToDataURL(t *testing.T) {
+	testCase := []struct {
+		name     string
+		img      image.Image
+		opts     []ImageEncodeOption
+		wantMime string
+		wantSize image.Point
+		wantErr  error
+	}{
+		{
+			name:     "test encode png",
+			img:      newTestImage(20, 10),
+			wantMime: "image/png",
+			wantSize: image.Pt(20, 10),
+		},
+		{
+			name:     "test encode with max dimension",
+			img:      newTestImage(200, 100),
+			opts:     []ImageEncodeOption{WithImageMaxDimension(50), WithImageFormat("jpg")},
+			wantMime: "image/jpeg",
+			wantSize: image.Pt(50, 25),
+		},
+		{
+			name:     "test encode with max bytes",
+			img:      newTestImage(200, 200),
+			opts:     []ImageEncodeOption{WithImageMaxBytes(8 * 1024), WithImageFormat(ImageFormatJPEG)},
+			wantMime: "image/jpeg",
+		},
+		{
+			name:    "test encode too large",
+			img:     newTestImage(100, 100),
+			opts:    []ImageEncodeOption{WithImageMaxBytes(10)},
+			wantErr: ErrImageTooLarge,
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			url, err := ImageToDataURL(tc.img, tc.opts...)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			mime, data := decodeDataURL(t, url)
+			require.Equal(t, tc.wantMime, mime)
+
+			img, _, err := image.Decode(bytes.NewReader(data))
+			require.NoError(t, err)
+			if tc.wantSize != (image.Point{}) {
+				require.Equal(t, tc.wantSize, img.Bounds().Size())
+			}
+		})
+	}
+
+	_, err := ImageToDataURL(newTestImage(1, 1), WithImageFormat("bmp"))
+	require.Error(t, err)
+}
+
+func TestImageFileToDataURL(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, newTestImage(40, 80)))
+
+	path := filepath.Join(t.TempDir(), "image.png")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+
+	// 不需要处理时直接使用原始的内容
+	url, err := ImageFileToDataURL(path)
+	require.NoError(t, err)
+	mime, data := decodeDataURL(t, url)
+	require.Equal(t, "image/png", mime)
+	require.Equal(t, buf.Bytes(), data)
+
+	part, err := NewImageFilePart(path, ImageDetailLow, WithImageMaxDimension(20))
+	require.NoError(t, err)
+	require.Equal(t, ContentPartTypeImageURL, part.Type)
+	require.Equal(t, ImageDetailLow, part.ImageURL.Detail)
+
+	_, data = decodeDataURL(t, part.ImageURL.URL)
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, image.Pt(10, 20), img.Bounds().Size())
+
+	_, err = ImageBytesToDataURL([]byte("not an image"))
+	require.Error(t, err)
+
+	_, err = ImageFileToDataURL(filepath.Join(t.TempDir(), "missing.png"))
+	require.Error(t, err)
+}